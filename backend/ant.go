@@ -1,57 +1,122 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/anatasluo/ant/backend/engine"
 	"github.com/anatasluo/ant/backend/router"
 	"github.com/anatasluo/ant/backend/setting"
 	log "github.com/sirupsen/logrus"
-	"github.com/urfave/negroni"
 	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
 	"syscall"
+	"time"
 )
 
-var (
-	clientConfig = setting.GetClientSetting()
-	logger       = clientConfig.LoggerSetting.Logger
-	nRouter      *negroni.Negroni
+// Time given to in-flight requests and to saving tasks, each, when the programme stops
+const shutdownTimeout = 10 * time.Second
+
+// Exit status of the programme
+const (
+	exitOK = iota
+	exitFailed
+	// Stopped by signal, but requests or tasks could not be finished cleanly
+	exitUnclean
 )
 
-func runAPP() {
+func runAPP(ctx context.Context, clientConfig *setting.ClientSetting, runningEngine *engine.Engine) int {
+	logger := clientConfig.LoggerSetting.Logger
 	logger.SetFormatter(&log.TextFormatter{
 		ForceColors:     true,
 		FullTimestamp:   true,
 		TimestampFormat: "2006-01-92T15:04:05",
 	})
+
+	// Init server router
+	server := router.New(runningEngine)
+	httpServer := &http.Server{
+		Addr:    clientConfig.ConnectSetting.Addr,
+		Handler: server,
+	}
+	httpServer.RegisterOnShutdown(server.CloseStreams)
+	serveErr := make(chan error, 1)
 	go func() {
-		// Init server router
-		nRouter = router.InitRouter()
-		err := http.ListenAndServe(clientConfig.ConnectSetting.Addr, nRouter)
-		if err != nil {
-			logger.WithFields(log.Fields{"Error": err}).Fatal("Failed to created http service")
-		}
+		serveErr <- httpServer.ListenAndServe()
 	}()
+
+	exitCode := exitOK
+	select {
+	case <-ctx.Done():
+		logger.Info("The programme will stop!")
+	case err := <-serveErr:
+		logger.WithFields(log.Fields{"Error": err}).Error("Failed to created http service")
+		exitCode = exitFailed
+	}
+
+	// Stop taking requests first, so nothing changes tasks while they are saved
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.WithFields(log.Fields{"Error": err}).Error("Unable to finish in-flight requests")
+		_ = httpServer.Close()
+		exitCode = exitUnclean
+	}
+
+	engineCtx, cancelEngine := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelEngine()
+	if err := runningEngine.Shutdown(engineCtx); err != nil {
+		logger.WithFields(log.Fields{"Error": err}).Error("Unable to stop engine cleanly")
+		if exitCode == exitOK {
+			exitCode = exitUnclean
+		}
+	}
+	return exitCode
 }
 
-func cleanUp() {
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt,
-			syscall.SIGHUP,
-			syscall.SIGINT,
-			syscall.SIGTERM,
-			syscall.SIGQUIT)
-		<-c
-		log.Info("The programme will stop!")
-		engine.GetEngine().Cleanup()
-		os.Exit(0)
-	}()
+func checkConfig(clientConfig *setting.ClientSetting) {
+	if setting.Flags.PrintConfig {
+		if err := clientConfig.PrintConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailed)
+		}
+		os.Exit(exitOK)
+	}
+	if setting.Flags.CheckConfig {
+		problems := clientConfig.CheckConfig()
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(exitFailed)
+		}
+		fmt.Printf("%s is valid\n", clientConfig.Paths.ConfigFile)
+		os.Exit(exitOK)
+	}
 }
 
 func main() {
-	runAPP()
-	cleanUp()
-	runtime.Goexit()
+	clientConfig, err := setting.NewFromFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailed)
+	}
+	checkConfig(clientConfig)
+
+	// A second signal kills the programme at once, as stop restores default behaviour
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGHUP,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	runningEngine, err := engine.New(clientConfig)
+	if err != nil {
+		clientConfig.LoggerSetting.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to start engine")
+		os.Exit(exitFailed)
+	}
+	os.Exit(runAPP(ctx, clientConfig, runningEngine))
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fields of engine.TorrentWebInfo used by antctl
+type torrentInfo struct {
+	TorrentName   string
+	TotalLength   string
+	HexString     string
+	Status        string
+	StoragePath   string
+	Category      string
+	Percentage    float64
+	DownloadSpeed string
+	LeftTime      string
+	Files         []struct {
+		Path     string
+		Priority byte
+		Size     string
+	}
+}
+
+type client struct {
+	host       string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func newClient(host string, username string, password string, timeout time.Duration) *client {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &client{
+		host:       strings.TrimSuffix(host, "/"),
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func md5Hex(text string) string {
+	hash := md5.Sum([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
+
+func (c *client) do(req *http.Request, res interface{}) error {
+	if c.username != "" || c.password != "" {
+		token := base64.StdEncoding.EncodeToString([]byte(md5Hex(c.username) + ":" + md5Hex(c.password)))
+		req.Header.Set("Authorization", "MD5 "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s %s: engine needs login, set -user and -password or -config", req.Method, req.URL.Path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func (c *client) get(path string, res interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.host+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, res)
+}
+
+func (c *client) postForm(path string, form url.Values, res interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, c.host+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req, res)
+}
+
+func (c *client) postJSON(path string, body interface{}, res interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.host+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, res)
+}
+
+func (c *client) postFile(path string, fileName string, content io.Reader, form url.Values, res interface{}) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key := range form {
+		_ = writer.WriteField(key, form.Get(key))
+	}
+	part, err := writer.CreateFormFile("oneTorrentFile", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, content); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.host+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return c.do(req, res)
+}
+
+func (c *client) torrents() (torrents []torrentInfo, err error) {
+	err = c.get("/torrent/getAllTorrents", &torrents)
+	return
+}
+
+// resolveHash accepts a full info hash or a unique prefix of one
+func (c *client) resolveHash(prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) == 40 {
+		return prefix, nil
+	}
+	torrents, err := c.torrents()
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, info := range torrents {
+		if strings.HasPrefix(strings.ToLower(info.HexString), prefix) {
+			found = append(found, info.HexString)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no torrent matches %q", prefix)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%q matches %d torrents", prefix, len(found))
+	}
+}
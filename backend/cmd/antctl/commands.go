@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var filePriorities = map[string]int{
+	"skip":   0,
+	"normal": 1,
+	"high":   2,
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+func progressBar(percentage float64, width int) string {
+	done := int(percentage * float64(width))
+	if done > width {
+		done = width
+	}
+	return "[" + strings.Repeat("#", done) + strings.Repeat(".", width-done) + "]"
+}
+
+func printTorrents(torrents []torrentInfo) {
+	table := newTable()
+	_, _ = fmt.Fprintln(table, "HASH\tNAME\tSTATUS\tSIZE\tPROGRESS\tSPEED\tLEFT\tCATEGORY")
+	for _, info := range torrents {
+		_, _ = fmt.Fprintf(table, "%.8s\t%s\t%s\t%s\t%s %5.1f%%\t%s\t%s\t%s\n",
+			info.HexString, info.TorrentName, info.Status, info.TotalLength,
+			progressBar(info.Percentage, 10), info.Percentage*100, info.DownloadSpeed, info.LeftTime, info.Category)
+	}
+	_ = table.Flush()
+}
+
+func runList(c *client, opts options, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "", "only torrents with this status")
+	category := flags.String("category", "", "only torrents in this category")
+	name := flags.String("name", "", "only torrents whose name matches this regexp")
+	_ = flags.Parse(args)
+
+	nameRegexp, err := regexp.Compile("(?i)" + *name)
+	if err != nil {
+		return err
+	}
+	torrents, err := c.torrents()
+	if err != nil {
+		return err
+	}
+	var selected []torrentInfo
+	for _, info := range torrents {
+		if *status != "" && !strings.EqualFold(info.Status, *status) {
+			continue
+		}
+		if *category != "" && info.Category != *category {
+			continue
+		}
+		if !nameRegexp.MatchString(info.TorrentName) {
+			continue
+		}
+		selected = append(selected, info)
+	}
+	if opts.asJSON {
+		return printJSON(selected)
+	}
+	printTorrents(selected)
+	return nil
+}
+
+func runAdd(c *client, opts options, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	category := flags.String("category", "", "category of new torrents")
+	storagePath := flags.String("path", "", "save path of new torrents")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("add needs a torrent file, magnet, url or -")
+	}
+	form := url.Values{}
+	form.Set("category", *category)
+	form.Set("storagePath", *storagePath)
+
+	failed := 0
+	for _, source := range flags.Args() {
+		var err error
+		if source == "-" {
+			err = addFromStdin(c, form)
+		} else {
+			err = addOne(c, form, source)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sources not added", failed, flags.NArg())
+	}
+	return nil
+}
+
+func addOne(c *client, form url.Values, source string) error {
+	var res struct{ IsAdded bool }
+	var err error
+	if strings.HasPrefix(source, "magnet:") || strings.HasPrefix(source, "infohash:") ||
+		strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		linkForm := url.Values{"linkAddress": {source}}
+		for key := range form {
+			linkForm.Set(key, form.Get(key))
+		}
+		err = c.postForm("/torrent/addOneURL", linkForm, &res)
+	} else {
+		var file *os.File
+		file, err = os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		err = c.postFile("/torrent/addOneFile", filepath.Base(source), file, form, &res)
+	}
+	if err == nil && !res.IsAdded {
+		err = fmt.Errorf("rejected by engine")
+	}
+	return err
+}
+
+// stdin is either a torrent file or a list of links, one per line
+func addFromStdin(c *client, form url.Values) error {
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(content, []byte("d")) {
+		var res struct{ IsAdded bool }
+		err = c.postFile("/torrent/addOneFile", "stdin.torrent", bytes.NewReader(content), form, &res)
+		if err == nil && !res.IsAdded {
+			err = fmt.Errorf("rejected by engine")
+		}
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	var lastErr error
+	for scanner.Scan() {
+		link := strings.TrimSpace(scanner.Text())
+		if link == "" || strings.HasPrefix(link, "#") {
+			continue
+		}
+		if err = addOne(c, form, link); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", link, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Run one form request for every hash, the result field tells whether it worked
+func forEachHash(c *client, args []string, path string, resultField string, extra url.Values) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no torrent hash given")
+	}
+	failed := 0
+	for _, prefix := range args {
+		hexString, err := c.resolveHash(prefix)
+		if err == nil {
+			form := url.Values{"hexString": {hexString}}
+			for key := range extra {
+				form.Set(key, extra.Get(key))
+			}
+			res := map[string]bool{}
+			err = c.postForm(path, form, &res)
+			if err == nil && !res[resultField] {
+				err = fmt.Errorf("rejected by engine")
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d torrents failed", failed, len(args))
+	}
+	return nil
+}
+
+func runStart(c *client, opts options, args []string) error {
+	return forEachHash(c, args, "/torrent/startDownload", "IsDownloading", nil)
+}
+
+func runStop(c *client, opts options, args []string) error {
+	return forEachHash(c, args, "/torrent/stopDownload", "IsStopped", nil)
+}
+
+func runDelete(c *client, opts options, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	withData := flags.Bool("with-data", false, "delete downloaded files from disk too")
+	_ = flags.Parse(args)
+	return forEachHash(c, flags.Args(), "/torrent/delOne", "IsDeleted", url.Values{
+		"deleteFiles": {strconv.FormatBool(*withData)},
+	})
+}
+
+func runPriority(c *client, opts options, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: priority <hash> <file-index> <skip|normal|high>")
+	}
+	priority, isExist := filePriorities[strings.ToLower(args[2])]
+	if !isExist {
+		return fmt.Errorf("unknown priority %q", args[2])
+	}
+	if _, err := strconv.Atoi(args[1]); err != nil {
+		return fmt.Errorf("invalid file index %q", args[1])
+	}
+	return forEachHash(c, args[:1], "/torrent/setPriority", "IsChanged", url.Values{
+		"fileIndex": {args[1]},
+		"priority":  {strconv.Itoa(priority)},
+	})
+}
+
+func runSettings(c *client, opts options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: settings get [key] | settings set <key=value>...")
+	}
+	settings := map[string]interface{}{}
+	if err := c.get("/settings/config", &settings); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "get":
+		if len(args) > 1 {
+			key, err := findSettingKey(settings, args[1])
+			if err != nil {
+				return err
+			}
+			settings = map[string]interface{}{key: settings[key]}
+		}
+		if opts.asJSON {
+			return printJSON(settings)
+		}
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		table := newTable()
+		for _, key := range keys {
+			_, _ = fmt.Fprintf(table, "%s\t%v\n", key, settings[key])
+		}
+		return table.Flush()
+	case "set":
+		if len(args) == 1 {
+			return fmt.Errorf("usage: settings set <key=value>...")
+		}
+		for _, pair := range args[1:] {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			key, err := findSettingKey(settings, parts[0])
+			if err != nil {
+				return err
+			}
+			if settings[key], err = convertSetting(settings[key], parts[1]); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		var res struct{ IsApplied bool }
+		if err := c.postJSON("/settings/apply", settings, &res); err != nil {
+			return err
+		}
+		if !res.IsApplied {
+			return fmt.Errorf("settings rejected by engine")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown settings command %q", args[0])
+}
+
+func findSettingKey(settings map[string]interface{}, name string) (string, error) {
+	for key := range settings {
+		if strings.EqualFold(key, name) {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("unknown setting %q", name)
+}
+
+// The new value gets the json type of the current one
+func convertSetting(current interface{}, value string) (interface{}, error) {
+	switch current.(type) {
+	case bool:
+		return strconv.ParseBool(value)
+	case float64:
+		return strconv.ParseFloat(value, 64)
+	case string, nil:
+		return value, nil
+	}
+	var converted interface{}
+	err := json.Unmarshal([]byte(value), &converted)
+	return converted, err
+}
+
+func runWatch(c *client, opts options, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", time.Second, "refresh interval")
+	_ = flags.Parse(args)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		var torrents []torrentInfo
+		err := c.get("/torrent/getAllEngineTorrents", &torrents)
+		if err != nil {
+			return err
+		}
+		if opts.asJSON {
+			_ = printJSON(torrents)
+		} else {
+			// clear screen and move cursor home
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("%s  %s\n\n", c.host, time.Now().Format("15:04:05"))
+			printTorrents(torrents)
+		}
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+func runStats(c *client, opts options, args []string) error {
+	stats := map[string]interface{}{}
+	if err := c.get("/settings/stats", &stats); err != nil {
+		return err
+	}
+	if opts.asJSON {
+		return printJSON(stats)
+	}
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	table := newTable()
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(table, "%s\t%v\n", key, stats[key])
+	}
+	return table.Flush()
+}
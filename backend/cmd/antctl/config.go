@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// defaultConfigFile Config file the engine uses without flags: ./config.toml if it exists,
+// as for the Electron app, else config.toml in $XDG_CONFIG_HOME/ant
+func defaultConfigFile() string {
+	if _, err := os.Stat("config.toml"); err == nil {
+		return "config.toml"
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ant", "config.toml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "ant", "config.toml")
+}
+
+// configCredentials Auth username and password in config file of engine, empty if the file can not be read.
+// Keys are matched ignoring case, like the engine does
+func configCredentials(configFile string) (username string, password string) {
+	if configFile == "" {
+		configFile = defaultConfigFile()
+	}
+	tree, err := toml.LoadFile(configFile)
+	if err != nil {
+		return "", ""
+	}
+	connectSetting, _ := getIgnoreCase(tree, "ConnectSetting").(*toml.Tree)
+	if connectSetting == nil {
+		return "", ""
+	}
+	username, _ = getIgnoreCase(connectSetting, "AuthUsername").(string)
+	password, _ = getIgnoreCase(connectSetting, "AuthPassword").(string)
+	return
+}
+
+func getIgnoreCase(tree *toml.Tree, key string) interface{} {
+	for _, treeKey := range tree.Keys() {
+		if strings.EqualFold(treeKey, key) {
+			return tree.Get(treeKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Command antctl controls a running ANT engine through its http api
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const usage = `Usage: antctl [flags] <command> [arguments]
+
+Commands:
+  list [-status s] [-category c] [-name regexp]   list torrents
+  add [-category c] [-path dir] <file|magnet|url|->...
+                                                  add torrents, - reads stdin
+  start <hash>...                                 start downloading
+  stop <hash>...                                  stop downloading
+  delete [-with-data] <hash>...                   delete torrents, files are kept unless -with-data
+  priority <hash> <file-index> <skip|normal|high> change priority of a file
+  settings get [key]                              show settings
+  settings set <key=value>...                     change settings
+  watch [-interval 1s]                            show live progress
+  stats                                           show engine statistics
+
+Hashes may be shortened to any unique prefix.
+
+Flags:
+`
+
+type options struct {
+	host     string
+	username string
+	password string
+	config   string
+	asJSON   bool
+	timeout  time.Duration
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.host, "host", envOr("ANT_HOST", "http://127.0.0.1:8482"), "address of ANT engine (env ANT_HOST)")
+	flag.StringVar(&opts.username, "user", os.Getenv("ANT_USER"), "username for remote engine (env ANT_USER)")
+	flag.StringVar(&opts.password, "password", os.Getenv("ANT_PASSWORD"), "password for remote engine (env ANT_PASSWORD)")
+	flag.StringVar(&opts.config, "config", os.Getenv("ANT_CONFIG"), "config file of a local engine, username and password are read from it if not given (env ANT_CONFIG)")
+	flag.BoolVar(&opts.asJSON, "json", false, "print json instead of tables")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout of each request")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if opts.username == "" && opts.password == "" {
+		opts.username, opts.password = configCredentials(opts.config)
+	}
+	c := newClient(opts.host, opts.username, opts.password, opts.timeout)
+	commands := map[string]func(*client, options, []string) error{
+		"list":     runList,
+		"add":      runAdd,
+		"start":    runStart,
+		"stop":     runStop,
+		"delete":   runDelete,
+		"priority": runPriority,
+		"settings": runSettings,
+		"watch":    runWatch,
+		"stats":    runStats,
+	}
+	command, isExist := commands[flag.Arg(0)]
+	if !isExist {
+		fmt.Fprintf(os.Stderr, "antctl: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := command(c, opts, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "antctl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	activityInterval = 10 * time.Second
+	// Times are saved to db less often, they are saved on cleanup too
+	activitySaveInterval = time.Minute
+)
+
+// activityTracker Accounts durations and last activity of tasks in client
+type activityTracker struct {
+	transferred map[metainfo.Hash]int64
+	lastTick    time.Time
+	lastSave    time.Time
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func (engine *Engine) startActivityTracker() {
+	timeNow := time.Now()
+	engine.activity = &activityTracker{
+		transferred: make(map[metainfo.Hash]int64),
+		lastTick:    timeNow,
+		lastSave:    timeNow,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go func(tracker *activityTracker) {
+		defer close(tracker.done)
+		ticker := time.NewTicker(activityInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				engine.trackActivity(tracker)
+			case <-tracker.stop:
+				return
+			}
+		}
+	}(engine.activity)
+}
+
+// stopActivityTracker Account time since last tick, caller saves the logs then
+func (engine *Engine) stopActivityTracker() {
+	if engine.activity == nil {
+		return
+	}
+	close(engine.activity.stop)
+	<-engine.activity.done
+	engine.trackActivity(engine.activity)
+	engine.activity = nil
+}
+
+func (engine *Engine) trackActivity(tracker *activityTracker) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+
+	timeNow := time.Now()
+	elapsed := int64(timeNow.Sub(tracker.lastTick).Seconds())
+	if elapsed <= 0 {
+		return
+	}
+	tracker.lastTick = tracker.lastTick.Add(time.Duration(elapsed) * time.Second)
+
+	transferred := make(map[metainfo.Hash]int64)
+	for _, torrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		infoHash := torrentLogHash(*torrentLog)
+		singleTorrent, isExist := engine.TorrentEngine.Torrent(infoHash)
+		if !isExist {
+			continue
+		}
+		torrentStats := singleTorrent.Stats()
+		bytesTransferred := torrentStats.BytesReadData.Int64() + torrentStats.BytesWrittenData.Int64()
+		transferred[infoHash] = bytesTransferred
+		if bytesTransferred != tracker.transferred[infoHash] {
+			torrentLog.LastActivity = timeNow
+		}
+
+		isComplete := singleTorrent.Info() != nil && singleTorrent.BytesCompleted() == singleTorrent.Length()
+		if isComplete || torrentStats.ConnectedSeeders > 0 {
+			torrentLog.LastSeenComplete = timeNow
+		}
+		switch torrentLog.Status {
+		case RunningStatus, AnalysingStatus, CompletedStatus:
+			torrentLog.ActiveSeconds += elapsed
+			if isComplete {
+				torrentLog.SeedingSeconds += elapsed
+			} else {
+				torrentLog.DownloadingSeconds += elapsed
+			}
+		}
+	}
+	tracker.transferred = transferred
+
+	if timeNow.Sub(tracker.lastSave) >= activitySaveInterval {
+		tracker.lastSave = timeNow
+		engine.saveInfo()
+	}
+}
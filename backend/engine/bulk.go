@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/types"
+)
+
+// Workers of one bulk operation, moving and deleting data can be slow
+const maxBulkWorkers = 4
+
+type BulkAction string
+
+const (
+	BulkStart       BulkAction = "start"
+	BulkStop        BulkAction = "stop"
+	BulkDelete      BulkAction = "delete"
+	BulkRecheck     BulkAction = "recheck"
+	BulkMove        BulkAction = "move"
+	BulkSetCategory BulkAction = "setCategory"
+	BulkSetPriority BulkAction = "setPriority"
+	BulkSetTags     BulkAction = "setTags"
+)
+
+// TorrentFilter Empty fields match every task
+type TorrentFilter struct {
+	// name of status, like Running
+	Status string
+	// nil matches any category, "" only tasks without one
+	Category *string
+	Tag      string
+	// regular expression on name of task
+	Name string
+}
+
+// BulkRequest Tasks are chosen by Hashes, or by Filter when there is no hash
+type BulkRequest struct {
+	Action BulkAction
+	Hashes []string
+	Filter *TorrentFilter
+	// For delete
+	DeleteFiles bool
+	// For move
+	StoragePath string
+	// For setCategory
+	Category string
+	// For setPriority, 0 skips files, 1 is normal and 2 is high
+	Priority types.PiecePriority
+	// For setTags
+	Tags []string
+}
+
+// BulkResult Result of one task in a bulk operation
+type BulkResult struct {
+	HexString string
+	Success   bool
+	// Action goes on in background, as recheck does, Success only tells it has been started
+	Started bool
+	Error   string
+}
+
+// Matcher Compile filter into a function, name is a regular expression
+func (filter TorrentFilter) Matcher() (func(detail TorrentDetail) bool, error) {
+	var nameRegexp *regexp.Regexp
+	if filter.Name != "" {
+		var err error
+		nameRegexp, err = regexp.Compile(filter.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %v", err)
+		}
+	}
+	return func(detail TorrentDetail) bool {
+		if filter.Status != "" && !strings.EqualFold(detail.StatusName, filter.Status) {
+			return false
+		}
+		if filter.Category != nil && detail.Category != *filter.Category {
+			return false
+		}
+		if filter.Tag != "" && !hasTag(detail.Tags, filter.Tag) {
+			return false
+		}
+		return nameRegexp == nil || nameRegexp.MatchString(detail.Name)
+	}, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, singleTag := range tags {
+		if singleTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (request BulkRequest) validate() error {
+	switch request.Action {
+	case BulkStart, BulkStop, BulkDelete, BulkRecheck, BulkSetCategory, BulkSetTags:
+	case BulkMove:
+		if request.StoragePath == "" || !filepath.IsAbs(request.StoragePath) {
+			return errors.New("move needs an absolute StoragePath")
+		}
+	case BulkSetPriority:
+		if request.Priority > types.PiecePriorityHigh {
+			return fmt.Errorf("priority must be from 0 to %d", types.PiecePriorityHigh)
+		}
+	default:
+		return fmt.Errorf("action %q is not supported", request.Action)
+	}
+	if len(request.Hashes) == 0 && request.Filter == nil {
+		return errors.New("no hashes or filter given")
+	}
+	return nil
+}
+
+// RunBulk Run one action on many tasks concurrently, every task gets its own result
+func (engine *Engine) RunBulk(request BulkRequest) (results []BulkResult, err error) {
+	if err = request.validate(); err != nil {
+		return
+	}
+	hexStrings, results, err := engine.bulkTargets(request)
+	if err != nil {
+		return
+	}
+
+	offset := len(results)
+	results = append(results, make([]BulkResult, len(hexStrings))...)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < maxBulkWorkers && worker < len(hexStrings); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				result := BulkResult{HexString: hexStrings[index], Success: true, Started: request.Action == BulkRecheck}
+				if runErr := engine.bulkOne(request, hexStrings[index]); runErr != nil {
+					result.Success = false
+					result.Started = false
+					result.Error = runErr.Error()
+				}
+				results[offset+index] = result
+			}
+		}()
+	}
+	for index := range hexStrings {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return
+}
+
+// bulkTargets Hashes of tasks to run on, unknown hashes are failed at once
+func (engine *Engine) bulkTargets(request BulkRequest) (hexStrings []string, failed []BulkResult, err error) {
+	if len(request.Hashes) == 0 {
+		match, matchErr := request.Filter.Matcher()
+		if matchErr != nil {
+			return nil, nil, matchErr
+		}
+		for _, detail := range engine.GetTorrentDetails() {
+			if match(detail) {
+				hexStrings = append(hexStrings, detail.HexString)
+			}
+		}
+		return
+	}
+	seen := make(map[string]bool)
+	for _, hexString := range request.Hashes {
+		hexString = strings.ToLower(hexString)
+		if seen[hexString] {
+			continue
+		}
+		seen[hexString] = true
+		if _, isExist := engine.GetTorrentLog(hexString); isExist {
+			hexStrings = append(hexStrings, hexString)
+		} else {
+			failed = append(failed, BulkResult{HexString: hexString, Error: "torrent not found"})
+		}
+	}
+	return
+}
+
+func (engine *Engine) bulkOne(request BulkRequest, hexString string) error {
+	var done bool
+	switch request.Action {
+	case BulkRecheck:
+		return engine.RecheckOneTorrent(hexString)
+	case BulkMove:
+		return engine.MoveOneTorrent(hexString, request.StoragePath)
+	case BulkDelete:
+		done = engine.DelOneTorrent(hexString, request.DeleteFiles)
+	case BulkStart:
+		done = engine.StartDownloadTorrent(hexString)
+	case BulkStop:
+		done = engine.StopOneTorrent(hexString)
+	case BulkSetCategory:
+		done = engine.SetCategory(hexString, request.Category)
+	case BulkSetPriority:
+		done = engine.SetTorrentPriority(hexString, request.Priority)
+	case BulkSetTags:
+		done = engine.SetTags(hexString, request.Tags)
+	}
+	if !done {
+		return fmt.Errorf("unable to %s torrent now", request.Action)
+	}
+	return nil
+}
+
+// StopAllTorrents Pause every running task
+func (engine *Engine) StopAllTorrents() ([]BulkResult, error) {
+	return engine.runOnStatus(BulkStop, RunningStatus)
+}
+
+// StartAllTorrents Resume every stopped or queued task
+func (engine *Engine) StartAllTorrents() ([]BulkResult, error) {
+	return engine.runOnStatus(BulkStart, StoppedStatus, QueuedStatus)
+}
+
+func (engine *Engine) runOnStatus(action BulkAction, statuses ...TorrentStatus) ([]BulkResult, error) {
+	request := BulkRequest{Action: action}
+	for _, detail := range engine.GetTorrentDetails() {
+		for _, status := range statuses {
+			if detail.Status == status {
+				request.Hashes = append(request.Hashes, detail.HexString)
+			}
+		}
+	}
+	if len(request.Hashes) == 0 {
+		return []BulkResult{}, nil
+	}
+	return engine.RunBulk(request)
+}
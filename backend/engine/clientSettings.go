@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// newClient Create torrent client from current settings. The client gets its own copy of
+// config, so settings can be reloaded while it runs
+func (engine *Engine) newClient() (*torrent.Client, error) {
+	clientConfig := engine.config.Current().EngineSetting.TorrentConfig
+	clientConfig.UploadRateLimiter = copyLimiter(clientConfig.UploadRateLimiter)
+	clientConfig.DownloadRateLimiter = copyLimiter(clientConfig.DownloadRateLimiter)
+	clientConfig.HTTPProxy = engine.httpProxy
+	// listen port is mapped by startPortMapping, which renews and removes mappings
+	clientConfig.NoDefaultPortForwarding = true
+	proxyPeers := engine.useProxy(&clientConfig)
+	binding := engine.bindInterface(&clientConfig)
+	engine.updateProxy()
+	return engine.startClient(&clientConfig, binding, proxyPeers)
+}
+
+// startClient Create torrent client from a prepared config, which becomes config of current client
+func (engine *Engine) startClient(clientConfig *torrent.ClientConfig, binding *interfaceBinding, proxyPeers bool) (*torrent.Client, error) {
+	engine.clientConfig = clientConfig
+	engine.binding.Store(binding)
+	engine.proxyPeers = proxyPeers
+	client, err := torrent.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	if proxyPeers {
+		client.AddDialer(proxyDialer{engine: engine})
+	}
+	if binding != nil {
+		if err = binding.listen(client, clientConfig.ListenPort); err != nil {
+			binding.close()
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// restoreClient Create a client like the closed one again, after a client of new settings failed.
+// Its port may be taken meanwhile, then any free port is used
+func (engine *Engine) restoreClient(clientConfig *torrent.ClientConfig, binding *interfaceBinding, proxyOnly bool, proxyPeers bool) (client *torrent.Client, err error) {
+	engine.proxyOnly = proxyOnly
+	client, err = engine.startClient(clientConfig, binding, proxyPeers)
+	if err != nil && clientConfig.ListenPort != 0 {
+		engine.logger.WithFields(log.Fields{"Error": err}).Warn("Listen port of previous client is taken, a free one is used")
+		anyPort := *clientConfig
+		anyPort.ListenPort = 0
+		client, err = engine.startClient(&anyPort, binding, proxyPeers)
+	}
+	return
+}
+
+// Client Current torrent client, it is replaced when client is restarted
+func (engine *Engine) Client() *torrent.Client {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.TorrentEngine
+}
+
+func copyLimiter(limiter *rate.Limiter) *rate.Limiter {
+	return rate.NewLimiter(limiter.Limit(), limiter.Burst())
+}
+
+// ApplyLiveSettings Make the running client and tasks follow settings which need no new client:
+// trackers, connections of a task, rate limits and proxy url.
+// Logging level is taken by logger when settings are loaded
+func (engine *Engine) ApplyLiveSettings() {
+	engine.updateProxy()
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	config := engine.config.Current()
+	for _, limiters := range [][2]*rate.Limiter{
+		{engine.clientConfig.UploadRateLimiter, config.TorrentConfig.UploadRateLimiter},
+		{engine.clientConfig.DownloadRateLimiter, config.TorrentConfig.DownloadRateLimiter},
+	} {
+		limiters[0].SetBurst(limiters[1].Burst())
+		limiters[0].SetLimit(limiters[1].Limit())
+	}
+	for _, singleTorrent := range engine.TorrentEngine.Torrents() {
+		engine.addTrackers(singleTorrent, config.DefaultTrackers)
+		singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+		if isExist && singleTorrentLog.Status == RunningStatus {
+			singleTorrent.SetMaxEstablishedConns(config.EngineSetting.MaxEstablishedConns)
+		}
+	}
+	engine.logger.Info("Live settings applied to running tasks")
+}
+
+// RestartClient Replace torrent client by one created from current settings, for settings which
+// are only read when a client starts, such as listen port and interface, IPv4/IPv6 and DHT.
+// Tasks keep their status and storage, TorrentDB and RSS keep running. If a client can not be
+// created from new settings, tasks go on in a client of previous ones and the error is returned
+func (engine *Engine) RestartClient() error {
+	engine.restartLock.Lock()
+	defer engine.restartLock.Unlock()
+	engine.logger.Info("Restart torrent client")
+	engine.stopActivityTracker()
+	defer engine.startActivityTracker()
+	engine.stopPortMapping()
+	defer engine.startPortMapping()
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+
+	oldClient := engine.TorrentEngine
+	var inClient, magnets []*TorrentLog
+	// trackers of magnet links are only known to old client until info is got
+	magnetTrackers := make(map[metainfo.Hash][][]string)
+	for _, singleTorrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		infoHash := torrentLogHash(*singleTorrentLog)
+		if singleTorrentLog.Status == AnalysingStatus {
+			if extendLog, isExist := engine.EngineRunningInfo.TorrentLogExtends[infoHash]; isExist && extendLog.HasMagnetChan {
+				// goroutine waiting for info of magnet quits, a new one waits on new client
+				extendLog.MagnetAnalyseChan <- true
+				delete(engine.EngineRunningInfo.TorrentLogExtends, infoHash)
+			}
+			if oldTorrent, isExist := oldClient.Torrent(infoHash); isExist {
+				oldMetaInfo := oldTorrent.Metainfo()
+				magnetTrackers[infoHash] = oldMetaInfo.UpvertedAnnounceList()
+			}
+			magnets = append(magnets, singleTorrentLog)
+			continue
+		}
+		if _, isExist := oldClient.Torrent(infoHash); isExist {
+			// WaitForCompleted of task quits, it is started again on new client
+			engine.closeStatusPub(infoHash)
+			inClient = append(inClient, singleTorrentLog)
+		}
+	}
+	// the new client usually needs the port of old one, so old one is closed first
+	oldConfig, oldBinding, oldProxyOnly, oldProxyPeers := engine.clientConfig, engine.interfaceBinding(), engine.proxyOnly, engine.proxyPeers
+	oldClient.Close()
+	if oldBinding != nil {
+		oldBinding.close()
+	}
+
+	newClient, err := engine.newClient()
+	if err != nil {
+		engine.logger.WithFields(log.Fields{"Error": err}).Error("Failed to restart torrent client, previous settings are used")
+		var restoreErr error
+		newClient, restoreErr = engine.restoreClient(oldConfig, oldBinding, oldProxyOnly, oldProxyPeers)
+		if restoreErr != nil {
+			// nothing is left to run tasks with, the closed client stays until next restart
+			engine.logger.WithFields(log.Fields{"Error": restoreErr}).Error("Failed to restore torrent client")
+			return fmt.Errorf("%v, previous client can not be restored: %v", err, restoreErr)
+		}
+	}
+	engine.TorrentEngine = newClient
+	config := engine.config.Current()
+	// byte counters start from zero again
+	engine.rates.forget(nil)
+
+	for _, singleTorrentLog := range inClient {
+		singleTorrent, addErr := engine.addTorrentToClient(&singleTorrentLog.MetaInfo, singleTorrentLog.StoragePath)
+		if addErr != nil {
+			engine.logger.WithFields(log.Fields{"Error": addErr}).Errorf("Failed to add torrent %q to new client", singleTorrentLog.TorrentName)
+			engine.fireEvent(EventError, *singleTorrentLog, addErr.Error())
+			continue
+		}
+		engine.addTrackers(singleTorrent, config.DefaultTrackers)
+		engine.watchWriteErrors(singleTorrent)
+		switch singleTorrentLog.Status {
+		case RunningStatus:
+			singleTorrent.SetMaxEstablishedConns(config.EngineSetting.MaxEstablishedConns)
+			engine.checkExtend(singleTorrent)
+			engine.WaitForCompleted(singleTorrent)
+			singleTorrent.DownloadAll()
+		case StoppedStatus:
+			singleTorrent.SetMaxEstablishedConns(0)
+		}
+	}
+	for _, singleTorrentLog := range magnets {
+		infoHash := torrentLogHash(*singleTorrentLog)
+		engine.EngineRunningInfo.TorrentLogExtends[infoHash] = &TorrentLogExtend{
+			HasMagnetChan:     true,
+			MagnetAnalyseChan: make(chan bool, 100),
+		}
+		tmpTorrent, _ := engine.TorrentEngine.AddTorrentInfoHashWithStorage(infoHash, engine.getStorage(singleTorrentLog.StoragePath))
+		engine.addTrackers(tmpTorrent, magnetTrackers[infoHash])
+		engine.addTrackers(tmpTorrent, config.DefaultTrackers)
+		engine.resolveMagnet(tmpTorrent, singleTorrentLog)
+	}
+	engine.updateInfo()
+	if err != nil {
+		return err
+	}
+	engine.logger.WithFields(log.Fields{"Tasks": len(inClient), "Magnets": len(magnets)}).Info("Torrent client restarted")
+	return nil
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestRestartClientKeepsWorkingClient(t *testing.T) {
+	engine := newTestEngine(t)
+	torrentMetaInfo := testMetaInfo(t, "task")
+	tmpTorrent, err := engine.AddOneTorrentFromInfoHashWithOptions(&torrentMetaInfo, AddOptions{Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a port taken by someone else, new client can not listen on it
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	engine.config.TorrentConfig.ListenPort = listener.Addr().(*net.TCPAddr).Port
+
+	if err = engine.RestartClient(); err == nil {
+		t.Fatal("client restarted on a taken port")
+	}
+	client := engine.Client()
+	select {
+	case <-client.Closed():
+		t.Fatal("closed client is left installed")
+	default:
+	}
+	if _, isExist := client.Torrent(tmpTorrent.InfoHash()); !isExist {
+		t.Error("task is not in restored client")
+	}
+	if torrentLog, _ := engine.GetTorrentLog(tmpTorrent.InfoHash().HexString()); torrentLog.Status != StoppedStatus {
+		t.Errorf("task has status %d after restore, want stopped", torrentLog.Status)
+	}
+
+	// and it restarts once settings are fixed
+	engine.config.TorrentConfig.ListenPort = 0
+	if err = engine.RestartClient(); err != nil {
+		t.Fatal(err)
+	}
+	if _, isExist := engine.Client().Torrent(tmpTorrent.InfoHash()); !isExist {
+		t.Error("task is not in new client")
+	}
+}
+
+func TestRestartClientKeepsMagnetTrackers(t *testing.T) {
+	engine := newTestEngine(t)
+	const tracker = "http://tracker.invalid/announce"
+	tmpTorrent, err := engine.AddOneTorrentFromMagnetWithOptions(testMagnet(0)+"&tr="+url.QueryEscape(tracker), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoHash := tmpTorrent.InfoHash()
+
+	if err = engine.RestartClient(); err != nil {
+		t.Fatal(err)
+	}
+	newTorrent, isExist := engine.Client().Torrent(infoHash)
+	if !isExist || newTorrent == tmpTorrent {
+		t.Fatal("magnet is not added to new client")
+	}
+	newMetaInfo := newTorrent.Metainfo()
+	for _, tier := range newMetaInfo.UpvertedAnnounceList() {
+		for _, announce := range tier {
+			if announce == tracker {
+				return
+			}
+		}
+	}
+	t.Errorf("trackers after restart %v, want %s", newMetaInfo.UpvertedAnnounceList(), tracker)
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
+)
+
+// TorrentDetail State of one task in plain numbers, for api of other clients
+// TorrentWebInfo is made for showing in the website and keeps sizes as text
+type TorrentDetail struct {
+	HexString   string
+	Name        string
+	Status      TorrentStatus
+	StatusName  string
+	StoragePath string
+	Category    string
+	Tags        []string
+	MagnetLink  string
+	// Task is loaded in torrent client, completed tasks may only exist in log
+	InClient bool
+	HasInfo  bool
+
+	TotalLength     int64
+	BytesCompleted  int64
+	BytesDownloaded int64
+	BytesUploaded   int64
+	DownloadRate    float64
+	UploadRate      float64
+
+	TotalPeers       int
+	ActivePeers      int
+	ConnectedSeeders int
+	PieceLength      int64
+	PieceCount       int
+	PiecesComplete   int
+	Files            []FileDetail
+	TorrentTimes
+}
+
+type FileDetail struct {
+	Path           string
+	Length         int64
+	BytesCompleted int64
+	Priority       types.PiecePriority
+}
+
+// Magnet hash is stored in torrentName until its info is got
+func torrentLogHash(torrentLog TorrentLog) (infoHash metainfo.Hash) {
+	if torrentLog.Status == AnalysingStatus {
+		_ = infoHash.FromHexString(torrentLog.TorrentName)
+		return
+	}
+	return torrentLog.HashInfoBytes()
+}
+
+// GetTorrentDetails returns every task of engine, in the order they were added
+func (engine *Engine) GetTorrentDetails() (details []TorrentDetail) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	for _, singleTorrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		details = append(details, engine.torrentDetail(*singleTorrentLog))
+	}
+	return
+}
+
+// GetTorrentDetail hexString may also be hash of a magnet still being analysed
+func (engine *Engine) GetTorrentDetail(hexString string) (detail TorrentDetail, isExist bool) {
+	infoHash := metainfo.Hash{}
+	if err := infoHash.FromHexString(hexString); err != nil {
+		return
+	}
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	if singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[infoHash]; isExist {
+		return engine.torrentDetail(*singleTorrentLog), true
+	}
+	return
+}
+
+func (engine *Engine) torrentDetail(torrentLog TorrentLog) (detail TorrentDetail) {
+	infoHash := torrentLogHash(torrentLog)
+	detail = TorrentDetail{
+		HexString:    infoHash.HexString(),
+		Name:         torrentLog.TorrentName,
+		Status:       torrentLog.Status,
+		StatusName:   StatusIDToName[torrentLog.Status],
+		StoragePath:  torrentLog.StoragePath,
+		Category:     torrentLog.Category,
+		Tags:         torrentLog.Tags,
+		TorrentTimes: torrentLog.TorrentTimes,
+	}
+	if torrentLog.Status == AnalysingStatus {
+		detail.MagnetLink = metainfo.Magnet{InfoHash: infoHash}.String()
+	} else {
+		info, err := torrentLog.UnmarshalInfo()
+		if err == nil {
+			detail.MagnetLink = torrentLog.Magnet(&infoHash, &info).String()
+			detail.HasInfo = true
+			detail.TotalLength = info.TotalLength()
+			detail.PieceLength = info.PieceLength
+			detail.PieceCount = info.NumPieces()
+		}
+		// Completed tasks keep no state in client, their files are assumed to be done
+		if torrentLog.Status == CompletedStatus {
+			detail.BytesCompleted = detail.TotalLength
+			detail.PiecesComplete = detail.PieceCount
+			for _, file := range info.UpvertedFiles() {
+				detail.Files = append(detail.Files, FileDetail{
+					Path:           file.DisplayPath(&info),
+					Length:         file.Length,
+					BytesCompleted: file.Length,
+					Priority:       types.PiecePriorityNormal,
+				})
+			}
+		}
+	}
+
+	singleTorrent, isExist := engine.TorrentEngine.Torrent(infoHash)
+	if !isExist {
+		return
+	}
+	detail.InClient = true
+	engine.fillTorrentDetail(&detail, singleTorrent)
+	return
+}
+
+func (engine *Engine) fillTorrentDetail(detail *TorrentDetail, singleTorrent *torrent.Torrent) {
+	rate := engine.torrentRate(singleTorrent)
+	torrentStats := singleTorrent.Stats()
+	detail.BytesDownloaded = rate.BytesDownloaded
+	detail.BytesUploaded = rate.BytesUploaded
+	detail.DownloadRate = rate.DownloadRate
+	detail.UploadRate = rate.UploadRate
+	detail.TotalPeers = torrentStats.TotalPeers
+	detail.ActivePeers = torrentStats.ActivePeers
+	detail.ConnectedSeeders = torrentStats.ConnectedSeeders
+	if singleTorrent.Info() == nil {
+		return
+	}
+	detail.HasInfo = true
+	detail.TotalLength = singleTorrent.Length()
+	detail.BytesCompleted = singleTorrent.BytesCompleted()
+	detail.PiecesComplete = torrentStats.PiecesComplete
+	detail.Files = nil
+	for _, file := range singleTorrent.Files() {
+		detail.Files = append(detail.Files, FileDetail{
+			Path:           file.Path(),
+			Length:         file.Length(),
+			BytesCompleted: file.BytesCompleted(),
+			Priority:       file.Priority(),
+		})
+	}
+}
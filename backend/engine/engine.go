@@ -1,12 +1,17 @@
 package engine
 
 import (
+	"context"
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
 	"github.com/anatasluo/ant/backend/setting"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 type Engine struct {
@@ -14,134 +19,241 @@ type Engine struct {
 	TorrentDB         *TorrentDB
 	WebInfo           *WebviewInfo
 	EngineRunningInfo *RunningInfo
+	RSS               *RSSManager
+
+	// file storages for tasks saved outside of DataDir, keyed by absolute path
+	storages    map[string]storage.ClientImplCloser
+	storageLock sync.Mutex
+	events      eventBroker
+	hooks       hookWorker
+	// stateLock guards EngineRunningInfo and WebInfo. Exported methods take it,
+	// their unexported twins like stopOneTorrent expect caller to hold it.
+	// Waiting for info, verifying data and touching files are done without it.
+	stateLock sync.Mutex
+	activity  *activityTracker
+	rates     rateSampler
+
+	config *setting.ClientSetting
+	// Copy of TorrentConfig the client runs with, reloading settings does not touch it
+	clientConfig *torrent.ClientConfig
+	// *url.URL of proxy for http trackers and web seeds, nil for none
+	proxyURL atomic.Value
+	// Fetches everything engine gets over http apart from trackers and web seeds, see newHTTPClient
+	httpClient *http.Client
+	// Client runs in proxy-only mode, set when client is created
+	proxyOnly bool
+	// Client dials TCP peers through proxy, set when client is created
+	proxyPeers bool
+	// *interfaceBinding of client, nil if ListenInterface is not set
+	binding atomic.Value
+	// Tasks paused because ListenInterface is gone, they are resumed when it is back
+	interfacePaused []string
+	interfaceWatch  *interfaceWatcher
+	// RestartClient is called by settings and interface watcher
+	restartLock sync.Mutex
+	// Maps listen port of current client on gateway
+	portMapper *portMapper
+	logger     *log.Logger
 }
 
-var (
-	onlyEngine     Engine
-	onlyEngineOnce sync.Once
-	clientConfig   = setting.GetClientSetting()
-	logger         = clientConfig.LoggerSetting.Logger
-)
+// New Create an engine from config and recover tasks from its TorrentDB,
+// several engines can run in one process as long as their paths and ports differ.
+// This could be slow if there are a lot of torrents to be recovered
+func New(config *setting.ClientSetting) (*Engine, error) {
+	engine := &Engine{
+		config: config,
+		logger: config.LoggerSetting.Logger,
+	}
+	engine.events.logger = engine.logger
+	engine.httpClient = engine.newHTTPClient()
+	err := engine.initAndRunEngine()
+	if err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
 
-func GetEngine() *Engine {
-	onlyEngineOnce.Do(func() {
-		onlyEngine.initAndRunEngine()
-	})
-	return &onlyEngine
+// Config Settings this engine was created with
+func (engine *Engine) Config() *setting.ClientSetting {
+	return engine.config
 }
 
-// this could be slow if there are a lot of torrents to be recovered
-func (engine *Engine) initAndRunEngine() {
-	engine.TorrentDB = GetTorrentDB(clientConfig.EngineSetting.TorrentDBPath)
+func (engine *Engine) initAndRunEngine() error {
+	torrentDB, err := OpenTorrentDB(engine.config.EngineSetting.TorrentDBPath, engine.logger)
+	if err != nil {
+		return err
+	}
 
-	var tmpErr error
-	engine.TorrentEngine, tmpErr = torrent.NewClient(&clientConfig.EngineSetting.TorrentConfig)
-	if tmpErr != nil {
-		logger.WithFields(log.Fields{"Error": tmpErr}).Error("Failed to Created torrent engine")
+	torrentEngine, err := engine.newClient()
+	if err != nil {
+		engine.logger.WithFields(log.Fields{"Error": err}).Error("Failed to Created torrent engine")
+		_ = torrentDB.Cleanup()
+		return err
 	}
+	engine.TorrentDB = torrentDB
+	engine.TorrentEngine = torrentEngine
+	// proxy of settings is known once client is created
+	engine.config.SetHTTPClient(engine.httpClient)
 
 	engine.WebInfo = &WebviewInfo{}
 	engine.WebInfo.HashToTorrentWebInfo = make(map[metainfo.Hash]*TorrentWebInfo)
 
 	engine.EngineRunningInfo = &RunningInfo{}
-	engine.EngineRunningInfo.init()
+	engine.EngineRunningInfo.init(engine.config)
+	engine.storages = make(map[string]storage.ClientImplCloser)
+	engine.rates = rateSampler{samples: make(map[string]rateSample)}
+	engine.startHookWorker()
 
 	// recover from storm database
 	engine.setEnvironment()
+
+	engine.RSS = newRSSManager(engine)
+	if engine.config.RSSSetting.EnableRSS {
+		engine.RSS.Start()
+	}
+	engine.startActivityTracker()
+	engine.startInterfaceWatch()
+	engine.startPortMapping()
+	return nil
+}
+
+// Storage for a task, nil means the default storage of client (DataDir)
+func (engine *Engine) getStorage(storagePath string) storage.ClientImpl {
+	defaultPath, err := filepath.Abs(engine.clientConfig.DataDir)
+	if storagePath == "" || (err == nil && storagePath == defaultPath) {
+		return nil
+	}
+	engine.storageLock.Lock()
+	defer engine.storageLock.Unlock()
+	pathStorage, isExist := engine.storages[storagePath]
+	if !isExist {
+		_ = os.MkdirAll(storagePath, 0755)
+		pathStorage = storage.NewFile(storagePath)
+		engine.storages[storagePath] = pathStorage
+	}
+	return pathStorage
+}
+
+func (engine *Engine) addTorrentToClient(torrentMetaInfo *metainfo.MetaInfo, storagePath string) (*torrent.Torrent, error) {
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(torrentMetaInfo)
+	if err != nil {
+		return nil, err
+	}
+	spec.Storage = engine.getStorage(storagePath)
+	spec.Trackers = engine.trackers(spec.Trackers)
+	singleTorrent, _, err := engine.TorrentEngine.AddTorrentSpec(spec)
+	return singleTorrent, err
 }
 
 func (engine *Engine) setEnvironment() {
-	engine.TorrentDB.GetLogs(&engine.EngineRunningInfo.TorrentLogsAndID)
+	var torrentLogs TorrentLogsAndID
+	engine.TorrentDB.GetLogs(&torrentLogs)
+	engine.stateLock.Lock()
+	for index := range torrentLogs.TorrentLogs {
+		engine.EngineRunningInfo.TorrentLogs = append(engine.EngineRunningInfo.TorrentLogs, &torrentLogs.TorrentLogs[index])
+	}
 	engine.EngineRunningInfo.UpdateTorrentLog()
-	logger.Infof("Number of torrent(s) in db: %d", len(engine.EngineRunningInfo.TorrentLogs))
+	engine.logger.Infof("Number of torrent(s) in db: %d", len(torrentLogs.TorrentLogs))
 	var wg sync.WaitGroup
-	for i, singleLog := range engine.EngineRunningInfo.TorrentLogs {
-		switch singleLog.Status {
+	for _, aimLog := range engine.EngineRunningInfo.TorrentLogs {
+		switch aimLog.Status {
 		case CompletedStatus:
 		default:
 			// 把未完成的种子添加到下载队列中，初始状态为Stopped
 			wg.Add(1)
-			go func(i int, singleLog TorrentLog) {
-				logger.Infof("setEnvironment: adding torrent %v(%v) on %q to queue",
+			go func(aimLog *TorrentLog, singleLog TorrentLog) {
+				engine.logger.Infof("setEnvironment: adding torrent %v(%v) on %q to queue",
 					singleLog.TorrentName,
 					singleLog.MetaInfo.HashInfoBytes(),
 					singleLog.StoragePath)
 				defer wg.Done()
-				t, tmpErr := engine.TorrentEngine.AddTorrent(&singleLog.MetaInfo)
+				t, tmpErr := engine.addTorrentToClient(&singleLog.MetaInfo, singleLog.StoragePath)
 				if tmpErr != nil {
-					logger.WithFields(log.Fields{"Error": tmpErr}).Infof("Failed to add torrent %q to client", singleLog.TorrentName)
+					engine.logger.WithFields(log.Fields{"Error": tmpErr}).Infof("Failed to add torrent %q to client", singleLog.TorrentName)
+					engine.fireEvent(EventError, singleLog, tmpErr.Error())
+					return
+				}
+				config := engine.config.Current()
+				engine.addTrackers(t, config.DefaultTrackers)
+				t.SetMaxEstablishedConns(config.EngineSetting.MaxEstablishedConns)
+				engine.stateLock.Lock()
+				if engine.EngineRunningInfo.HashToTorrentLog[t.InfoHash()] != aimLog {
+					// task has been deleted meanwhile
+					t.Drop()
+					engine.stateLock.Unlock()
 					return
 				}
-				t.AddTrackers(clientConfig.DefaultTrackers)
-				t.SetMaxEstablishedConns(clientConfig.EngineSetting.MaxEstablishedConns)
 				engine.checkExtend(t)
-				engine.EngineRunningInfo.TorrentLogs[i].Status = RunningStatus
+				engine.watchWriteErrors(t)
+				aimLog.Status = RunningStatus
 				engine.WaitForCompleted(t)
 				t.DownloadAll()
-				logger.Infof("added %s to engine", singleLog.TorrentName)
-			}(i, singleLog)
+				engine.stateLock.Unlock()
+				engine.logger.Infof("added %s to engine", singleLog.TorrentName)
+			}(aimLog, *aimLog)
 		}
 	}
+	engine.stateLock.Unlock()
 	go func() {
 		wg.Wait()
-		if len(engine.EngineRunningInfo.TorrentLogs) > 0 {
-			logger.Info("all torrents from TorrentDB loaded")
+		if len(torrentLogs.TorrentLogs) > 0 {
+			engine.logger.Info("all torrents from TorrentDB loaded")
 		}
 		engine.UpdateInfo()
 	}()
 }
 
-func (engine *Engine) Restart() {
-
-	logger.Info("Restart engine")
+// SaveInfo Save all tasks in one transaction, saveTorrentLog is enough when only one is changed
+func (engine *Engine) SaveInfo() {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	engine.saveInfo()
+}
 
-	//To handle problems caused by change of settings
-	for index := range engine.EngineRunningInfo.TorrentLogs {
-		if engine.EngineRunningInfo.TorrentLogs[index].Status != CompletedStatus && engine.EngineRunningInfo.TorrentLogs[index].StoragePath != clientConfig.TorrentConfig.DataDir {
-			filePath := filepath.Join(engine.EngineRunningInfo.TorrentLogs[index].StoragePath, engine.EngineRunningInfo.TorrentLogs[index].TorrentName)
-			log.WithFields(log.Fields{"Path": filePath}).Info("To restart engine, these unfinished files will be deleted")
-			singleTorrent, torrentExist := engine.GetOneTorrent(engine.EngineRunningInfo.TorrentLogs[index].HashInfoBytes().HexString())
-			if torrentExist {
-				singleTorrent.Drop()
-			}
-			engine.EngineRunningInfo.TorrentLogs[index].StoragePath = clientConfig.TorrentConfig.DataDir
-			engine.UpdateInfo()
-			delFiles(filePath)
-		}
+func (engine *Engine) saveInfo() error {
+	tmpErr := engine.TorrentDB.SaveLogs(engine.EngineRunningInfo.TorrentLogs)
+	if tmpErr != nil {
+		engine.logger.WithFields(log.Fields{"Error": tmpErr}).Error("Failed to save torrent queues")
 	}
-	engine.Cleanup()
-	GetEngine()
-
+	return tmpErr
 }
 
-func (engine *Engine) SaveInfo() {
-	tmpErr := engine.TorrentDB.DB.Save(&engine.EngineRunningInfo.TorrentLogsAndID)
+func (engine *Engine) saveTorrentLog(torrentLog *TorrentLog) {
+	tmpErr := engine.TorrentDB.SaveLog(torrentLog)
 	if tmpErr != nil {
-		logger.WithFields(log.Fields{"Error": tmpErr}).Fatal("Failed to save torrent queues")
+		engine.logger.WithFields(log.Fields{"Error": tmpErr, "Hash": torrentLog.HexString}).Error("Failed to save torrent")
 	}
 }
 
-func (engine *Engine) Cleanup() {
-	engine.UpdateInfo()
+// Cleanup Stop polling feeds, remove port mappings, stop tasks, save them to TorrentDB, then close torrent client and TorrentDB in this order.
+// The error tells whether tasks could not be saved or TorrentDB not be closed, they are logged too
+func (engine *Engine) Cleanup() error {
+	// no task may be added by a poll once tasks are saved
+	engine.RSS.Stop()
+	engine.stopInterfaceWatch()
+	engine.stopPortMapping()
+	engine.stopActivityTracker()
+	engine.stateLock.Lock()
+	engine.updateInfo()
 
-	for index := range engine.EngineRunningInfo.TorrentLogs {
-		status := engine.EngineRunningInfo.TorrentLogs[index].Status
+	for _, singleTorrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		status := singleTorrentLog.Status
 		if status != CompletedStatus {
 			switch status {
 			case AnalysingStatus:
-				aimLog := engine.EngineRunningInfo.TorrentLogs[index]
 				torrentHash := metainfo.Hash{}
-				_ = torrentHash.FromHexString(aimLog.TorrentName)
+				_ = torrentHash.FromHexString(singleTorrentLog.TorrentName)
 				magnetTorrent, isExist := engine.TorrentEngine.Torrent(torrentHash)
 				if isExist {
-					logger.Info("One magnet will be deleted " + magnetTorrent.String())
+					engine.logger.Info("One magnet will be deleted " + magnetTorrent.String())
 					magnetTorrent.Drop()
 				}
+				engine.TorrentDB.DeleteLog(singleTorrentLog.HexString)
 			case RunningStatus:
-				engine.StopOneTorrent(engine.EngineRunningInfo.TorrentLogs[index].HashInfoBytes().HexString())
-				//engine.EngineRunningInfo.TorrentLogs[index].Status = StoppedStatus
+				engine.stopOneTorrent(singleTorrentLog.HashInfoBytes().HexString())
+				//singleTorrentLog.Status = StoppedStatus
 			case QueuedStatus:
-				//engine.EngineRunningInfo.TorrentLogs[index].Status = StoppedStatus
+				//singleTorrentLog.Status = StoppedStatus
 			}
 		}
 	}
@@ -150,16 +262,48 @@ func (engine *Engine) Cleanup() {
 	tmpLogs := engine.EngineRunningInfo.TorrentLogs
 	engine.EngineRunningInfo.TorrentLogs = nil
 
-	for index := range tmpLogs {
-		if tmpLogs[index].Status != AnalysingStatus {
-			engine.EngineRunningInfo.TorrentLogs = append(engine.EngineRunningInfo.TorrentLogs, tmpLogs[index])
+	for _, singleTorrentLog := range tmpLogs {
+		if singleTorrentLog.Status != AnalysingStatus {
+			engine.EngineRunningInfo.TorrentLogs = append(engine.EngineRunningInfo.TorrentLogs, singleTorrentLog)
 		}
 	}
+	engine.EngineRunningInfo.UpdateTorrentLog()
 
-	engine.SaveInfo()
+	saveErr := engine.saveInfo()
+	engine.stateLock.Unlock()
 
 	engine.TorrentEngine.Close()
-	engine.TorrentDB.Cleanup()
+	if binding := engine.interfaceBinding(); binding != nil {
+		binding.close()
+	}
+	engine.storageLock.Lock()
+	for storagePath, pathStorage := range engine.storages {
+		if err := pathStorage.Close(); err != nil {
+			engine.logger.WithFields(log.Fields{"Error": err, "Path": storagePath}).Error("Failed to close storage")
+		}
+	}
+	engine.storages = make(map[string]storage.ClientImplCloser)
+	engine.storageLock.Unlock()
+	engine.stopHookWorker()
+	closeErr := engine.TorrentDB.Cleanup()
+	if saveErr != nil {
+		return saveErr
+	}
+	return closeErr
+}
+
+// Shutdown Cleanup which gives up waiting when ctx is done, the engine must not be used afterwards
+func (engine *Engine) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- engine.Cleanup()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (engine *Engine) checkExtend(singleTorrent *torrent.Torrent) {
@@ -172,7 +316,7 @@ func (engine *Engine) checkExtend(singleTorrent *torrent.Torrent) {
 			HasMagnetChan: false,
 		}
 	} else if extendIsExist && !engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()].HasStatusPub {
-		logger.Debug("it has extend but no status pub")
+		engine.logger.Debug("it has extend but no status pub")
 		engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()].HasStatusPub = true
 		engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()].StatusPub = singleTorrent.SubscribePieceStateChanges()
 	}
@@ -1,49 +1,106 @@
 package engine
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
 	log "github.com/sirupsen/logrus"
 )
 
-func (engine *Engine) AddOneTorrentFromFile(filepathAbs string) (tmpTorrent *torrent.Torrent, err error) {
+const maxTorrentFileSize = 10 << 20
+
+func (engine *Engine) AddOneTorrentFromFile(filepathAbs string, options AddOptions) (tmpTorrent *torrent.Torrent, err error) {
 	torrentMetaInfo, err := metainfo.LoadFromFile(filepathAbs)
 	if err == nil {
-		return engine.AddOneTorrentFromInfoHash(torrentMetaInfo)
+		return engine.AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo, options)
 	}
 	return tmpTorrent, err
 }
 
 func (engine *Engine) AddOneTorrentFromInfoHash(torrentMetaInfo *metainfo.MetaInfo) (tmpTorrent *torrent.Torrent, err error) {
+	return engine.AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo, AddOptions{})
+}
+
+func (engine *Engine) AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo *metainfo.MetaInfo, options AddOptions) (tmpTorrent *torrent.Torrent, err error) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
 	//To solve problem of different variable scope
 	needMoreOperation := false
 	tmpTorrent, needMoreOperation = engine.checkOneHash(torrentMetaInfo.HashInfoBytes())
 	if needMoreOperation {
-		tmpTorrent, err = engine.TorrentEngine.AddTorrent(torrentMetaInfo)
-		engine.EngineRunningInfo.AddOneTorrent(tmpTorrent)
-		engine.SaveInfo()
+		storagePath, _ := engine.EngineRunningInfo.storagePath(options)
+		tmpTorrent, err = engine.addTorrentToClient(torrentMetaInfo, storagePath)
+		if err != nil {
+			return
+		}
+		singleTorrentLog := engine.EngineRunningInfo.AddOneTorrent(tmpTorrent, options)
+		engine.saveTorrentLog(singleTorrentLog)
+		engine.fireEvent(EventAdded, *singleTorrentLog, "")
+		if options.Paused && !engine.stopOneTorrent(tmpTorrent.InfoHash().HexString()) {
+			err = fmt.Errorf("unable to pause task %s", tmpTorrent.InfoHash().HexString())
+		}
 	}
 	return tmpTorrent, err
 }
 
+// AddOneTorrentFromURL support 'magnet:', 'infohash:' and http(s) links to a torrent file
+func (engine *Engine) AddOneTorrentFromURL(linkAddress string, options AddOptions) (tmpTorrent *torrent.Torrent, err error) {
+	if !strings.HasPrefix(linkAddress, "http://") && !strings.HasPrefix(linkAddress, "https://") {
+		return engine.AddOneTorrentFromMagnetWithOptions(linkAddress, options)
+	}
+	resp, err := engine.httpClient.Get(linkAddress)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// Some indexers answer a torrent link with a redirect to magnet
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location := resp.Header.Get("Location")
+		if strings.HasPrefix(location, "magnet:") {
+			return engine.AddOneTorrentFromMagnetWithOptions(location, options)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %q while fetching torrent", resp.Status)
+		return
+	}
+	torrentMetaInfo, err := metainfo.Load(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return
+	}
+	tmpTorrent, err = engine.AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo, options)
+	if err == nil && tmpTorrent != nil && !options.Paused {
+		engine.GenerateInfoFromTorrent(tmpTorrent)
+		engine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
+	}
+	return
+}
+
 //Check if duplicated torrent
 func (engine *Engine) checkOneHash(infoHash metainfo.Hash) (tmpTorrent *torrent.Torrent, needMoreOperation bool) {
 	torrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[infoHash]
 	if isExist && torrentLog.Status != CompletedStatus {
-		logger.Info("Task has been created")
+		engine.logger.Info("Task has been created")
 		tmpTorrent, _ = engine.TorrentEngine.Torrent(infoHash)
 		needMoreOperation = false
 	} else if isExist && torrentLog.Status == CompletedStatus {
-		logger.Info("Task has been completed")
+		engine.logger.Info("Task has been completed")
 		tmpTorrent = nil
 		needMoreOperation = false
 	} else {
-		logger.Info("Create a new task")
+		engine.logger.Info("Create a new task")
 		needMoreOperation = true
 	}
 	return
@@ -51,14 +108,18 @@ func (engine *Engine) checkOneHash(infoHash metainfo.Hash) (tmpTorrent *torrent.
 
 // AddOneTorrentFromMagnet support 'magnet:' and 'infohash:'
 func (engine *Engine) AddOneTorrentFromMagnet(linkAddress string) (tmpTorrent *torrent.Torrent, err error) {
+	return engine.AddOneTorrentFromMagnetWithOptions(linkAddress, AddOptions{})
+}
+
+func (engine *Engine) AddOneTorrentFromMagnetWithOptions(linkAddress string, options AddOptions) (tmpTorrent *torrent.Torrent, err error) {
 	isMagnet, isInfoHash := strings.HasPrefix(linkAddress, "magnet:"), strings.HasPrefix(linkAddress, "infohash:")
 	if isMagnet || isInfoHash {
 		var infoHash metainfo.Hash
+		var torrentMetaInfo *torrent.TorrentSpec
 		if strings.HasPrefix(linkAddress, "magnet:") {
-			var torrentMetaInfo *torrent.TorrentSpec
 			torrentMetaInfo, err = torrent.TorrentSpecFromMagnetUri(linkAddress)
 			if err != nil {
-				logger.WithFields(log.Fields{"Error": err}).Error("unable to resolve magnet")
+				engine.logger.WithFields(log.Fields{"Error": err}).Error("unable to resolve magnet")
 				return
 			} else {
 				infoHash = torrentMetaInfo.InfoHash
@@ -66,51 +127,28 @@ func (engine *Engine) AddOneTorrentFromMagnet(linkAddress string) (tmpTorrent *t
 		} else {
 			infoHash = metainfo.NewHashFromHex(strings.TrimPrefix(linkAddress, "infohash:"))
 		}
+		engine.stateLock.Lock()
+		defer engine.stateLock.Unlock()
 		var needMoreOperation bool
 		tmpTorrent, needMoreOperation = engine.checkOneHash(infoHash)
 
 		if needMoreOperation {
-			engine.EngineRunningInfo.AddOneTorrentFromMagnet(infoHash)
-			extendLog, _ := engine.EngineRunningInfo.TorrentLogExtends[infoHash]
-			engine.EngineRunningInfo.MagnetNum++
+			singleTorrentLog := engine.EngineRunningInfo.AddOneTorrentFromMagnet(infoHash, options)
+			engine.fireEvent(EventAdded, *singleTorrentLog, "")
 
+			storagePath, _ := engine.EngineRunningInfo.storagePath(options)
 			if isMagnet {
-				tmpTorrent, err = engine.TorrentEngine.AddMagnet(linkAddress)
+				torrentMetaInfo.Storage = engine.getStorage(storagePath)
+				torrentMetaInfo.Trackers = engine.trackers(torrentMetaInfo.Trackers)
+				tmpTorrent, _, err = engine.TorrentEngine.AddTorrentSpec(torrentMetaInfo)
 			} else {
-				tmpTorrent, _ = engine.TorrentEngine.AddTorrentInfoHash(infoHash)
+				tmpTorrent, _ = engine.TorrentEngine.AddTorrentInfoHashWithStorage(infoHash, engine.getStorage(storagePath))
 			}
 			if err != nil {
-				logger.WithFields(log.Fields{"Error": err, "Torrent": tmpTorrent}).Error("Unable to resolve magnet")
+				engine.logger.WithFields(log.Fields{"Error": err, "Torrent": tmpTorrent}).Error("Unable to resolve magnet")
 				return
 			}
-			go func() {
-				select {
-				case <-tmpTorrent.GotInfo():
-					logger.Debug("Add torrent from magnet, url successfully resolved")
-					engine.EngineRunningInfo.UpdateMagnetInfo(tmpTorrent)
-					engine.GenerateInfoFromTorrent(tmpTorrent)
-					engine.SaveInfo()
-					engine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
-					engine.EngineRunningInfo.EngineCMD <- RefreshInfo
-					logger.Debug("It should refresh")
-					// save torrent as file
-					if f, fErr := os.OpenFile(filepath.Join(clientConfig.EngineSetting.Tmpdir, tmpTorrent.Name()+".torrent"), os.O_WRONLY|os.O_CREATE, 0666); err == nil {
-						defer f.Close()
-						info := tmpTorrent.Metainfo()
-						fErr = info.Write(f)
-						if fErr != nil {
-							logger.WithFields(log.Fields{"Error": err, "Torrent": tmpTorrent}).Error("Unable write torrent file")
-						}
-					} else {
-						logger.WithFields(log.Fields{"Error": err, "Torrent": tmpTorrent}).Error("Unable save torrent file")
-					}
-				case <-extendLog.MagnetAnalyseChan:
-					tmpTorrent.Drop()
-					extendLog.MagnetDelChan <- true
-					logger.Debug("One magnet has been deleted")
-				}
-				engine.EngineRunningInfo.MagnetNum--
-			}()
+			engine.resolveMagnet(tmpTorrent, singleTorrentLog)
 		}
 	} else {
 		err = errors.New("invalid address")
@@ -118,12 +156,82 @@ func (engine *Engine) AddOneTorrentFromMagnet(linkAddress string) (tmpTorrent *t
 	return tmpTorrent, err
 }
 
+// resolveMagnet Wait for info of a magnet in client, from DHT, peers or torrent caches,
+// then start downloading it, or stop it if it was added paused. Caller holds stateLock
+func (engine *Engine) resolveMagnet(tmpTorrent *torrent.Torrent, singleTorrentLog *TorrentLog) {
+	infoHash := tmpTorrent.InfoHash()
+	extendLog, _ := engine.EngineRunningInfo.TorrentLogExtends[infoHash]
+	engine.EngineRunningInfo.MagnetNum++
+	cacheCtx, cancelCache := context.WithCancel(context.Background())
+	go engine.fetchFromCaches(cacheCtx, tmpTorrent)
+	go func(tmpTorrent *torrent.Torrent) {
+		defer cancelCache()
+		defer func() {
+			engine.stateLock.Lock()
+			engine.EngineRunningInfo.MagnetNum--
+			engine.stateLock.Unlock()
+		}()
+		select {
+		case <-tmpTorrent.GotInfo():
+			engine.logger.Debug("Add torrent from magnet, url successfully resolved")
+			engine.stateLock.Lock()
+			if _, isExist := engine.EngineRunningInfo.HashToTorrentLog[infoHash]; !isExist {
+				// deleted while its info was arriving
+				tmpTorrent.Drop()
+				engine.stateLock.Unlock()
+				return
+			}
+			if updateErr := engine.EngineRunningInfo.UpdateMagnetInfo(tmpTorrent); updateErr != nil {
+				engine.logger.WithFields(log.Fields{"Error": updateErr, "Torrent": tmpTorrent}).Error("Magnet info rejected")
+				engine.fireEvent(EventError, *singleTorrentLog, updateErr.Error())
+				engine.stateLock.Unlock()
+				return
+			}
+			engine.generateInfoFromTorrent(tmpTorrent)
+			if singleTorrentLog.AddPaused {
+				singleTorrentLog.AddPaused = false
+				// saves the log too
+				engine.stopOneTorrent(tmpTorrent.InfoHash().HexString())
+			} else {
+				engine.saveTorrentLog(singleTorrentLog)
+				engine.startDownloadTorrent(tmpTorrent.InfoHash().HexString())
+			}
+			engine.stateLock.Unlock()
+			select {
+			case engine.EngineRunningInfo.EngineCMD <- RefreshInfo:
+			default:
+			}
+			engine.logger.Debug("It should refresh")
+			// save torrent as file
+			if f, fErr := os.OpenFile(filepath.Join(engine.config.Current().EngineSetting.Tmpdir, tmpTorrent.Name()+".torrent"), os.O_WRONLY|os.O_CREATE, 0666); fErr == nil {
+				defer f.Close()
+				info := tmpTorrent.Metainfo()
+				fErr = info.Write(f)
+				if fErr != nil {
+					engine.logger.WithFields(log.Fields{"Error": fErr, "Torrent": tmpTorrent}).Error("Unable write torrent file")
+				}
+			} else {
+				engine.logger.WithFields(log.Fields{"Error": fErr, "Torrent": tmpTorrent}).Error("Unable save torrent file")
+			}
+		case <-extendLog.MagnetAnalyseChan:
+			// DelOneTorrent has dropped it, or client is restarted
+			engine.logger.Debug("One magnet has been deleted")
+		}
+	}(tmpTorrent)
+}
+
 // GetOneTorrent Only handle torrent in client
 func (engine *Engine) GetOneTorrent(hexString string) (tmpTorrent *torrent.Torrent, isExist bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.getOneTorrent(hexString)
+}
+
+func (engine *Engine) getOneTorrent(hexString string) (tmpTorrent *torrent.Torrent, isExist bool) {
 	torrentHash := metainfo.Hash{}
 	err := torrentHash.FromHexString(hexString)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("Unable to get hash from hex string")
+		engine.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get hash from hex string")
 		tmpTorrent = nil
 		isExist = false
 	} else {
@@ -142,18 +250,28 @@ func (engine *Engine) GetOneTorrent(hexString string) (tmpTorrent *torrent.Torre
 
 //Max number of downloading torrents should be considered in electron
 func (engine *Engine) StartDownloadTorrent(hexString string) (downloaded bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.startDownloadTorrent(hexString)
+}
+
+func (engine *Engine) startDownloadTorrent(hexString string) (downloaded bool) {
 	downloaded = true
-	singleTorrent, isExist := engine.GetOneTorrent(hexString)
+	singleTorrent, isExist := engine.getOneTorrent(hexString)
 	if isExist {
 		singleTorrentLog, _ := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
 		if singleTorrentLog.Status != RunningStatus {
 			singleTorrentLog.Status = RunningStatus
 			engine.checkExtend(singleTorrent)
 			//Some download setting for task
-			singleTorrent.AddTrackers(clientConfig.DefaultTrackers)
-			singleTorrent.SetMaxEstablishedConns(clientConfig.EngineSetting.MaxEstablishedConns)
+			config := engine.config.Current()
+			engine.addTrackers(singleTorrent, config.DefaultTrackers)
+			singleTorrent.SetMaxEstablishedConns(config.EngineSetting.MaxEstablishedConns)
+			engine.watchWriteErrors(singleTorrent)
+			singleTorrent.AllowDataDownload()
 			engine.WaitForCompleted(singleTorrent)
 			singleTorrent.DownloadAll()
+			engine.fireEvent(EventStarted, *singleTorrentLog, "")
 		}
 	} else {
 		downloaded = false
@@ -161,67 +279,266 @@ func (engine *Engine) StartDownloadTorrent(hexString string) (downloaded bool) {
 	return
 }
 
+// SetFilePriority change priority of one file, fileIndex follows the order of Files in TorrentWebInfo
+func (engine *Engine) SetFilePriority(hexString string, fileIndex int, priority types.PiecePriority) (changed bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.setFilePriority(hexString, fileIndex, priority)
+}
+
+func (engine *Engine) setFilePriority(hexString string, fileIndex int, priority types.PiecePriority) (changed bool) {
+	singleTorrent, isExist := engine.getOneTorrent(hexString)
+	if !isExist || singleTorrent.Info() == nil {
+		return false
+	}
+	files := singleTorrent.Files()
+	if fileIndex < 0 || fileIndex >= len(files) {
+		return false
+	}
+	files[fileIndex].SetPriority(priority)
+	if torrentWebInfo, isExist := engine.WebInfo.HashToTorrentWebInfo[singleTorrent.InfoHash()]; isExist && fileIndex < len(torrentWebInfo.Files) {
+		torrentWebInfo.Files[fileIndex].Priority = byte(priority)
+	}
+	return true
+}
+
+// SetTorrentPriority change priority of all files of one task
+func (engine *Engine) SetTorrentPriority(hexString string, priority types.PiecePriority) (changed bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	singleTorrent, isExist := engine.getOneTorrent(hexString)
+	if !isExist || singleTorrent.Info() == nil {
+		return false
+	}
+	for fileIndex := range singleTorrent.Files() {
+		engine.setFilePriority(hexString, fileIndex, priority)
+	}
+	return true
+}
+
+// GetTorrentLog Copy of log of a task, magnets being analysed included
+func (engine *Engine) GetTorrentLog(hexString string) (torrentLog TorrentLog, isExist bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	singleTorrentLog, isExist := engine.getTorrentLog(hexString)
+	if isExist {
+		torrentLog = *singleTorrentLog
+	}
+	return
+}
+
+func (engine *Engine) getTorrentLog(hexString string) (torrentLog *TorrentLog, isExist bool) {
+	torrentHash := metainfo.Hash{}
+	if err := torrentHash.FromHexString(hexString); err != nil {
+		return nil, false
+	}
+	torrentLog, isExist = engine.EngineRunningInfo.HashToTorrentLog[torrentHash]
+	return
+}
+
+func (engine *Engine) SetCategory(hexString string, category string) (changed bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	torrentLog, isExist := engine.getTorrentLog(hexString)
+	if !isExist {
+		return false
+	}
+	torrentLog.Category = category
+	if torrentWebInfo, isExist := engine.WebInfo.HashToTorrentWebInfo[torrentLogHash(*torrentLog)]; isExist {
+		torrentWebInfo.Category = category
+	}
+	engine.saveTorrentLog(torrentLog)
+	return true
+}
+
+func (engine *Engine) SetTags(hexString string, tags []string) (changed bool) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	torrentLog, isExist := engine.getTorrentLog(hexString)
+	if !isExist {
+		return false
+	}
+	torrentLog.Tags = tags
+	engine.saveTorrentLog(torrentLog)
+	return true
+}
+
+// RecheckOneTorrent Verification runs in background, pieces found missing are downloaded again if task is running
+func (engine *Engine) RecheckOneTorrent(hexString string) error {
+	singleTorrent, isExist := engine.GetOneTorrent(hexString)
+	if !isExist {
+		return errors.New("torrent is not loaded in client")
+	}
+	if singleTorrent.Info() == nil {
+		return errors.New("info of torrent has not been got")
+	}
+	go func() {
+		entry := engine.logger.WithFields(log.Fields{"TorrentName": singleTorrent.Name()})
+		entry.Info("Verifying data...")
+		singleTorrent.VerifyData()
+		entry.Info("Data verified!")
+	}()
+	return nil
+}
+
+// MoveOneTorrent Move data of a task to storagePath, task is dropped from client while moving and added back then
+func (engine *Engine) MoveOneTorrent(hexString string, storagePath string) error {
+	absPath, err := filepath.Abs(storagePath)
+	if err != nil || storagePath == "" {
+		return errors.New("invalid storage path")
+	}
+
+	engine.stateLock.Lock()
+	torrentLog, isExist := engine.getTorrentLog(hexString)
+	if !isExist {
+		engine.stateLock.Unlock()
+		return errors.New("torrent not found")
+	}
+	if torrentLog.Status == AnalysingStatus {
+		engine.stateLock.Unlock()
+		return errors.New("magnet is still being analysed")
+	}
+	if torrentLog.StoragePath == absPath {
+		engine.stateLock.Unlock()
+		return nil
+	}
+	torrentHash := torrentLog.HashInfoBytes()
+	wasRunning := torrentLog.Status == RunningStatus
+	if wasRunning {
+		engine.stopOneTorrent(hexString)
+	}
+	if singleTorrent, isExist := engine.TorrentEngine.Torrent(torrentHash); isExist {
+		singleTorrent.Drop()
+	}
+	fromPath := filepath.Join(torrentLog.StoragePath, torrentLog.TorrentName)
+	toPath := filepath.Join(absPath, torrentLog.TorrentName)
+	engine.stateLock.Unlock()
+
+	moveErr := moveFiles(fromPath, toPath)
+
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	// task may have been deleted while unlocked
+	torrentLog, isExist = engine.EngineRunningInfo.HashToTorrentLog[torrentHash]
+	if !isExist {
+		return moveErr
+	}
+	if moveErr == nil {
+		defaultPath, _ := filepath.Abs(engine.config.Current().TorrentConfig.DataDir)
+		torrentLog.StoragePath = absPath
+		torrentLog.CustomStoragePath = absPath != defaultPath
+		engine.logger.WithFields(log.Fields{"From": fromPath, "To": toPath}).Info("Files have been moved!")
+	}
+	if torrentLog.Status != CompletedStatus {
+		singleTorrent, err := engine.addTorrentToClient(&torrentLog.MetaInfo, torrentLog.StoragePath)
+		if err != nil {
+			engine.logger.WithFields(log.Fields{"Error": err}).Error("Failed to add moved torrent back to client")
+			engine.fireEvent(EventError, *torrentLog, err.Error())
+			engine.saveTorrentLog(torrentLog)
+			return err
+		}
+		singleTorrent.SetMaxEstablishedConns(0)
+		torrentLog.Status = StoppedStatus
+		if wasRunning {
+			engine.startDownloadTorrent(hexString)
+		}
+	}
+	engine.saveTorrentLog(torrentLog)
+	engine.updateWebInfo()
+	return moveErr
+}
+
+// CompleteOneTorrent Data is verified with state unlocked, it can be called more than once for a task
 func (engine *Engine) CompleteOneTorrent(singleTorrent *torrent.Torrent) {
-	singleTorrentLog, exist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
-	if !exist {
+	if engine.torrentStatus(singleTorrent.InfoHash()) == CompletedStatus {
 		return
 	}
-	singleTorrentLogExtend, extendExist := engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()]
 	<-singleTorrent.GotInfo()
 	//One more check
-	entry := logger.WithFields(log.Fields{"TorrentName": singleTorrent.Name()})
+	entry := engine.logger.WithFields(log.Fields{"TorrentName": singleTorrent.Name()})
 	if singleTorrent.BytesCompleted() == singleTorrent.Info().TotalLength() {
 		entry.Info("Torrent has been finished, verifying data...")
 		singleTorrent.VerifyData()
 		entry.Infof("Data verified!")
-		singleTorrentLog.Status = CompletedStatus
-		engine.SaveInfo()
-		if extendExist && singleTorrentLogExtend.HasStatusPub && singleTorrentLogExtend.StatusPub != nil {
-			singleTorrentLogExtend.HasStatusPub = false
-			if !channelClosed(singleTorrentLogExtend.StatusPub.Values) {
-				singleTorrentLogExtend.StatusPub.Values <- struct{}{}
-				singleTorrentLogExtend.StatusPub.Close()
-			}
+		engine.stateLock.Lock()
+		defer engine.stateLock.Unlock()
+		singleTorrentLog, exist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+		if !exist || singleTorrentLog.Status == CompletedStatus {
+			return
 		}
+		singleTorrentLog.Status = CompletedStatus
+		singleTorrentLog.CompletedTime = time.Now()
+		singleTorrentLog.LastSeenComplete = singleTorrentLog.CompletedTime
+		engine.saveTorrentLog(singleTorrentLog)
+		engine.fireEvent(EventCompleted, *singleTorrentLog, "")
+		engine.closeStatusPub(singleTorrent.InfoHash())
 	} else {
 		entry.Warnf("Torrent wants to be marked as finished, but bytes are not totally completed")
 	}
 }
 
+// torrentStatus Status of a task, 0 if it is not found
+func (engine *Engine) torrentStatus(infoHash metainfo.Hash) TorrentStatus {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	if singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[infoHash]; isExist {
+		return singleTorrentLog.Status
+	}
+	return 0
+}
+
+// closeStatusPub Wake goroutine of WaitForCompleted, it checks status of task then
+func (engine *Engine) closeStatusPub(infoHash metainfo.Hash) {
+	singleTorrentLogExtend, extendExist := engine.EngineRunningInfo.TorrentLogExtends[infoHash]
+	if extendExist && singleTorrentLogExtend.HasStatusPub && singleTorrentLogExtend.StatusPub != nil {
+		singleTorrentLogExtend.HasStatusPub = false
+		singleTorrentLogExtend.StatusPub.Close()
+	}
+}
+
 func (engine *Engine) WaitForCompleted(singleTorrent *torrent.Torrent) {
 	go func() {
-		singleTorrentLog := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
-		singleTorrentLogExtend := engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()]
+		infoHash := singleTorrent.InfoHash()
+		engine.stateLock.Lock()
+		singleTorrentLogExtend, extendExist := engine.EngineRunningInfo.TorrentLogExtends[infoHash]
+		if !extendExist || singleTorrentLogExtend.StatusPub == nil {
+			engine.stateLock.Unlock()
+			return
+		}
+		statusPub := singleTorrentLogExtend.StatusPub
+		engine.stateLock.Unlock()
 		<-singleTorrent.GotInfo()
-		for singleTorrentLog.Status == RunningStatus {
+		for engine.torrentStatus(infoHash) == RunningStatus {
 			if singleTorrent.BytesCompleted() == singleTorrent.Info().TotalLength() {
 				engine.CompleteOneTorrent(singleTorrent)
 				engine.UpdateInfo()
 				return
 			}
-			<-singleTorrentLogExtend.StatusPub.Values
+			// closed when task is stopped or dropped, a new goroutine is started if it runs again
+			if _, ok := <-statusPub.Values; !ok {
+				break
+			}
 		}
-		log.WithFields(log.Fields{"TorrentName": singleTorrentLog.TorrentName, "Status": singleTorrentLog.Status}).Info("Torrent status changed !")
+		engine.logger.WithFields(log.Fields{"TorrentName": singleTorrent.Name(), "Status": engine.torrentStatus(infoHash)}).Info("Torrent status changed !")
 	}()
 }
 
 func (engine *Engine) StopOneTorrent(hexString string) (stopped bool) {
-	singleTorrent, torrentExist := engine.GetOneTorrent(hexString)
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.stopOneTorrent(hexString)
+}
+
+func (engine *Engine) stopOneTorrent(hexString string) (stopped bool) {
+	singleTorrent, torrentExist := engine.getOneTorrent(hexString)
 	if torrentExist {
 		singleTorrentLog := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
 		if singleTorrentLog.Status != CompletedStatus {
 			singleTorrentLog.Status = StoppedStatus
-			engine.SaveInfo()
-			//engine.EngineRunningInfo.UpdateTorrentLog()
-			singleTorrentLogExtend, extendExist := engine.EngineRunningInfo.TorrentLogExtends[singleTorrent.InfoHash()]
-			if extendExist && singleTorrentLogExtend.HasStatusPub && singleTorrentLogExtend.StatusPub != nil {
-				singleTorrentLogExtend.HasStatusPub = false
-				if !channelClosed(singleTorrentLogExtend.StatusPub.Values) {
-					singleTorrentLogExtend.StatusPub.Values <- struct{}{}
-					singleTorrentLogExtend.StatusPub.Close()
-				}
-			}
+			engine.saveTorrentLog(singleTorrentLog)
+			engine.closeStatusPub(singleTorrent.InfoHash())
 			singleTorrent.SetMaxEstablishedConns(0)
+			engine.fireEvent(EventStopped, *singleTorrentLog, "")
 		}
 		stopped = true
 	} else {
@@ -230,59 +547,114 @@ func (engine *Engine) StopOneTorrent(hexString string) (stopped bool) {
 	return
 }
 
-// TODO: Find error of out range of index, not find reason now
-// Delete on torrent will operate logs directly, rather than get from getOne
-func (engine *Engine) DelOneTorrent(hexString string) (deleted bool) {
-	deleted = false
+// DelOneTorrent hexString may also be hash of a magnet still being analysed
+// Downloaded data is kept on disk unless deleteFiles is set, it is deleted after task is removed
+func (engine *Engine) DelOneTorrent(hexString string, deleteFiles bool) (deleted bool) {
+	torrentHash := metainfo.Hash{}
+	if err := torrentHash.FromHexString(hexString); err != nil {
+		return false
+	}
+	engine.stateLock.Lock()
+	singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[torrentHash]
+	if !isExist {
+		engine.stateLock.Unlock()
+		return false
+	}
+	isMagnet := singleTorrentLog.Status == AnalysingStatus
+	if singleTorrentLog.Status == RunningStatus {
+		engine.stopOneTorrent(hexString)
+	}
+	engine.fireEvent(EventRemoved, *singleTorrentLog, "")
+	if isMagnet {
+		// goroutine waiting for info of magnet quits
+		engine.EngineRunningInfo.TorrentLogExtends[torrentHash].MagnetAnalyseChan <- true
+	}
+	if singleTorrent, torrentExist := engine.TorrentEngine.Torrent(torrentHash); torrentExist {
+		singleTorrent.Drop()
+	}
+	filePath := filepath.Join(singleTorrentLog.StoragePath, singleTorrentLog.TorrentName)
+	deleted = engine.EngineRunningInfo.removeTorrentLog(torrentHash)
+	engine.updateInfo()
+	engine.TorrentDB.DeleteLog(hexString)
+	engine.stateLock.Unlock()
 
-	for index := 0; index < len(engine.EngineRunningInfo.TorrentLogs); index++ {
-		if engine.EngineRunningInfo.TorrentLogs[index].Status != AnalysingStatus && engine.EngineRunningInfo.TorrentLogs[index].HashInfoBytes().HexString() == hexString {
-			if engine.EngineRunningInfo.TorrentLogs[index].Status == RunningStatus {
-				engine.StopOneTorrent(hexString)
-			}
-			singleTorrent, torrentExist := engine.TorrentEngine.Torrent(engine.EngineRunningInfo.TorrentLogs[index].HashInfoBytes())
-			if torrentExist {
-				singleTorrent.Drop()
-			}
-			filePath := filepath.Join(engine.EngineRunningInfo.TorrentLogs[index].StoragePath, engine.EngineRunningInfo.TorrentLogs[index].TorrentName)
-			logger.WithFields(log.Fields{"Path": filePath}).Info("Files have been deleted!")
-			//fmt.Printf("Before delete: %+v\n", engine.EngineRunningInfo.TorrentLogsAndID)
-			engine.EngineRunningInfo.TorrentLogsAndID.TorrentLogs = append(engine.EngineRunningInfo.TorrentLogs[:index], engine.EngineRunningInfo.TorrentLogs[index+1:]...)
-			//fmt.Printf("After delete: %+v\n", engine.EngineRunningInfo.TorrentLogsAndID)
-			engine.UpdateInfo()
-			engine.SaveInfo()
-			delFiles(filePath)
-			deleted = true
-		} else if engine.EngineRunningInfo.TorrentLogs[index].Status == AnalysingStatus && engine.EngineRunningInfo.TorrentLogs[index].TorrentName == hexString {
-			//Magnet hash is stored in torrentName
-			torrentHash := metainfo.Hash{}
-			_ = torrentHash.FromHexString(engine.EngineRunningInfo.TorrentLogs[index].TorrentName)
-			extendLog := engine.EngineRunningInfo.TorrentLogExtends[torrentHash]
-			extendLog.MagnetAnalyseChan <- true
-			<-extendLog.MagnetDelChan
-			engine.EngineRunningInfo.TorrentLogs = append(engine.EngineRunningInfo.TorrentLogs[:index], engine.EngineRunningInfo.TorrentLogs[index+1:]...)
-			engine.UpdateInfo()
-			engine.SaveInfo()
-			deleted = true
-			logger.Debug("Delete Magnet Done")
-			return
-		}
+	if isMagnet {
+		engine.logger.Debug("Delete Magnet Done")
+	} else if deleteFiles {
+		engine.delFiles(filePath)
+		engine.logger.WithFields(log.Fields{"Path": filePath}).Info("Files have been deleted!")
 	}
 	return
 }
 
-func delFiles(path string) {
+// Storage failures stop the download and are reported as error event
+func (engine *Engine) watchWriteErrors(singleTorrent *torrent.Torrent) {
+	singleTorrent.SetOnWriteChunkError(func(err error) {
+		engine.logger.WithFields(log.Fields{"Error": err, "Torrent": singleTorrent.Name()}).Error("Unable to write torrent data")
+		singleTorrent.DisallowDataDownload()
+		engine.stateLock.Lock()
+		defer engine.stateLock.Unlock()
+		if singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]; isExist {
+			engine.fireEvent(EventError, *singleTorrentLog, err.Error())
+		}
+	})
+}
+
+func (engine *Engine) delFiles(path string) {
 	err := os.RemoveAll(path)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("unable to delete files")
+		engine.logger.WithFields(log.Fields{"Error": err}).Error("unable to delete files")
 	}
 }
 
-func channelClosed(ch <-chan interface{}) bool {
-	select {
-	case <-ch:
-		return true
-	default:
+// moveFiles Rename is tried first, data is copied when target is on another device
+func moveFiles(fromPath string, toPath string) error {
+	if _, err := os.Lstat(fromPath); os.IsNotExist(err) {
+		// nothing has been downloaded yet
+		return nil
+	}
+	if _, err := os.Lstat(toPath); err == nil {
+		return fmt.Errorf("%s already exists", toPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(fromPath, toPath); err == nil {
+		return nil
+	}
+	if err := copyFiles(fromPath, toPath); err != nil {
+		_ = os.RemoveAll(toPath)
+		return err
 	}
-	return false
+	return os.RemoveAll(fromPath)
+}
+
+func copyFiles(fromPath string, toPath string) error {
+	return filepath.Walk(fromPath, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(fromPath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(toPath, relPath)
+		if fileInfo.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		dest, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileInfo.Mode())
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(dest, source); err != nil {
+			_ = dest.Close()
+			return err
+		}
+		return dest.Close()
+	})
 }
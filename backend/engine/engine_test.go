@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anatasluo/ant/backend/setting"
+)
+
+// testConfig Engine without network services, lists to download or port mapping
+const testConfig = `[enginesetting]
+  disableipv6 = true
+  defaultipblocklist = ""
+  defaulttrackerlist = ""
+  enabledefaulttrackers = false
+  torrentcaches = []
+[loggersetting]
+  loggingoutput = "stdout"
+  logginglevel = 2
+[portmappingsetting]
+  enableportmapping = false
+[rsssetting]
+  enablerss = false
+[torrentconfig]
+  nodht = true
+  disableutp = true
+  listenport = 0
+`
+
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	return newTestEngineWithConfig(t, testConfig)
+}
+
+// newTestEngineWithConfig Engine of config, which is usually testConfig with some settings changed
+func newTestEngineWithConfig(t *testing.T, config string) *Engine {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientSetting, err := setting.New(setting.PathSetting{ConfigFile: configFile, DataDir: dir, StateDir: dir, LogDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := New(clientSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = engine.Cleanup() })
+	return engine
+}
+
+func testMagnet(index int) string {
+	return fmt.Sprintf("magnet:?xt=urn:btih:%040x", index+1)
+}
+
+// TestConcurrentEngineAPI Run with -race, tasks are added, stopped, listed and deleted while info is updated
+func TestConcurrentEngineAPI(t *testing.T) {
+	engine := newTestEngine(t)
+	const workers = 8
+	done := make(chan struct{})
+	var updaters sync.WaitGroup
+	updaters.Add(1)
+	go func() {
+		defer updaters.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			engine.UpdateInfo()
+			for _, detail := range engine.GetTorrentDetails() {
+				engine.GetTorrentDetail(detail.HexString)
+				if singleTorrent, isExist := engine.GetOneTorrent(detail.HexString); isExist {
+					engine.GenerateInfoFromTorrent(singleTorrent)
+				}
+			}
+			engine.GetEngineStats()
+		}
+	}()
+
+	var workerGroup sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		workerGroup.Add(1)
+		go func(worker int) {
+			defer workerGroup.Done()
+			torrentMetaInfo := testMetaInfo(t, fmt.Sprintf("task-%d", worker))
+			tmpTorrent, err := engine.AddOneTorrentFromInfoHashWithOptions(&torrentMetaInfo, AddOptions{Category: "test"})
+			if err != nil {
+				t.Errorf("add task %d: %v", worker, err)
+				return
+			}
+			hexString := tmpTorrent.InfoHash().HexString()
+			if _, err = engine.AddOneTorrentFromMagnetWithOptions(testMagnet(worker), AddOptions{}); err != nil {
+				t.Errorf("add magnet %d: %v", worker, err)
+			}
+			engine.StartDownloadTorrent(hexString)
+			engine.SetCategory(hexString, "other")
+			engine.StopOneTorrent(hexString)
+			if !engine.DelOneTorrent(hexString, true) {
+				t.Errorf("task %d is not deleted", worker)
+			}
+			if !engine.DelOneTorrent(fmt.Sprintf("%040x", worker+1), false) {
+				t.Errorf("magnet %d is not deleted", worker)
+			}
+		}(worker)
+	}
+	workerGroup.Wait()
+	close(done)
+	updaters.Wait()
+
+	if details := engine.GetTorrentDetails(); len(details) != 0 {
+		t.Errorf("%d tasks left after deleting all of them", len(details))
+	}
+}
+
+// TestWebInfoSkipsTorrentsWithoutLogOrInfo Torrents in client without log or info are left out,
+// instead of panicking or blocking with state locked
+func TestWebInfoSkipsTorrentsWithoutLogOrInfo(t *testing.T) {
+	engine := newTestEngine(t)
+	torrentMetaInfo := testMetaInfo(t, "no-log")
+	// added to client but not to logs, as a task being added or deleted
+	noLog, err := engine.Client().AddTorrent(&torrentMetaInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noInfo, err := engine.AddOneTorrentFromMagnetWithOptions(testMagnet(0), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.stateLock.Lock()
+	engine.EngineRunningInfo.HashToTorrentLog[noInfo.InfoHash()].Status = StoppedStatus
+	engine.stateLock.Unlock()
+
+	updated := make(chan struct{})
+	go func() {
+		engine.UpdateInfo()
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("UpdateInfo is blocked by a torrent without info")
+	}
+	if torrentWebInfo := engine.GenerateInfoFromTorrent(noLog); torrentWebInfo != nil {
+		t.Errorf("info of torrent without log: %+v", torrentWebInfo)
+	}
+	if torrentWebInfo := engine.GenerateInfoFromTorrent(noInfo); torrentWebInfo != nil {
+		t.Errorf("info of stopped magnet without info: %+v", torrentWebInfo)
+	}
+	engine.stateLock.Lock()
+	_, hasNoLog := engine.WebInfo.HashToTorrentWebInfo[noLog.InfoHash()]
+	_, hasNoInfo := engine.WebInfo.HashToTorrentWebInfo[noInfo.InfoHash()]
+	engine.stateLock.Unlock()
+	if hasNoLog || hasNoInfo {
+		t.Errorf("web info has skipped torrents: without log %v, without info %v", hasNoLog, hasNoInfo)
+	}
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Subscribers get every fired event, slow ones lose events instead of blocking the engine
+type eventBroker struct {
+	lock        sync.Mutex
+	subscribers map[chan HookPayload]bool
+	logger      *log.Logger
+}
+
+const eventBufferSize = 64
+
+// SubscribeEvents returns events fired from now on, cancel must be called when done
+func (engine *Engine) SubscribeEvents() (events <-chan HookPayload, cancel func()) {
+	broker := &engine.events
+	channel := make(chan HookPayload, eventBufferSize)
+	broker.lock.Lock()
+	if broker.subscribers == nil {
+		broker.subscribers = make(map[chan HookPayload]bool)
+	}
+	broker.subscribers[channel] = true
+	broker.lock.Unlock()
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			broker.lock.Lock()
+			delete(broker.subscribers, channel)
+			broker.lock.Unlock()
+			close(channel)
+		})
+	}
+	return channel, cancel
+}
+
+func (broker *eventBroker) publish(payload HookPayload) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	for channel := range broker.subscribers {
+		select {
+		case channel <- payload:
+		default:
+			broker.logger.WithField("Event", payload.Event).Warn("Event subscriber is too slow, event dropped")
+		}
+	}
+}
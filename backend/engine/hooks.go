@@ -0,0 +1,377 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anatasluo/ant/backend/setting"
+	log "github.com/sirupsen/logrus"
+)
+
+type TorrentEvent string
+
+const (
+	EventAdded     TorrentEvent = "added"
+	EventCompleted TorrentEvent = "completed"
+	EventError     TorrentEvent = "error"
+	EventRemoved   TorrentEvent = "removed"
+	EventStarted   TorrentEvent = "started"
+	EventStopped   TorrentEvent = "stopped"
+)
+
+const (
+	defaultHookTimeout = time.Minute
+	maxHookOutput      = 4 << 10
+	// Retries of a webhook are capped whatever its setting says, backoff doubles up to maxWebhookBackoff
+	maxWebhookRetries = 8
+	maxWebhookBackoff = 5 * time.Minute
+)
+
+// HookPayload is sent to webhooks, the same values fill placeholders of command hooks
+type HookPayload struct {
+	Event     TorrentEvent
+	Name      string
+	HexString string
+	SavePath  string
+	Category  string
+	Files     []string
+	Detail    string
+	Time      time.Time
+}
+
+func newHookPayload(event TorrentEvent, torrentLog TorrentLog, detail string) HookPayload {
+	payload := HookPayload{
+		Event:     event,
+		Name:      torrentLog.TorrentName,
+		HexString: torrentLog.HashInfoBytes().HexString(),
+		SavePath:  torrentLog.StoragePath,
+		Category:  torrentLog.Category,
+		Detail:    detail,
+		Time:      time.Now(),
+	}
+	if torrentLog.Status == AnalysingStatus {
+		// Magnet hash is stored in torrentName
+		payload.HexString = torrentLog.TorrentName
+	}
+	if torrentLog.InfoBytes != nil {
+		if info, err := torrentLog.UnmarshalInfo(); err == nil {
+			for _, file := range info.UpvertedFiles() {
+				payload.Files = append(payload.Files, strings.Join(append([]string{info.Name}, file.Path...), "/"))
+			}
+		}
+	}
+	return payload
+}
+
+func hookWanted(events []string, event TorrentEvent) bool {
+	for _, wanted := range events {
+		if strings.EqualFold(wanted, string(event)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fireEvent Publish the event and queue it to hook worker, which records it and runs hooks.
+// It is called with stateLock held, so it never waits for disk or network
+func (engine *Engine) fireEvent(event TorrentEvent, torrentLog TorrentLog, detail string) {
+	payload := newHookPayload(event, torrentLog, detail)
+	engine.events.publish(payload)
+	engine.hooks.queue(hookJob{payload: payload})
+}
+
+// hookJob Either a fired event or result of a hook to record
+type hookJob struct {
+	payload HookPayload
+	history *TorrentHistory
+}
+
+// hookWorker Writes history to TorrentDB in order of events and starts hooks for them.
+// Its queue has no limit, events are never dropped nor is fireEvent blocked
+type hookWorker struct {
+	lock    sync.Mutex
+	pending []hookJob
+	stopped bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (worker *hookWorker) queue(job hookJob) {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	if worker.stopped {
+		return
+	}
+	worker.pending = append(worker.pending, job)
+	select {
+	case worker.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (worker *hookWorker) take() []hookJob {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	pending := worker.pending
+	worker.pending = nil
+	return pending
+}
+
+func (engine *Engine) startHookWorker() {
+	worker := &engine.hooks
+	worker.wake = make(chan struct{}, 1)
+	worker.stop = make(chan struct{})
+	worker.done = make(chan struct{})
+	go func() {
+		defer close(worker.done)
+		for {
+			select {
+			case <-worker.wake:
+			case <-worker.stop:
+				// events fired before stop are still recorded
+				for _, job := range worker.take() {
+					engine.handleHookJob(job)
+				}
+				return
+			}
+			for _, job := range worker.take() {
+				engine.handleHookJob(job)
+			}
+		}
+	}()
+}
+
+// stopHookWorker Record queued events and stop, events and hook results coming afterwards are dropped
+func (engine *Engine) stopHookWorker() {
+	worker := &engine.hooks
+	worker.lock.Lock()
+	if worker.stopped || worker.stop == nil {
+		worker.lock.Unlock()
+		return
+	}
+	worker.stopped = true
+	worker.lock.Unlock()
+	close(worker.stop)
+	<-worker.done
+}
+
+func (engine *Engine) handleHookJob(job hookJob) {
+	if job.history != nil {
+		engine.TorrentDB.AddHistory(job.history)
+		return
+	}
+	payload := job.payload
+	engine.TorrentDB.AddHistory(&TorrentHistory{
+		HexString: payload.HexString,
+		Time:      payload.Time,
+		Event:     payload.Event,
+		Success:   payload.Event != EventError,
+		Detail:    payload.Detail,
+	})
+	config := engine.config.Current()
+	for _, hook := range config.HookSetting.CommandHooks {
+		if hookWanted(hook.Events, payload.Event) {
+			go engine.runCommandHook(hook, payload)
+		}
+	}
+	for _, hook := range config.HookSetting.Webhooks {
+		if hookWanted(hook.Events, payload.Event) {
+			go engine.runWebhook(hook, payload)
+		}
+	}
+}
+
+// Placeholders are replaced per argument, so values never pass through a shell.
+// An argument of exactly {files} expands to one argument per file.
+func expandCommand(command string, payload HookPayload) []string {
+	replacer := strings.NewReplacer(
+		"{name}", payload.Name,
+		"{hash}", payload.HexString,
+		"{path}", payload.SavePath,
+		"{category}", payload.Category,
+		"{event}", string(payload.Event),
+		"{files}", strings.Join(payload.Files, "\n"),
+	)
+	var args []string
+	for _, arg := range splitCommand(command) {
+		if arg == "{files}" {
+			args = append(args, payload.Files...)
+		} else {
+			args = append(args, replacer.Replace(arg))
+		}
+	}
+	return args
+}
+
+// Split a command line on spaces, single and double quotes group words
+func splitCommand(command string) (args []string) {
+	var (
+		current  strings.Builder
+		quote    rune
+		hasToken bool
+	)
+	for _, char := range command {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(char)
+		case char == '"' || char == '\'':
+			quote = char
+			hasToken = true
+		case char == ' ' || char == '\t':
+			if hasToken {
+				args = append(args, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		default:
+			current.WriteRune(char)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		args = append(args, current.String())
+	}
+	return
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	if left := maxHookOutput - buffer.Len(); left > 0 {
+		if len(p) > left {
+			buffer.Buffer.Write(p[:left])
+		} else {
+			buffer.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (engine *Engine) runCommandHook(hook setting.CommandHook, payload HookPayload) {
+	args := expandCommand(hook.Command, payload)
+	if len(args) == 0 {
+		return
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var output limitedBuffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = append(os.Environ(),
+		"ANT_EVENT="+string(payload.Event),
+		"ANT_NAME="+payload.Name,
+		"ANT_HASH="+payload.HexString,
+		"ANT_PATH="+payload.SavePath,
+		"ANT_CATEGORY="+payload.Category,
+	)
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	detail := output.String()
+	if err != nil {
+		detail = err.Error() + "\n" + detail
+		engine.logger.WithFields(log.Fields{"Error": err, "Command": args[0], "Hash": payload.HexString}).Error("Command hook failed")
+	}
+	engine.hooks.queue(hookJob{history: &TorrentHistory{
+		HexString: payload.HexString,
+		Time:      time.Now(),
+		Event:     payload.Event,
+		Hook:      "command " + args[0],
+		Success:   err == nil,
+		Detail:    detail,
+	}})
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (engine *Engine) runWebhook(hook setting.Webhook, payload HookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		engine.logger.WithFields(log.Fields{"Error": err}).Error("Unable to format webhook payload")
+		return
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	retries := hook.Retries
+	if retries > maxWebhookRetries {
+		retries = maxWebhookRetries
+	}
+	backoff := time.Second
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxWebhookBackoff {
+				backoff = maxWebhookBackoff
+			}
+		}
+		err = postWebhook(client, hook, payload.Event, body)
+		if err == nil {
+			break
+		}
+		engine.logger.WithFields(log.Fields{"Error": err, "URL": hook.URL, "Attempt": attempt + 1}).Warn("Webhook failed")
+	}
+
+	history := &TorrentHistory{
+		HexString: payload.HexString,
+		Time:      time.Now(),
+		Event:     payload.Event,
+		Hook:      "webhook " + hook.URL,
+		Success:   err == nil,
+	}
+	if err != nil {
+		history.Detail = err.Error()
+	}
+	engine.hooks.queue(hookJob{history: history})
+}
+
+func postWebhook(client *http.Client, hook setting.Webhook, event TorrentEvent, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ant-Event", string(event))
+	if hook.Secret != "" {
+		req.Header.Set("X-Ant-Signature", signPayload(hook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"notify-send done", []string{"notify-send", "done"}},
+		{"a  \tb", []string{"a", "b"}},
+		{`echo "two words" 'single quoted'`, []string{"echo", "two words", "single quoted"}},
+		{`echo "it's"`, []string{"echo", "it's"}},
+		{`echo ""`, []string{"echo", ""}},
+		{`echo pre"fix"post`, []string{"echo", "prefixpost"}},
+		{`echo "unterminated`, []string{"echo", "unterminated"}},
+	}
+	for _, test := range tests {
+		if got := splitCommand(test.command); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	payload := HookPayload{
+		Event:     EventCompleted,
+		Name:      "a name; rm -rf /",
+		HexString: "0123456789abcdef0123456789abcdef01234567",
+		SavePath:  "/data/my files",
+		Category:  "tv",
+		Files:     []string{"show/e01.mkv", "show/e02.mkv"},
+	}
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{
+			"/bin/hook {event} {hash}",
+			[]string{"/bin/hook", "completed", payload.HexString},
+		},
+		{
+			// values with spaces or shell characters stay one argument
+			"/bin/hook {name} {path}",
+			[]string{"/bin/hook", payload.Name, payload.SavePath},
+		},
+		{
+			`/bin/hook "{category}/{name}" --path={path}`,
+			[]string{"/bin/hook", "tv/" + payload.Name, "--path=" + payload.SavePath},
+		},
+		{
+			"/bin/hook {files} end",
+			[]string{"/bin/hook", "show/e01.mkv", "show/e02.mkv", "end"},
+		},
+		{
+			// {files} inside an argument joins files by lines
+			"/bin/hook list={files}",
+			[]string{"/bin/hook", "list=show/e01.mkv\nshow/e02.mkv"},
+		},
+		{
+			"/bin/hook {unknown}",
+			[]string{"/bin/hook", "{unknown}"},
+		},
+	}
+	for _, test := range tests {
+		if got := expandCommand(test.command, payload); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expandCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+
+	payload.Files = nil
+	if got := expandCommand("/bin/hook {files}", payload); !reflect.DeepEqual(got, []string{"/bin/hook"}) {
+		t.Errorf("expandCommand without files = %q", got)
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	body := []byte(`{"Event":"completed"}`)
+	signature := signPayload("secret", body)
+	// same as: printf '%s' "$body" | openssl dgst -sha256 -hmac secret
+	if want := "sha256=e87a59eb590e1a70f37873097c28971f33dad276ad54304a3ac9ad09f5f4f19d"; signature != want {
+		t.Errorf("signPayload = %q, want %q", signature, want)
+	}
+	if signPayload("other", body) == signature {
+		t.Error("signature does not depend on secret")
+	}
+	if signPayload("secret", append(body, ' ')) == signature {
+		t.Error("signature does not depend on body")
+	}
+}
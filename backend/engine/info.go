@@ -5,31 +5,38 @@ import (
 	"github.com/anacrolix/missinggo/pubsub"
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anatasluo/ant/backend/setting"
 	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
 	"math"
 	"path/filepath"
 	"time"
 )
 
+// WebviewInfo Guarded by stateLock of Engine
 type WebviewInfo struct {
 	HashToTorrentWebInfo map[metainfo.Hash]*TorrentWebInfo
 }
 
+// RunningInfo Guarded by stateLock of Engine, except EngineCMD
 type RunningInfo struct {
-	TorrentLogsAndID
+	// Tasks in the order they were added, pointers to them stay valid when list is changed
+	TorrentLogs       []*TorrentLog
 	MagnetNum         int
 	EngineCMD         chan MessageTypeID
-	HasRestarted      bool
 	HashToTorrentLog  map[metainfo.Hash]*TorrentLog
 	TorrentLogExtends map[metainfo.Hash]*TorrentLogExtend
+
+	config *setting.ClientSetting
+	logger *log.Logger
 }
 
 // TorrentLogExtend This information is needed in running time
 type TorrentLogExtend struct {
-	StatusPub         *pubsub.Subscription
-	HasStatusPub      bool
+	StatusPub    *pubsub.Subscription
+	HasStatusPub bool
+	// MagnetAnalyseChan tells goroutine waiting for info of magnet to quit
 	MagnetAnalyseChan chan bool
-	MagnetDelChan     chan bool
 	HasMagnetChan     bool
 }
 
@@ -40,12 +47,14 @@ type TorrentWebInfo struct {
 	HexString     string
 	Status        string
 	StoragePath   string
+	Category      string
 	Percentage    float64
 	DownloadSpeed string
 	LeftTime      string
 	Files         []FileInfo
 	TorrentStatus torrent.TorrentStats
 	UpdateTime    time.Time
+	TorrentTimes
 }
 
 type MessageTypeID int
@@ -81,14 +90,49 @@ type TorrentProgressInfo struct {
 	HexString     string
 }
 
-// TorrentLog will be saved to storm db, so types of its support is limited
+// TorrentTimes History of a task, zero time means it has not happened yet
+type TorrentTimes struct {
+	AddedTime time.Time `storm:"index"`
+	// Info of torrent got, it is the same as AddedTime except for magnets
+	MetadataTime  time.Time
+	CompletedTime time.Time
+	// Last time a whole copy was seen, here or at a connected seeder
+	LastSeenComplete time.Time
+	// Last time any byte was sent or received
+	LastActivity time.Time
+	// Time spent in client, seeding and downloading
+	ActiveSeconds      int64
+	SeedingSeconds     int64
+	DownloadingSeconds int64
+}
+
+// TorrentLog will be saved to storm db as one record for each task, so types of its support is limited
 type TorrentLog struct {
+	// Info hash of task, for magnets being analysed too
+	HexString string `storm:"id"`
 	metainfo.MetaInfo
-	TorrentName string
-	Status      TorrentStatus
+	TorrentTimes `storm:"inline"`
+	TorrentName  string
+	Status       TorrentStatus `storm:"index"`
+	StoragePath  string
+	// CustomStoragePath marks a storage path chosen on add, it is kept when DataDir changes
+	CustomStoragePath bool
+	Category          string `storm:"index"`
+	Tags              []string
+	// Magnet added paused, it is stopped instead of started once its info arrives
+	AddPaused bool
+}
+
+// AddOptions Optional settings of a task, zero value means the defaults from config
+type AddOptions struct {
+	Category    string
 	StoragePath string
+	Tags        []string
+	// Task is added stopped, magnets are stopped once resolved
+	Paused bool
 }
 
+// TorrentLogsAndID Tasks loaded from db, they were saved as this single document before db version 2
 type TorrentLogsAndID struct {
 	ID          OnlyStormID `storm:"id"`
 	TorrentLogs []TorrentLog
@@ -96,7 +140,7 @@ type TorrentLogsAndID struct {
 
 type TorrentStatus int
 
-//StormID cant not be zero
+// StormID cant not be zero
 const (
 	QueuedStatus TorrentStatus = iota + 1
 	// AnalysingStatus status only used for magnet
@@ -119,151 +163,234 @@ type OnlyStormID int
 
 const (
 	TorrentLogsID OnlyStormID = iota + 1
+	TorrentDBVersionID
 )
 
-func (engineInfo *RunningInfo) init() {
+func (engineInfo *RunningInfo) init(config *setting.ClientSetting) {
+	engineInfo.config = config
+	engineInfo.logger = config.LoggerSetting.Logger
 	engineInfo.MagnetNum = 0
-	engineInfo.HasRestarted = false
 	engineInfo.EngineCMD = make(chan MessageTypeID, 100)
-	engineInfo.ID = TorrentLogsID
 	engineInfo.HashToTorrentLog = make(map[metainfo.Hash]*TorrentLog)
 	engineInfo.TorrentLogExtends = make(map[metainfo.Hash]*TorrentLogExtend)
 }
 
-func (engineInfo *RunningInfo) AddOneTorrent(singleTorrent *torrent.Torrent) (singleTorrentLog *TorrentLog) {
+func (engineInfo *RunningInfo) AddOneTorrent(singleTorrent *torrent.Torrent, options AddOptions) (singleTorrentLog *TorrentLog) {
 	var isExist bool
 	singleTorrentLog, isExist = engineInfo.HashToTorrentLog[singleTorrent.InfoHash()]
 	if !isExist {
-		singleTorrentLog = createTorrentLogFromTorrent(singleTorrent)
-		engineInfo.TorrentLogs = append(engineInfo.TorrentLogs, *singleTorrentLog)
+		singleTorrentLog = engineInfo.createTorrentLogFromTorrent(singleTorrent, options)
+		engineInfo.TorrentLogs = append(engineInfo.TorrentLogs, singleTorrentLog)
 		engineInfo.UpdateTorrentLog()
 	}
 	return
 }
 
 // AddOneTorrentFromMagnet For magnet
-func (engineInfo *RunningInfo) AddOneTorrentFromMagnet(infoHash metainfo.Hash) (singleTorrentLog *TorrentLog) {
+func (engineInfo *RunningInfo) AddOneTorrentFromMagnet(infoHash metainfo.Hash, options AddOptions) (singleTorrentLog *TorrentLog) {
 	singleTorrentLog, isExist := engineInfo.HashToTorrentLog[infoHash]
 	if !isExist {
-		singleTorrentLog = createTorrentLogFromMagnet(infoHash)
-		engineInfo.TorrentLogs = append(engineInfo.TorrentLogs, *singleTorrentLog)
+		singleTorrentLog = engineInfo.createTorrentLogFromMagnet(infoHash, options)
+		engineInfo.TorrentLogs = append(engineInfo.TorrentLogs, singleTorrentLog)
 		engineInfo.UpdateTorrentLog()
 		//create extend log
 		_, extendIsExist := engineInfo.TorrentLogExtends[infoHash]
 		if !extendIsExist {
-			logger.Debug("create extend for magnet", infoHash)
+			engineInfo.logger.Debug("create extend for magnet", infoHash)
 			engineInfo.TorrentLogExtends[infoHash] = &TorrentLogExtend{
 				HasStatusPub:      false,
 				HasMagnetChan:     true,
 				MagnetAnalyseChan: make(chan bool, 100),
-				MagnetDelChan:     make(chan bool, 100),
 			}
 		} else if extendIsExist && !engineInfo.TorrentLogExtends[infoHash].HasMagnetChan {
 			engineInfo.TorrentLogExtends[infoHash].HasMagnetChan = true
 			engineInfo.TorrentLogExtends[infoHash].MagnetAnalyseChan = make(chan bool, 100)
-			engineInfo.TorrentLogExtends[infoHash].MagnetDelChan = make(chan bool, 100)
 		}
 	}
 	return
 }
 
-// After get magnet info, update log information
-func (engineInfo *RunningInfo) UpdateMagnetInfo(singleTorrent *torrent.Torrent) {
-	singleTorrentLog, _ := engineInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+// UpdateMagnetInfo After get magnet info, update log information
+// Info from DHT, peers or torrent caches is only accepted if it matches the info hash of magnet
+func (engineInfo *RunningInfo) UpdateMagnetInfo(singleTorrent *torrent.Torrent) error {
+	singleTorrentLog, isExist := engineInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+	if !isExist {
+		return fmt.Errorf("no magnet log for %s", singleTorrent.InfoHash().HexString())
+	}
+	torrentMetaInfo := singleTorrent.Metainfo()
+	if torrentMetaInfo.HashInfoBytes() != singleTorrent.InfoHash() {
+		return fmt.Errorf("info hash mismatch, got %s", torrentMetaInfo.HashInfoBytes().HexString())
+	}
 	singleTorrentLog.TorrentName = singleTorrent.Name()
-	singleTorrentLog.MetaInfo = singleTorrent.Metainfo()
+	singleTorrentLog.MetaInfo = torrentMetaInfo
+	singleTorrentLog.MetadataTime = time.Now()
 	singleTorrentLog.Status = QueuedStatus
 	engineInfo.UpdateTorrentLog()
 
 	singleTorrentLogExtend, _ := engineInfo.TorrentLogExtends[singleTorrent.InfoHash()]
 	singleTorrentLogExtend.HasMagnetChan = false
 	close(singleTorrentLogExtend.MagnetAnalyseChan)
-	close(singleTorrentLogExtend.MagnetDelChan)
-	return
+	return nil
 }
 
 func (engine *Engine) UpdateInfo() {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	engine.updateInfo()
+}
+
+func (engine *Engine) updateInfo() {
 	engine.EngineRunningInfo.UpdateTorrentLog()
-	engine.UpdateWebInfo()
+	engine.updateWebInfo()
 }
 
 func (engineInfo *RunningInfo) UpdateTorrentLog() {
 	engineInfo.HashToTorrentLog = make(map[metainfo.Hash]*TorrentLog)
+	for _, singleTorrentLog := range engineInfo.TorrentLogs {
+		engineInfo.HashToTorrentLog[torrentLogHash(*singleTorrentLog)] = singleTorrentLog
+	}
+}
 
+// removeTorrentLog Remove a task from list and maps, list is never changed while it is iterated
+func (engineInfo *RunningInfo) removeTorrentLog(infoHash metainfo.Hash) (removed bool) {
 	for index, singleTorrentLog := range engineInfo.TorrentLogs {
-		if singleTorrentLog.Status != AnalysingStatus {
-			engineInfo.HashToTorrentLog[singleTorrentLog.HashInfoBytes()] = &engineInfo.TorrentLogs[index]
-		} else {
-			torrentHash := metainfo.Hash{}
-			_ = torrentHash.FromHexString(singleTorrentLog.TorrentName)
-			engineInfo.HashToTorrentLog[torrentHash] = &engineInfo.TorrentLogs[index]
+		if torrentLogHash(*singleTorrentLog) == infoHash {
+			engineInfo.TorrentLogs = append(engineInfo.TorrentLogs[:index], engineInfo.TorrentLogs[index+1:]...)
+			removed = true
+			break
 		}
 	}
+	delete(engineInfo.HashToTorrentLog, infoHash)
+	delete(engineInfo.TorrentLogExtends, infoHash)
+	return
 }
 
 func (engine *Engine) UpdateWebInfo() {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	engine.updateWebInfo()
+}
+
+func (engine *Engine) updateWebInfo() {
 	engine.WebInfo.HashToTorrentWebInfo = make(map[metainfo.Hash]*TorrentWebInfo)
 	for _, singleTorrent := range engine.TorrentEngine.Torrents() {
-		engine.WebInfo.HashToTorrentWebInfo[singleTorrent.InfoHash()] = engine.GenerateInfoFromTorrent(singleTorrent)
+		if torrentWebInfo := engine.generateInfoFromTorrent(singleTorrent); torrentWebInfo != nil {
+			engine.WebInfo.HashToTorrentWebInfo[singleTorrent.InfoHash()] = torrentWebInfo
+		}
 	}
 }
 
-func createTorrentLogFromTorrent(singleTorrent *torrent.Torrent) *TorrentLog {
-	absPath, err := filepath.Abs(clientConfig.EngineSetting.TorrentConfig.DataDir)
-	if err != nil {
-		logger.Error("Unable to get abs path -> ", err)
+func (engineInfo *RunningInfo) createTorrentLogFromTorrent(singleTorrent *torrent.Torrent, options AddOptions) *TorrentLog {
+	absPath, isCustom := engineInfo.storagePath(options)
+	timeNow := time.Now()
+	return &TorrentLog{
+		HexString:         singleTorrent.InfoHash().HexString(),
+		MetaInfo:          singleTorrent.Metainfo(),
+		TorrentTimes:      TorrentTimes{AddedTime: timeNow, MetadataTime: timeNow},
+		TorrentName:       singleTorrent.Name(),
+		Status:            QueuedStatus,
+		StoragePath:       absPath,
+		CustomStoragePath: isCustom,
+		Category:          options.Category,
+		Tags:              options.Tags,
 	}
+}
+
+func (engineInfo *RunningInfo) createTorrentLogFromMagnet(infoHash metainfo.Hash, options AddOptions) *TorrentLog {
+	absPath, isCustom := engineInfo.storagePath(options)
 	return &TorrentLog{
-		MetaInfo:    singleTorrent.Metainfo(),
-		TorrentName: singleTorrent.Name(),
-		Status:      QueuedStatus,
-		StoragePath: absPath,
+		HexString:         infoHash.HexString(),
+		MetaInfo:          metainfo.MetaInfo{},
+		TorrentTimes:      TorrentTimes{AddedTime: time.Now()},
+		TorrentName:       infoHash.String(),
+		Status:            AnalysingStatus,
+		StoragePath:       absPath,
+		CustomStoragePath: isCustom,
+		Category:          options.Category,
+		Tags:              options.Tags,
+		AddPaused:         options.Paused,
 	}
 }
 
-func createTorrentLogFromMagnet(infoHash metainfo.Hash) *TorrentLog {
-	absPath, err := filepath.Abs(clientConfig.EngineSetting.TorrentConfig.DataDir)
+// Absolute storage path of a new task, and whether it differs from DataDir
+func (engineInfo *RunningInfo) storagePath(options AddOptions) (absPath string, isCustom bool) {
+	defaultPath, err := filepath.Abs(engineInfo.config.Current().EngineSetting.TorrentConfig.DataDir)
 	if err != nil {
-		logger.Error("Unable to get abs path -> ", err)
+		engineInfo.logger.Error("Unable to get abs path -> ", err)
 	}
-	return &TorrentLog{
-		MetaInfo:    metainfo.MetaInfo{},
-		TorrentName: infoHash.String(),
-		Status:      AnalysingStatus,
-		StoragePath: absPath,
+	if options.StoragePath == "" {
+		return defaultPath, false
+	}
+	absPath, err = filepath.Abs(options.StoragePath)
+	if err != nil {
+		engineInfo.logger.Error("Unable to get abs path -> ", err)
+		return defaultPath, false
 	}
+	return absPath, absPath != defaultPath
 }
 
 func generateByteSize(byteSize int64) string {
 	return humanize.Bytes(uint64(byteSize))
 }
 
+// copy Info in map is changed by engine later, callers get a copy of it
+func (torrentWebInfo TorrentWebInfo) copy() *TorrentWebInfo {
+	torrentWebInfo.Files = append([]FileInfo(nil), torrentWebInfo.Files...)
+	return &torrentWebInfo
+}
+
 // GenerateInfoFromLog For complete status
-func (engine *Engine) GenerateInfoFromLog(torrentLog TorrentLog) (torrentWebInfo *TorrentWebInfo) {
-	torrentWebInfo, _ = engine.WebInfo.HashToTorrentWebInfo[torrentLog.HashInfoBytes()]
+func (engine *Engine) GenerateInfoFromLog(torrentLog TorrentLog) *TorrentWebInfo {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return engine.generateInfoFromLog(torrentLog).copy()
+}
+
+func (engine *Engine) generateInfoFromLog(torrentLog TorrentLog) (torrentWebInfo *TorrentWebInfo) {
 	torrentWebInfo = &TorrentWebInfo{
-		TorrentName: torrentLog.TorrentName,
-		HexString:   torrentLog.HashInfoBytes().HexString(),
-		Status:      StatusIDToName[torrentLog.Status],
-		StoragePath: torrentLog.StoragePath,
-		Percentage:  1,
+		TorrentName:  torrentLog.TorrentName,
+		HexString:    torrentLog.HashInfoBytes().HexString(),
+		Status:       StatusIDToName[torrentLog.Status],
+		StoragePath:  torrentLog.StoragePath,
+		Category:     torrentLog.Category,
+		Percentage:   1,
+		TorrentTimes: torrentLog.TorrentTimes,
 	}
 	engine.WebInfo.HashToTorrentWebInfo[torrentLog.HashInfoBytes()] = torrentWebInfo
 	return
 }
 
-func (engine *Engine) GenerateInfoFromTorrent(singleTorrent *torrent.Torrent) (torrentWebInfo *TorrentWebInfo) {
+// GenerateInfoFromTorrent Nil for a task that is deleted or still waits for its info
+func (engine *Engine) GenerateInfoFromTorrent(singleTorrent *torrent.Torrent) *TorrentWebInfo {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	torrentWebInfo := engine.generateInfoFromTorrent(singleTorrent)
+	if torrentWebInfo == nil {
+		return nil
+	}
+	return torrentWebInfo.copy()
+}
+
+// generateInfoFromTorrent Torrents are added to client before their logs and dropped after, so a torrent
+// may have no log or no info yet, nil is returned for them rather than waiting with state locked
+func (engine *Engine) generateInfoFromTorrent(singleTorrent *torrent.Torrent) (torrentWebInfo *TorrentWebInfo) {
+	torrentLog, logExist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+	if !logExist {
+		return nil
+	}
 	torrentWebInfo, isExist := engine.WebInfo.HashToTorrentWebInfo[singleTorrent.InfoHash()]
+	if torrentLog.Status != AnalysingStatus && singleTorrent.Info() == nil {
+		return nil
+	}
 	if !isExist || torrentWebInfo.Status == StatusIDToName[AnalysingStatus] {
-		torrentLog, _ := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
 		if torrentLog.Status != AnalysingStatus {
-			<-singleTorrent.GotInfo()
 			torrentWebInfo = &TorrentWebInfo{
 				TorrentName:   singleTorrent.Info().Name,
 				TotalLength:   generateByteSize(singleTorrent.Info().TotalLength()),
 				HexString:     torrentLog.HashInfoBytes().HexString(),
 				Status:        StatusIDToName[torrentLog.Status],
 				StoragePath:   torrentLog.StoragePath,
+				Category:      torrentLog.Category,
 				Percentage:    float64(singleTorrent.BytesCompleted()) / float64(singleTorrent.Info().TotalLength()),
 				DownloadSpeed: "Estimating",
 				LeftTime:      "Estimating",
@@ -284,6 +411,7 @@ func (engine *Engine) GenerateInfoFromTorrent(singleTorrent *torrent.Torrent) (t
 				HexString:     torrentLog.TorrentName,
 				Status:        StatusIDToName[torrentLog.Status],
 				StoragePath:   torrentLog.StoragePath,
+				Category:      torrentLog.Category,
 				Percentage:    0,
 				DownloadSpeed: "Estimating",
 				LeftTime:      "Estimating",
@@ -291,9 +419,10 @@ func (engine *Engine) GenerateInfoFromTorrent(singleTorrent *torrent.Torrent) (t
 				UpdateTime:    time.Now(),
 			}
 		}
+		torrentWebInfo.TorrentTimes = torrentLog.TorrentTimes
 		engine.WebInfo.HashToTorrentWebInfo[singleTorrent.InfoHash()] = torrentWebInfo
 	} else {
-		torrentLog, _ := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+		torrentWebInfo.TorrentTimes = torrentLog.TorrentTimes
 		torrentWebInfo.TorrentStatus = singleTorrent.Stats()
 		torrentWebInfo.Status = StatusIDToName[torrentLog.Status]
 
@@ -308,7 +437,8 @@ func (engine *Engine) GenerateInfoFromTorrent(singleTorrent *torrent.Torrent) (t
 			torrentWebInfo.Percentage = percentageNow
 			torrentWebInfo.UpdateTime = time.Now()
 			if torrentWebInfo.Percentage == 1 {
-				engine.CompleteOneTorrent(singleTorrent)
+				// data is verified there, which is too slow to be done with state locked
+				go engine.CompleteOneTorrent(singleTorrent)
 			}
 		}
 	}
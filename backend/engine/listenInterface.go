@@ -0,0 +1,344 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	log "github.com/sirupsen/logrus"
+)
+
+// Time between checks of ListenInterface
+const interfacePollInterval = 2 * time.Second
+
+var errInterfaceDown = errors.New("listen interface is down")
+
+// interfaceBinding Addresses of ListenInterface a client is bound to. A binding without address
+// was made while the interface was gone, its client reaches no peers
+type interfaceBinding struct {
+	name string
+	ipv4 net.IP
+	ipv6 net.IP
+	// TCP listeners bound to the addresses, torrent client does not close them
+	listeners []net.Listener
+	// listen and dial TCP through listeners, false if TCP is off or goes through proxy
+	tcp bool
+	// 1 once the bound addresses are gone
+	down int32
+}
+
+func (binding *interfaceBinding) isDown() bool {
+	return (binding.ipv4 == nil && binding.ipv6 == nil) || atomic.LoadInt32(&binding.down) == 1
+}
+
+// host ListenHost of client config
+func (binding *interfaceBinding) host(network string) string {
+	if strings.HasSuffix(network, "6") {
+		return binding.ipv6.String()
+	}
+	return binding.ipv4.String()
+}
+
+// bound Whether bound addresses are the ones interface has now
+func (binding *interfaceBinding) bound(ipv4, ipv6 net.IP) bool {
+	if binding.ipv4 == nil && binding.ipv6 == nil {
+		return false
+	}
+	return binding.ipv4.Equal(ipv4) && binding.ipv6.Equal(ipv6)
+}
+
+func (binding *interfaceBinding) addresses() (addresses []string) {
+	for _, ip := range []net.IP{binding.ipv4, binding.ipv6} {
+		if ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return
+}
+
+// listen Bind a TCP listener and dialer for each address, on the port of uTP sockets if client has them
+func (binding *interfaceBinding) listen(client *torrent.Client, port int) error {
+	if !binding.tcp {
+		return nil
+	}
+	if localPort := client.LocalPort(); localPort != 0 {
+		port = localPort
+	}
+	for _, bound := range []struct {
+		network string
+		ip      net.IP
+	}{{"tcp4", binding.ipv4}, {"tcp6", binding.ipv6}} {
+		if bound.ip == nil {
+			continue
+		}
+		listener, err := net.Listen(bound.network, net.JoinHostPort(bound.ip.String(), strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		port = listener.Addr().(*net.TCPAddr).Port
+		binding.listeners = append(binding.listeners, listener)
+		client.AddListener(listener)
+		client.AddDialer(torrent.NetworkDialer{
+			Network: bound.network,
+			Dialer:  &net.Dialer{LocalAddr: &net.TCPAddr{IP: bound.ip}},
+		})
+	}
+	return nil
+}
+
+func (binding *interfaceBinding) close() {
+	for _, listener := range binding.listeners {
+		_ = listener.Close()
+	}
+	binding.listeners = nil
+}
+
+// dialContext Dial addr from the bound address of its family, host names are looked up first
+func (binding *interfaceBinding) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if binding.isDown() {
+		return nil, errInterfaceDown
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	err = fmt.Errorf("%s can not be reached from interface %s", host, binding.name)
+	for _, ipAddr := range ipAddrs {
+		localIP := binding.ipv4
+		if ipAddr.IP.To4() == nil {
+			localIP = binding.ipv6
+		}
+		if localIP == nil {
+			continue
+		}
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: localIP}}
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ipAddr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// interfaceAddrs First IPv4 and IPv6 addresses of an interface which is up, families disabled
+// in settings are left out. Link-local IPv6 addresses can not be bound without zone, they are skipped too
+func (engine *Engine) interfaceAddrs(name string) (ipv4, ipv6 net.IP, err error) {
+	netInterface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if netInterface.Flags&net.FlagUp == 0 {
+		return nil, nil, errInterfaceDown
+	}
+	addrs, err := netInterface.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	torrentConfig := engine.config.Current().EngineSetting.TorrentConfig
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			if ipv4 == nil && !torrentConfig.DisableIPv4 {
+				ipv4 = ip4
+			}
+		} else if ipv6 == nil && ipNet.IP.IsGlobalUnicast() && !torrentConfig.DisableIPv6 {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv4 == nil && ipv6 == nil {
+		err = fmt.Errorf("interface %s has no usable address", name)
+	}
+	return
+}
+
+func (engine *Engine) interfaceBinding() *interfaceBinding {
+	binding, _ := engine.binding.Load().(*interfaceBinding)
+	return binding
+}
+
+// bindInterface Prepare config of a new client for ListenInterface of settings: sockets listen on
+// its addresses, and TCP goes through listeners and dialers bound to them. WebRTC peers, UDP trackers
+// and port forwarding can not be bound, they are turned off. Torrent client can not bind HTTP trackers
+// and web seeds either, they follow routing table. Nil is returned if no interface is set
+func (engine *Engine) bindInterface(clientConfig *torrent.ClientConfig) *interfaceBinding {
+	name := engine.config.Current().ListenInterface
+	if name == "" {
+		return nil
+	}
+	binding := &interfaceBinding{name: name}
+	var err error
+	binding.ipv4, binding.ipv6, err = engine.interfaceAddrs(name)
+	if err != nil {
+		engine.logger.WithFields(log.Fields{"Error": err, "Interface": name}).Warn("Listen interface is not usable, no peer is reached until it is")
+		clientConfig.DisableUTP = true
+		clientConfig.NoDHT = true
+		clientConfig.DisableTCP = true
+	}
+	clientConfig.DisableIPv4 = binding.ipv4 == nil
+	clientConfig.DisableIPv6 = binding.ipv6 == nil
+	clientConfig.ListenHost = binding.host
+	clientConfig.DisableWebtorrent = true
+	clientConfig.NoDefaultPortForwarding = true
+	clientConfig.LookupTrackerIp = engine.lookupTrackerIP
+	binding.tcp = !clientConfig.DisableTCP
+	clientConfig.DisableTCP = true
+	engine.logger.WithFields(log.Fields{"Interface": name}).Warn("HTTP trackers and web seeds can not be bound to listen interface, they follow routing table")
+	return binding
+}
+
+// dialContext Dial from ListenInterface if it is set
+func (engine *Engine) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if binding := engine.interfaceBinding(); binding != nil {
+		return binding.dialContext(ctx, network, addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// boundDialer Forward dialer of SOCKS5 proxies, dials from ListenInterface if it is set
+type boundDialer struct {
+	engine *Engine
+}
+
+func (dialer boundDialer) Dial(network, addr string) (net.Conn, error) {
+	return dialer.engine.dialContext(context.Background(), network, addr)
+}
+
+func (dialer boundDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialer.engine.dialContext(ctx, network, addr)
+}
+
+type interfaceWatcher struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startInterfaceWatch Follow ListenInterface, it keeps running when the interface is changed or unset
+func (engine *Engine) startInterfaceWatch() {
+	watcher := &interfaceWatcher{stop: make(chan struct{}), done: make(chan struct{})}
+	engine.interfaceWatch = watcher
+	go func() {
+		defer close(watcher.done)
+		ticker := time.NewTicker(interfacePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				engine.checkInterface()
+			case <-watcher.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (engine *Engine) stopInterfaceWatch() {
+	if engine.interfaceWatch == nil {
+		return
+	}
+	close(engine.interfaceWatch.stop)
+	<-engine.interfaceWatch.done
+	engine.interfaceWatch = nil
+}
+
+// checkInterface Pause running tasks when bound addresses are gone, trackers and web seeds are
+// refused meanwhile. Client is bound again and tasks resumed when interface has addresses again
+func (engine *Engine) checkInterface() {
+	engine.stateLock.Lock()
+	binding := engine.interfaceBinding()
+	if binding == nil {
+		// interface is not used anymore
+		engine.resumeInterfacePaused()
+		engine.stateLock.Unlock()
+		return
+	}
+	ipv4, ipv6, err := engine.interfaceAddrs(binding.name)
+	if binding.bound(ipv4, ipv6) && atomic.LoadInt32(&binding.down) == 0 {
+		engine.stateLock.Unlock()
+		return
+	}
+	if atomic.CompareAndSwapInt32(&binding.down, 0, 1) {
+		engine.logger.WithFields(log.Fields{"Error": err, "Interface": binding.name}).Warn("Listen interface is gone, tasks are paused")
+	}
+	engine.pauseForInterface()
+	engine.stateLock.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err = engine.RestartClient(); err != nil {
+		return
+	}
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	if binding = engine.interfaceBinding(); binding != nil && !binding.isDown() {
+		engine.logger.WithFields(log.Fields{"Interface": binding.name, "Addresses": binding.addresses()}).Info("Listen interface is back, tasks are resumed")
+		engine.resumeInterfacePaused()
+	}
+}
+
+// pauseForInterface Stop running tasks and remember them, caller holds stateLock
+func (engine *Engine) pauseForInterface() {
+	for _, singleTorrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		if singleTorrentLog.Status != RunningStatus {
+			continue
+		}
+		hexString := singleTorrentLog.HashInfoBytes().HexString()
+		if engine.stopOneTorrent(hexString) {
+			engine.interfacePaused = append(engine.interfacePaused, hexString)
+		}
+	}
+}
+
+// resumeInterfacePaused Start tasks paused by pauseForInterface which are still stopped, caller holds stateLock
+func (engine *Engine) resumeInterfacePaused() {
+	for _, hexString := range engine.interfacePaused {
+		singleTorrent, isExist := engine.getOneTorrent(hexString)
+		if !isExist {
+			continue
+		}
+		if singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]; isExist && singleTorrentLog.Status == StoppedStatus {
+			engine.startDownloadTorrent(hexString)
+		}
+	}
+	engine.interfacePaused = nil
+}
+
+// ListenInterfaceStatus State of ListenInterface of settings
+type ListenInterfaceStatus struct {
+	Name string
+	Up   bool
+	// Addresses client is bound to
+	Addresses []string
+	// Tasks paused while interface is gone
+	PausedTorrents int
+}
+
+func (engine *Engine) listenInterfaceStatus() *ListenInterfaceStatus {
+	binding := engine.interfaceBinding()
+	if binding == nil {
+		return nil
+	}
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	return &ListenInterfaceStatus{
+		Name:           binding.name,
+		Up:             !binding.isDown(),
+		Addresses:      binding.addresses(),
+		PausedTorrents: len(engine.interfacePaused),
+	}
+}
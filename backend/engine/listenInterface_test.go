@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newLoopbackEngine Engine bound to loopback interface
+func newLoopbackEngine(t *testing.T) *Engine {
+	t.Helper()
+	if _, err := net.InterfaceByName("lo"); err != nil {
+		t.Skip("no loopback interface named lo")
+	}
+	config := strings.Replace(testConfig, "[torrentconfig]\n", "[torrentconfig]\n  listeninterface = \"lo\"\n", 1)
+	engine := newTestEngineWithConfig(t, config)
+	if binding := engine.interfaceBinding(); binding == nil || binding.isDown() {
+		t.Fatalf("engine is not bound to lo: %+v", binding)
+	}
+	return engine
+}
+
+func TestInterfaceDropsUDPTrackers(t *testing.T) {
+	engine := newLoopbackEngine(t)
+	trackers := engine.trackers([][]string{
+		{"udp://tracker.invalid:80/announce", "http://tracker.invalid/announce"},
+		{"udp://other.invalid:80/announce"},
+		{"wss://tracker.invalid/announce", "https://tracker.invalid/announce"},
+	})
+	want := [][]string{{"http://tracker.invalid/announce"}, {"https://tracker.invalid/announce"}}
+	if !reflect.DeepEqual(trackers, want) {
+		t.Errorf("trackers %v, want %v", trackers, want)
+	}
+}
+
+// TestInterfaceBindsHTTPClient Feeds and other downloads of engine are refused while interface is gone
+func TestInterfaceBindsHTTPClient(t *testing.T) {
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		fmt.Fprint(w, `<rss><channel><item><title>bound</title></item></channel></rss>`)
+	}))
+	defer server.Close()
+	engine := newLoopbackEngine(t)
+
+	if items, err := engine.FetchFeed(server.URL); err != nil || len(items) != 1 {
+		t.Fatalf("items %+v, error %v", items, err)
+	}
+	atomic.StoreInt32(&engine.interfaceBinding().down, 1)
+	// connection of last fetch is not reused
+	engine.httpClient.CloseIdleConnections()
+	if _, err := engine.FetchFeed(server.URL); !errors.Is(err, errInterfaceDown) {
+		t.Errorf("error %v while interface is down", err)
+	}
+	if got := atomic.LoadInt32(&fetched); got != 1 {
+		t.Errorf("feed fetched %d times", got)
+	}
+}
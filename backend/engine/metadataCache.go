@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	log "github.com/sirupsen/logrus"
+)
+
+func torrentCacheURL(template string, infoHash metainfo.Hash) string {
+	hexString := infoHash.HexString()
+	template = strings.ReplaceAll(template, "{HASH}", strings.ToUpper(hexString))
+	return strings.ReplaceAll(template, "{hash}", hexString)
+}
+
+// fetchFromCaches races all torrent caches against DHT and peers, first valid info wins.
+// It returns once info is known, whoever provides it, or ctx is done.
+func (engine *Engine) fetchFromCaches(ctx context.Context, singleTorrent *torrent.Torrent) {
+	torrentCaches := engine.config.Current().EngineSetting.TorrentCaches
+	if len(torrentCaches) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-singleTorrent.GotInfo():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	infoHash := singleTorrent.InfoHash()
+	results := make(chan *metainfo.MetaInfo, len(torrentCaches))
+	for _, template := range torrentCaches {
+		go func(cacheURL string) {
+			torrentMetaInfo, err := engine.fetchOneCache(ctx, cacheURL, infoHash)
+			if err != nil {
+				engine.logger.WithFields(log.Fields{"Error": err, "URL": cacheURL}).Debug("Torrent cache missed")
+				torrentMetaInfo = nil
+			}
+			results <- torrentMetaInfo
+		}(torrentCacheURL(template, infoHash))
+	}
+
+	for range torrentCaches {
+		var torrentMetaInfo *metainfo.MetaInfo
+		select {
+		case torrentMetaInfo = <-results:
+		case <-ctx.Done():
+			return
+		}
+		if torrentMetaInfo == nil {
+			continue
+		}
+		err := singleTorrent.SetInfoBytes(torrentMetaInfo.InfoBytes)
+		if err != nil {
+			engine.logger.WithFields(log.Fields{"Error": err, "Hash": infoHash}).Error("Unable to use info from torrent cache")
+			continue
+		}
+		engine.addTrackers(singleTorrent, torrentMetaInfo.UpvertedAnnounceList())
+		engine.logger.WithFields(log.Fields{"Hash": infoHash}).Info("Magnet resolved from torrent cache")
+		return
+	}
+}
+
+// Download one cached torrent, info is only returned if it matches the info hash
+func (engine *Engine) fetchOneCache(ctx context.Context, cacheURL string, infoHash metainfo.Hash) (*metainfo.MetaInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := engine.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	// some caches send gzip files without Content-Encoding, both sizes are limited
+	var body io.Reader = bufio.NewReader(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		body, err = gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	torrentFile, err := io.ReadAll(io.LimitReader(body, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(torrentFile) > maxTorrentFileSize {
+		return nil, fmt.Errorf("torrent file is larger than %d bytes", maxTorrentFileSize)
+	}
+	torrentMetaInfo, err := metainfo.Load(bytes.NewReader(torrentFile))
+	if err != nil {
+		return nil, err
+	}
+	if torrentMetaInfo.HashInfoBytes() != infoHash {
+		return nil, fmt.Errorf("info hash mismatch, got %s", torrentMetaInfo.HashInfoBytes().HexString())
+	}
+	return torrentMetaInfo, nil
+}
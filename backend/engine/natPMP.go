@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NAT-PMP (RFC 6886) and PCP (RFC 6887) gateways listen on the same port. A NAT-PMP gateway
+// answers PCP requests with version 0 and result unsupported version
+const (
+	natPMPPort    = 5351
+	natPMPVersion = 0
+	pcpVersion    = 2
+
+	natPMPOpAddress = 0
+	natPMPOpMapUDP  = 1
+	natPMPOpMapTCP  = 2
+	pcpOpAnnounce   = 0
+	pcpOpMap        = 1
+
+	natPMPResultUnsupportedVersion = 1
+	// First wait for an answer, it is doubled for every retry as both RFCs ask
+	natPMPRetry = 250 * time.Millisecond
+)
+
+var errUnsupportedVersion = errors.New("gateway does not support this version")
+
+// natPMPGateway Maps ports with PCP, or NAT-PMP if pcp is false
+type natPMPGateway struct {
+	addr    *net.UDPAddr
+	pcp     bool
+	localIP net.IP
+	// PCP tells mappings of a client apart by nonce, renewing and removing one needs the same
+	nonce      [12]byte
+	externalIP net.IP
+}
+
+// newNATPMPGateway Check that gateway speaks PCP or NAT-PMP, gateway is host or host:port,
+// the default gateway of system if empty
+func newNATPMPGateway(ctx context.Context, gateway string, pcp bool) (*natPMPGateway, error) {
+	addr, err := natPMPAddr(gateway)
+	if err != nil {
+		return nil, err
+	}
+	natGateway := &natPMPGateway{addr: addr, pcp: pcp}
+	if pcp {
+		if _, err = rand.Read(natGateway.nonce[:]); err != nil {
+			return nil, err
+		}
+		// an announce asks for nothing, it only gets an answer from PCP gateways
+		_, err = natGateway.request(ctx, pcpOpAnnounce, natGateway.pcpHeader(pcpOpAnnounce, 0))
+		return natGateway, err
+	}
+	response, err := natGateway.request(ctx, natPMPOpAddress, []byte{natPMPVersion, natPMPOpAddress})
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 12 {
+		return nil, errors.New("answer of gateway is too short")
+	}
+	natGateway.externalIP = net.IP(response[8:12])
+	return natGateway, nil
+}
+
+func (gateway *natPMPGateway) protocol() string {
+	if gateway.pcp {
+		return PortMappingPCP
+	}
+	return PortMappingNATPMP
+}
+
+func (gateway *natPMPGateway) address() string {
+	return gateway.addr.String()
+}
+
+func (gateway *natPMPGateway) localAddress() net.IP {
+	return gateway.localIP
+}
+
+func (gateway *natPMPGateway) getExternalIP(ctx context.Context) (net.IP, error) {
+	if gateway.externalIP == nil {
+		return nil, errors.New("gateway has not told its external address")
+	}
+	return gateway.externalIP, nil
+}
+
+func (gateway *natPMPGateway) addMapping(ctx context.Context, network string, internalPort, externalPort int, lease time.Duration) (int, time.Duration, error) {
+	return gateway.mapPort(ctx, network, internalPort, externalPort, lease)
+}
+
+// deleteMapping A mapping with lifetime 0 is removed
+func (gateway *natPMPGateway) deleteMapping(ctx context.Context, network string, internalPort, externalPort int) error {
+	_, _, err := gateway.mapPort(ctx, network, internalPort, 0, 0)
+	return err
+}
+
+func (gateway *natPMPGateway) mapPort(ctx context.Context, network string, internalPort, externalPort int, lease time.Duration) (int, time.Duration, error) {
+	lifetime := uint32(lease / time.Second)
+	if !gateway.pcp {
+		op := byte(natPMPOpMapTCP)
+		if network == "udp" {
+			op = natPMPOpMapUDP
+		}
+		request := make([]byte, 12)
+		request[0], request[1] = natPMPVersion, op
+		binary.BigEndian.PutUint16(request[4:], uint16(internalPort))
+		binary.BigEndian.PutUint16(request[6:], uint16(externalPort))
+		binary.BigEndian.PutUint32(request[8:], lifetime)
+		response, err := gateway.request(ctx, op, request)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(response) < 16 {
+			return 0, 0, errors.New("answer of gateway is too short")
+		}
+		return int(binary.BigEndian.Uint16(response[10:])), time.Duration(binary.BigEndian.Uint32(response[12:])) * time.Second, nil
+	}
+
+	protocol := byte(6)
+	if network == "udp" {
+		protocol = 17
+	}
+	request := gateway.pcpHeader(pcpOpMap, lifetime)
+	payload := make([]byte, 36)
+	copy(payload, gateway.nonce[:])
+	payload[12] = protocol
+	binary.BigEndian.PutUint16(payload[16:], uint16(internalPort))
+	binary.BigEndian.PutUint16(payload[18:], uint16(externalPort))
+	copy(payload[20:], net.IPv4zero.To16())
+	response, err := gateway.request(ctx, pcpOpMap, append(request, payload...))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(response) < 60 {
+		return 0, 0, errors.New("answer of gateway is too short")
+	}
+	if lifetime > 0 {
+		gateway.externalIP = net.IP(response[44:60])
+		if ip4 := gateway.externalIP.To4(); ip4 != nil {
+			gateway.externalIP = ip4
+		}
+	}
+	return int(binary.BigEndian.Uint16(response[42:])), time.Duration(binary.BigEndian.Uint32(response[4:])) * time.Second, nil
+}
+
+// pcpHeader Common header of PCP requests, it carries address of client
+func (gateway *natPMPGateway) pcpHeader(op byte, lifetime uint32) []byte {
+	header := make([]byte, 24)
+	header[0], header[1] = pcpVersion, op
+	binary.BigEndian.PutUint32(header[4:], lifetime)
+	if gateway.localIP != nil {
+		copy(header[8:], gateway.localIP.To16())
+	}
+	return header
+}
+
+// request Send request until gateway answers it or ctx is done, and check result code of answer
+func (gateway *natPMPGateway) request(ctx context.Context, op byte, request []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp", nil, gateway.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if gateway.localIP == nil {
+		gateway.localIP = conn.LocalAddr().(*net.UDPAddr).IP
+		if gateway.pcp {
+			copy(request[8:24], gateway.localIP.To16())
+		}
+	}
+	response := make([]byte, 1100)
+	for wait := natPMPRetry; ; wait *= 2 {
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(wait)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			n, readErr := conn.Read(response)
+			if readErr != nil {
+				break
+			}
+			// answers to older requests of other opcodes are skipped
+			if n < 4 || response[1] != op|0x80 {
+				continue
+			}
+			return gateway.checkResult(response[:n])
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("no answer from gateway %s: %w", gateway.addr, ctx.Err())
+		}
+	}
+}
+
+func (gateway *natPMPGateway) checkResult(response []byte) ([]byte, error) {
+	var result int
+	if response[0] == pcpVersion {
+		result = int(response[3])
+	} else {
+		result = int(binary.BigEndian.Uint16(response[2:]))
+	}
+	switch {
+	case result == natPMPResultUnsupportedVersion || (gateway.pcp && response[0] != pcpVersion):
+		return nil, errUnsupportedVersion
+	case result != 0:
+		return nil, fmt.Errorf("gateway refused with result code %d", result)
+	}
+	return response, nil
+}
+
+// natPMPAddr Address of gateway, natPMPPort is used if it has no port
+func natPMPAddr(gateway string) (*net.UDPAddr, error) {
+	if gateway == "" {
+		gatewayIP, err := defaultGateway()
+		if err != nil {
+			return nil, err
+		}
+		gateway = gatewayIP.String()
+	}
+	if _, _, err := net.SplitHostPort(gateway); err != nil {
+		gateway = net.JoinHostPort(gateway, strconv.Itoa(natPMPPort))
+	}
+	return net.ResolveUDPAddr("udp4", gateway)
+}
+
+// defaultGateway IPv4 default gateway from routing table of Linux. Elsewhere it is guessed as
+// address 1 of the local network, which is the one of most home routers
+func defaultGateway() (net.IP, error) {
+	if routes, err := os.Open("/proc/net/route"); err == nil {
+		defer routes.Close()
+		scanner := bufio.NewScanner(routes)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			gateway, err := hex.DecodeString(fields[2])
+			if err == nil && len(gateway) == 4 {
+				// written in byte order of host, which is little endian
+				return net.IPv4(gateway[3], gateway[2], gateway[1], gateway[0]), nil
+			}
+		}
+	}
+	localIP, err := outboundIP()
+	if err != nil {
+		return nil, fmt.Errorf("default gateway is not known: %w", err)
+	}
+	localIP = localIP.To4()
+	if localIP == nil || !localIP.IsPrivate() {
+		return nil, errors.New("default gateway is not known")
+	}
+	return net.IPv4(localIP[0], localIP[1], localIP[2], 1), nil
+}
+
+// outboundIP Local IPv4 address of default route, nothing is sent to find it
+func outboundIP() (net.IP, error) {
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMapRequest A map request seen by fakeNATPMP, lifetime 0 removes a mapping
+type fakeMapRequest struct {
+	pcp          bool
+	network      string
+	internalPort int
+	externalPort int
+	lifetime     uint32
+	nonce        [12]byte
+}
+
+// fakeNATPMP Gateway on loopback answering PCP and NAT-PMP, or only one of them
+type fakeNATPMP struct {
+	conn       *net.UDPConn
+	pcp        bool
+	natPMP     bool
+	externalIP net.IP
+
+	lock sync.Mutex
+	// Longest lease granted, in seconds
+	maxLease uint32
+	// External ports in use by other hosts, the next free one is given instead
+	takenPorts map[int]bool
+	requests   []fakeMapRequest
+}
+
+func newFakeNATPMP(t *testing.T, pcp bool, natPMP bool) *fakeNATPMP {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeNATPMP{
+		conn:       conn,
+		pcp:        pcp,
+		natPMP:     natPMP,
+		externalIP: net.IPv4(203, 0, 113, 7).To4(),
+		maxLease:   3600,
+		takenPorts: make(map[int]bool),
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go fake.serve()
+	return fake
+}
+
+func (fake *fakeNATPMP) address() string {
+	return fake.conn.LocalAddr().String()
+}
+
+func (fake *fakeNATPMP) mapRequests() []fakeMapRequest {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return append([]fakeMapRequest(nil), fake.requests...)
+}
+
+func (fake *fakeNATPMP) takePort(port int) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.takenPorts[port] = true
+}
+
+func (fake *fakeNATPMP) setMaxLease(seconds uint32) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.maxLease = seconds
+}
+
+// assign Record a map request and give its external port and lease
+func (fake *fakeNATPMP) assign(request fakeMapRequest) (int, uint32) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.requests = append(fake.requests, request)
+	if request.lifetime == 0 {
+		return 0, 0
+	}
+	externalPort := request.externalPort
+	if externalPort == 0 {
+		externalPort = request.internalPort
+	}
+	for fake.takenPorts[externalPort] {
+		externalPort++
+	}
+	lifetime := request.lifetime
+	if lifetime > fake.maxLease {
+		lifetime = fake.maxLease
+	}
+	return externalPort, lifetime
+}
+
+func (fake *fakeNATPMP) serve() {
+	buffer := make([]byte, 1100)
+	for {
+		n, addr, err := fake.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		if response := fake.answer(buffer[:n]); response != nil {
+			_, _ = fake.conn.WriteToUDP(response, addr)
+		}
+	}
+}
+
+func (fake *fakeNATPMP) answer(request []byte) []byte {
+	if len(request) < 2 {
+		return nil
+	}
+	version, op := request[0], request[1]
+	switch {
+	case version == pcpVersion && !fake.pcp:
+		// NAT-PMP gateways answer in their own version
+		return []byte{natPMPVersion, op | 0x80, 0, natPMPResultUnsupportedVersion}
+	case version == natPMPVersion && !fake.natPMP:
+		response := make([]byte, 24)
+		response[0], response[1], response[3] = pcpVersion, op|0x80, natPMPResultUnsupportedVersion
+		return response
+	case version == pcpVersion:
+		response := make([]byte, 24)
+		response[0], response[1] = pcpVersion, op|0x80
+		if op != pcpOpMap || len(request) < 60 {
+			return response
+		}
+		mapRequest := fakeMapRequest{
+			pcp:          true,
+			network:      "tcp",
+			internalPort: int(binary.BigEndian.Uint16(request[40:])),
+			externalPort: int(binary.BigEndian.Uint16(request[42:])),
+			lifetime:     binary.BigEndian.Uint32(request[4:]),
+		}
+		if request[36] == 17 {
+			mapRequest.network = "udp"
+		}
+		copy(mapRequest.nonce[:], request[24:36])
+		externalPort, lifetime := fake.assign(mapRequest)
+		binary.BigEndian.PutUint32(response[4:], lifetime)
+		payload := make([]byte, 36)
+		copy(payload, request[24:40])
+		binary.BigEndian.PutUint16(payload[16:], uint16(mapRequest.internalPort))
+		binary.BigEndian.PutUint16(payload[18:], uint16(externalPort))
+		copy(payload[20:], fake.externalIP.To16())
+		return append(response, payload...)
+	case op == natPMPOpAddress:
+		response := make([]byte, 12)
+		response[1] = op | 0x80
+		copy(response[8:], fake.externalIP)
+		return response
+	case (op == natPMPOpMapTCP || op == natPMPOpMapUDP) && len(request) >= 12:
+		mapRequest := fakeMapRequest{
+			network:      "tcp",
+			internalPort: int(binary.BigEndian.Uint16(request[4:])),
+			externalPort: int(binary.BigEndian.Uint16(request[6:])),
+			lifetime:     binary.BigEndian.Uint32(request[8:]),
+		}
+		if op == natPMPOpMapUDP {
+			mapRequest.network = "udp"
+		}
+		externalPort, lifetime := fake.assign(mapRequest)
+		response := make([]byte, 16)
+		response[1] = op | 0x80
+		binary.BigEndian.PutUint16(response[8:], uint16(mapRequest.internalPort))
+		binary.BigEndian.PutUint16(response[10:], uint16(externalPort))
+		binary.BigEndian.PutUint32(response[12:], lifetime)
+		return response
+	}
+	return nil
+}
+
+func TestNATPMPGateway(t *testing.T) {
+	for _, pcp := range []bool{true, false} {
+		fake := newFakeNATPMP(t, true, true)
+		fake.takePort(6881)
+		fake.setMaxLease(600)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		gateway, err := newNATPMPGateway(ctx, fake.address(), pcp)
+		if err != nil {
+			t.Fatalf("pcp %v: %v", pcp, err)
+		}
+		for _, network := range []string{"tcp", "udp"} {
+			externalPort, lease, err := gateway.addMapping(ctx, network, 6881, 6881, time.Hour)
+			if err != nil || externalPort != 6882 || lease != 10*time.Minute {
+				t.Errorf("pcp %v, %s mapped to %d for %v, error %v", pcp, network, externalPort, lease, err)
+			}
+		}
+		if externalIP, err := gateway.getExternalIP(ctx); err != nil || !externalIP.Equal(fake.externalIP) {
+			t.Errorf("pcp %v, external address %v, error %v", pcp, externalIP, err)
+		}
+		if err = gateway.deleteMapping(ctx, "udp", 6881, 6882); err != nil {
+			t.Errorf("pcp %v, delete: %v", pcp, err)
+		}
+		cancel()
+
+		requests := fake.mapRequests()
+		if len(requests) != 3 {
+			t.Fatalf("pcp %v, gateway got %d map requests, want 3", pcp, len(requests))
+		}
+		for _, request := range requests {
+			if request.pcp != pcp || request.internalPort != 6881 || request.nonce != gateway.nonce {
+				t.Errorf("pcp %v, request %+v", pcp, request)
+			}
+		}
+		if requests[1].network != "udp" || requests[2].network != "udp" || requests[2].lifetime != 0 {
+			t.Errorf("pcp %v, udp mapping is not removed: %+v", pcp, requests)
+		}
+	}
+}
+
+func TestNATPMPGatewayVersions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := newNATPMPGateway(ctx, newFakeNATPMP(t, false, true).address(), true); err != errUnsupportedVersion {
+		t.Errorf("PCP to NAT-PMP gateway: %v", err)
+	}
+	if _, err := newNATPMPGateway(ctx, newFakeNATPMP(t, true, false).address(), false); err != errUnsupportedVersion {
+		t.Errorf("NAT-PMP to PCP gateway: %v", err)
+	}
+}
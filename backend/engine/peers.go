@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"encoding/hex"
+	"reflect"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/mse"
+)
+
+// Encryption of a peer connection, see PeerDetail
+const (
+	PeerEncryptionNone = "none"
+	// Only handshake is obfuscated, data goes in plain text
+	PeerEncryptionHeader = "header"
+	PeerEncryptionRC4    = "rc4"
+	// Version of torrent client keeps it from being known
+	PeerEncryptionUnknown = "unknown"
+)
+
+// PeerDetail One connected peer of a task
+type PeerDetail struct {
+	Address string
+	// tcp or utp
+	Network string
+	// How the peer was found, such as Tr (tracker), Hg (DHT) or X (PEX), empty for incoming ones
+	Source string
+	// Hex of peer ID, Client is the tag in it such as qB4250, empty if peer ID is not in that style
+	PeerID    string
+	Client    string
+	Encrypted bool
+	// none, header or rc4
+	Encryption string
+}
+
+// peerEncryption torrent client does not export encryption of a connection, so it is read from
+// fields headerEncrypted and cryptoMethod, which are set before connection is added to a torrent
+func peerEncryption(peerConn *torrent.PeerConn) string {
+	conn := reflect.ValueOf(peerConn).Elem()
+	headerEncrypted, cryptoMethod := conn.FieldByName("headerEncrypted"), conn.FieldByName("cryptoMethod")
+	if headerEncrypted.Kind() != reflect.Bool || cryptoMethod.Kind() != reflect.Uint32 {
+		return PeerEncryptionUnknown
+	}
+	switch {
+	case mse.CryptoMethod(cryptoMethod.Uint()) == mse.CryptoMethodRC4:
+		return PeerEncryptionRC4
+	case headerEncrypted.Bool():
+		return PeerEncryptionHeader
+	}
+	return PeerEncryptionNone
+}
+
+func peerDetail(peerConn *torrent.PeerConn) PeerDetail {
+	detail := PeerDetail{
+		Network:    peerConn.Network,
+		Source:     string(peerConn.Discovery),
+		PeerID:     hex.EncodeToString(peerConn.PeerID[:]),
+		Encryption: peerEncryption(peerConn),
+	}
+	if peerID := peerConn.PeerID; peerID[0] == '-' && peerID[7] == '-' {
+		detail.Client = string(peerID[1:7])
+	}
+	if peerConn.RemoteAddr != nil {
+		detail.Address = peerConn.RemoteAddr.String()
+	}
+	detail.Encrypted = detail.Encryption == PeerEncryptionHeader || detail.Encryption == PeerEncryptionRC4
+	return detail
+}
+
+// GetTorrentPeers Peers connected to a task, isExist is false if the task is not in client
+func (engine *Engine) GetTorrentPeers(hexString string) (peers []PeerDetail, isExist bool) {
+	infoHash := metainfo.Hash{}
+	if err := infoHash.FromHexString(hexString); err != nil {
+		return
+	}
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	singleTorrent, isExist := engine.TorrentEngine.Torrent(infoHash)
+	if !isExist {
+		return
+	}
+	peers = []PeerDetail{}
+	for _, peerConn := range singleTorrent.PeerConns() {
+		peers = append(peers, peerDetail(peerConn))
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"github.com/anacrolix/torrent"
 	"io"
 )
@@ -16,6 +17,12 @@ type SeekableContent interface {
 type FileEntry struct {
 	*torrent.File
 	torrent.Reader
+	ctx context.Context
+}
+
+// Read stops waiting for pieces once the context of stream is done.
+func (f *FileEntry) Read(p []byte) (int, error) {
+	return f.Reader.ReadContext(f.ctx, p)
 }
 
 // Seek seeks to the correct file position, paying attention to the offset.
@@ -43,11 +50,12 @@ func getLargestFile(singleTorrent *torrent.Torrent) *torrent.File {
 }
 
 //TODO: Get selected file
-func (engine *Engine)GetReaderFromTorrent(singleTorrent *torrent.Torrent, fileID string)(SeekableContent, *torrent.File, error)  {
-	return getReaderFromFile(getLargestFile(singleTorrent))
+// GetReaderFromTorrent Reads of the returned content fail when ctx is done
+func (engine *Engine)GetReaderFromTorrent(ctx context.Context, singleTorrent *torrent.Torrent, fileID string)(SeekableContent, *torrent.File, error)  {
+	return getReaderFromFile(ctx, getLargestFile(singleTorrent))
 }
 
-func getReaderFromFile(singleFile *torrent.File)(SeekableContent, *torrent.File, error) {
+func getReaderFromFile(ctx context.Context, singleFile *torrent.File)(SeekableContent, *torrent.File, error) {
 	singleTorrent := singleFile.Torrent()
 	fileReader := singleTorrent.NewReader()
 
@@ -58,5 +66,6 @@ func getReaderFromFile(singleFile *torrent.File)(SeekableContent, *torrent.File,
 	return &FileEntry{
 		File: singleFile,
 		Reader: fileReader,
+		ctx: ctx,
 	}, singleFile, err
 }
@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anatasluo/ant/backend/setting"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Longest time one discovery, mapping or removal may take
+	portMappingTimeout = 5 * time.Second
+	// Time before a failed discovery or mapping is tried again
+	portMappingRetry = 5 * time.Minute
+	// Shortest time between renewals of a lease
+	portMappingMinRenew = 30 * time.Second
+)
+
+// Port mapping protocols, PortMappingSetting.Protocols tells which are tried in which order
+const (
+	PortMappingPCP    = "pcp"
+	PortMappingNATPMP = "natpmp"
+	PortMappingUPnP   = "upnp"
+)
+
+// States of port mapping
+const (
+	PortMappingDisabled    = "disabled"
+	PortMappingDiscovering = "discovering"
+	PortMappingMapped      = "mapped"
+	PortMappingFailed      = "failed"
+	// Host has a public address, there is nothing to map
+	PortMappingDirect = "direct"
+)
+
+// Estimates whether peers can connect to us
+const (
+	// Peers have connected to us
+	ReachabilityReachable = "reachable"
+	// Port is mapped on a gateway with a public address, or host has one
+	ReachabilityLikely = "likely"
+	// Port could not be mapped, or gateway is behind another NAT
+	ReachabilityUnlikely = "unlikely"
+	ReachabilityUnknown  = "unknown"
+)
+
+// 100.64.0.0/10, shared by carrier-grade NATs
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// gatewayMapper Gateway which maps ports, by PCP, NAT-PMP or UPnP
+type gatewayMapper interface {
+	protocol() string
+	address() string
+	// Address gateway sees us from
+	localAddress() net.IP
+	getExternalIP(ctx context.Context) (net.IP, error)
+	// Lease granted is 0 for mappings kept until they are removed
+	addMapping(ctx context.Context, network string, internalPort, externalPort int, lease time.Duration) (int, time.Duration, error)
+	deleteMapping(ctx context.Context, network string, internalPort, externalPort int) error
+}
+
+// PortMapping One listen port mapped on gateway
+type PortMapping struct {
+	// tcp or udp
+	Network      string
+	InternalPort int
+	ExternalPort int
+	// Zero for mappings without lease
+	ExpiresAt time.Time
+	Error     string `json:",omitempty"`
+}
+
+// PortMappingStatus Port mapping of the listen port, reported by status api
+type PortMappingStatus struct {
+	State string
+	// pcp, natpmp or upnp
+	Protocol   string
+	Gateway    string
+	LocalIP    string
+	ExternalIP string
+	Mappings   []PortMapping
+	Error      string `json:",omitempty"`
+	UpdatedAt  time.Time
+	// Connected peers which connected to us
+	IncomingPeers int
+	Reachability  string
+}
+
+// estimateReachability Peers which connected to us prove it, otherwise it is guessed from mappings
+func (status *PortMappingStatus) estimateReachability() {
+	switch {
+	case status.IncomingPeers > 0:
+		status.Reachability = ReachabilityReachable
+	case status.State == PortMappingDirect:
+		status.Reachability = ReachabilityLikely
+	case status.State == PortMappingMapped:
+		status.Reachability = ReachabilityLikely
+		if externalIP := net.ParseIP(status.ExternalIP); externalIP != nil && !isPublicIP(externalIP) {
+			status.Reachability = ReachabilityUnlikely
+		}
+	case status.State == PortMappingFailed:
+		status.Reachability = ReachabilityUnlikely
+	default:
+		status.Reachability = ReachabilityUnknown
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !carrierGradeNAT.Contains(ip)
+}
+
+// portMapper Keeps listen port mapped on gateway until it is stopped, then removes the mappings
+type portMapper struct {
+	setting  setting.PortMappingSetting
+	port     int
+	networks []string
+	logger   *log.Logger
+
+	lock   sync.Mutex
+	status PortMappingStatus
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startPortMapping Map listen port of current client, port mapping of torrent client is never used
+func (engine *Engine) startPortMapping() {
+	client := engine.Client()
+	mapper := &portMapper{
+		setting: engine.config.Current().PortMappingSetting,
+		port:    client.LocalPort(),
+		logger:  engine.logger,
+		status:  PortMappingStatus{State: PortMappingDisabled, UpdatedAt: time.Now()},
+	}
+	if !engine.clientConfig.DisableTCP {
+		mapper.networks = append(mapper.networks, "tcp")
+	}
+	if !engine.clientConfig.DisableUTP || !engine.clientConfig.NoDHT {
+		mapper.networks = append(mapper.networks, "udp")
+	}
+	switch {
+	case !mapper.setting.EnablePortMapping:
+	case engine.proxyOnly:
+		mapper.status.Error = "port mapping is off in proxy-only mode"
+	case engine.interfaceBinding() != nil:
+		mapper.status.Error = "port mapping is off while peers are bound to ListenInterface"
+	case mapper.port == 0 || len(mapper.networks) == 0:
+		mapper.status.Error = "torrent client does not listen"
+	default:
+		mapper.stop = make(chan struct{})
+		mapper.done = make(chan struct{})
+		go mapper.run()
+	}
+	engine.stateLock.Lock()
+	engine.portMapper = mapper
+	engine.stateLock.Unlock()
+}
+
+// stopPortMapping Remove mappings from gateway, it takes up to portMappingTimeout for each of them
+func (engine *Engine) stopPortMapping() {
+	engine.stateLock.Lock()
+	mapper := engine.portMapper
+	engine.portMapper = nil
+	engine.stateLock.Unlock()
+	if mapper != nil && mapper.stop != nil {
+		close(mapper.stop)
+		<-mapper.done
+	}
+}
+
+// GetPortMapping State of port mapping and whether peers can likely connect to us
+func (engine *Engine) GetPortMapping() PortMappingStatus {
+	engine.stateLock.Lock()
+	mapper := engine.portMapper
+	engine.stateLock.Unlock()
+	status := PortMappingStatus{State: PortMappingDisabled}
+	if mapper != nil {
+		status = mapper.getStatus()
+	}
+	for _, singleTorrent := range engine.Client().Torrents() {
+		for _, peerConn := range singleTorrent.PeerConns() {
+			if peerConn.Discovery == torrent.PeerSourceIncoming {
+				status.IncomingPeers++
+			}
+		}
+	}
+	status.estimateReachability()
+	return status
+}
+
+func (mapper *portMapper) getStatus() PortMappingStatus {
+	mapper.lock.Lock()
+	defer mapper.lock.Unlock()
+	status := mapper.status
+	status.Mappings = append([]PortMapping(nil), mapper.status.Mappings...)
+	return status
+}
+
+func (mapper *portMapper) setStatus(update func(status *PortMappingStatus)) {
+	mapper.lock.Lock()
+	defer mapper.lock.Unlock()
+	update(&mapper.status)
+	mapper.status.UpdatedAt = time.Now()
+}
+
+func (mapper *portMapper) run() {
+	defer close(mapper.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-mapper.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var gateway gatewayMapper
+	for {
+		wait := portMappingRetry
+		if gateway == nil {
+			gateway = mapper.discover(ctx)
+		}
+		if gateway != nil {
+			if renew, mapped := mapper.mapPorts(ctx, gateway); mapped {
+				wait = renew
+			} else {
+				// gateway may have been replaced, it is looked for again
+				gateway = nil
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-mapper.stop:
+			timer.Stop()
+			mapper.unmapPorts(gateway)
+			return
+		}
+	}
+}
+
+// discover First gateway which answers by a protocol of settings, nil if host needs none or none answers
+func (mapper *portMapper) discover(ctx context.Context) gatewayMapper {
+	mapper.setStatus(func(status *PortMappingStatus) {
+		status.State = PortMappingDiscovering
+	})
+	// a gateway of settings is asked anyway
+	explicit := mapper.setting.Gateway != "" || mapper.setting.UPnPRootURL != ""
+	if localIP, err := outboundIP(); err == nil && isPublicIP(localIP) && !explicit {
+		mapper.setStatus(func(status *PortMappingStatus) {
+			status.State, status.LocalIP, status.ExternalIP, status.Error = PortMappingDirect, localIP.String(), localIP.String(), ""
+		})
+		return nil
+	}
+	var errs []string
+	for _, protocol := range mapper.setting.Protocols {
+		discoverCtx, cancel := context.WithTimeout(ctx, portMappingTimeout)
+		var gateway gatewayMapper
+		var err error
+		switch protocol {
+		case PortMappingPCP, PortMappingNATPMP:
+			gateway, err = newNATPMPGateway(discoverCtx, mapper.setting.Gateway, protocol == PortMappingPCP)
+		case PortMappingUPnP:
+			gateway, err = newUPnPGateway(discoverCtx, mapper.setting.UPnPRootURL)
+		default:
+			err = errors.New("protocol is not supported")
+		}
+		cancel()
+		if err == nil {
+			mapper.logger.WithFields(log.Fields{"Protocol": protocol, "Gateway": gateway.address()}).Info("Found gateway for port mapping")
+			return gateway
+		}
+		errs = append(errs, protocol+": "+err.Error())
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	mapper.logger.WithFields(log.Fields{"Error": strings.Join(errs, "; ")}).Warn("No gateway maps ports")
+	mapper.setStatus(func(status *PortMappingStatus) {
+		status.State, status.Protocol, status.Gateway, status.Mappings = PortMappingFailed, "", "", nil
+		status.Error = strings.Join(errs, "; ")
+	})
+	return nil
+}
+
+// mapPorts Map or renew listen port for each network, renew tells when to renew them
+func (mapper *portMapper) mapPorts(ctx context.Context, gateway gatewayMapper) (renew time.Duration, mapped bool) {
+	previous := mapper.getStatus()
+	var mappings []PortMapping
+	var errs []string
+	renew = mapper.setting.Lease / 2
+	for _, network := range mapper.networks {
+		externalPort := mapper.port
+		for _, mapping := range previous.Mappings {
+			if mapping.Network == network && mapping.Error == "" && previous.Protocol == gateway.protocol() {
+				externalPort = mapping.ExternalPort
+			}
+		}
+		mapCtx, cancel := context.WithTimeout(ctx, portMappingTimeout)
+		mappedPort, lease, err := gateway.addMapping(mapCtx, network, mapper.port, externalPort, mapper.setting.Lease)
+		cancel()
+		mapping := PortMapping{Network: network, InternalPort: mapper.port, ExternalPort: mappedPort}
+		if err != nil {
+			mapping.Error = err.Error()
+			errs = append(errs, network+": "+err.Error())
+		} else {
+			mapped = true
+			if lease > 0 {
+				mapping.ExpiresAt = time.Now().Add(lease)
+				if lease/2 < renew {
+					renew = lease / 2
+				}
+			}
+		}
+		mappings = append(mappings, mapping)
+	}
+	if renew < portMappingMinRenew {
+		renew = portMappingMinRenew
+	}
+
+	var externalIP net.IP
+	if mapped {
+		ipCtx, cancel := context.WithTimeout(ctx, portMappingTimeout)
+		var err error
+		if externalIP, err = gateway.getExternalIP(ipCtx); err != nil {
+			errs = append(errs, "external address: "+err.Error())
+		}
+		cancel()
+	}
+	mapper.setStatus(func(status *PortMappingStatus) {
+		status.State = PortMappingFailed
+		if mapped {
+			status.State = PortMappingMapped
+		}
+		status.Protocol, status.Gateway, status.Mappings = gateway.protocol(), gateway.address(), mappings
+		status.LocalIP, status.ExternalIP, status.Error = "", "", strings.Join(errs, "; ")
+		if localIP := gateway.localAddress(); localIP != nil {
+			status.LocalIP = localIP.String()
+		}
+		if externalIP != nil {
+			status.ExternalIP = externalIP.String()
+		}
+	})
+
+	fields := log.Fields{"Protocol": gateway.protocol(), "Gateway": gateway.address(), "ExternalIP": externalIP, "Port": mapper.port}
+	switch {
+	case !mapped:
+		fields["Error"] = strings.Join(errs, "; ")
+		mapper.logger.WithFields(fields).Warn("Failed to map listen port")
+	case previous.State != PortMappingMapped || len(errs) > 0:
+		for _, mapping := range mappings {
+			fields[strings.ToUpper(mapping.Network)] = mapping.ExternalPort
+		}
+		mapper.logger.WithFields(fields).Info("Listen port mapped")
+	default:
+		mapper.logger.WithFields(fields).Debug("Port mappings renewed")
+	}
+	return
+}
+
+// unmapPorts Remove mappings made on gateway
+func (mapper *portMapper) unmapPorts(gateway gatewayMapper) {
+	if gateway == nil {
+		return
+	}
+	for _, mapping := range mapper.getStatus().Mappings {
+		if mapping.Error != "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), portMappingTimeout)
+		err := gateway.deleteMapping(ctx, mapping.Network, mapping.InternalPort, mapping.ExternalPort)
+		cancel()
+		fields := log.Fields{"Protocol": gateway.protocol(), "Network": mapping.Network, "Port": mapping.ExternalPort}
+		if err != nil {
+			fields["Error"] = err
+			mapper.logger.WithFields(fields).Warn("Failed to remove port mapping")
+		} else {
+			mapper.logger.WithFields(fields).Info("Port mapping removed")
+		}
+	}
+	mapper.setStatus(func(status *PortMappingStatus) {
+		status.State, status.Mappings = PortMappingDisabled, nil
+	})
+}
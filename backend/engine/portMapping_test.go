@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatasluo/ant/backend/setting"
+)
+
+func newTestPortMapper(protocols []string, gateway string, rootURL string) *portMapper {
+	return &portMapper{
+		setting: setting.PortMappingSetting{
+			EnablePortMapping: true,
+			Protocols:         protocols,
+			Gateway:           gateway,
+			UPnPRootURL:       rootURL,
+			Lease:             time.Hour,
+		},
+		port:     6881,
+		networks: []string{"tcp", "udp"},
+		logger:   quietLogger(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// closedRootURL Address of a gateway description nobody serves
+func closedRootURL() string {
+	server := httptest.NewServer(nil)
+	server.Close()
+	return server.URL + "/rootDesc.xml"
+}
+
+func TestPortMapperDiscoveryOrder(t *testing.T) {
+	pcpGateway := newFakeNATPMP(t, true, true)
+	natPMPGateway := newFakeNATPMP(t, false, true)
+	pcpOnlyGateway := newFakeNATPMP(t, true, false)
+	igd := newFakeIGD(t)
+	tests := []struct {
+		name      string
+		protocols []string
+		gateway   string
+		rootURL   string
+		want      string
+	}{
+		{"pcp first", []string{"pcp", "natpmp", "upnp"}, pcpGateway.address(), igd.rootURL(), PortMappingPCP},
+		{"natpmp when pcp is unsupported", []string{"pcp", "natpmp", "upnp"}, natPMPGateway.address(), igd.rootURL(), PortMappingNATPMP},
+		{"upnp when natpmp is unsupported", []string{"natpmp", "upnp"}, pcpOnlyGateway.address(), igd.rootURL(), PortMappingUPnP},
+		{"order of settings", []string{"upnp", "pcp"}, pcpGateway.address(), igd.rootURL(), PortMappingUPnP},
+		{"pcp when upnp is missing", []string{"upnp", "pcp"}, pcpGateway.address(), closedRootURL(), PortMappingPCP},
+		{"only protocols of settings", []string{"natpmp"}, pcpOnlyGateway.address(), igd.rootURL(), ""},
+	}
+	for _, test := range tests {
+		mapper := newTestPortMapper(test.protocols, test.gateway, test.rootURL)
+		gateway := mapper.discover(context.Background())
+		switch {
+		case test.want == "" && gateway != nil:
+			t.Errorf("%s: found %s gateway", test.name, gateway.protocol())
+		case test.want != "" && (gateway == nil || gateway.protocol() != test.want):
+			t.Errorf("%s: found %v, want %s; status %+v", test.name, gateway, test.want, mapper.getStatus())
+		}
+	}
+}
+
+func TestPortMapperDiscoveryFails(t *testing.T) {
+	mapper := newTestPortMapper([]string{"upnp", "pcp", "nat"}, newFakeNATPMP(t, false, true).address(), closedRootURL())
+	if gateway := mapper.discover(context.Background()); gateway != nil {
+		t.Fatalf("found %s gateway", gateway.protocol())
+	}
+	status := mapper.getStatus()
+	errs := strings.Split(status.Error, "; ")
+	if status.State != PortMappingFailed || len(errs) != 3 {
+		t.Fatalf("status %+v", status)
+	}
+	for i, protocol := range []string{"upnp", "pcp", "nat"} {
+		if !strings.HasPrefix(errs[i], protocol+": ") {
+			t.Errorf("error %d is %q, want one of %s", i, errs[i], protocol)
+		}
+	}
+	if !strings.Contains(errs[1], errUnsupportedVersion.Error()) {
+		t.Errorf("pcp error %q", errs[1])
+	}
+}
+
+func TestPortMapperRenewal(t *testing.T) {
+	for _, protocol := range []string{PortMappingPCP, PortMappingNATPMP} {
+		fake := newFakeNATPMP(t, true, true)
+		fake.takePort(6881)
+		fake.setMaxLease(600)
+		mapper := newTestPortMapper([]string{protocol}, fake.address(), "")
+		gateway := mapper.discover(context.Background())
+		if gateway == nil {
+			t.Fatalf("%s: no gateway, status %+v", protocol, mapper.getStatus())
+		}
+
+		// renewed at half the lease granted, which is shorter than asked for
+		renew, mapped := mapper.mapPorts(context.Background(), gateway)
+		if !mapped || renew != 5*time.Minute {
+			t.Errorf("%s: mapped %v, renew after %v", protocol, mapped, renew)
+		}
+		status := mapper.getStatus()
+		if status.State != PortMappingMapped || status.Protocol != protocol || status.ExternalIP != "203.0.113.7" || len(status.Mappings) != 2 {
+			t.Fatalf("%s: status %+v", protocol, status)
+		}
+		for _, mapping := range status.Mappings {
+			if mapping.ExternalPort != 6882 || mapping.Error != "" || time.Until(mapping.ExpiresAt) > 10*time.Minute {
+				t.Errorf("%s: mapping %+v", protocol, mapping)
+			}
+		}
+
+		// renewal asks for ports given before, never sooner than portMappingMinRenew
+		fake.setMaxLease(10)
+		renew, mapped = mapper.mapPorts(context.Background(), gateway)
+		if !mapped || renew != portMappingMinRenew {
+			t.Errorf("%s: renewal mapped %v, renew after %v", protocol, mapped, renew)
+		}
+		requests := fake.mapRequests()
+		if len(requests) != 4 {
+			t.Fatalf("%s: gateway got %d map requests, want 4", protocol, len(requests))
+		}
+		for i, request := range requests {
+			wantPort := 6881
+			if i >= 2 {
+				wantPort = 6882
+			}
+			if request.externalPort != wantPort || request.lifetime != 3600 || request.nonce != requests[0].nonce {
+				t.Errorf("%s: request %d %+v, want external port %d", protocol, i, request, wantPort)
+			}
+		}
+	}
+}
+
+func TestPortMapperRemovesMappings(t *testing.T) {
+	natGateway := newFakeNATPMP(t, true, true)
+	igd := newFakeIGD(t)
+	for _, protocol := range []string{PortMappingPCP, PortMappingUPnP} {
+		mapper := newTestPortMapper([]string{protocol}, natGateway.address(), igd.rootURL())
+		go mapper.run()
+		deadline := time.Now().Add(5 * time.Second)
+		for mapper.getStatus().State != PortMappingMapped {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: not mapped, status %+v", protocol, mapper.getStatus())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if protocol == PortMappingUPnP && len(igd.tableOfMappings()) != 2 {
+			t.Errorf("mappings of gateway %v", igd.tableOfMappings())
+		}
+
+		close(mapper.stop)
+		<-mapper.done
+		if status := mapper.getStatus(); status.State != PortMappingDisabled || len(status.Mappings) != 0 {
+			t.Errorf("%s: status after stop %+v", protocol, status)
+		}
+	}
+
+	requests := natGateway.mapRequests()
+	if len(requests) != 4 || requests[2].lifetime != 0 || requests[3].lifetime != 0 {
+		t.Errorf("pcp mappings are not removed: %+v", requests)
+	}
+	if mappings := igd.tableOfMappings(); len(mappings) != 0 {
+		t.Errorf("upnp mappings are not removed: %v", mappings)
+	}
+}
@@ -0,0 +1,346 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+)
+
+// Longest time tracker names are looked up through proxy
+const proxyLookupTimeout = 15 * time.Second
+
+var errNoProxy = errors.New("proxy is not set")
+
+// httpProxy Proxy of client for http trackers and web seeds, it follows settings without a new client.
+// net/http takes http, https and socks5 proxies
+func (engine *Engine) httpProxy(request *http.Request) (*url.URL, error) {
+	if binding := engine.interfaceBinding(); binding != nil && binding.isDown() {
+		return nil, errInterfaceDown
+	}
+	proxyURL, _ := engine.proxyURL.Load().(*url.URL)
+	return proxyURL, nil
+}
+
+// newHTTPClient Client of engine for torrent files, feeds, indexers, torrent caches and lists of
+// settings. Like torrent client it goes through proxy of settings and dials from ListenInterface.
+// Proxies are given host names, so names of servers are only looked up by proxy
+func (engine *Engine) newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = engine.httpProxy
+	transport.DialContext = engine.dialHTTP
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Some indexers answer a torrent link with a redirect to magnet
+			if req.URL.Scheme == "magnet" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// dialHTTP Dial proxy, or server of request if there is no proxy. Proxy-only mode refuses
+// the latter, which happens when proxy url of settings is invalid
+func (engine *Engine) dialHTTP(ctx context.Context, network, addr string) (net.Conn, error) {
+	config := engine.config.Current()
+	if proxyURL, _ := engine.proxyURL.Load().(*url.URL); proxyURL == nil && config.UseSocksproxy && config.ProxyOnly {
+		return nil, errNoProxy
+	}
+	return engine.dialContext(ctx, network, addr)
+}
+
+func (engine *Engine) updateProxy() {
+	var proxyURL *url.URL
+	config := engine.config.Current()
+	if config.UseSocksproxy {
+		parsedURL, err := url.Parse(config.SocksProxyURL)
+		if err != nil {
+			engine.logger.WithFields(log.Fields{"Error": err}).Error("Invalid proxy url, nothing is sent through proxy")
+		} else {
+			proxyURL = parsedURL
+		}
+	}
+	engine.proxyURL.Store(proxyURL)
+}
+
+// useProxy Prepare config of a new client for proxy of settings. TCP peers are only reached through
+// proxy, which can not take incoming connections for us. Proxy-only mode also turns off everything
+// which can not go through it: uTP, DHT, UDP and websocket trackers, and port forwarding
+func (engine *Engine) useProxy(clientConfig *torrent.ClientConfig) (proxyPeers bool) {
+	engine.proxyOnly = false
+	config := engine.config.Current()
+	if !config.UseSocksproxy {
+		return false
+	}
+	proxyPeers = !clientConfig.DisableTCP
+	clientConfig.DisableTCP = true
+	if config.ProxyOnly {
+		engine.proxyOnly = true
+		clientConfig.DisableUTP = true
+		clientConfig.NoDHT = true
+		clientConfig.DisableWebtorrent = true
+		clientConfig.NoDefaultPortForwarding = true
+		clientConfig.LookupTrackerIp = engine.lookupTrackerIP
+	}
+	return
+}
+
+// trackers Trackers which can be used by current client. Proxy-only mode and ListenInterface keep
+// http ones, torrent client can neither send UDP trackers through proxy nor bind them to interface
+func (engine *Engine) trackers(announceList [][]string) [][]string {
+	if !engine.proxyOnly && engine.interfaceBinding() == nil {
+		return announceList
+	}
+	var kept [][]string
+	for _, tier := range announceList {
+		var keptTier []string
+		for _, tracker := range tier {
+			if strings.HasPrefix(tracker, "http://") || strings.HasPrefix(tracker, "https://") {
+				keptTier = append(keptTier, tracker)
+			}
+		}
+		if len(keptTier) > 0 {
+			kept = append(kept, keptTier)
+		}
+	}
+	return kept
+}
+
+func (engine *Engine) addTrackers(singleTorrent *torrent.Torrent, announceList [][]string) {
+	singleTorrent.AddTrackers(engine.trackers(announceList))
+}
+
+// proxyDialer Dials TCP peers through proxy, it is the only TCP dialer of client when proxy is used
+type proxyDialer struct {
+	engine *Engine
+}
+
+func (dialer proxyDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return dialer.engine.dialProxy(ctx, addr)
+}
+
+func (dialer proxyDialer) DialerNetwork() string {
+	return "tcp"
+}
+
+// dialProxy Connect to addr through proxy of settings, by SOCKS5 or HTTP CONNECT
+func (engine *Engine) dialProxy(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, _ := engine.proxyURL.Load().(*url.URL)
+	if proxyURL == nil {
+		return nil, errNoProxy
+	}
+	switch proxyURL.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, boundDialer{engine: engine})
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	case "http", "https":
+		return engine.dialConnect(ctx, proxyURL, addr)
+	}
+	return nil, fmt.Errorf("proxy scheme %q is not supported", proxyURL.Scheme)
+}
+
+// bufferedConn Conn whose first bytes were read by proxy handshake
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// dialConnect Open a tunnel to addr with HTTP CONNECT, credentials of proxyURL are sent as basic auth
+func (engine *Engine) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := engine.dialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err = request.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused tunnel to %s: %s", addr, response.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		return bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// proxyResolver Looks names up by DNS over TCP to ProxyDNSServer, through proxy
+func (engine *Engine) proxyResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return engine.dialProxy(ctx, engine.config.Current().ProxyDNSServer)
+		},
+	}
+}
+
+// lookupTrackerIP Torrent client looks trackers up before announcing, this keeps the lookups in proxy
+// in proxy-only mode, and refuses them while ListenInterface is gone
+func (engine *Engine) lookupTrackerIP(trackerURL *url.URL) ([]net.IP, error) {
+	if binding := engine.interfaceBinding(); binding != nil && binding.isDown() {
+		return nil, errInterfaceDown
+	}
+	if !engine.proxyOnly {
+		return net.LookupIP(trackerURL.Hostname())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyLookupTimeout)
+	defer cancel()
+	addrs, err := engine.proxyResolver().LookupIPAddr(ctx, trackerURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// ProxyTestStep Result of one step of TestProxy
+type ProxyTestStep struct {
+	Name     string
+	OK       bool
+	Error    string `json:",omitempty"`
+	Duration time.Duration
+}
+
+// ProxyTestResult Proxy is shown without its password
+type ProxyTestResult struct {
+	Proxy     string
+	ProxyOnly bool
+	Target    string
+	OK        bool
+	Steps     []ProxyTestStep
+}
+
+// TestProxy Check proxy of settings step by step: reach proxy, open a tunnel to target through it,
+// fetch target over http through it, and look target up through it in proxy-only mode.
+// Target is the default trackers list if empty
+func (engine *Engine) TestProxy(ctx context.Context, target string) (result ProxyTestResult) {
+	config := engine.config.Current()
+	if target == "" {
+		target = config.DefaultTrackerList
+	}
+	result.Target = target
+	result.ProxyOnly = config.ProxyOnly
+	proxyURL, _ := engine.proxyURL.Load().(*url.URL)
+	step := func(name string, run func() error) bool {
+		start := time.Now()
+		err := run()
+		testStep := ProxyTestStep{Name: name, OK: err == nil, Duration: time.Since(start)}
+		if err != nil {
+			testStep.Error = err.Error()
+		}
+		result.Steps = append(result.Steps, testStep)
+		return err == nil
+	}
+	if proxyURL == nil {
+		step("proxy", func() error { return errNoProxy })
+		return
+	}
+	result.Proxy = proxyURL.Redacted()
+	targetURL, err := url.Parse(target)
+	if err != nil || targetURL.Host == "" {
+		step("target", func() error { return fmt.Errorf("target %q is not an absolute url", target) })
+		return
+	}
+	targetAddr := targetURL.Host
+	if targetURL.Port() == "" {
+		port := "80"
+		if targetURL.Scheme == "https" {
+			port = "443"
+		}
+		targetAddr = net.JoinHostPort(targetURL.Hostname(), port)
+	}
+
+	result.OK = step("proxy", func() error {
+		conn, err := engine.dialContext(ctx, "tcp", proxyURL.Host)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}) && step("tunnel", func() error {
+		conn, err := engine.dialProxy(ctx, targetAddr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}) && step("http", func() error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+		if err != nil {
+			return err
+		}
+		response, err := engine.httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		_ = response.Body.Close()
+		if response.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("target answered %s", response.Status)
+		}
+		return nil
+	})
+	if result.OK && result.ProxyOnly {
+		result.OK = step("dns", func() error {
+			_, err := engine.proxyResolver().LookupIPAddr(ctx, targetURL.Hostname())
+			return err
+		})
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// proxyOnlyConfig testConfig in proxy-only mode through proxyURL
+func proxyOnlyConfig(proxyURL string) string {
+	return strings.Replace(testConfig, "[enginesetting]\n", fmt.Sprintf(`[enginesetting]
+  usesocksproxy = true
+  socksproxyurl = %q
+  proxyonly = true
+`, proxyURL), 1)
+}
+
+// TestHTTPClientUsesProxy Feeds, torrent files, indexers and torrent caches are fetched through proxy,
+// names of servers are not looked up here, so an unresolvable name is fetched too
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var lock sync.Mutex
+	var requested []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requested = append(requested, r.URL.String())
+		lock.Unlock()
+		fmt.Fprint(w, `<rss><channel><item><title>through proxy</title><link>http://feed.invalid/1.torrent</link></item></channel></rss>`)
+	}))
+	defer proxyServer.Close()
+	engine := newTestEngineWithConfig(t, proxyOnlyConfig(proxyServer.URL))
+
+	items, err := engine.FetchFeed("http://feed.invalid/rss")
+	if err != nil || len(items) != 1 || items[0].Title != "through proxy" {
+		t.Fatalf("items %+v, error %v", items, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(requested) != 1 || requested[0] != "http://feed.invalid/rss" {
+		t.Errorf("proxy got %v", requested)
+	}
+}
+
+// TestHTTPClientProxyOnlyRefusesDirect Nothing goes straight to servers in proxy-only mode,
+// even if proxy url can not be used
+func TestHTTPClientProxyOnlyRefusesDirect(t *testing.T) {
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&fetched, 1)
+	}))
+	defer server.Close()
+	engine := newTestEngineWithConfig(t, proxyOnlyConfig("http://%zz"))
+
+	if _, err := engine.FetchFeed(server.URL); err == nil || atomic.LoadInt32(&fetched) == 1 {
+		t.Errorf("fetched without proxy, error %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys to sort lists of tasks
+const (
+	SortName     = "name"
+	SortSize     = "size"
+	SortProgress = "progress"
+	SortSpeed    = "speed"
+	SortRatio    = "ratio"
+	SortAdded    = "added"
+	SortETA      = "eta"
+	SortHash     = "hash"
+)
+
+// TorrentQuery Filter, sort and page tasks, zero value lists all in the order they were added
+type TorrentQuery struct {
+	Filter  TorrentFilter
+	Sort    string
+	Reverse bool
+	// Cursor is NextCursor of last page, it is used instead of Offset when set.
+	// Sort and Reverse must be those of last page
+	Cursor string
+	Offset int
+	// 0 means no limit
+	Limit int
+}
+
+type TorrentPage struct {
+	Torrents []TorrentDetail
+	// Number of tasks matched, before paging
+	Total int
+	// Empty on last page
+	NextCursor string
+}
+
+// Progress from 0 to 1
+func (detail TorrentDetail) Progress() float64 {
+	if detail.TotalLength == 0 {
+		return 0
+	}
+	return float64(detail.BytesCompleted) / float64(detail.TotalLength)
+}
+
+// Ratio Uploaded bytes of session by downloaded bytes, 0 if nothing has been downloaded.
+// Every api reports and sorts by this one
+func (detail TorrentDetail) Ratio() float64 {
+	if detail.BytesDownloaded == 0 {
+		return 0
+	}
+	return float64(detail.BytesUploaded) / float64(detail.BytesDownloaded)
+}
+
+// ETA Seconds left with current speed, -1 means unknown
+func (detail TorrentDetail) ETA() int64 {
+	left := detail.TotalLength - detail.BytesCompleted
+	if detail.HasInfo && left <= 0 {
+		return 0
+	}
+	if detail.DownloadRate <= 0 {
+		return -1
+	}
+	return int64(float64(left) / detail.DownloadRate)
+}
+
+var torrentSortKeys = map[string]func(detail TorrentDetail) float64{
+	SortSize:     func(detail TorrentDetail) float64 { return float64(detail.TotalLength) },
+	SortProgress: func(detail TorrentDetail) float64 { return detail.Progress() },
+	SortSpeed:    func(detail TorrentDetail) float64 { return detail.DownloadRate },
+	SortRatio:    func(detail TorrentDetail) float64 { return detail.Ratio() },
+	SortETA: func(detail TorrentDetail) float64 {
+		// unknown is the longest
+		if eta := detail.ETA(); eta >= 0 {
+			return float64(eta)
+		}
+		return math.Inf(1)
+	},
+}
+
+// SortTorrentDetails Sort in place by one of the sort keys, equal ones are ordered by hash
+func SortTorrentDetails(details []TorrentDetail, sortKey string, reverse bool) error {
+	if sortKey == "" {
+		sortKey = SortAdded
+	}
+	var compare func(a, b TorrentDetail) int
+	if sortKey == SortName {
+		compare = func(a, b TorrentDetail) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+	} else if sortKey == SortAdded {
+		compare = func(a, b TorrentDetail) int {
+			switch {
+			case a.AddedTime.Before(b.AddedTime):
+				return -1
+			case a.AddedTime.After(b.AddedTime):
+				return 1
+			}
+			return 0
+		}
+	} else if sortKey == SortHash {
+		// ties are ordered by hash anyway
+		compare = func(a, b TorrentDetail) int { return 0 }
+	} else if key, isExist := torrentSortKeys[sortKey]; isExist {
+		compare = func(a, b TorrentDetail) int {
+			valueA, valueB := key(a), key(b)
+			switch {
+			case valueA < valueB:
+				return -1
+			case valueA > valueB:
+				return 1
+			}
+			return 0
+		}
+	} else {
+		return fmt.Errorf("unable to sort by %q", sortKey)
+	}
+	sort.SliceStable(details, func(i, j int) bool {
+		compared := compare(details[i], details[j])
+		if compared == 0 {
+			compared = strings.Compare(details[i].HexString, details[j].HexString)
+		}
+		if reverse {
+			return compared > 0
+		}
+		return compared < 0
+	})
+	return nil
+}
+
+// QueryTorrents List tasks matching query, a page at a time
+func (engine *Engine) QueryTorrents(query TorrentQuery) (page TorrentPage, err error) {
+	match, err := query.Filter.Matcher()
+	if err != nil {
+		return
+	}
+	details := []TorrentDetail{}
+	for _, detail := range engine.GetTorrentDetails() {
+		if match(detail) {
+			details = append(details, detail)
+		}
+	}
+	if err = SortTorrentDetails(details, query.Sort, query.Reverse); err != nil {
+		return
+	}
+	return PageTorrentDetails(details, query)
+}
+
+// PageTorrentDetails Cut one page from a sorted list, by Cursor or Offset and Limit of query
+func PageTorrentDetails(details []TorrentDetail, query TorrentQuery) (page TorrentPage, err error) {
+	page.Total = len(details)
+	start := query.Offset
+	if query.Cursor != "" {
+		if start, err = cursorStart(details, query); err != nil {
+			return
+		}
+	}
+	if start < 0 || start > len(details) {
+		start = len(details)
+	}
+	end := len(details)
+	if query.Limit > 0 && start+query.Limit < end {
+		end = start + query.Limit
+		page.NextCursor = encodeCursor(details[end-1], query.Sort)
+	}
+	page.Torrents = details[start:end]
+	return
+}
+
+// A cursor holds hash and sort value of last task of a page, encoded so it is opaque to clients
+func encodeCursor(detail TorrentDetail, sortKey string) string {
+	var value string
+	switch sortKey {
+	case "", SortAdded:
+		value = detail.AddedTime.UTC().Format(time.RFC3339Nano)
+	case SortName:
+		value = strings.ToLower(detail.Name)
+	case SortHash:
+	default:
+		if key, isExist := torrentSortKeys[sortKey]; isExist {
+			value = strconv.FormatFloat(key(detail), 'g', -1, 64)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(detail.HexString + ":" + value))
+}
+
+func decodeCursor(cursor string) (hexString string, value string, err error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.New("invalid cursor")
+	}
+	return parts[0], parts[1], nil
+}
+
+// cursorStart Index after the task of cursor. When that task is gone, as it is after being
+// deleted, it is the first task sorted after the sort value kept in cursor, so no task is
+// skipped or listed twice
+func cursorStart(details []TorrentDetail, query TorrentQuery) (int, error) {
+	hexString, value, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return 0, err
+	}
+	for index, detail := range details {
+		if detail.HexString == hexString {
+			return index + 1, nil
+		}
+	}
+	compare, err := cursorComparer(query.Sort, value)
+	if err != nil {
+		return 0, err
+	}
+	for index, detail := range details {
+		compared := compare(detail)
+		if compared == 0 {
+			compared = strings.Compare(detail.HexString, hexString)
+		}
+		if query.Reverse {
+			compared = -compared
+		}
+		if compared > 0 {
+			return index, nil
+		}
+	}
+	return len(details), nil
+}
+
+// cursorComparer Compare tasks with sort value of a cursor, as SortTorrentDetails compares tasks
+func cursorComparer(sortKey string, value string) (func(detail TorrentDetail) int, error) {
+	switch sortKey {
+	case "", SortAdded:
+		added, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		return func(detail TorrentDetail) int {
+			switch {
+			case detail.AddedTime.Before(added):
+				return -1
+			case detail.AddedTime.After(added):
+				return 1
+			}
+			return 0
+		}, nil
+	case SortName:
+		return func(detail TorrentDetail) int {
+			return strings.Compare(strings.ToLower(detail.Name), value)
+		}, nil
+	case SortHash:
+		return func(detail TorrentDetail) int { return 0 }, nil
+	}
+	key, isExist := torrentSortKeys[sortKey]
+	if !isExist {
+		return nil, fmt.Errorf("unable to sort by %q", sortKey)
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return func(detail TorrentDetail) int {
+		switch keyValue := key(detail); {
+		case keyValue < number:
+			return -1
+		case keyValue > number:
+			return 1
+		}
+		return 0
+	}, nil
+}
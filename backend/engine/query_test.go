@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDetails() []TorrentDetail {
+	added := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []TorrentDetail{
+		{HexString: "d", HasInfo: true, Name: "delta", TotalLength: 300, BytesCompleted: 150, DownloadRate: 10, StatusName: "Running", Category: "tv", Tags: []string{"hd"}, TorrentTimes: TorrentTimes{AddedTime: added.Add(time.Hour)}},
+		{HexString: "b", HasInfo: true, Name: "Bravo", TotalLength: 100, BytesCompleted: 100, BytesDownloaded: 100, BytesUploaded: 50, StatusName: "Completed", TorrentTimes: TorrentTimes{AddedTime: added}},
+		{HexString: "a", HasInfo: true, Name: "alpha", TotalLength: 300, StatusName: "Stopped", Category: "tv", TorrentTimes: TorrentTimes{AddedTime: added}},
+		{HexString: "c", HasInfo: true, Name: "Charlie", TotalLength: 200, BytesCompleted: 50, DownloadRate: 50, BytesDownloaded: 100, BytesUploaded: 200, StatusName: "Running", Tags: []string{"hd", "new"}, TorrentTimes: TorrentTimes{AddedTime: added.Add(2 * time.Hour)}},
+	}
+}
+
+func detailHashes(details []TorrentDetail) string {
+	hashes := make([]string, 0, len(details))
+	for _, detail := range details {
+		hashes = append(hashes, detail.HexString)
+	}
+	return strings.Join(hashes, "")
+}
+
+func TestSortTorrentDetails(t *testing.T) {
+	tests := []struct {
+		sortKey string
+		reverse bool
+		want    string
+	}{
+		// added at the same time, ordered by hash
+		{"", false, "abdc"},
+		{SortAdded, true, "cdba"},
+		{SortName, false, "abcd"},
+		{SortName, true, "dcba"},
+		// same size ordered by hash
+		{SortSize, false, "bcad"},
+		{SortSize, true, "dacb"},
+		{SortProgress, false, "acdb"},
+		{SortSpeed, true, "cdba"},
+		{SortRatio, false, "adbc"},
+		// unknown eta is longest, done has none left
+		{SortETA, false, "bcda"},
+		{SortHash, false, "abcd"},
+	}
+	for _, test := range tests {
+		details := testDetails()
+		if err := SortTorrentDetails(details, test.sortKey, test.reverse); err != nil {
+			t.Errorf("sort by %q: %v", test.sortKey, err)
+			continue
+		}
+		if got := detailHashes(details); got != test.want {
+			t.Errorf("sort by %q, reverse %v: %s, want %s", test.sortKey, test.reverse, got, test.want)
+		}
+	}
+	if err := SortTorrentDetails(testDetails(), "color", false); err == nil {
+		t.Error("no error for unknown sort key")
+	}
+}
+
+func TestPageTorrentDetails(t *testing.T) {
+	details := testDetails()
+	if err := SortTorrentDetails(details, SortName, false); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		query      TorrentQuery
+		want       string
+		nextCursor bool
+	}{
+		{"all", TorrentQuery{}, "abcd", false},
+		{"limit", TorrentQuery{Limit: 2}, "ab", true},
+		{"offset", TorrentQuery{Offset: 1, Limit: 2}, "bc", true},
+		{"last page", TorrentQuery{Offset: 2, Limit: 2}, "cd", false},
+		{"offset after end", TorrentQuery{Offset: 9, Limit: 2}, "", false},
+		{"negative offset", TorrentQuery{Offset: -1}, "", false},
+		{"cursor", TorrentQuery{Sort: SortName, Cursor: encodeCursor(details[0], SortName), Limit: 2}, "bc", true},
+		{"cursor of last", TorrentQuery{Sort: SortName, Cursor: encodeCursor(details[3], SortName)}, "", false},
+		{"cursor is used instead of offset", TorrentQuery{Sort: SortName, Cursor: encodeCursor(details[1], SortName), Offset: 3}, "cd", false},
+	}
+	for _, test := range tests {
+		page, err := PageTorrentDetails(details, test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := detailHashes(page.Torrents); got != test.want || page.Total != len(details) || (page.NextCursor != "") != test.nextCursor {
+			t.Errorf("%s: page %s of %d, next cursor %q, want %s", test.name, got, page.Total, page.NextCursor, test.want)
+		}
+	}
+
+	for _, cursor := range []string{"not base64!", encodeCursor(TorrentDetail{}, SortName)[:2], "YWJj"} {
+		if _, err := PageTorrentDetails(details, TorrentQuery{Sort: SortName, Cursor: cursor}); err == nil {
+			t.Errorf("no error for cursor %q", cursor)
+		}
+	}
+}
+
+// TestPageRemovedCursor Pages go on after the task of cursor is deleted, no task is skipped or listed twice
+func TestPageRemovedCursor(t *testing.T) {
+	for _, sortKey := range []string{"", SortAdded, SortName, SortSize, SortProgress, SortSpeed, SortRatio, SortETA, SortHash} {
+		for _, reverse := range []bool{false, true} {
+			for removed := 0; removed < 4; removed++ {
+				details := testDetails()
+				if err := SortTorrentDetails(details, sortKey, reverse); err != nil {
+					t.Fatal(err)
+				}
+				query := TorrentQuery{Sort: sortKey, Reverse: reverse, Limit: removed + 1}
+				page, err := PageTorrentDetails(details, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := detailHashes(details[removed+1:])
+				details = append(details[:removed:removed], details[removed+1:]...)
+
+				query.Cursor, query.Limit = page.NextCursor, 0
+				if query.Cursor == "" && removed < 3 {
+					t.Fatalf("no next cursor with limit %d", removed+1)
+				}
+				if query.Cursor == "" {
+					continue
+				}
+				page, err = PageTorrentDetails(details, query)
+				if got := detailHashes(page.Torrents); err != nil || got != want {
+					t.Errorf("sort by %q, reverse %v, %d removed: page %s, want %s, error %v", sortKey, reverse, removed, got, want, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTorrentFilterMatcher(t *testing.T) {
+	tv, none := "tv", ""
+	tests := []struct {
+		filter TorrentFilter
+		want   string
+	}{
+		{TorrentFilter{}, "dbac"},
+		{TorrentFilter{Status: "running"}, "dc"},
+		{TorrentFilter{Category: &tv}, "da"},
+		{TorrentFilter{Category: &none}, "bc"},
+		{TorrentFilter{Tag: "hd"}, "dc"},
+		{TorrentFilter{Tag: "h"}, ""},
+		{TorrentFilter{Name: "^[A-Z]"}, "bc"},
+		{TorrentFilter{Name: "(?i)^(alpha|bravo)$"}, "ba"},
+		{TorrentFilter{Status: "Running", Tag: "new", Name: "ar"}, "c"},
+	}
+	for _, test := range tests {
+		match, err := test.filter.Matcher()
+		if err != nil {
+			t.Errorf("%+v: %v", test.filter, err)
+			continue
+		}
+		var matched []TorrentDetail
+		for _, detail := range testDetails() {
+			if match(detail) {
+				matched = append(matched, detail)
+			}
+		}
+		if got := detailHashes(matched); got != test.want {
+			t.Errorf("%+v: matched %s, want %s", test.filter, got, test.want)
+		}
+	}
+	if _, err := (TorrentFilter{Name: "("}).Matcher(); err == nil {
+		t.Error("no error for invalid name pattern")
+	}
+}
+
+func TestCursorIsOpaque(t *testing.T) {
+	detail := TorrentDetail{HexString: "ab", Name: "Name: with colon"}
+	cursor := encodeCursor(detail, SortName)
+	hexString, value, err := decodeCursor(cursor)
+	if err != nil || hexString != "ab" || value != "name: with colon" {
+		t.Errorf("cursor %q decoded to %q, %q, error %v", cursor, hexString, value, err)
+	}
+	if strings.ContainsAny(cursor, ":/+= ") {
+		t.Errorf("cursor %q is not url safe", cursor)
+	}
+}
@@ -0,0 +1,414 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	log "github.com/sirupsen/logrus"
+)
+
+// RSSFeed A subscribed feed, saved in storm db
+type RSSFeed struct {
+	ID        int `storm:"id,increment"`
+	Name      string
+	URL       string `storm:"unique"`
+	Enabled   bool
+	LastPoll  time.Time
+	LastError string
+}
+
+// RSSRule Decide which items of feeds will be downloaded
+type RSSRule struct {
+	ID      int `storm:"id,increment"`
+	Name    string
+	Enabled bool
+	// Empty means the rule applies to all feeds
+	FeedIDs []int
+	Include string
+	Exclude string
+	// Size limits in bytes, 0 means no limit
+	MinSize int64
+	MaxSize int64
+	// Only download one item for every episode of a series
+	EpisodeDedup bool
+	Category     string
+	SavePath     string
+}
+
+// RSSSeenItem Items that have been added, never add them again
+type RSSSeenItem struct {
+	Key    string `storm:"id"`
+	FeedID int    `storm:"index"`
+	Title  string
+	SeenAt time.Time
+}
+
+// RSSEpisode Episodes downloaded by a rule with EpisodeDedup
+type RSSEpisode struct {
+	Key    string `storm:"id"`
+	RuleID int    `storm:"index"`
+	Title  string
+	SeenAt time.Time
+}
+
+// RSSMatchResult Outcome of one item against one rule
+type RSSMatchResult struct {
+	FeedItem
+	Matched bool
+	Reason  string
+}
+
+// rssRule RSSRule with its patterns compiled, a poll compiles each rule once
+type rssRule struct {
+	RSSRule
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+type RSSManager struct {
+	engine *Engine
+	// lock guards running, stopChan and done
+	lock     sync.Mutex
+	running  bool
+	stopChan chan struct{}
+	// Closed when polling goroutine returns
+	done chan struct{}
+	// Only one poll at a time, or an item may be added twice
+	pollLock sync.Mutex
+	// Set by Stop with pollLock held, no poll runs afterwards
+	stopped bool
+}
+
+var (
+	episodeRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^(.*?)[\s._\-\[(]+s(\d{1,2})[\s._\-]*e(\d{1,3})`),
+		regexp.MustCompile(`(?i)^(.*?)[\s._\-\[(]+(\d{1,2})x(\d{2,3})\b`),
+	}
+	nonWordRegexp = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+)
+
+func newRSSManager(engine *Engine) *RSSManager {
+	return &RSSManager{
+		engine: engine,
+	}
+}
+
+func (manager *RSSManager) db() *storm.DB {
+	return manager.engine.TorrentDB.DB
+}
+
+// Start polling all enabled feeds every RSSPollInterval
+func (manager *RSSManager) Start() {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.running {
+		return
+	}
+	manager.pollLock.Lock()
+	manager.stopped = false
+	manager.pollLock.Unlock()
+	manager.running = true
+	manager.stopChan = make(chan struct{})
+	manager.done = make(chan struct{})
+	go func(stopChan chan struct{}, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(manager.engine.config.RSSSetting.RSSPollInterval)
+		defer ticker.Stop()
+		for {
+			manager.pollAll(stopChan)
+			select {
+			case <-ticker.C:
+			case <-stopChan:
+				return
+			}
+		}
+	}(manager.stopChan, manager.done)
+	manager.engine.logger.WithFields(log.Fields{"Interval": manager.engine.config.RSSSetting.RSSPollInterval}).Info("RSS polling started")
+}
+
+// Stop polling and wait until no poll runs, polls started by api included. Database is closed after stop
+func (manager *RSSManager) Stop() {
+	if manager == nil {
+		return
+	}
+	manager.lock.Lock()
+	if manager.running {
+		manager.running = false
+		close(manager.stopChan)
+	}
+	done := manager.done
+	manager.lock.Unlock()
+	if done != nil {
+		<-done
+	}
+	manager.pollLock.Lock()
+	manager.stopped = true
+	manager.pollLock.Unlock()
+}
+
+func (manager *RSSManager) GetFeeds() (feeds []RSSFeed, err error) {
+	err = manager.db().All(&feeds)
+	return
+}
+
+func (manager *RSSManager) SaveFeed(feed *RSSFeed) error {
+	if !strings.HasPrefix(feed.URL, "http://") && !strings.HasPrefix(feed.URL, "https://") {
+		return fmt.Errorf("invalid feed url %q", feed.URL)
+	}
+	if feed.Name == "" {
+		feed.Name = feed.URL
+	}
+	return manager.db().Save(feed)
+}
+
+func (manager *RSSManager) DelFeed(feedID int) error {
+	feed := RSSFeed{ID: feedID}
+	return manager.db().DeleteStruct(&feed)
+}
+
+func (manager *RSSManager) GetRules() (rules []RSSRule, err error) {
+	err = manager.db().All(&rules)
+	return
+}
+
+func (manager *RSSManager) SaveRule(rule *RSSRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	return manager.db().Save(rule)
+}
+
+func (manager *RSSManager) DelRule(ruleID int) error {
+	rule := RSSRule{ID: ruleID}
+	return manager.db().DeleteStruct(&rule)
+}
+
+// PollAll check every enabled feed once
+func (manager *RSSManager) PollAll() {
+	manager.pollAll(nil)
+}
+
+// pollAll Feeds left are skipped once stopChan is closed
+func (manager *RSSManager) pollAll(stopChan chan struct{}) {
+	feeds, err := manager.GetFeeds()
+	if err != nil {
+		manager.engine.logger.WithFields(log.Fields{"Error": err}).Error("Unable to load rss feeds")
+		return
+	}
+	for index := range feeds {
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+		if feeds[index].Enabled {
+			manager.PollFeed(&feeds[index])
+		}
+	}
+}
+
+// PollFeed fetch one feed and add every item that matches an enabled rule
+func (manager *RSSManager) PollFeed(feed *RSSFeed) {
+	manager.pollLock.Lock()
+	defer manager.pollLock.Unlock()
+	if manager.stopped {
+		return
+	}
+
+	entry := manager.engine.logger.WithFields(log.Fields{"Feed": feed.Name})
+	items, err := manager.engine.FetchFeed(feed.URL)
+	feed.LastPoll = time.Now()
+	feed.LastError = ""
+	if err != nil {
+		feed.LastError = err.Error()
+		entry.WithFields(log.Fields{"Error": err}).Error("Unable to fetch rss feed")
+	}
+	if saveErr := manager.db().Update(feed); saveErr != nil {
+		entry.WithFields(log.Fields{"Error": saveErr}).Error("Unable to save rss feed")
+	}
+	if err != nil {
+		return
+	}
+
+	savedRules, err := manager.GetRules()
+	if err != nil {
+		entry.WithFields(log.Fields{"Error": err}).Error("Unable to load rss rules")
+		return
+	}
+	var rules []rssRule
+	for _, savedRule := range savedRules {
+		if !savedRule.Enabled || !savedRule.appliesTo(feed.ID) {
+			continue
+		}
+		// rules are validated when saved, a db written by someone else may still hold a bad one
+		rule, compileErr := savedRule.compile()
+		if compileErr != nil {
+			entry.WithFields(log.Fields{"Error": compileErr, "Rule": savedRule.Name}).Error("Unable to compile rss rule")
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	for _, item := range items {
+		if manager.isSeen(feed.ID, item) {
+			continue
+		}
+		for _, rule := range rules {
+			result := manager.matchItem(rule, item)
+			if !result.Matched {
+				continue
+			}
+			_, err = manager.engine.AddOneTorrentFromURL(item.Link, AddOptions{
+				Category:    rule.Category,
+				StoragePath: rule.SavePath,
+			})
+			if err != nil {
+				entry.WithFields(log.Fields{"Error": err, "Item": item.Title}).Error("Unable to add rss item")
+				break
+			}
+			entry.WithFields(log.Fields{"Item": item.Title, "Rule": rule.Name}).Info("Rss item added")
+			manager.markSeen(feed.ID, rule.RSSRule, item)
+			break
+		}
+	}
+}
+
+// TestRule match a rule against a feed without adding anything
+func (manager *RSSManager) TestRule(savedRule RSSRule, feedURL string) (results []RSSMatchResult, err error) {
+	rule, err := savedRule.compile()
+	if err != nil {
+		return
+	}
+	items, err := manager.engine.FetchFeed(feedURL)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		results = append(results, manager.matchItem(rule, item))
+	}
+	return
+}
+
+// matchItem Match an item against a rule, episodes downloaded before are looked up in db
+func (manager *RSSManager) matchItem(rule rssRule, item FeedItem) RSSMatchResult {
+	result := rule.match(item)
+	if result.Matched && rule.EpisodeDedup && manager.hasEpisode(rule.RSSRule, item) {
+		result.Matched, result.Reason = false, "episode has been downloaded"
+	}
+	return result
+}
+
+// match Match an item against patterns and sizes of a rule
+func (rule rssRule) match(item FeedItem) RSSMatchResult {
+	result := RSSMatchResult{FeedItem: item}
+	switch {
+	case item.Link == "":
+		result.Reason = "item has no link"
+	case rule.include != nil && !rule.include.MatchString(item.Title):
+		result.Reason = "include pattern not matched"
+	case rule.exclude != nil && rule.exclude.MatchString(item.Title):
+		result.Reason = "exclude pattern matched"
+	case rule.MinSize > 0 && item.Size > 0 && item.Size < rule.MinSize:
+		result.Reason = "smaller than minimum size"
+	case rule.MaxSize > 0 && item.Size > rule.MaxSize:
+		result.Reason = "larger than maximum size"
+	default:
+		result.Matched = true
+	}
+	return result
+}
+
+func (rule *RSSRule) validate() error {
+	_, err := rule.compile()
+	return err
+}
+
+// compile Compile patterns of rule, empty patterns match everything
+func (rule RSSRule) compile() (compiled rssRule, err error) {
+	compiled.RSSRule = rule
+	if rule.Include != "" {
+		if compiled.include, err = regexp.Compile(rule.Include); err != nil {
+			return
+		}
+	}
+	if rule.Exclude != "" {
+		if compiled.exclude, err = regexp.Compile(rule.Exclude); err != nil {
+			return
+		}
+	}
+	if rule.MaxSize > 0 && rule.MinSize > rule.MaxSize {
+		err = fmt.Errorf("minimum size is larger than maximum size")
+	}
+	return
+}
+
+func (rule *RSSRule) appliesTo(feedID int) bool {
+	if len(rule.FeedIDs) == 0 {
+		return true
+	}
+	for _, id := range rule.FeedIDs {
+		if id == feedID {
+			return true
+		}
+	}
+	return false
+}
+
+// episodeKey returns "series|s01e02", empty if title has no episode number
+func episodeKey(title string) string {
+	for _, episodeRegexp := range episodeRegexps {
+		match := episodeRegexp.FindStringSubmatch(title)
+		if match == nil {
+			continue
+		}
+		series := strings.TrimSpace(nonWordRegexp.ReplaceAllString(strings.ToLower(match[1]), " "))
+		season := strings.TrimLeft(match[2], "0")
+		episode := strings.TrimLeft(match[3], "0")
+		return fmt.Sprintf("%s|s%se%s", series, season, episode)
+	}
+	return ""
+}
+
+func (manager *RSSManager) seenKey(feedID int, item FeedItem) string {
+	return fmt.Sprintf("%d|%s", feedID, item.GUID)
+}
+
+func (manager *RSSManager) isSeen(feedID int, item FeedItem) bool {
+	var seen RSSSeenItem
+	return manager.db().One("Key", manager.seenKey(feedID, item), &seen) == nil
+}
+
+func (manager *RSSManager) hasEpisode(rule RSSRule, item FeedItem) bool {
+	key := episodeKey(item.Title)
+	if key == "" {
+		return false
+	}
+	var episode RSSEpisode
+	return manager.db().One("Key", fmt.Sprintf("%d|%s", rule.ID, key), &episode) == nil
+}
+
+func (manager *RSSManager) markSeen(feedID int, rule RSSRule, item FeedItem) {
+	err := manager.db().Save(&RSSSeenItem{
+		Key:    manager.seenKey(feedID, item),
+		FeedID: feedID,
+		Title:  item.Title,
+		SeenAt: time.Now(),
+	})
+	if err != nil {
+		manager.engine.logger.WithFields(log.Fields{"Error": err, "Item": item.Title}).Error("Unable to save seen rss item")
+	}
+	if key := episodeKey(item.Title); rule.EpisodeDedup && key != "" {
+		err = manager.db().Save(&RSSEpisode{
+			Key:    fmt.Sprintf("%d|%s", rule.ID, key),
+			RuleID: rule.ID,
+			Title:  item.Title,
+			SeenAt: time.Now(),
+		})
+		if err != nil {
+			manager.engine.logger.WithFields(log.Fields{"Error": err, "Item": item.Title}).Error("Unable to save rss episode")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedItem is one entry of a RSS or Atom feed, reduced to what download rules need
+type FeedItem struct {
+	GUID      string
+	Title     string
+	Link      string
+	Size      int64
+	Published time.Time
+}
+
+// Only fields used by rules are decoded, both RSS 2.0 and Atom share this struct
+type rawFeed struct {
+	XMLName xml.Name
+	// RSS 2.0
+	Items []rawItem `xml:"channel>item"`
+	// Atom
+	Entries []rawEntry `xml:"entry"`
+}
+
+type rawItem struct {
+	Title     string `xml:"title"`
+	Link      string `xml:"link"`
+	GUID      string `xml:"guid"`
+	PubDate   string `xml:"pubDate"`
+	Size      string `xml:"size"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length string `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	// torznab and nyaa style extensions
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"attr"`
+	ContentLength string `xml:"contentLength"`
+	MagnetURI     string `xml:"magnetURI"`
+}
+
+type rawEntry struct {
+	Title   string `xml:"title"`
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+	Links   []struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr"`
+		Type   string `xml:"type,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"link"`
+}
+
+const torrentMIME = "application/x-bittorrent"
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseFeedSize(value string) int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// ParseFeed decodes a RSS 2.0 or Atom document
+func ParseFeed(reader io.Reader) (items []FeedItem, err error) {
+	var feed rawFeed
+	err = xml.NewDecoder(reader).Decode(&feed)
+	if err != nil {
+		return
+	}
+	for _, rawItem := range feed.Items {
+		item := FeedItem{
+			GUID:      strings.TrimSpace(rawItem.GUID),
+			Title:     strings.TrimSpace(rawItem.Title),
+			Link:      strings.TrimSpace(rawItem.Link),
+			Published: parseFeedTime(rawItem.PubDate),
+		}
+		if rawItem.MagnetURI != "" {
+			item.Link = strings.TrimSpace(rawItem.MagnetURI)
+		} else if rawItem.Enclosure.URL != "" && (rawItem.Enclosure.Type == torrentMIME || item.Link == "") {
+			item.Link = strings.TrimSpace(rawItem.Enclosure.URL)
+		}
+		for _, size := range []string{rawItem.Enclosure.Length, rawItem.ContentLength, rawItem.Size} {
+			if item.Size = parseFeedSize(size); item.Size > 0 {
+				break
+			}
+		}
+		for _, attr := range rawItem.Attrs {
+			switch attr.Name {
+			case "size":
+				if item.Size == 0 {
+					item.Size = parseFeedSize(attr.Value)
+				}
+			case "magneturl":
+				item.Link = attr.Value
+			}
+		}
+		items = append(items, item.withGUID())
+	}
+	for _, rawEntry := range feed.Entries {
+		item := FeedItem{
+			GUID:      strings.TrimSpace(rawEntry.ID),
+			Title:     strings.TrimSpace(rawEntry.Title),
+			Published: parseFeedTime(rawEntry.Updated),
+		}
+		for _, link := range rawEntry.Links {
+			if link.Type == torrentMIME || link.Rel == "enclosure" || strings.HasPrefix(link.Href, "magnet:") {
+				item.Link = link.Href
+				item.Size = parseFeedSize(link.Length)
+				break
+			}
+			if item.Link == "" {
+				item.Link = link.Href
+			}
+		}
+		items = append(items, item.withGUID())
+	}
+	if feed.Items == nil && feed.Entries == nil && feed.XMLName.Local != "rss" && feed.XMLName.Local != "feed" {
+		err = fmt.Errorf("unknown feed format %q", feed.XMLName.Local)
+	}
+	return
+}
+
+// Feeds without guid are identified by their link
+func (item FeedItem) withGUID() FeedItem {
+	if item.GUID == "" {
+		item.GUID = item.Link
+	}
+	return item
+}
+
+// FetchFeed download and parse a feed
+func (engine *Engine) FetchFeed(feedURL string) ([]FeedItem, error) {
+	resp, err := engine.httpClient.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q while fetching feed", resp.Status)
+	}
+	return ParseFeed(resp.Body)
+}
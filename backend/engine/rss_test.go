@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEpisodeKey(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Show.Name.S01E02.720p.WEB", "show name|s1e2"},
+		{"Show Name - s1e2 [1080p]", "show name|s1e2"},
+		{"Show_Name 1x02 HDTV", "show name|s1e2"},
+		{"[Group] Show Name S2 E10", "group show name|s2e10"},
+		{"Show.Name.S01.E102", "show name|s1e102"},
+		{"Movie.Name.2020.1080p", ""},
+		{"S01E01 title without series", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if key := episodeKey(test.title); key != test.want {
+			t.Errorf("episodeKey(%q) = %q, want %q", test.title, key, test.want)
+		}
+	}
+}
+
+func TestRSSRuleMatch(t *testing.T) {
+	rule, err := RSSRule{Include: `(?i)^show\b`, Exclude: `(?i)cam`, MinSize: 100, MaxSize: 1000}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		item   FeedItem
+		reason string
+	}{
+		{FeedItem{Title: "Show S01E01", Link: "magnet:?xt=1", Size: 500}, ""},
+		{FeedItem{Title: "Show S01E01", Link: "magnet:?xt=1"}, ""},
+		{FeedItem{Title: "Show S01E01", Size: 500}, "item has no link"},
+		{FeedItem{Title: "Other S01E01", Link: "magnet:?xt=1", Size: 500}, "include pattern not matched"},
+		{FeedItem{Title: "Show S01E01 CAM", Link: "magnet:?xt=1", Size: 500}, "exclude pattern matched"},
+		{FeedItem{Title: "Show S01E01", Link: "magnet:?xt=1", Size: 99}, "smaller than minimum size"},
+		{FeedItem{Title: "Show S01E01", Link: "magnet:?xt=1", Size: 1001}, "larger than maximum size"},
+	}
+	for _, test := range tests {
+		result := rule.match(test.item)
+		if result.Matched != (test.reason == "") || result.Reason != test.reason {
+			t.Errorf("%+v: matched %v, reason %q, want %q", test.item, result.Matched, result.Reason, test.reason)
+		}
+	}
+
+	// a rule without patterns or limits matches any item with link
+	if result := (rssRule{}).match(FeedItem{Title: "anything", Link: "magnet:?xt=1"}); !result.Matched {
+		t.Errorf("empty rule: %+v", result)
+	}
+}
+
+func TestRSSRuleCompile(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  RSSRule
+		valid bool
+	}{
+		{"empty", RSSRule{}, true},
+		{"patterns", RSSRule{Include: `^a`, Exclude: `b$`}, true},
+		{"bad include", RSSRule{Include: `([`}, false},
+		{"bad exclude", RSSRule{Exclude: `*`}, false},
+		{"sizes", RSSRule{MinSize: 10, MaxSize: 10}, true},
+		{"minimum over maximum", RSSRule{MinSize: 11, MaxSize: 10}, false},
+		{"minimum without maximum", RSSRule{MinSize: 11}, true},
+	}
+	for _, test := range tests {
+		if err := test.rule.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+}
+
+// fakeFeed Feed of items linking to torrents it serves, it counts fetches of each torrent
+type fakeFeed struct {
+	server *httptest.Server
+
+	lock    sync.Mutex
+	items   string
+	fetches map[string]int
+}
+
+func newFakeFeed(t *testing.T, titles ...string) *fakeFeed {
+	t.Helper()
+	feed := &fakeFeed{fetches: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		feed.lock.Lock()
+		defer feed.lock.Unlock()
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel>%s</channel></rss>`, feed.items)
+	})
+	torrents := make(map[string][]byte)
+	for index, title := range titles {
+		var content strings.Builder
+		if err := testMetaInfo(t, title).Write(&content); err != nil {
+			t.Fatal(err)
+		}
+		torrents[fmt.Sprintf("/%d.torrent", index)] = []byte(content.String())
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		content, isExist := torrents[r.URL.Path]
+		if !isExist {
+			http.NotFound(w, r)
+			return
+		}
+		feed.lock.Lock()
+		feed.fetches[r.URL.Path]++
+		feed.lock.Unlock()
+		_, _ = w.Write(content)
+	})
+	feed.server = httptest.NewServer(mux)
+	t.Cleanup(feed.server.Close)
+	for index, title := range titles {
+		feed.addItem(title, fmt.Sprint(index), fmt.Sprintf("/%d.torrent", index))
+	}
+	return feed
+}
+
+func (feed *fakeFeed) addItem(title string, guid string, path string) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	feed.items += fmt.Sprintf(`<item><title>%s</title><guid>%s</guid><link>%s%s</link></item>`, title, guid, feed.server.URL, path)
+}
+
+func (feed *fakeFeed) torrentFetches() map[string]int {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	fetches := make(map[string]int)
+	for path, count := range feed.fetches {
+		fetches[path] = count
+	}
+	return fetches
+}
+
+func TestRSSPollAddsItemsOnce(t *testing.T) {
+	engine := newTestEngine(t)
+	feed := newFakeFeed(t, "Show.S01E01.720p", "Show.S01E01.1080p", "Show.S01E02.720p", "Other.S01E01.720p")
+	rssFeed := RSSFeed{Name: "test", URL: feed.server.URL + "/feed.xml", Enabled: true}
+	if err := engine.RSS.SaveFeed(&rssFeed); err != nil {
+		t.Fatal(err)
+	}
+	rules := []RSSRule{
+		{Name: "bad pattern written by someone else", Enabled: true, Include: `([`},
+		{Name: "show", Enabled: true, Include: `(?i)^show\.`, EpisodeDedup: true},
+		{Name: "other feed", Enabled: true, FeedIDs: []int{rssFeed.ID + 1}},
+	}
+	for index := range rules {
+		// saved without validation, as an older version may have done
+		if err := engine.RSS.db().Save(&rules[index]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine.RSS.PollFeed(&rssFeed)
+	// 1080p copy of the first episode is skipped, other series matches no rule
+	want := map[string]int{"/0.torrent": 1, "/2.torrent": 1}
+	if fetches := feed.torrentFetches(); fmt.Sprint(fetches) != fmt.Sprint(want) {
+		t.Fatalf("torrents fetched %v, want %v", fetches, want)
+	}
+	if details := engine.GetTorrentDetails(); len(details) != 2 {
+		t.Errorf("%d tasks added, want 2", len(details))
+	}
+	if rssFeed.LastError != "" || rssFeed.LastPoll.IsZero() {
+		t.Errorf("feed after poll %+v", rssFeed)
+	}
+
+	// items seen are never fetched again
+	engine.RSS.PollAll()
+	if fetches := feed.torrentFetches(); fmt.Sprint(fetches) != fmt.Sprint(want) {
+		t.Errorf("torrents fetched %v after second poll, want %v", fetches, want)
+	}
+
+	// nothing is polled after stop, database is closed by then
+	engine.RSS.Start()
+	engine.RSS.Stop()
+	feed.addItem("Show.S01E03", "new", "/1.torrent")
+	engine.RSS.PollAll()
+	if fetches := feed.torrentFetches(); fetches["/1.torrent"] != 0 {
+		t.Errorf("feed is polled after stop: %v", fetches)
+	}
+}
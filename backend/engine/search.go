@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anatasluo/ant/backend/setting"
+	log "github.com/sirupsen/logrus"
+)
+
+// SearchResult One normalised result from torznab indexers
+type SearchResult struct {
+	Title       string
+	Size        int64
+	Seeders     int
+	Leechers    int
+	MagnetURI   string
+	TorrentURL  string
+	InfoHash    string
+	Indexer     string
+	PublishDate time.Time
+}
+
+// IndexerError Indexers that failed or timed out, results from others are still returned
+type IndexerError struct {
+	Indexer string
+	Error   string
+}
+
+type torznabFeed struct {
+	Items []rawItem `xml:"channel>item"`
+	// torznab reports errors as <error code="" description=""/>
+	Code        string `xml:"code,attr"`
+	Description string `xml:"description,attr"`
+}
+
+// SearchIndexers send the query to all enabled indexers in parallel
+func (engine *Engine) SearchIndexers(ctx context.Context, query string, categories string) (results []SearchResult, indexerErrors []IndexerError) {
+	var (
+		wg         sync.WaitGroup
+		resultLock sync.Mutex
+		allResults []SearchResult
+	)
+	for _, indexer := range engine.config.Current().SearchSetting.Indexers {
+		if indexer.Disabled {
+			continue
+		}
+		wg.Add(1)
+		go func(indexer setting.TorznabIndexer) {
+			defer wg.Done()
+			indexerCtx, cancel := context.WithTimeout(ctx, indexer.Timeout)
+			defer cancel()
+			indexerResults, err := engine.searchOneIndexer(indexerCtx, indexer, query, categories)
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			if err != nil {
+				engine.logger.WithFields(log.Fields{"Error": err, "Indexer": indexer.Name}).Error("Torznab search failed")
+				indexerErrors = append(indexerErrors, IndexerError{
+					Indexer: indexer.Name,
+					Error:   err.Error(),
+				})
+				return
+			}
+			allResults = append(allResults, indexerResults...)
+		}(indexer)
+	}
+	wg.Wait()
+	results = dedupSearchResults(allResults)
+	return
+}
+
+func (engine *Engine) searchOneIndexer(ctx context.Context, indexer setting.TorznabIndexer, query string, categories string) (results []SearchResult, err error) {
+	apiURL, err := url.Parse(indexer.URL)
+	if err != nil {
+		return
+	}
+	if !strings.HasSuffix(apiURL.Path, "/api") {
+		apiURL.Path = strings.TrimSuffix(apiURL.Path, "/") + "/api"
+	}
+	params := apiURL.Query()
+	params.Set("t", "search")
+	params.Set("q", query)
+	if indexer.APIKey != "" {
+		params.Set("apikey", indexer.APIKey)
+	}
+	if categories == "" {
+		categories = indexer.Categories
+	}
+	if categories != "" {
+		params.Set("cat", categories)
+	}
+	apiURL.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL.String(), nil)
+	if err != nil {
+		return
+	}
+	resp, err := engine.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %q", resp.Status)
+		return
+	}
+	var feed torznabFeed
+	err = xml.NewDecoder(resp.Body).Decode(&feed)
+	if err != nil {
+		return
+	}
+	if feed.Code != "" {
+		err = fmt.Errorf("indexer error %s: %s", feed.Code, feed.Description)
+		return
+	}
+	for _, item := range feed.Items {
+		results = append(results, normaliseTorznabItem(indexer.Name, item))
+	}
+	return
+}
+
+func normaliseTorznabItem(indexerName string, item rawItem) SearchResult {
+	result := SearchResult{
+		Title:       strings.TrimSpace(item.Title),
+		Indexer:     indexerName,
+		PublishDate: parseFeedTime(item.PubDate),
+		Size:        parseFeedSize(item.Size),
+	}
+	peers := -1
+	for _, attr := range item.Attrs {
+		switch attr.Name {
+		case "size":
+			if result.Size == 0 {
+				result.Size = parseFeedSize(attr.Value)
+			}
+		case "seeders":
+			result.Seeders, _ = strconv.Atoi(attr.Value)
+		case "peers":
+			peers, _ = strconv.Atoi(attr.Value)
+		case "leechers":
+			result.Leechers, _ = strconv.Atoi(attr.Value)
+		case "infohash":
+			result.InfoHash = strings.ToLower(attr.Value)
+		case "magneturl":
+			result.MagnetURI = attr.Value
+		}
+	}
+	// torznab peers include seeders
+	if peers >= result.Seeders && result.Leechers == 0 {
+		result.Leechers = peers - result.Seeders
+	}
+	if result.Size == 0 {
+		result.Size = parseFeedSize(item.Enclosure.Length)
+	}
+	for _, link := range []string{item.Enclosure.URL, item.Link} {
+		link = strings.TrimSpace(link)
+		if strings.HasPrefix(link, "magnet:") && result.MagnetURI == "" {
+			result.MagnetURI = link
+		} else if strings.HasPrefix(link, "http") && result.TorrentURL == "" {
+			result.TorrentURL = link
+		}
+	}
+	if result.InfoHash == "" && result.MagnetURI != "" {
+		if magnet, err := metainfo.ParseMagnetUri(result.MagnetURI); err == nil {
+			result.InfoHash = magnet.InfoHash.HexString()
+		}
+	}
+	return result
+}
+
+// Same torrent from several indexers is merged, the one with most seeders wins
+func dedupSearchResults(allResults []SearchResult) (results []SearchResult) {
+	hashToIndex := make(map[string]int)
+	for _, result := range allResults {
+		if result.InfoHash == "" {
+			results = append(results, result)
+			continue
+		}
+		index, isExist := hashToIndex[result.InfoHash]
+		if !isExist {
+			hashToIndex[result.InfoHash] = len(results)
+			results = append(results, result)
+			continue
+		}
+		kept := &results[index]
+		previous := *kept
+		if result.Seeders > kept.Seeders {
+			*kept = result
+		}
+		kept.Indexer = previous.Indexer + ", " + result.Indexer
+		for _, other := range []SearchResult{previous, result} {
+			if kept.MagnetURI == "" {
+				kept.MagnetURI = other.MagnetURI
+			}
+			if kept.TorrentURL == "" {
+				kept.TorrentURL = other.TorrentURL
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Seeders > results[j].Seeders
+	})
+	return
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatasluo/ant/backend/setting"
+)
+
+const testInfoHash = "0123456789abcdef0123456789abcdef01234567"
+
+func TestNormaliseTorznabItem(t *testing.T) {
+	published := time.Date(2020, 1, 2, 15, 4, 5, 0, time.FixedZone("", -7*3600))
+	tests := []struct {
+		name string
+		item string
+		want SearchResult
+	}{
+		{
+			"torrent link and peers",
+			`<title> Show S01E01 </title><link>http://indexer/1.torrent</link><pubDate>Thu, 02 Jan 2020 15:04:05 -0700</pubDate><size>1000</size>
+			<torznab:attr name="seeders" value="10"/><torznab:attr name="peers" value="15"/><torznab:attr name="infohash" value="` + strings.ToUpper(testInfoHash) + `"/>`,
+			SearchResult{Title: "Show S01E01", Size: 1000, Seeders: 10, Leechers: 5, TorrentURL: "http://indexer/1.torrent", InfoHash: testInfoHash, PublishDate: published},
+		},
+		{
+			"magnet link gives info hash",
+			`<title>Show S01E02</title><link>magnet:?xt=urn:btih:` + testInfoHash + `</link><torznab:attr name="size" value="2000"/><torznab:attr name="leechers" value="3"/>`,
+			SearchResult{Title: "Show S01E02", Size: 2000, Leechers: 3, MagnetURI: "magnet:?xt=urn:btih:" + testInfoHash, InfoHash: testInfoHash},
+		},
+		{
+			"magnet attribute and enclosure",
+			`<title>Show S01E03</title><enclosure url="http://indexer/3.torrent" length="3000" type="application/x-bittorrent"/>
+			<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:` + testInfoHash + `"/><torznab:attr name="seeders" value="7"/><torznab:attr name="peers" value="2"/>`,
+			SearchResult{Title: "Show S01E03", Size: 3000, Seeders: 7, MagnetURI: "magnet:?xt=urn:btih:" + testInfoHash, TorrentURL: "http://indexer/3.torrent", InfoHash: testInfoHash},
+		},
+		{
+			"no links",
+			`<title>Show S01E04</title><link>details page</link><size>bad</size>`,
+			SearchResult{Title: "Show S01E04"},
+		},
+	}
+	for _, test := range tests {
+		var feed torznabFeed
+		document := `<rss xmlns:torznab="http://torznab.com/schemas/2015/feed"><channel><item>` + test.item + `</item></channel></rss>`
+		if err := xml.Unmarshal([]byte(document), &feed); err != nil || len(feed.Items) != 1 {
+			t.Fatalf("%s: %d items, error %v", test.name, len(feed.Items), err)
+		}
+		test.want.Indexer = "indexer"
+		result := normaliseTorznabItem("indexer", feed.Items[0])
+		if !result.PublishDate.Equal(test.want.PublishDate) {
+			t.Errorf("%s: published %v, want %v", test.name, result.PublishDate, test.want.PublishDate)
+		}
+		result.PublishDate = test.want.PublishDate
+		if result != test.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.name, result, test.want)
+		}
+	}
+}
+
+func TestDedupSearchResults(t *testing.T) {
+	otherHash := strings.Repeat("f", 40)
+	results := dedupSearchResults([]SearchResult{
+		{Title: "a", InfoHash: testInfoHash, Seeders: 5, TorrentURL: "http://a/1.torrent", Indexer: "a"},
+		{Title: "no hash", Seeders: 6, TorrentURL: "http://a/2.torrent", Indexer: "a"},
+		{Title: "b", InfoHash: testInfoHash, Seeders: 9, MagnetURI: "magnet:?xt=urn:btih:" + testInfoHash, Indexer: "b"},
+		{Title: "other", InfoHash: otherHash, Seeders: 1, Indexer: "b"},
+		{Title: "c", InfoHash: testInfoHash, Seeders: 2, Indexer: "c"},
+		{Title: "no hash", Seeders: 6, TorrentURL: "http://c/2.torrent", Indexer: "c"},
+	})
+	want := []SearchResult{
+		// the copy with most seeders is kept, with links of the others
+		{Title: "b", InfoHash: testInfoHash, Seeders: 9, MagnetURI: "magnet:?xt=urn:btih:" + testInfoHash, TorrentURL: "http://a/1.torrent", Indexer: "a, b, c"},
+		// results without info hash can not be told apart, they are all kept
+		{Title: "no hash", Seeders: 6, TorrentURL: "http://a/2.torrent", Indexer: "a"},
+		{Title: "no hash", Seeders: 6, TorrentURL: "http://c/2.torrent", Indexer: "c"},
+		{Title: "other", InfoHash: otherHash, Seeders: 1, Indexer: "b"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", results, want)
+	}
+	if results := dedupSearchResults(nil); len(results) != 0 {
+		t.Errorf("results of nothing %+v", results)
+	}
+}
+
+func TestSearchOneIndexer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path != "/torznab/api" || query.Get("t") != "search" || query.Get("apikey") != "key":
+			http.Error(w, "bad request", http.StatusBadRequest)
+		case query.Get("q") == "fail":
+			fmt.Fprint(w, `<?xml version="1.0"?><error code="100" description="Incorrect user credentials"/>`)
+		default:
+			fmt.Fprintf(w, `<?xml version="1.0"?><rss><channel><item><title>%s %s</title><link>http://indexer/1.torrent</link></item></channel></rss>`,
+				query.Get("q"), query.Get("cat"))
+		}
+	}))
+	defer server.Close()
+	engine := newTestEngine(t)
+	indexer := setting.TorznabIndexer{Name: "test", URL: server.URL + "/torznab/", APIKey: "key", Categories: "5000", Timeout: time.Second}
+	ctx := context.Background()
+
+	results, err := engine.searchOneIndexer(ctx, indexer, "show", "")
+	if err != nil || len(results) != 1 || results[0].Title != "show 5000" || results[0].Indexer != "test" {
+		t.Errorf("results %+v, error %v", results, err)
+	}
+	// categories of request replace those of indexer
+	if results, err = engine.searchOneIndexer(ctx, indexer, "show", "2000"); err != nil || len(results) != 1 || results[0].Title != "show 2000" {
+		t.Errorf("results with categories %+v, error %v", results, err)
+	}
+	if _, err = engine.searchOneIndexer(ctx, indexer, "fail", ""); err == nil || !strings.Contains(err.Error(), "Incorrect user credentials") {
+		t.Errorf("error of indexer %v", err)
+	}
+	indexer.APIKey = "wrong"
+	if _, err = engine.searchOneIndexer(ctx, indexer, "show", ""); err == nil {
+		t.Error("no error for status 400")
+	}
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/torrent"
+)
+
+// EngineStats Totals of the whole engine, byte counters restart with the torrent client
+type EngineStats struct {
+	BytesDownloaded  int64
+	BytesUploaded    int64
+	DownloadRate     float64
+	UploadRate       float64
+	ActivePeers      int
+	ConnectedSeeders int
+	// Active peers whose connection is encrypted, by EncryptionMode of settings
+	EncryptedPeers   int
+	EncryptionMode   string
+	HashFailures     int64
+	DHTNodes         int
+	DHTGoodNodes     int
+	MagnetNum        int
+	TorrentsByStatus map[string]int
+	DBSize           int64
+
+	// nil if ListenInterface is not set
+	ListenInterface *ListenInterfaceStatus `json:",omitempty"`
+	PortMapping     PortMappingStatus
+}
+
+// TorrentRateInfo Transfer state of one torrent in client
+type TorrentRateInfo struct {
+	HexString        string
+	Name             string
+	Status           string
+	BytesCompleted   int64
+	TotalLength      int64
+	BytesDownloaded  int64
+	BytesUploaded    int64
+	DownloadRate     float64
+	UploadRate       float64
+	ActivePeers      int
+	ConnectedSeeders int
+	HashFailures     int64
+}
+
+type rateSample struct {
+	time         time.Time
+	downloaded   int64
+	uploaded     int64
+	downloadRate float64
+	uploadRate   float64
+}
+
+// rateSampler turns byte counters into rates, samples closer than a second reuse last rate
+type rateSampler struct {
+	lock    sync.Mutex
+	samples map[string]rateSample
+}
+
+func (sampler *rateSampler) rates(key string, downloaded, uploaded int64) (downloadRate, uploadRate float64) {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+	now := time.Now()
+	last, isExist := sampler.samples[key]
+	if isExist && now.Sub(last.time) < time.Second {
+		return last.downloadRate, last.uploadRate
+	}
+	sample := rateSample{time: now, downloaded: downloaded, uploaded: uploaded}
+	// counters go back after client restart
+	if isExist && downloaded >= last.downloaded && uploaded >= last.uploaded {
+		elapsed := now.Sub(last.time).Seconds()
+		sample.downloadRate = float64(downloaded-last.downloaded) / elapsed
+		sample.uploadRate = float64(uploaded-last.uploaded) / elapsed
+	}
+	sampler.samples[key] = sample
+	return sample.downloadRate, sample.uploadRate
+}
+
+func (sampler *rateSampler) forget(keep map[string]bool) {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+	for key := range sampler.samples {
+		if !keep[key] {
+			delete(sampler.samples, key)
+		}
+	}
+}
+
+func (engine *Engine) GetEngineStats() (stats EngineStats) {
+	torrentEngine := engine.Client()
+	connStats := torrentEngine.ConnStats()
+	stats.BytesDownloaded = connStats.BytesReadData.Int64()
+	stats.BytesUploaded = connStats.BytesWrittenData.Int64()
+	stats.HashFailures = connStats.PiecesDirtiedBad.Int64()
+	stats.DownloadRate, stats.UploadRate = engine.rates.rates("", stats.BytesDownloaded, stats.BytesUploaded)
+	for _, singleTorrent := range torrentEngine.Torrents() {
+		torrentStats := singleTorrent.Stats()
+		stats.ActivePeers += torrentStats.ActivePeers
+		stats.ConnectedSeeders += torrentStats.ConnectedSeeders
+		for _, peerConn := range singleTorrent.PeerConns() {
+			if peerDetail(peerConn).Encrypted {
+				stats.EncryptedPeers++
+			}
+		}
+	}
+	stats.EncryptionMode = engine.config.Current().EncryptionMode
+	stats.ListenInterface = engine.listenInterfaceStatus()
+	stats.PortMapping = engine.GetPortMapping()
+	for _, dhtServer := range torrentEngine.DhtServers() {
+		if dhtStats, ok := dhtServer.Stats().(dht.ServerStats); ok {
+			stats.DHTNodes += dhtStats.Nodes
+			stats.DHTGoodNodes += dhtStats.GoodNodes
+		}
+	}
+	stats.TorrentsByStatus = make(map[string]int)
+	for _, statusName := range StatusIDToName[1:] {
+		stats.TorrentsByStatus[statusName] = 0
+	}
+	engine.stateLock.Lock()
+	stats.MagnetNum = engine.EngineRunningInfo.MagnetNum
+	for _, singleTorrentLog := range engine.EngineRunningInfo.TorrentLogs {
+		stats.TorrentsByStatus[StatusIDToName[singleTorrentLog.Status]]++
+	}
+	engine.stateLock.Unlock()
+	if fileInfo, err := os.Stat(engine.TorrentDB.Path); err == nil {
+		stats.DBSize = fileInfo.Size()
+	}
+	return
+}
+
+// GetTorrentRates returns torrents in client, the most active first
+func (engine *Engine) GetTorrentRates() (rates []TorrentRateInfo) {
+	engine.stateLock.Lock()
+	defer engine.stateLock.Unlock()
+	keep := map[string]bool{"": true}
+	for _, singleTorrent := range engine.TorrentEngine.Torrents() {
+		rates = append(rates, engine.torrentRate(singleTorrent))
+		keep[singleTorrent.InfoHash().HexString()] = true
+	}
+	engine.rates.forget(keep)
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].DownloadRate+rates[i].UploadRate > rates[j].DownloadRate+rates[j].UploadRate
+	})
+	return
+}
+
+func (engine *Engine) torrentRate(singleTorrent *torrent.Torrent) TorrentRateInfo {
+	torrentStats := singleTorrent.Stats()
+	rate := TorrentRateInfo{
+		HexString:        singleTorrent.InfoHash().HexString(),
+		Name:             singleTorrent.Name(),
+		BytesDownloaded:  torrentStats.BytesReadData.Int64(),
+		BytesUploaded:    torrentStats.BytesWrittenData.Int64(),
+		ActivePeers:      torrentStats.ActivePeers,
+		ConnectedSeeders: torrentStats.ConnectedSeeders,
+		HashFailures:     torrentStats.PiecesDirtiedBad.Int64(),
+	}
+	if singleTorrentLog, isExist := engine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]; isExist {
+		rate.Status = StatusIDToName[singleTorrentLog.Status]
+	}
+	if singleTorrent.Info() != nil {
+		rate.BytesCompleted = singleTorrent.BytesCompleted()
+		rate.TotalLength = singleTorrent.Length()
+	}
+	rate.DownloadRate, rate.UploadRate = engine.rates.rates(rate.HexString, rate.BytesDownloaded, rate.BytesUploaded)
+	return rate
+}
@@ -1,49 +1,224 @@
 package engine
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
 	"github.com/asdine/storm"
 	log "github.com/sirupsen/logrus"
 )
 
 type TorrentDB struct {
-	DB   *storm.DB
-	Path string
+	DB     *storm.DB
+	Path   string
+	logger *log.Logger
 }
 
-func GetTorrentDB(dbPath string) *TorrentDB {
+// OpenTorrentDB Open database of one engine, records of old versions are migrated
+func OpenTorrentDB(dbPath string, logger *log.Logger) (*TorrentDB, error) {
 	db, err := storm.Open(dbPath)
-	var torrentDB TorrentDB
-	torrentDB.DB = db
-	torrentDB.Path = dbPath
 	if err != nil {
-		log.WithFields(log.Fields{"Error": err, "Path": torrentDB.Path}).Fatal("Failed to create database for engine")
+		return nil, err
+	}
+	torrentDB := &TorrentDB{DB: db, Path: dbPath, logger: logger}
+	if err = torrentDB.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return torrentDB, nil
+}
+
+// TorrentDBVersion Version of records in db, migrations after it are run when db is opened
+type TorrentDBVersion struct {
+	ID      OnlyStormID `storm:"id"`
+	Version int
+}
+
+// Migration n upgrades records of version n to n+1, never change or remove one after release
+var torrentDBMigrations = []func(tx storm.Node) error{
+	migrateTorrentTimes,
+	migrateTorrentRecords,
+}
+
+// Each migration is committed with its version, so a failed one is run again next time
+func (TorrentDB *TorrentDB) migrate() error {
+	version := TorrentDBVersion{ID: TorrentDBVersionID}
+	err := TorrentDB.DB.One("ID", TorrentDBVersionID, &version)
+	if err != nil && err != storm.ErrNotFound {
+		return fmt.Errorf("failed to get version of database: %v", err)
+	}
+	for version.Version < len(torrentDBMigrations) {
+		tx, err := TorrentDB.DB.Begin(true)
+		if err == nil {
+			err = torrentDBMigrations[version.Version](tx)
+		}
+		if err == nil {
+			version.Version++
+			err = tx.Save(&version)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			if tx != nil {
+				_ = tx.Rollback()
+			}
+			return fmt.Errorf("failed to migrate database from version %d: %v", version.Version, err)
+		}
+		TorrentDB.logger.Infof("Database migrated to version %d", version.Version)
+	}
+	return nil
+}
+
+// Tasks saved before times were recorded get time of migration, completed time is guessed from their files
+func migrateTorrentTimes(tx storm.Node) error {
+	var torrentLogs TorrentLogsAndID
+	err := tx.One("ID", TorrentLogsID, &torrentLogs)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	timeNow := time.Now()
+	for index := range torrentLogs.TorrentLogs {
+		torrentLog := &torrentLogs.TorrentLogs[index]
+		if !torrentLog.AddedTime.IsZero() {
+			continue
+		}
+		torrentLog.AddedTime = timeNow
+		if torrentLog.Status != AnalysingStatus {
+			torrentLog.MetadataTime = timeNow
+		}
+		if torrentLog.Status == CompletedStatus {
+			torrentLog.CompletedTime = timeNow
+			if fileInfo, statErr := os.Stat(filepath.Join(torrentLog.StoragePath, torrentLog.TorrentName)); statErr == nil {
+				torrentLog.CompletedTime = fileInfo.ModTime()
+			}
+			torrentLog.LastSeenComplete = torrentLog.CompletedTime
+		}
+	}
+	return tx.Save(&torrentLogs)
+}
+
+// Tasks are moved from the single TorrentLogsAndID document to records of their own
+func migrateTorrentRecords(tx storm.Node) error {
+	var torrentLogs TorrentLogsAndID
+	err := tx.One("ID", TorrentLogsID, &torrentLogs)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var lastAdded time.Time
+	for index := range torrentLogs.TorrentLogs {
+		torrentLog := &torrentLogs.TorrentLogs[index]
+		torrentLog.HexString = torrentLogHash(*torrentLog).HexString()
+		// records are loaded in order of added time, keep order of the old list for tasks migrated at the same time
+		if !torrentLog.AddedTime.After(lastAdded) {
+			torrentLog.AddedTime = lastAdded.Add(time.Nanosecond)
+		}
+		lastAdded = torrentLog.AddedTime
+		if err = tx.Save(torrentLog); err != nil {
+			return err
+		}
+	}
+	return tx.Drop(&torrentLogs)
+}
+
+// TorrentHistory Events of one torrent and results of hooks run for them
+type TorrentHistory struct {
+	ID        int    `storm:"id,increment"`
+	HexString string `storm:"index"`
+	Time      time.Time
+	Event     TorrentEvent
+	Hook      string
+	Success   bool
+	Detail    string
+}
+
+func (TorrentDB *TorrentDB) AddHistory(history *TorrentHistory) {
+	err := TorrentDB.DB.Save(history)
+	if err != nil {
+		TorrentDB.logger.WithFields(log.Fields{"Error": err, "Hash": history.HexString}).Error("Failed to save torrent history")
+	}
+}
+
+func (TorrentDB *TorrentDB) GetHistory(hexString string) (histories []TorrentHistory, err error) {
+	err = TorrentDB.DB.Find("HexString", hexString, &histories)
+	if err == storm.ErrNotFound {
+		err = nil
 	}
-	return &torrentDB
+	return
 }
 
-func (TorrentDB *TorrentDB) Cleanup() {
+func (TorrentDB *TorrentDB) Cleanup() (err error) {
 	if TorrentDB.DB != nil {
-		err := TorrentDB.DB.Close()
+		err = TorrentDB.DB.Close()
 		if err != nil {
-			logger.WithFields(log.Fields{"Detail": err}).Error("Failed to closed database")
+			TorrentDB.logger.WithFields(log.Fields{"Detail": err}).Error("Failed to closed database")
 		}
 	}
+	return
 }
 
+// GetLogs Load records of all tasks, in the order they were added
 func (TorrentDB *TorrentDB) GetLogs(torrentLogs *TorrentLogsAndID) {
 	torrentLogs.ID = TorrentLogsID
-	err := TorrentDB.DB.One("ID", TorrentLogsID, torrentLogs)
+	var records []TorrentLog
+	err := TorrentDB.DB.All(&records)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Info("Init running queue now")
+		TorrentDB.logger.WithFields(log.Fields{"Error": err}).Error("Failed to load torrent queue")
+	}
+	if len(records) == 0 {
+		TorrentDB.logger.Info("Init running queue now")
 	}
 	// remove uninitialized logs
 	var ok []TorrentLog
-	for _, tl := range torrentLogs.TorrentLogs {
+	for _, tl := range records {
 		if tl.InfoBytes == nil {
-			logger.Warnf("torrent %q MetaInfo seems to be uninitialized, remove it from db", tl.TorrentName)
+			TorrentDB.logger.Warnf("torrent %q MetaInfo seems to be uninitialized, remove it from db", tl.TorrentName)
+			TorrentDB.DeleteLog(tl.HexString)
 		} else {
 			ok = append(ok, tl)
 		}
 	}
+	sort.SliceStable(ok, func(i, j int) bool {
+		if ok[i].AddedTime.Equal(ok[j].AddedTime) {
+			return ok[i].HexString < ok[j].HexString
+		}
+		return ok[i].AddedTime.Before(ok[j].AddedTime)
+	})
 	torrentLogs.TorrentLogs = ok
 }
+
+// SaveLog Save record of one task
+func (TorrentDB *TorrentDB) SaveLog(torrentLog *TorrentLog) error {
+	return TorrentDB.DB.Save(torrentLog)
+}
+
+// SaveLogs Save records of many tasks in one transaction, none of them is saved if one fails
+func (TorrentDB *TorrentDB) SaveLogs(torrentLogs []*TorrentLog) error {
+	tx, err := TorrentDB.DB.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	for _, torrentLog := range torrentLogs {
+		if err = tx.Save(torrentLog); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (TorrentDB *TorrentDB) DeleteLog(hexString string) {
+	err := TorrentDB.DB.DeleteStruct(&TorrentLog{HexString: hexString})
+	if err != nil && err != storm.ErrNotFound {
+		TorrentDB.logger.WithFields(log.Fields{"Error": err, "Hash": hexString}).Error("Failed to delete torrent record")
+	}
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/asdine/storm"
+	log "github.com/sirupsen/logrus"
+)
+
+// testMetaInfo Torrent of one file with given name, its content is never read
+func testMetaInfo(t *testing.T, name string) metainfo.MetaInfo {
+	t.Helper()
+	infoBytes, err := bencode.Marshal(metainfo.Info{
+		Name:        name,
+		Length:      1 << 14,
+		PieceLength: 1 << 14,
+		Pieces:      make([]byte, 20),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metainfo.MetaInfo{InfoBytes: infoBytes}
+}
+
+func quietLogger() *log.Logger {
+	logger := log.New()
+	logger.Out = io.Discard
+	return logger
+}
+
+// writeOldTorrentDB Create a db as an older version left it: every task in the single
+// TorrentLogsAndID document and no record of their own
+func writeOldTorrentDB(t *testing.T, dbPath string, version int, torrentLogs []TorrentLog) {
+	t.Helper()
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Save(&TorrentLogsAndID{ID: TorrentLogsID, TorrentLogs: torrentLogs}); err != nil {
+		t.Fatal(err)
+	}
+	if version > 0 {
+		if err = db.Save(&TorrentDBVersion{ID: TorrentDBVersionID, Version: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadTorrentDB(t *testing.T, dbPath string) (version int, torrentLogs []TorrentLog) {
+	t.Helper()
+	torrentDB, err := OpenTorrentDB(dbPath, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer torrentDB.Cleanup()
+	var dbVersion TorrentDBVersion
+	if err = torrentDB.DB.One("ID", TorrentDBVersionID, &dbVersion); err != nil {
+		t.Fatal(err)
+	}
+	var loaded TorrentLogsAndID
+	torrentDB.GetLogs(&loaded)
+	var oldDocument TorrentLogsAndID
+	if err = torrentDB.DB.One("ID", TorrentLogsID, &oldDocument); err != storm.ErrNotFound {
+		t.Errorf("old document is still in db, error %v", err)
+	}
+	return dbVersion.Version, loaded.TorrentLogs
+}
+
+func TestMigrateFromVersion0(t *testing.T) {
+	dir := t.TempDir()
+	completedFile := filepath.Join(dir, "done")
+	if err := os.WriteFile(completedFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(completedFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "storm.db")
+	// version 0 had neither times nor HexString
+	writeOldTorrentDB(t, dbPath, 0, []TorrentLog{
+		{MetaInfo: testMetaInfo(t, "b"), TorrentName: "b", Status: StoppedStatus, StoragePath: dir},
+		{MetaInfo: testMetaInfo(t, "done"), TorrentName: "done", Status: CompletedStatus, StoragePath: dir},
+		{MetaInfo: testMetaInfo(t, "a"), TorrentName: "a", Status: QueuedStatus, StoragePath: dir},
+	})
+
+	before := time.Now()
+	version, torrentLogs := loadTorrentDB(t, dbPath)
+	if version != len(torrentDBMigrations) {
+		t.Fatalf("version %d after migration, want %d", version, len(torrentDBMigrations))
+	}
+	if len(torrentLogs) != 3 {
+		t.Fatalf("%d records after migration, want 3", len(torrentLogs))
+	}
+	for index, name := range []string{"b", "done", "a"} {
+		torrentLog := torrentLogs[index]
+		if torrentLog.TorrentName != name {
+			t.Errorf("record %d is %q, order of old list is not kept", index, torrentLog.TorrentName)
+		}
+		if torrentLog.HexString != torrentLog.HashInfoBytes().HexString() {
+			t.Errorf("record %q has HexString %q", name, torrentLog.HexString)
+		}
+		if torrentLog.AddedTime.Before(before) || torrentLog.MetadataTime.IsZero() {
+			t.Errorf("record %q has times %+v", name, torrentLog.TorrentTimes)
+		}
+	}
+	if completed := torrentLogs[1]; !completed.CompletedTime.Equal(modTime) || !completed.LastSeenComplete.Equal(modTime) {
+		t.Errorf("completed time %v, want time of its file %v", completed.CompletedTime, modTime)
+	}
+	if !torrentLogs[0].CompletedTime.IsZero() {
+		t.Errorf("unfinished task has completed time %v", torrentLogs[0].CompletedTime)
+	}
+
+	// opening a migrated db changes nothing
+	version, again := loadTorrentDB(t, dbPath)
+	if version != len(torrentDBMigrations) {
+		t.Errorf("version %d after reopen", version)
+	}
+	if !reflect.DeepEqual(again, torrentLogs) {
+		t.Errorf("records changed by reopening:\n%+v\n%+v", torrentLogs, again)
+	}
+}
+
+func TestMigrateFromVersion1(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "storm.db")
+	added := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	magnetHash := "0123456789abcdef0123456789abcdef01234567"
+	// version 1 had times, tasks added at the same time keep their order
+	writeOldTorrentDB(t, dbPath, 1, []TorrentLog{
+		{MetaInfo: testMetaInfo(t, "first"), TorrentName: "first", Status: RunningStatus, TorrentTimes: TorrentTimes{AddedTime: added, MetadataTime: added, ActiveSeconds: 42}},
+		{MetaInfo: testMetaInfo(t, "second"), TorrentName: "second", Status: StoppedStatus, TorrentTimes: TorrentTimes{AddedTime: added, MetadataTime: added}, Category: "tv"},
+		{MetaInfo: metainfo.MetaInfo{}, TorrentName: magnetHash, Status: AnalysingStatus, TorrentTimes: TorrentTimes{AddedTime: added}},
+	})
+
+	version, torrentLogs := loadTorrentDB(t, dbPath)
+	if version != 2 {
+		t.Fatalf("version %d after migration, want 2", version)
+	}
+	// the magnet has no info, GetLogs drops it
+	if len(torrentLogs) != 2 {
+		t.Fatalf("%d records after migration, want 2", len(torrentLogs))
+	}
+	first, second := torrentLogs[0], torrentLogs[1]
+	if first.TorrentName != "first" || second.TorrentName != "second" {
+		t.Fatalf("records in order %q, %q", first.TorrentName, second.TorrentName)
+	}
+	if !first.AddedTime.Equal(added) || !second.AddedTime.After(first.AddedTime) || second.AddedTime.Sub(added) > time.Microsecond {
+		t.Errorf("added times %v, %v should start at %v and stay in order", first.AddedTime, second.AddedTime, added)
+	}
+	if first.ActiveSeconds != 42 || !first.MetadataTime.Equal(added) || second.Category != "tv" {
+		t.Errorf("fields lost by migration: %+v %+v", first, second)
+	}
+
+	version, again := loadTorrentDB(t, dbPath)
+	if version != 2 || !reflect.DeepEqual(again, torrentLogs) {
+		t.Errorf("reopening changed db: version %d\n%+v\n%+v", version, torrentLogs, again)
+	}
+}
+
+func TestMigrationsWithoutOldDocument(t *testing.T) {
+	db, err := storm.Open(filepath.Join(t.TempDir(), "storm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	record := TorrentLog{HexString: "x", MetaInfo: testMetaInfo(t, "x"), TorrentName: "x"}
+	if err = db.Save(&record); err != nil {
+		t.Fatal(err)
+	}
+	for index, migration := range torrentDBMigrations {
+		tx, err := db.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = migration(tx); err != nil {
+			t.Errorf("migration %d on a new db: %v", index, err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var records []TorrentLog
+	if err = db.All(&records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], record) {
+		t.Errorf("records changed by migrations: %+v", records)
+	}
+}
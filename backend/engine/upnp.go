@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr = "239.255.255.250:1900"
+	// UPnP errors of AddPortMapping
+	upnpConflictInMappingEntry       = 718
+	upnpOnlyPermanentLeasesSupported = 725
+	// External ports tried after the one asked for is taken by another host
+	upnpPortTries = 4
+	// Name of mappings in table of gateway
+	upnpDescription = "ant"
+)
+
+// Services of internet gateway devices which map ports
+var upnpServicePrefixes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// findService First WANIPConnection or WANPPPConnection service in device tree
+func (device upnpDevice) findService() (upnpService, bool) {
+	for _, service := range device.Services {
+		for _, prefix := range upnpServicePrefixes {
+			if strings.HasPrefix(service.ServiceType, prefix) {
+				return service, true
+			}
+		}
+	}
+	for _, child := range device.Devices {
+		if service, ok := child.findService(); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// upnpError Fault of a SOAP action, with the code of UPnP
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (err upnpError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", err.Code, err.Description)
+}
+
+// upnpGateway Internet gateway device which maps ports with UPnP IGD
+type upnpGateway struct {
+	rootURL     string
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	client      http.Client
+}
+
+// newUPnPGateway Read description of gateway at rootURL, which is found by SSDP if empty
+func newUPnPGateway(ctx context.Context, rootURL string) (*upnpGateway, error) {
+	var err error
+	if rootURL == "" {
+		if rootURL, err = ssdpSearch(ctx); err != nil {
+			return nil, err
+		}
+	}
+	parsedURL, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, err
+	}
+	gateway := &upnpGateway{rootURL: rootURL}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rootURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := gateway.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("description of gateway: %s", response.Status)
+	}
+	var root upnpRoot
+	if err = xml.NewDecoder(response.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("description of gateway: %w", err)
+	}
+	service, ok := root.Device.findService()
+	if !ok {
+		return nil, errors.New("gateway has no WANIPConnection or WANPPPConnection service")
+	}
+	if root.URLBase != "" {
+		if parsedURL, err = url.Parse(root.URLBase); err != nil {
+			return nil, err
+		}
+	}
+	controlURL, err := parsedURL.Parse(service.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	gateway.controlURL = controlURL.String()
+	gateway.serviceType = service.ServiceType
+
+	// address gateway sees us from, mappings point to it
+	port := controlURL.Port()
+	if port == "" {
+		port = "80"
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(controlURL.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	gateway.localIP = conn.LocalAddr().(*net.UDPAddr).IP
+	_ = conn.Close()
+	return gateway, nil
+}
+
+// ssdpSearch Ask internet gateway devices of local network for location of their description
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	multicastAddr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(portMappingTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	for _, searchTarget := range []string{"urn:schemas-upnp-org:device:InternetGatewayDevice:1", "urn:schemas-upnp-org:device:InternetGatewayDevice:2"} {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"ST: " + searchTarget + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err = conn.WriteTo([]byte(search), multicastAddr); err != nil {
+			return "", err
+		}
+	}
+	buffer := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return "", errors.New("no UPnP gateway answered")
+		}
+		reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(buffer[:n])))
+		if _, err = reader.ReadLine(); err != nil {
+			continue
+		}
+		header, _ := reader.ReadMIMEHeader()
+		if location := header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+func (gateway *upnpGateway) protocol() string {
+	return PortMappingUPnP
+}
+
+func (gateway *upnpGateway) address() string {
+	return gateway.rootURL
+}
+
+func (gateway *upnpGateway) localAddress() net.IP {
+	return gateway.localIP
+}
+
+func (gateway *upnpGateway) getExternalIP(ctx context.Context) (net.IP, error) {
+	response, err := gateway.call(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	externalIP := net.ParseIP(xmlValue(response, "NewExternalIPAddress"))
+	if externalIP == nil {
+		return nil, errors.New("gateway has no external address")
+	}
+	return externalIP, nil
+}
+
+// addMapping Gateways which only keep mappings without lease get one of those, the port next to
+// externalPort is tried if it is mapped to another host
+func (gateway *upnpGateway) addMapping(ctx context.Context, network string, internalPort, externalPort int, lease time.Duration) (int, time.Duration, error) {
+	var err error
+	for try := 0; try < upnpPortTries; try++ {
+		err = gateway.addPortMapping(ctx, network, internalPort, externalPort+try, lease)
+		var mappingErr upnpError
+		if errors.As(err, &mappingErr) && mappingErr.Code == upnpOnlyPermanentLeasesSupported && lease != 0 {
+			lease = 0
+			err = gateway.addPortMapping(ctx, network, internalPort, externalPort+try, lease)
+			errors.As(err, &mappingErr)
+		}
+		if err == nil {
+			return externalPort + try, lease, nil
+		}
+		if mappingErr.Code != upnpConflictInMappingEntry {
+			break
+		}
+	}
+	return 0, 0, err
+}
+
+func (gateway *upnpGateway) addPortMapping(ctx context.Context, network string, internalPort, externalPort int, lease time.Duration) error {
+	_, err := gateway.call(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(network)},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", gateway.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", upnpDescription},
+		{"NewLeaseDuration", strconv.Itoa(int(lease / time.Second))},
+	})
+	return err
+}
+
+func (gateway *upnpGateway) deleteMapping(ctx context.Context, network string, internalPort, externalPort int) error {
+	_, err := gateway.call(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(network)},
+	})
+	return err
+}
+
+// call Run a SOAP action of the service, arguments are sent in their order
+func (gateway *upnpGateway) call(ctx context.Context, action string, arguments [][2]string) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, gateway.serviceType)
+	for _, argument := range arguments {
+		fmt.Fprintf(&body, "<%s>", argument[0])
+		_ = xml.EscapeText(&body, []byte(argument[1]))
+		fmt.Fprintf(&body, "</%s>", argument[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, gateway.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, gateway.serviceType, action))
+	response, err := gateway.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		if code, convErr := strconv.Atoi(xmlValue(responseBody, "errorCode")); convErr == nil {
+			return nil, upnpError{Code: code, Description: xmlValue(responseBody, "errorDescription")}
+		}
+		return nil, fmt.Errorf("%s of gateway: %s", action, response.Status)
+	}
+	return responseBody, nil
+}
+
+// xmlValue Text of first element with this local name, empty if there is none
+func xmlValue(document []byte, name string) string {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == name {
+			var value string
+			if decoder.DecodeElement(&value, &start) != nil {
+				return ""
+			}
+			return strings.TrimSpace(value)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/l3f</controlURL></service>
+    </serviceList>
+    <deviceList><device>
+      <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+      <deviceList><device>
+        <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+        <serviceList>
+          <service><serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+        </serviceList>
+      </device></deviceList>
+    </device></deviceList>
+  </device>
+</root>`
+
+// fakeIGD Internet gateway device on loopback with a table of mappings
+type fakeIGD struct {
+	server *httptest.Server
+
+	lock sync.Mutex
+	// Refuse leases, as gateways with UPnPError 725 do
+	permanentOnly bool
+	// External ports mapped to another host
+	takenPorts map[int]bool
+	// Lease of each mapping by protocol and external port, such as TCP/6881
+	mappings map[string]int
+}
+
+func newFakeIGD(t *testing.T) *fakeIGD {
+	t.Helper()
+	fake := &fakeIGD{takenPorts: make(map[int]bool), mappings: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, fakeIGDDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", fake.control)
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (fake *fakeIGD) rootURL() string {
+	return fake.server.URL + "/rootDesc.xml"
+}
+
+func (fake *fakeIGD) tableOfMappings() map[string]int {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	mappings := make(map[string]int)
+	for key, lease := range fake.mappings {
+		mappings[key] = lease
+	}
+	return mappings
+}
+
+func (fake *fakeIGD) control(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	soapAction := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	if !strings.HasPrefix(soapAction, "urn:schemas-upnp-org:service:WANIPConnection:1#") {
+		http.Error(w, "unknown service", http.StatusBadRequest)
+		return
+	}
+	action := strings.TrimPrefix(soapAction, "urn:schemas-upnp-org:service:WANIPConnection:1#")
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	key := xmlValue(body, "NewProtocol") + "/" + xmlValue(body, "NewExternalPort")
+	switch action {
+	case "GetExternalIPAddress":
+		fake.respond(w, action, "<NewExternalIPAddress>203.0.113.9</NewExternalIPAddress>")
+	case "AddPortMapping":
+		externalPort, _ := strconv.Atoi(xmlValue(body, "NewExternalPort"))
+		lease, _ := strconv.Atoi(xmlValue(body, "NewLeaseDuration"))
+		switch {
+		case xmlValue(body, "NewInternalClient") != "127.0.0.1":
+			fake.fault(w, 402, "Invalid Args")
+		case fake.takenPorts[externalPort]:
+			fake.fault(w, upnpConflictInMappingEntry, "ConflictInMappingEntry")
+		case fake.permanentOnly && lease != 0:
+			fake.fault(w, upnpOnlyPermanentLeasesSupported, "OnlyPermanentLeasesSupported")
+		default:
+			fake.mappings[key] = lease
+			fake.respond(w, action, "")
+		}
+	case "DeletePortMapping":
+		if _, isExist := fake.mappings[key]; !isExist {
+			fake.fault(w, 714, "NoSuchEntryInArray")
+			return
+		}
+		delete(fake.mappings, key)
+		fake.respond(w, action, "")
+	default:
+		fake.fault(w, 401, "Invalid Action")
+	}
+}
+
+func (fake *fakeIGD) respond(w http.ResponseWriter, action string, arguments string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+		`<u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">%s</u:%sResponse></s:Body></s:Envelope>`, action, arguments, action)
+}
+
+func (fake *fakeIGD) fault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, description)
+}
+
+func TestUPnPGateway(t *testing.T) {
+	fake := newFakeIGD(t)
+	fake.takenPorts[6881] = true
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	gateway, err := newUPnPGateway(ctx, fake.rootURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gateway.controlURL != fake.server.URL+"/ctl/IPConn" || gateway.localIP.String() != "127.0.0.1" {
+		t.Errorf("control url %q, local address %v", gateway.controlURL, gateway.localIP)
+	}
+
+	// the port next to a taken one is used
+	externalPort, lease, err := gateway.addMapping(ctx, "tcp", 6881, 6881, time.Hour)
+	if err != nil || externalPort != 6882 || lease != time.Hour {
+		t.Errorf("mapped to %d for %v, error %v", externalPort, lease, err)
+	}
+	if externalIP, err := gateway.getExternalIP(ctx); err != nil || externalIP.String() != "203.0.113.9" {
+		t.Errorf("external address %v, error %v", externalIP, err)
+	}
+
+	// a gateway without leases gets a permanent mapping
+	fake.lock.Lock()
+	fake.permanentOnly = true
+	fake.lock.Unlock()
+	externalPort, lease, err = gateway.addMapping(ctx, "udp", 6881, 6882, time.Hour)
+	if err != nil || externalPort != 6882 || lease != 0 {
+		t.Errorf("permanent mapping to %d for %v, error %v", externalPort, lease, err)
+	}
+	if mappings := fake.tableOfMappings(); len(mappings) != 2 || mappings["TCP/6882"] != 3600 || mappings["UDP/6882"] != 0 {
+		t.Errorf("mappings of gateway %v", mappings)
+	}
+
+	if err = gateway.deleteMapping(ctx, "tcp", 6881, 6882); err != nil {
+		t.Error(err)
+	}
+	err = gateway.deleteMapping(ctx, "tcp", 6881, 6882)
+	if upnpErr, ok := err.(upnpError); !ok || upnpErr.Code != 714 {
+		t.Errorf("second delete: %v", err)
+	}
+
+	// every port tried is taken
+	fake.lock.Lock()
+	for port := 7000; port < 7000+upnpPortTries; port++ {
+		fake.takenPorts[port] = true
+	}
+	fake.lock.Unlock()
+	if _, _, err = gateway.addMapping(ctx, "tcp", 7000, 7000, 0); err == nil {
+		t.Error("mapped although every port is taken")
+	}
+}
@@ -0,0 +1,452 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// Resource oriented api. Every route is listed in apiV1Routes, which both registers the
+// handlers and generates the OpenAPI document served at /api/v1/openapi.json
+
+const apiV1Prefix = "/api/v1"
+
+// Codes of APIError, clients should check them rather than messages
+const (
+	apiErrBadRequest        = "bad_request"
+	apiErrInvalidHash       = "invalid_hash"
+	apiErrTorrentNotFound   = "torrent_not_found"
+	apiErrFileNotFound      = "file_not_found"
+	apiErrUnsupportedAction = "unsupported_action"
+	apiErrConflict          = "conflict"
+	apiErrAddFailed         = "add_failed"
+	apiErrInternal          = "internal"
+)
+
+// APIError Body of every failed request
+type APIError struct {
+	Error APIErrorDetail
+}
+
+type APIErrorDetail struct {
+	Code    string
+	Message string
+}
+
+// APIAddTorrent Body of adding a torrent from a link, a torrent file is sent as multipart field "torrent" instead
+type APIAddTorrent struct {
+	// magnet, infohash or http(s) link to a torrent file
+	URL         string
+	Category    string
+	StoragePath string
+	Tags        []string
+	Paused      bool
+}
+
+type APIFilePriority struct {
+	// 0 skips the file, 1 is normal and 2 is high
+	Priority types.PiecePriority
+}
+
+type apiParam struct {
+	Name        string
+	In          string
+	Description string
+	Type        string
+}
+
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	// Method expression of Server, so the table is shared by all servers
+	Handle func(server *Server, w http.ResponseWriter, r *http.Request, ps httprouter.Params)
+	Query  []apiParam
+	// Types of bodies, nil means none
+	Request  interface{}
+	Response interface{}
+	// Status of success, errors are described by Errors
+	Status int
+	Errors []int
+}
+
+func (server *Server) writeAPI(w http.ResponseWriter, status int, body interface{}) {
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to format a json")
+		status = http.StatusInternalServerError
+		data = []byte(`{"Error":{"Code":"internal","Message":"unable to format response"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func (server *Server) writeAPIError(w http.ResponseWriter, status int, code string, format string, args ...interface{}) {
+	server.writeAPI(w, status, APIError{Error: APIErrorDetail{Code: code, Message: fmt.Sprintf(format, args...)}})
+}
+
+// apiTorrent finds torrent of path, answering the error itself if there is none
+func (server *Server) apiTorrent(w http.ResponseWriter, ps httprouter.Params) (detail engine.TorrentDetail, isExist bool) {
+	hexString := strings.ToLower(ps.ByName("hash"))
+	if len(hexString) != 40 || strings.Trim(hexString, "0123456789abcdef") != "" {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrInvalidHash, "%q is not an info hash in hex", ps.ByName("hash"))
+		return
+	}
+	detail, isExist = server.engine.GetTorrentDetail(hexString)
+	if !isExist {
+		server.writeAPIError(w, http.StatusNotFound, apiErrTorrentNotFound, "torrent %s not found", hexString)
+	}
+	return
+}
+
+func (server *Server) apiListTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query, err := torrentQuery(r)
+	var page engine.TorrentPage
+	if err == nil {
+		page, err = server.engine.QueryTorrents(query)
+	}
+	if err != nil {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "%v", err)
+		return
+	}
+	body, err := selectFields(r, page.Torrents)
+	if err != nil {
+		server.writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to select fields")
+		return
+	}
+	writePageHeaders(w, page)
+	server.writeAPI(w, http.StatusOK, body)
+}
+
+func (server *Server) apiGetTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if detail, isExist := server.apiTorrent(w, ps); isExist {
+		server.writeAPI(w, http.StatusOK, detail)
+	}
+}
+
+func (server *Server) apiAddTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var request APIAddTorrent
+	var tmpTorrent *torrent.Torrent
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err = r.ParseMultipartForm(32 << 20); err != nil {
+			server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "unable to parse form: %v", err)
+			return
+		}
+		request.Category = r.FormValue("category")
+		request.StoragePath = r.FormValue("storagePath")
+		request.Paused = r.FormValue("paused") == "true"
+		request.Tags = r.Form["tags"]
+		file, handler, fileErr := r.FormFile("torrent")
+		if fileErr != nil {
+			server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "torrent file missing in field \"torrent\"")
+			return
+		}
+		defer file.Close()
+		filePathAbs, saveErr := server.saveTorrentFile(file, handler.Filename)
+		if saveErr != nil {
+			server.logger.WithFields(log.Fields{"Error": saveErr}).Error("Unable to copy file from form")
+			server.writeAPIError(w, http.StatusInternalServerError, apiErrAddFailed, "unable to save torrent file")
+			return
+		}
+		tmpTorrent, err = server.engine.AddOneTorrentFromFile(filePathAbs, engine.AddOptions{
+			Category:    request.Category,
+			StoragePath: request.StoragePath,
+			Tags:        request.Tags,
+			Paused:      request.Paused,
+		})
+		if err == nil && tmpTorrent != nil && !request.Paused {
+			server.engine.GenerateInfoFromTorrent(tmpTorrent)
+			server.engine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
+		}
+	case "application/json":
+		if err = json.NewDecoder(r.Body).Decode(&request); err != nil {
+			server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "invalid json: %v", err)
+			return
+		}
+		if request.URL == "" {
+			server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "URL is required")
+			return
+		}
+		tmpTorrent, err = server.engine.AddOneTorrentFromURL(request.URL, engine.AddOptions{
+			Category:    request.Category,
+			StoragePath: request.StoragePath,
+			Tags:        request.Tags,
+			Paused:      request.Paused,
+		})
+	default:
+		server.writeAPIError(w, http.StatusUnsupportedMediaType, apiErrBadRequest, "send application/json or multipart/form-data")
+		return
+	}
+
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent")
+		server.writeAPIError(w, http.StatusUnprocessableEntity, apiErrAddFailed, "unable to add torrent: %v", err)
+		return
+	}
+	if tmpTorrent == nil {
+		server.writeAPIError(w, http.StatusConflict, apiErrConflict, "torrent has been completed before")
+		return
+	}
+	hexString := tmpTorrent.InfoHash().HexString()
+	detail, _ := server.engine.GetTorrentDetail(hexString)
+	w.Header().Set("Location", apiV1Prefix+"/torrents/"+hexString)
+	server.writeAPI(w, http.StatusCreated, detail)
+}
+
+func (server *Server) apiDeleteTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.apiTorrent(w, ps)
+	if !isExist {
+		return
+	}
+	deleteFiles := r.FormValue("deleteFiles") == "true"
+	if !server.engine.DelOneTorrent(detail.HexString, deleteFiles) {
+		server.writeAPIError(w, http.StatusConflict, apiErrConflict, "torrent %s can not be deleted now", detail.HexString)
+		return
+	}
+	server.writeAPI(w, http.StatusNoContent, nil)
+}
+
+var apiTorrentActions = map[string]func(runningEngine *engine.Engine, hexString string) bool{
+	"start": (*engine.Engine).StartDownloadTorrent,
+	"stop":  (*engine.Engine).StopOneTorrent,
+}
+
+func (server *Server) apiTorrentAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	action, isSupported := apiTorrentActions[ps.ByName("action")]
+	if !isSupported {
+		server.writeAPIError(w, http.StatusNotFound, apiErrUnsupportedAction, "action %q is not supported", ps.ByName("action"))
+		return
+	}
+	detail, isExist := server.apiTorrent(w, ps)
+	if !isExist {
+		return
+	}
+	if !action(server.engine, detail.HexString) {
+		server.writeAPIError(w, http.StatusConflict, apiErrConflict, "torrent %s can not %s now", detail.HexString, ps.ByName("action"))
+		return
+	}
+	detail, _ = server.engine.GetTorrentDetail(detail.HexString)
+	server.writeAPI(w, http.StatusOK, detail)
+}
+
+func (server *Server) apiTorrentFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if detail, isExist := server.apiTorrent(w, ps); isExist {
+		files := detail.Files
+		if files == nil {
+			files = []engine.FileDetail{}
+		}
+		server.writeAPI(w, http.StatusOK, files)
+	}
+}
+
+func (server *Server) apiSetFilePriority(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.apiTorrent(w, ps)
+	if !isExist {
+		return
+	}
+	fileIndex, err := strconv.Atoi(ps.ByName("index"))
+	if err != nil || fileIndex < 0 || fileIndex >= len(detail.Files) {
+		server.writeAPIError(w, http.StatusNotFound, apiErrFileNotFound, "file %s not found in torrent", ps.ByName("index"))
+		return
+	}
+	var request APIFilePriority
+	if err = json.NewDecoder(r.Body).Decode(&request); err != nil || request.Priority > types.PiecePriorityHigh {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "Priority must be from 0 to %d", types.PiecePriorityHigh)
+		return
+	}
+	if !server.engine.SetFilePriority(detail.HexString, fileIndex, request.Priority) {
+		server.writeAPIError(w, http.StatusConflict, apiErrConflict, "priority can not be changed now")
+		return
+	}
+	detail, _ = server.engine.GetTorrentDetail(detail.HexString)
+	server.writeAPI(w, http.StatusOK, detail.Files[fileIndex])
+}
+
+func (server *Server) apiTorrentHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.apiTorrent(w, ps)
+	if !isExist {
+		return
+	}
+	histories, err := server.engine.TorrentDB.GetHistory(detail.HexString)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get torrent history")
+	}
+	if histories == nil {
+		histories = []engine.TorrentHistory{}
+	}
+	server.writeAPI(w, http.StatusOK, histories)
+}
+
+func (server *Server) apiTorrentPeers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.apiTorrent(w, ps)
+	if !isExist {
+		return
+	}
+	peers, inClient := server.engine.GetTorrentPeers(detail.HexString)
+	if !inClient {
+		peers = []engine.PeerDetail{}
+	}
+	server.writeAPI(w, http.StatusOK, peers)
+}
+
+func (server *Server) apiStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeAPI(w, http.StatusOK, server.engine.GetEngineStats())
+}
+
+func (server *Server) apiPortMapping(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeAPI(w, http.StatusOK, server.engine.GetPortMapping())
+}
+
+// Results of a bulk operation, one for each torrent
+type APIBulkResults struct {
+	Results []engine.BulkResult
+}
+
+func (server *Server) apiBulk(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var request engine.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "invalid json: %v", err)
+		return
+	}
+	results, err := server.engine.RunBulk(request)
+	if err != nil {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "%v", err)
+		return
+	}
+	server.writeAPI(w, http.StatusOK, APIBulkResults{Results: results})
+}
+
+var apiGlobalActions = map[string]func(runningEngine *engine.Engine) ([]engine.BulkResult, error){
+	"startAll": (*engine.Engine).StartAllTorrents,
+	"stopAll":  (*engine.Engine).StopAllTorrents,
+}
+
+func (server *Server) apiGlobalAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	action, isSupported := apiGlobalActions[ps.ByName("action")]
+	if !isSupported {
+		server.writeAPIError(w, http.StatusNotFound, apiErrUnsupportedAction, "action %q is not supported", ps.ByName("action"))
+		return
+	}
+	results, err := action(server.engine)
+	if err != nil {
+		server.writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, "%v", err)
+		return
+	}
+	server.writeAPI(w, http.StatusOK, APIBulkResults{Results: results})
+}
+
+func (server *Server) apiOpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeAPI(w, http.StatusOK, openAPIDocument(apiV1Routes))
+}
+
+var apiV1Routes []apiRoute
+
+// Parameters of torrentQuery, total count and next cursor are sent in headers X-Total-Count and X-Next-Cursor
+var torrentListParams = []apiParam{
+	{Name: "status", In: "query", Type: "string", Description: "Queued, Analysing, Running, Stopped or Completed"},
+	{Name: "category", In: "query", Type: "string", Description: "only torrents of this category, empty for ones without category"},
+	{Name: "tag", In: "query", Type: "string", Description: "only torrents with this tag"},
+	{Name: "search", In: "query", Type: "string", Description: "part of name, case insensitive"},
+	{Name: "sort", In: "query", Type: "string", Description: "name, size, progress, speed, ratio, added, eta or hash"},
+	{Name: "reverse", In: "query", Type: "boolean", Description: "sort descending"},
+	{Name: "cursor", In: "query", Type: "string", Description: "X-Next-Cursor of last page"},
+	{Name: "offset", In: "query", Type: "integer", Description: "skip this many torrents, when there is no cursor"},
+	{Name: "limit", In: "query", Type: "integer", Description: "size of page, 0 means all"},
+	{Name: "fields", In: "query", Type: "string", Description: "comma separated fields to return, all by default"},
+}
+
+func init() {
+	hashErrors := []int{http.StatusBadRequest, http.StatusNotFound}
+	apiV1Routes = []apiRoute{
+		{
+			Method: http.MethodGet, Path: "/torrents", Summary: "List torrents",
+			Handle: (*Server).apiListTorrents, Response: []engine.TorrentDetail{}, Status: http.StatusOK, Errors: []int{http.StatusBadRequest},
+			Query: torrentListParams,
+		},
+		{
+			Method: http.MethodPost, Path: "/torrents", Summary: "Add a torrent from a link (json) or a torrent file (multipart field \"torrent\")",
+			Handle: (*Server).apiAddTorrent, Request: APIAddTorrent{}, Response: engine.TorrentDetail{}, Status: http.StatusCreated,
+			Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity},
+		},
+		{
+			Method: http.MethodGet, Path: "/torrents/:hash", Summary: "Get one torrent",
+			Handle: (*Server).apiGetTorrent, Response: engine.TorrentDetail{}, Status: http.StatusOK, Errors: hashErrors,
+		},
+		{
+			Method: http.MethodDelete, Path: "/torrents/:hash", Summary: "Delete a torrent",
+			Handle: (*Server).apiDeleteTorrent, Status: http.StatusNoContent, Errors: append(hashErrors, http.StatusConflict),
+			Query: []apiParam{
+				{Name: "deleteFiles", In: "query", Type: "boolean", Description: "delete downloaded files too"},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/torrents/:hash/actions/:action", Summary: "Run an action on a torrent, action is start or stop",
+			Handle: (*Server).apiTorrentAction, Response: engine.TorrentDetail{}, Status: http.StatusOK, Errors: append(hashErrors, http.StatusConflict),
+		},
+		{
+			Method: http.MethodGet, Path: "/torrents/:hash/files", Summary: "List files of a torrent",
+			Handle: (*Server).apiTorrentFiles, Response: []engine.FileDetail{}, Status: http.StatusOK, Errors: hashErrors,
+		},
+		{
+			Method: http.MethodPut, Path: "/torrents/:hash/files/:index/priority", Summary: "Change priority of a file",
+			Handle: (*Server).apiSetFilePriority, Request: APIFilePriority{}, Response: engine.FileDetail{}, Status: http.StatusOK,
+			Errors: append(hashErrors, http.StatusConflict),
+		},
+		{
+			Method: http.MethodGet, Path: "/torrents/:hash/history", Summary: "Events of a torrent, with results of hooks",
+			Handle: (*Server).apiTorrentHistory, Response: []engine.TorrentHistory{}, Status: http.StatusOK, Errors: hashErrors,
+		},
+		{
+			Method: http.MethodGet, Path: "/torrents/:hash/peers", Summary: "Connected peers of a torrent, with encryption of each connection",
+			Handle: (*Server).apiTorrentPeers, Response: []engine.PeerDetail{}, Status: http.StatusOK, Errors: hashErrors,
+		},
+		{
+			Method: http.MethodPost, Path: "/bulk", Summary: "Run start, stop, delete, recheck, move, setCategory, setPriority or setTags on many torrents, chosen by Hashes or Filter",
+			Handle: (*Server).apiBulk, Request: engine.BulkRequest{}, Response: APIBulkResults{}, Status: http.StatusOK,
+			Errors: []int{http.StatusBadRequest},
+		},
+		{
+			Method: http.MethodPost, Path: "/actions/:action", Summary: "Run an action on all torrents, action is startAll or stopAll",
+			Handle: (*Server).apiGlobalAction, Response: APIBulkResults{}, Status: http.StatusOK, Errors: []int{http.StatusNotFound},
+		},
+		{
+			Method: http.MethodGet, Path: "/stats", Summary: "Statistics of engine",
+			Handle: (*Server).apiStats, Response: engine.EngineStats{}, Status: http.StatusOK,
+		},
+		{
+			Method: http.MethodGet, Path: "/portmapping", Summary: "Mappings of listen port on gateway, external address and whether peers can likely connect to us",
+			Handle: (*Server).apiPortMapping, Response: engine.PortMappingStatus{}, Status: http.StatusOK,
+		},
+		{
+			Method: http.MethodGet, Path: "/openapi.json", Summary: "This document",
+			Handle: (*Server).apiOpenAPI, Response: map[string]interface{}{}, Status: http.StatusOK,
+		},
+	}
+}
+
+func (server *Server) handleAPIV1(router *httprouter.Router) {
+	for _, route := range apiV1Routes {
+		handle := route.Handle
+		router.Handle(route.Method, apiV1Prefix+route.Path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			handle(server, w, r, ps)
+		})
+	}
+}
@@ -0,0 +1,657 @@
+package router
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// aria2 JSON-RPC over http and websocket, for AriaNg and browser integrations
+// https://aria2.github.io/manual/en/html/aria2c.html#rpc-interface
+
+const (
+	aria2Path    = "/jsonrpc"
+	aria2Version = "1.36.0"
+	// gid of aria2 is 16 hex digits, the start of info hash is used
+	aria2GIDLength = 16
+)
+
+// Error codes of JSON-RPC, aria2 itself answers 1 for every failed method
+const (
+	aria2ParseError     = -32700
+	aria2InvalidRequest = -32600
+	aria2MethodNotFound = -32601
+	aria2Failed         = 1
+)
+
+type aria2Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type aria2Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *aria2Error) Error() string {
+	return err.Message
+}
+
+type aria2Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *aria2Error     `json:"error,omitempty"`
+}
+
+type aria2Notification struct {
+	JSONRPC string       `json:"jsonrpc"`
+	Method  string       `json:"method"`
+	Params  []JsonFormat `json:"params"`
+}
+
+// Engine events and the aria2 notifications sent for them, added torrents send
+// onDownloadStart once they are started, as aria2 does
+var aria2Notifications = map[engine.TorrentEvent]string{
+	engine.EventStarted:   "aria2.onDownloadStart",
+	engine.EventStopped:   "aria2.onDownloadPause",
+	engine.EventRemoved:   "aria2.onDownloadStop",
+	engine.EventCompleted: "aria2.onBtDownloadComplete",
+	engine.EventError:     "aria2.onDownloadError",
+}
+
+func aria2GID(hexString string) string {
+	if len(hexString) < aria2GIDLength {
+		return hexString
+	}
+	return hexString[:aria2GIDLength]
+}
+
+func (server *Server) aria2FindTorrent(gid string) (detail engine.TorrentDetail, err error) {
+	gid = strings.ToLower(gid)
+	if len(gid) != aria2GIDLength {
+		return detail, fmt.Errorf("GID %s is not valid", gid)
+	}
+	for _, detail = range server.engine.GetTorrentDetails() {
+		if strings.HasPrefix(detail.HexString, gid) {
+			return detail, nil
+		}
+	}
+	return detail, fmt.Errorf("GID %s is not found", gid)
+}
+
+func aria2Status(detail engine.TorrentDetail) string {
+	switch detail.Status {
+	case engine.RunningStatus, engine.AnalysingStatus:
+		return "active"
+	case engine.QueuedStatus:
+		return "waiting"
+	case engine.StoppedStatus:
+		return "paused"
+	}
+	return "complete"
+}
+
+func aria2Bool(value bool) string {
+	return strconv.FormatBool(value)
+}
+
+func aria2Int(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
+
+func aria2Files(detail engine.TorrentDetail) []JsonFormat {
+	files := []JsonFormat{}
+	for index, file := range detail.Files {
+		files = append(files, JsonFormat{
+			"index":           strconv.Itoa(index + 1),
+			"path":            filepath.Join(detail.StoragePath, file.Path),
+			"length":          aria2Int(file.Length),
+			"completedLength": aria2Int(file.BytesCompleted),
+			"selected":        aria2Bool(file.Priority != types.PiecePriorityNone),
+			"uris":            []string{},
+		})
+	}
+	return files
+}
+
+// aria2 sends every number as string
+func (server *Server) aria2TorrentStatus(detail engine.TorrentDetail) JsonFormat {
+	status := JsonFormat{
+		"gid":             aria2GID(detail.HexString),
+		"status":          aria2Status(detail),
+		"totalLength":     aria2Int(detail.TotalLength),
+		"completedLength": aria2Int(detail.BytesCompleted),
+		"uploadLength":    aria2Int(detail.BytesUploaded),
+		"downloadSpeed":   aria2Int(int64(detail.DownloadRate)),
+		"uploadSpeed":     aria2Int(int64(detail.UploadRate)),
+		"infoHash":        detail.HexString,
+		"numSeeders":      strconv.Itoa(detail.ConnectedSeeders),
+		"seeder":          aria2Bool(detail.HasInfo && detail.BytesCompleted == detail.TotalLength),
+		"pieceLength":     aria2Int(detail.PieceLength),
+		"numPieces":       strconv.Itoa(detail.PieceCount),
+		"connections":     strconv.Itoa(detail.ActivePeers),
+		"errorCode":       "0",
+		"dir":             detail.StoragePath,
+		"files":           aria2Files(detail),
+	}
+	bittorrent := JsonFormat{"announceList": [][]string{}}
+	if detail.HasInfo {
+		bittorrent["info"] = JsonFormat{"name": detail.Name}
+	}
+	status["bittorrent"] = bittorrent
+	return status
+}
+
+func aria2SelectKeys(status JsonFormat, keys []string) JsonFormat {
+	if len(keys) == 0 {
+		return status
+	}
+	selected := JsonFormat{}
+	for _, key := range keys {
+		if value, isExist := status[key]; isExist {
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+// aria2Params params without the secret token, which must be checked by caller
+type aria2Params []json.RawMessage
+
+func (params aria2Params) get(index int, value interface{}) error {
+	if index >= len(params) {
+		return nil
+	}
+	if err := json.Unmarshal(params[index], value); err != nil {
+		return fmt.Errorf("invalid parameter %d: %v", index+1, err)
+	}
+	return nil
+}
+
+func (params aria2Params) keys(index int) (keys []string, err error) {
+	err = params.get(index, &keys)
+	return
+}
+
+func (server *Server) aria2Torrent(params aria2Params) (detail engine.TorrentDetail, err error) {
+	var gid string
+	if err = params.get(0, &gid); err != nil {
+		return
+	}
+	return server.aria2FindTorrent(gid)
+}
+
+// Options of aria2 used here, the others are ignored
+type aria2Options struct {
+	Dir   string `json:"dir"`
+	Pause string `json:"pause"`
+}
+
+func (options aria2Options) addOptions() engine.AddOptions {
+	return engine.AddOptions{StoragePath: options.Dir, Paused: options.Pause == "true"}
+}
+
+func (server *Server) aria2Added(tmpTorrent *torrent.Torrent, options aria2Options, startNow bool) (interface{}, error) {
+	if tmpTorrent == nil {
+		return nil, fmt.Errorf("task has been completed")
+	}
+	hexString := tmpTorrent.InfoHash().HexString()
+	if startNow && options.Pause != "true" {
+		server.engine.GenerateInfoFromTorrent(tmpTorrent)
+		server.engine.StartDownloadTorrent(hexString)
+	}
+	return aria2GID(hexString), nil
+}
+
+func (server *Server) aria2AddURI(params aria2Params) (interface{}, error) {
+	var uris []string
+	var options aria2Options
+	if err := params.get(0, &uris); err != nil {
+		return nil, err
+	}
+	if err := params.get(1, &options); err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("no uri given")
+	}
+	// Every uri of aria2 points to the same download, the first usable one is taken
+	var lastErr error
+	for _, uri := range uris {
+		tmpTorrent, err := server.engine.AddOneTorrentFromURL(uri, options.addOptions())
+		if err == nil {
+			return server.aria2Added(tmpTorrent, options, false)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (server *Server) aria2AddTorrent(params aria2Params) (interface{}, error) {
+	var encoded string
+	var options aria2Options
+	if err := params.get(0, &encoded); err != nil {
+		return nil, err
+	}
+	if err := params.get(2, &options); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 torrent: %v", err)
+	}
+	torrentMetaInfo, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent: %v", err)
+	}
+	tmpTorrent, err := server.engine.AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo, options.addOptions())
+	if err != nil {
+		return nil, err
+	}
+	return server.aria2Added(tmpTorrent, options, true)
+}
+
+func (server *Server) aria2TellStatus(params aria2Params) (interface{}, error) {
+	detail, err := server.aria2Torrent(params)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := params.keys(1)
+	if err != nil {
+		return nil, err
+	}
+	return aria2SelectKeys(server.aria2TorrentStatus(detail), keys), nil
+}
+
+func (server *Server) aria2TellList(params aria2Params, statuses map[string]bool, keysIndex int) (interface{}, error) {
+	keys, err := params.keys(keysIndex)
+	if err != nil {
+		return nil, err
+	}
+	list := []JsonFormat{}
+	for _, detail := range server.engine.GetTorrentDetails() {
+		status := server.aria2TorrentStatus(detail)
+		if statuses[status["status"].(string)] {
+			list = append(list, aria2SelectKeys(status, keys))
+		}
+	}
+	if keysIndex == 0 {
+		return list, nil
+	}
+	// tellWaiting and tellStopped page with offset and num, negative offset counts from the end
+	var offset, num int
+	if err = params.get(0, &offset); err != nil {
+		return nil, err
+	}
+	if err = params.get(1, &num); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		offset += len(list)
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+		offset = len(list) - 1 - offset
+	}
+	if offset < 0 || offset >= len(list) || num <= 0 {
+		return []JsonFormat{}, nil
+	}
+	list = list[offset:]
+	if num < len(list) {
+		list = list[:num]
+	}
+	return list, nil
+}
+
+func (server *Server) aria2Action(params aria2Params, action func(hexString string) bool) (interface{}, error) {
+	detail, err := server.aria2Torrent(params)
+	if err != nil {
+		return nil, err
+	}
+	if !action(detail.HexString) {
+		return nil, fmt.Errorf("GID %s can not be changed now", aria2GID(detail.HexString))
+	}
+	return aria2GID(detail.HexString), nil
+}
+
+func (server *Server) aria2AllAction(action func(hexString string) bool, statuses ...engine.TorrentStatus) (interface{}, error) {
+	for _, detail := range server.engine.GetTorrentDetails() {
+		for _, status := range statuses {
+			if detail.Status == status {
+				action(detail.HexString)
+			}
+		}
+	}
+	return "OK", nil
+}
+
+func (server *Server) aria2GetGlobalStat(params aria2Params) (interface{}, error) {
+	stats := server.engine.GetEngineStats()
+	counts := make(map[string]int)
+	details := server.engine.GetTorrentDetails()
+	for _, detail := range details {
+		counts[aria2Status(detail)]++
+	}
+	return JsonFormat{
+		"downloadSpeed":   aria2Int(int64(stats.DownloadRate)),
+		"uploadSpeed":     aria2Int(int64(stats.UploadRate)),
+		"numActive":       strconv.Itoa(counts["active"]),
+		"numWaiting":      strconv.Itoa(counts["waiting"] + counts["paused"]),
+		"numStopped":      strconv.Itoa(counts["complete"]),
+		"numStoppedTotal": strconv.Itoa(counts["complete"]),
+	}, nil
+}
+
+func (server *Server) aria2GetGlobalOption(params aria2Params) (interface{}, error) {
+	config := server.config.Current()
+	torrentConfig := config.TorrentConfig
+	return JsonFormat{
+		"dir":                      torrentConfig.DataDir,
+		"max-concurrent-downloads": strconv.Itoa(config.EngineSetting.MaxActiveTorrents),
+		"bt-max-peers":             strconv.Itoa(config.EngineSetting.MaxEstablishedConns),
+		"listen-port":              strconv.Itoa(torrentConfig.ListenPort),
+		"enable-dht":               aria2Bool(!torrentConfig.NoDHT),
+		"enable-peer-exchange":     aria2Bool(!torrentConfig.DisablePEX),
+		"seed-ratio":               "0",
+	}, nil
+}
+
+var aria2Methods map[string]func(server *Server, params aria2Params) (interface{}, error)
+
+func init() {
+	aria2Methods = map[string]func(server *Server, params aria2Params) (interface{}, error){
+		"aria2.addUri":     (*Server).aria2AddURI,
+		"aria2.addTorrent": (*Server).aria2AddTorrent,
+		"aria2.tellStatus": (*Server).aria2TellStatus,
+		"aria2.tellActive": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2TellList(params, map[string]bool{"active": true}, 0)
+		},
+		"aria2.tellWaiting": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2TellList(params, map[string]bool{"waiting": true, "paused": true}, 2)
+		},
+		"aria2.tellStopped": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2TellList(params, map[string]bool{"complete": true, "error": true, "removed": true}, 2)
+		},
+		"aria2.getFiles": func(server *Server, params aria2Params) (interface{}, error) {
+			detail, err := server.aria2Torrent(params)
+			if err != nil {
+				return nil, err
+			}
+			return aria2Files(detail), nil
+		},
+		"aria2.getPeers": func(server *Server, params aria2Params) (interface{}, error) {
+			if _, err := server.aria2Torrent(params); err != nil {
+				return nil, err
+			}
+			return []JsonFormat{}, nil
+		},
+		"aria2.getOption": func(server *Server, params aria2Params) (interface{}, error) {
+			detail, err := server.aria2Torrent(params)
+			if err != nil {
+				return nil, err
+			}
+			return JsonFormat{"dir": detail.StoragePath}, nil
+		},
+		"aria2.pause": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2Action(params, server.engine.StopOneTorrent)
+		},
+		"aria2.forcePause": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2Action(params, server.engine.StopOneTorrent)
+		},
+		"aria2.unpause": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2Action(params, server.engine.StartDownloadTorrent)
+		},
+		// Like aria2, remove keeps downloaded files
+		"aria2.remove": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2Action(params, func(hexString string) bool {
+				return server.engine.DelOneTorrent(hexString, false)
+			})
+		},
+		"aria2.forceRemove": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2Action(params, func(hexString string) bool {
+				return server.engine.DelOneTorrent(hexString, false)
+			})
+		},
+		"aria2.pauseAll": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2AllAction(server.engine.StopOneTorrent, engine.RunningStatus, engine.QueuedStatus)
+		},
+		"aria2.forcePauseAll": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2AllAction(server.engine.StopOneTorrent, engine.RunningStatus, engine.QueuedStatus)
+		},
+		"aria2.unpauseAll": func(server *Server, params aria2Params) (interface{}, error) {
+			return server.aria2AllAction(server.engine.StartDownloadTorrent, engine.StoppedStatus)
+		},
+		// Completed tasks stay in history of ANT, so there is no result to purge
+		"aria2.removeDownloadResult": func(server *Server, params aria2Params) (interface{}, error) { return "OK", nil },
+		"aria2.purgeDownloadResult":  func(server *Server, params aria2Params) (interface{}, error) { return "OK", nil },
+		"aria2.saveSession":          func(server *Server, params aria2Params) (interface{}, error) { return "OK", nil },
+		"aria2.getGlobalStat":        (*Server).aria2GetGlobalStat,
+		"aria2.getGlobalOption":      (*Server).aria2GetGlobalOption,
+		"aria2.getVersion": func(server *Server, params aria2Params) (interface{}, error) {
+			return JsonFormat{
+				"version":         aria2Version,
+				"enabledFeatures": []string{"BitTorrent", "Message Digest"},
+			}, nil
+		},
+		"aria2.getSessionInfo": func(server *Server, params aria2Params) (interface{}, error) {
+			return JsonFormat{"sessionId": server.aria2SessionID}, nil
+		},
+		"system.listMethods": func(server *Server, params aria2Params) (interface{}, error) {
+			methods := []string{"system.multicall"}
+			for method := range aria2Methods {
+				methods = append(methods, method)
+			}
+			return methods, nil
+		},
+		"system.listNotifications": func(server *Server, params aria2Params) (interface{}, error) {
+			notifications := []string{"aria2.onDownloadStart", "aria2.onDownloadPause", "aria2.onDownloadStop",
+				"aria2.onDownloadComplete", "aria2.onDownloadError", "aria2.onBtDownloadComplete"}
+			return notifications, nil
+		},
+	}
+}
+
+// Token is the auth password, it is needed when remote support is on
+func (server *Server) aria2CheckToken(params []json.RawMessage) (aria2Params, *aria2Error) {
+	var token string
+	if len(params) > 0 && json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, "token:") {
+		params = params[1:]
+	} else {
+		token = ""
+	}
+	if server.config.ConnectSetting.SupportRemote {
+		secret := "token:" + server.config.ConnectSetting.AuthPassword
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return nil, &aria2Error{Code: aria2Failed, Message: "Unauthorized"}
+		}
+	}
+	return params, nil
+}
+
+func (server *Server) aria2Call(request aria2Request) aria2Response {
+	response := aria2Response{JSONRPC: "2.0", ID: request.ID}
+	if request.Method == "" {
+		response.Error = &aria2Error{Code: aria2InvalidRequest, Message: "Invalid Request."}
+		return response
+	}
+	params := aria2Params(request.Params)
+	if request.Method == "system.multicall" {
+		result, err := server.aria2Multicall(params)
+		if err != nil {
+			response.Error = &aria2Error{Code: aria2Failed, Message: err.Error()}
+		}
+		response.Result = result
+		return response
+	}
+	// system methods take no token
+	if !strings.HasPrefix(request.Method, "system.") {
+		var authErr *aria2Error
+		if params, authErr = server.aria2CheckToken(request.Params); authErr != nil {
+			response.Error = authErr
+			return response
+		}
+	}
+	method, isExist := aria2Methods[request.Method]
+	if !isExist {
+		response.Error = &aria2Error{Code: aria2MethodNotFound, Message: "Method not found."}
+		return response
+	}
+	result, err := method(server, params)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err, "Method": request.Method}).Debug("aria2 rpc failed")
+		response.Error = &aria2Error{Code: aria2Failed, Message: err.Error()}
+		return response
+	}
+	response.Result = result
+	return response
+}
+
+// system.multicall runs each call with its own token
+func (server *Server) aria2Multicall(params aria2Params) (interface{}, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if err := params.get(0, &calls); err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		if call.MethodName == "system.multicall" {
+			results = append(results, &aria2Error{Code: aria2Failed, Message: "Recursive system.multicall forbidden."})
+			continue
+		}
+		response := server.aria2Call(aria2Request{Method: call.MethodName, Params: call.Params})
+		if response.Error != nil {
+			results = append(results, response.Error)
+		} else {
+			results = append(results, []interface{}{response.Result})
+		}
+	}
+	return results, nil
+}
+
+// aria2Handle answers a single request or a batch of them
+func (server *Server) aria2Handle(body []byte) interface{} {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []aria2Request
+		if err := json.Unmarshal(body, &requests); err != nil {
+			return aria2Response{JSONRPC: "2.0", Error: &aria2Error{Code: aria2ParseError, Message: "Parse error."}}
+		}
+		responses := make([]aria2Response, 0, len(requests))
+		for _, request := range requests {
+			responses = append(responses, server.aria2Call(request))
+		}
+		return responses
+	}
+	var request aria2Request
+	if err := json.Unmarshal(body, &request); err != nil {
+		return aria2Response{JSONRPC: "2.0", Error: &aria2Error{Code: aria2ParseError, Message: "Parse error."}}
+	}
+	return server.aria2Call(request)
+}
+
+func (server *Server) aria2HTTP(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(http.MaxBytesReader(w, r.Body, 32<<20)); err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	server.WriteResponse(w, server.aria2Handle(body.Bytes()))
+}
+
+// aria2WS Requests and answers go over websocket, together with notifications of engine events
+func (server *Server) aria2WS(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "aria2 rpc takes POST or websocket", http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to init aria2 websocket")
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx, cancelStream := server.streamContext(r)
+	defer cancelStream()
+	server.closeWebsocket(ctx, conn)
+
+	var writeLock sync.Mutex
+	writeJSON := func(value interface{}) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteJSON(value)
+	}
+
+	events, cancel := server.engine.SubscribeEvents()
+	defer cancel()
+	go func() {
+		for payload := range events {
+			method, isExist := aria2Notifications[payload.Event]
+			if !isExist {
+				continue
+			}
+			notification := aria2Notification{
+				JSONRPC: "2.0",
+				Method:  method,
+				Params:  []JsonFormat{{"gid": aria2GID(payload.HexString)}},
+			}
+			if err := writeJSON(notification); err != nil {
+				return
+			}
+			// Clients waiting for onDownloadComplete are told too
+			if payload.Event == engine.EventCompleted {
+				notification.Method = "aria2.onDownloadComplete"
+				if err := writeJSON(notification); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				server.logger.WithFields(log.Fields{"Error": err}).Debug("aria2 websocket closed")
+			}
+			return
+		}
+		if err = writeJSON(server.aria2Handle(message)); err != nil {
+			server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to write aria2 response")
+			return
+		}
+	}
+}
+
+func (server *Server) handleAria2(router *httprouter.Router) {
+	randomBytes := make([]byte, 20)
+	_, _ = rand.Read(randomBytes)
+	server.aria2SessionID = hex.EncodeToString(randomBytes)
+	router.POST(aria2Path, server.aria2HTTP)
+	router.GET(aria2Path, server.aria2WS)
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// aria2Post Send one request or a batch, the decoded answer is returned
+func aria2Post(t *testing.T, apiURL string, request interface{}, result interface{}) {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.Post(apiURL+aria2Path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if err = json.NewDecoder(response.Body).Decode(result); err != nil {
+		t.Fatalf("status %d, error %v", response.StatusCode, err)
+	}
+}
+
+type aria2TestResponse struct {
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *aria2Error     `json:"error"`
+}
+
+func aria2TestCall(t *testing.T, apiURL string, method string, params ...interface{}) aria2TestResponse {
+	t.Helper()
+	var response aria2TestResponse
+	aria2Post(t, apiURL, map[string]interface{}{"jsonrpc": "2.0", "id": "test", "method": method, "params": params}, &response)
+	if response.ID != "test" {
+		t.Fatalf("%s: id %q", method, response.ID)
+	}
+	return response
+}
+
+func TestAria2Token(t *testing.T) {
+	_, httpServer := newTestServer(t, testRemoteConfig)
+	tests := []struct {
+		name   string
+		method string
+		params []interface{}
+		valid  bool
+	}{
+		{"no token", "aria2.getVersion", nil, false},
+		{"wrong token", "aria2.getVersion", []interface{}{"token:wrong"}, false},
+		{"user name", "aria2.getVersion", []interface{}{"token:admin"}, false},
+		{"password", "aria2.getVersion", []interface{}{"token:secret"}, true},
+		{"token needed by method with params", "aria2.tellActive", []interface{}{[]string{"gid"}}, false},
+		{"token before params", "aria2.tellActive", []interface{}{"token:secret", []string{"gid"}}, true},
+		{"system method", "system.listMethods", nil, true},
+	}
+	for _, test := range tests {
+		response := aria2TestCall(t, httpServer.URL, test.method, test.params...)
+		if valid := response.Error == nil; valid != test.valid {
+			t.Errorf("%s: error %+v, result %s", test.name, response.Error, response.Result)
+		}
+		if response.Error != nil && response.Error.Message != "Unauthorized" {
+			t.Errorf("%s: error %+v", test.name, response.Error)
+		}
+	}
+
+	// every call of multicall has its own token
+	var response aria2TestResponse
+	aria2Post(t, httpServer.URL, map[string]interface{}{"jsonrpc": "2.0", "id": "test", "method": "system.multicall", "params": []interface{}{[]interface{}{
+		map[string]interface{}{"methodName": "aria2.getVersion", "params": []string{"token:secret"}},
+		map[string]interface{}{"methodName": "aria2.getVersion", "params": []string{"token:wrong"}},
+	}}}, &response)
+	var results []json.RawMessage
+	if err := json.Unmarshal(response.Result, &results); err != nil || len(results) != 2 {
+		t.Fatalf("multicall %s, error %v", response.Result, err)
+	}
+	if !bytes.HasPrefix(results[0], []byte("[")) || !bytes.Contains(results[1], []byte("Unauthorized")) {
+		t.Errorf("multicall results %s", response.Result)
+	}
+}
+
+func TestAria2RoundTrip(t *testing.T) {
+	_, httpServer := newTestServer(t, testConfig)
+	data, hexString := testTorrent(t, "aria2")
+	gid := aria2GID(hexString)
+
+	response := aria2TestCall(t, httpServer.URL, "aria2.addTorrent", base64.StdEncoding.EncodeToString(data), []string{}, map[string]string{})
+	var added string
+	if err := json.Unmarshal(response.Result, &added); err != nil || added != gid {
+		t.Fatalf("addTorrent %s, error %+v", response.Result, response.Error)
+	}
+
+	status := func() string {
+		t.Helper()
+		response := aria2TestCall(t, httpServer.URL, "aria2.tellStatus", gid, []string{"gid", "status", "infoHash"})
+		if response.Error != nil {
+			return ""
+		}
+		var result map[string]string
+		if err := json.Unmarshal(response.Result, &result); err != nil || result["gid"] != gid || result["infoHash"] != hexString {
+			t.Fatalf("tellStatus %s, error %v", response.Result, err)
+		}
+		return result["status"]
+	}
+	if got := status(); got != "active" {
+		t.Fatalf("status after add %q", got)
+	}
+	for _, step := range []struct {
+		method string
+		status string
+	}{
+		{"aria2.pause", "paused"},
+		{"aria2.unpause", "active"},
+		{"aria2.remove", ""},
+	} {
+		response := aria2TestCall(t, httpServer.URL, step.method, gid)
+		if response.Error != nil || string(response.Result) != `"`+gid+`"` {
+			t.Fatalf("%s: result %s, error %+v", step.method, response.Result, response.Error)
+		}
+		if got := status(); got != step.status {
+			t.Fatalf("status after %s %q, want %q", step.method, got, step.status)
+		}
+	}
+}
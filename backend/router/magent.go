@@ -7,24 +7,24 @@ import (
 )
 
 //Add magnet will let to serious problems, a better way is to get torrent file via magnet and then use addTorrent
-func addOneMagnet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) addOneMagnet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	linkAddress := r.FormValue("linkAddress")
-	logger.Infof("add magnet request, address: %s", linkAddress)
-	_, err := runningEngine.AddOneTorrentFromMagnet(linkAddress)
+	server.logger.Infof("add magnet request, address: %s", linkAddress)
+	_, err := server.engine.AddOneTorrentFromMagnet(linkAddress)
 
 	var isAdded bool
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("unable to add a magnet")
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a magnet")
 		isAdded = false
 	} else {
 		isAdded = true
 	}
 
-	WriteResponse(w, JsonFormat{
+	server.WriteResponse(w, JsonFormat{
 		"IsAdded": isAdded,
 	})
 }
 
-func handleMagnet(router *httprouter.Router) {
-	router.POST("/magnet/addOneMagnet", addOneMagnet)
+func (server *Server) handleMagnet(router *httprouter.Router) {
+	router.POST("/magnet/addOneMagnet", server.addOneMagnet)
 }
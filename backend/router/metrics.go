@@ -0,0 +1,189 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/urfave/negroni"
+)
+
+// Buckets in seconds, same as the default of prometheus client
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var hexStringRegexp = regexp.MustCompile(`/[0-9a-fA-F]{40}(/|$)`)
+
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// requestMetrics Latency of http requests, labelled by route rather than raw path
+type requestMetrics struct {
+	lock       sync.Mutex
+	histograms map[[2]string]*latencyHistogram
+	router     *httprouter.Router
+}
+
+func newRequestMetrics(router *httprouter.Router) *requestMetrics {
+	return &requestMetrics{
+		histograms: make(map[[2]string]*latencyHistogram),
+		router:     router,
+	}
+}
+
+// Unknown paths share one label, so scanners can not blow up the series
+func (metrics *requestMetrics) route(r *http.Request) string {
+	if handle, _, _ := metrics.router.Lookup(r.Method, r.URL.Path); handle == nil {
+		return "other"
+	}
+	return hexStringRegexp.ReplaceAllString(r.URL.Path, "/:hexString$1")
+}
+
+// Negroni compatible interface
+func (metrics *requestMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	next(w, r)
+	// websocket and player live as long as the client, they are not latency
+	if r.URL.Path == "/ws" || strings.HasPrefix(r.URL.Path, "/player/") {
+		return
+	}
+	status := http.StatusOK
+	if res, ok := w.(negroni.ResponseWriter); ok && res.Status() != 0 {
+		status = res.Status()
+	}
+	metrics.observe(r.Method+" "+metrics.route(r), strconv.Itoa(status), time.Since(start).Seconds())
+}
+
+func (metrics *requestMetrics) observe(route string, status string, seconds float64) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	key := [2]string{route, status}
+	histogram, isExist := metrics.histograms[key]
+	if !isExist {
+		histogram = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+		metrics.histograms[key] = histogram
+	}
+	for index, bound := range latencyBuckets {
+		if seconds <= bound {
+			histogram.counts[index]++
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+func (metrics *requestMetrics) write(w io.Writer) {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	keys := make([][2]string, 0, len(metrics.histograms))
+	for key := range metrics.histograms {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+	})
+	writeMetricHeader(w, "ant_http_request_duration_seconds", "histogram", "Latency of http requests by route")
+	for _, key := range keys {
+		histogram := metrics.histograms[key]
+		labels := fmt.Sprintf(`route="%s",code="%s"`, escapeLabel(key[0]), key[1])
+		for index, bound := range latencyBuckets {
+			_, _ = fmt.Fprintf(w, "ant_http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, histogram.counts[index])
+		}
+		_, _ = fmt.Fprintf(w, "ant_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, histogram.count)
+		_, _ = fmt.Fprintf(w, "ant_http_request_duration_seconds_sum{%s} %g\n", labels, histogram.sum)
+		_, _ = fmt.Fprintf(w, "ant_http_request_duration_seconds_count{%s} %d\n", labels, histogram.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string, value interface{}) {
+	writeMetricHeader(w, name, metricType, help)
+	_, _ = fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+// Prometheus text exposition format
+func (server *Server) getMetrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	stats := server.engine.GetEngineStats()
+
+	writeMetric(w, "ant_download_bytes_total", "counter", "Payload bytes downloaded since the client started", stats.BytesDownloaded)
+	writeMetric(w, "ant_upload_bytes_total", "counter", "Payload bytes uploaded since the client started", stats.BytesUploaded)
+	writeMetric(w, "ant_download_rate_bytes", "gauge", "Download rate in bytes per second", stats.DownloadRate)
+	writeMetric(w, "ant_upload_rate_bytes", "gauge", "Upload rate in bytes per second", stats.UploadRate)
+	writeMetric(w, "ant_peers", "gauge", "Active peers of all torrents", stats.ActivePeers)
+	writeMetric(w, "ant_seeds", "gauge", "Connected seeders of all torrents", stats.ConnectedSeeders)
+	writeMetric(w, "ant_hash_failures_total", "counter", "Pieces that failed hash check", stats.HashFailures)
+	writeMetric(w, "ant_dht_nodes", "gauge", "Nodes in DHT routing tables", stats.DHTNodes)
+	writeMetric(w, "ant_dht_good_nodes", "gauge", "Good nodes in DHT routing tables", stats.DHTGoodNodes)
+	writeMetric(w, "ant_magnets_analysing", "gauge", "Magnets waiting for metadata", stats.MagnetNum)
+	writeMetric(w, "ant_db_size_bytes", "gauge", "Size of the torrent database", stats.DBSize)
+
+	writeMetricHeader(w, "ant_torrents", "gauge", "Torrents by status")
+	statusNames := make([]string, 0, len(stats.TorrentsByStatus))
+	for statusName := range stats.TorrentsByStatus {
+		statusNames = append(statusNames, statusName)
+	}
+	sort.Strings(statusNames)
+	for _, statusName := range statusNames {
+		_, _ = fmt.Fprintf(w, "ant_torrents{status=\"%s\"} %d\n", statusName, stats.TorrentsByStatus[statusName])
+	}
+
+	if server.config.Current().MetricsSetting.MaxTorrentLabels > 0 {
+		server.writeTorrentMetrics(w)
+	}
+	if server.requestLatency != nil {
+		server.requestLatency.write(w)
+	}
+}
+
+func (server *Server) writeTorrentMetrics(w io.Writer) {
+	rates := server.engine.GetTorrentRates()
+	if maxLabels := server.config.Current().MetricsSetting.MaxTorrentLabels; len(rates) > maxLabels {
+		rates = rates[:maxLabels]
+	}
+	perTorrent := []struct {
+		name       string
+		metricType string
+		help       string
+		value      func(index int) interface{}
+	}{
+		{"ant_torrent_download_bytes_total", "counter", "Payload bytes downloaded by torrent", func(i int) interface{} { return rates[i].BytesDownloaded }},
+		{"ant_torrent_upload_bytes_total", "counter", "Payload bytes uploaded by torrent", func(i int) interface{} { return rates[i].BytesUploaded }},
+		{"ant_torrent_download_rate_bytes", "gauge", "Download rate of torrent in bytes per second", func(i int) interface{} { return rates[i].DownloadRate }},
+		{"ant_torrent_upload_rate_bytes", "gauge", "Upload rate of torrent in bytes per second", func(i int) interface{} { return rates[i].UploadRate }},
+		{"ant_torrent_completed_bytes", "gauge", "Verified bytes of torrent", func(i int) interface{} { return rates[i].BytesCompleted }},
+		{"ant_torrent_size_bytes", "gauge", "Total length of torrent", func(i int) interface{} { return rates[i].TotalLength }},
+		{"ant_torrent_peers", "gauge", "Active peers of torrent", func(i int) interface{} { return rates[i].ActivePeers }},
+		{"ant_torrent_seeds", "gauge", "Connected seeders of torrent", func(i int) interface{} { return rates[i].ConnectedSeeders }},
+		{"ant_torrent_hash_failures_total", "counter", "Pieces of torrent that failed hash check", func(i int) interface{} { return rates[i].HashFailures }},
+	}
+	for _, metric := range perTorrent {
+		writeMetricHeader(w, metric.name, metric.metricType, metric.help)
+		for index := range rates {
+			_, _ = fmt.Fprintf(w, "%s{hash=\"%s\",name=\"%s\",status=\"%s\"} %v\n",
+				metric.name, rates[index].HexString, escapeLabel(rates[index].Name), rates[index].Status, metric.value(index))
+		}
+	}
+}
+
+func (server *Server) handleMetrics(router *httprouter.Router) {
+	router.GET("/metrics", server.getMetrics)
+}
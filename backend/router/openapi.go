@@ -0,0 +1,168 @@
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3 document built from routes, schemas come from go types of bodies
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type schemaBuilder struct {
+	components JsonFormat
+}
+
+func (builder *schemaBuilder) schema(t reflect.Type) JsonFormat {
+	if t == timeType {
+		return JsonFormat{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return builder.schema(t.Elem())
+	case reflect.Bool:
+		return JsonFormat{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return JsonFormat{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return JsonFormat{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return JsonFormat{"type": "number"}
+	case reflect.String:
+		return JsonFormat{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return JsonFormat{"type": "string", "format": "byte"}
+		}
+		return JsonFormat{"type": "array", "items": builder.schema(t.Elem())}
+	case reflect.Map:
+		return JsonFormat{"type": "object", "additionalProperties": builder.schema(t.Elem())}
+	case reflect.Struct:
+		return builder.structSchema(t)
+	}
+	return JsonFormat{}
+}
+
+// Named structs go to components and are referred, so each is described once
+func (builder *schemaBuilder) structSchema(t reflect.Type) JsonFormat {
+	name := t.Name()
+	if name != "" {
+		ref := JsonFormat{"$ref": "#/components/schemas/" + name}
+		if _, isExist := builder.components[name]; isExist {
+			return ref
+		}
+		// placeholder against recursive types
+		builder.components[name] = JsonFormat{}
+	}
+	properties := JsonFormat{}
+	builder.addFields(t, properties)
+	schema := JsonFormat{"type": "object", "properties": properties}
+	if name == "" {
+		return schema
+	}
+	builder.components[name] = schema
+	return JsonFormat{"$ref": "#/components/schemas/" + name}
+}
+
+// Fields follow encoding/json: embedded structs are flattened, "-" and unexported fields skipped
+func (builder *schemaBuilder) addFields(t reflect.Type, properties JsonFormat) {
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			builder.addFields(field.Type, properties)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = builder.schema(field.Type)
+	}
+}
+
+func jsonContent(schema JsonFormat) JsonFormat {
+	return JsonFormat{"application/json": JsonFormat{"schema": schema}}
+}
+
+// openAPIPath turns ":hash" of httprouter into "{hash}"
+func openAPIPath(path string) (openPath string, pathParams []string) {
+	segments := strings.Split(path, "/")
+	for index, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			pathParams = append(pathParams, segment[1:])
+			segments[index] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), pathParams
+}
+
+func openAPIDocument(routes []apiRoute) JsonFormat {
+	builder := &schemaBuilder{components: JsonFormat{}}
+	errorSchema := builder.schema(reflect.TypeOf(APIError{}))
+	paths := JsonFormat{}
+	for _, route := range routes {
+		openPath, pathParams := openAPIPath(route.Path)
+		parameters := []JsonFormat{}
+		for _, name := range pathParams {
+			parameters = append(parameters, JsonFormat{
+				"name": name, "in": "path", "required": true, "schema": JsonFormat{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			parameters = append(parameters, JsonFormat{
+				"name": param.Name, "in": param.In, "description": param.Description, "schema": JsonFormat{"type": param.Type},
+			})
+		}
+
+		success := JsonFormat{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			success["content"] = jsonContent(builder.schema(reflect.TypeOf(route.Response)))
+		}
+		responses := JsonFormat{strconv.Itoa(route.Status): success}
+		for _, status := range route.Errors {
+			responses[strconv.Itoa(status)] = JsonFormat{
+				"description": http.StatusText(status),
+				"content":     jsonContent(errorSchema),
+			}
+		}
+
+		operation := JsonFormat{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + strings.NewReplacer("/", "_", ":", "", ".", "_").Replace(route.Path),
+			"parameters":  parameters,
+			"responses":   responses,
+		}
+		if route.Request != nil {
+			operation["requestBody"] = JsonFormat{
+				"required": true,
+				"content":  jsonContent(builder.schema(reflect.TypeOf(route.Request))),
+			}
+		}
+		pathItem, isExist := paths[openPath].(JsonFormat)
+		if !isExist {
+			pathItem = JsonFormat{}
+			paths[openPath] = pathItem
+		}
+		pathItem[strings.ToLower(route.Method)] = operation
+	}
+	return JsonFormat{
+		"openapi": "3.0.3",
+		"info": JsonFormat{
+			"title":   "ANT Downloader API",
+			"version": "1",
+		},
+		"servers":    []JsonFormat{{"url": apiV1Prefix}},
+		"paths":      paths,
+		"components": JsonFormat{"schemas": builder.components},
+	}
+}
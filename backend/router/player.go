@@ -7,21 +7,23 @@ import (
 	"time"
 )
 
-func startPlay(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) startPlay(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	hexString := ps.ByName("hexString")
-	singleTorrent, isExist := runningEngine.GetOneTorrent(hexString)
+	singleTorrent, isExist := server.engine.GetOneTorrent(hexString)
 	fileServed := false
 	if isExist {
-		singleTorrentLog := runningEngine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
+		singleTorrentLog, _ := server.engine.GetTorrentLog(hexString)
 		if singleTorrentLog.Status == engine.RunningStatus || singleTorrentLog.Status == engine.CompletedStatus {
-			fileEntry, target, err := runningEngine.GetReaderFromTorrent(singleTorrent, "")
+			ctx, cancel := server.streamContext(r)
+			defer cancel()
+			fileEntry, target, err := server.engine.GetReaderFromTorrent(ctx, singleTorrent, "")
 			if err != nil {
-				logger.Error("Unable to get reader : ", err)
+				server.logger.Error("Unable to get reader : ", err)
 			} else {
 				defer fileEntry.Close()
 				fileServed = true
 				w.Header().Set("Content-Disposition", "attachment; filename=\""+singleTorrent.Info().Name+"\"")
-				logger.Info("serve it now")
+				server.logger.Info("serve it now")
 				http.ServeContent(w, r, target.DisplayPath(), time.Now(), fileEntry)
 			}
 		}
@@ -29,9 +31,9 @@ func startPlay(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if !fileServed {
 		w.WriteHeader(http.StatusNotFound)
 	}
-	logger.Debug("Play has done")
+	server.logger.Debug("Play has done")
 }
 
-func handlePlayer(router *httprouter.Router)  {
-	router.GET("/player/:hexString", startPlay)
+func (server *Server) handlePlayer(router *httprouter.Router)  {
+	router.GET("/player/:hexString", server.startPlay)
 }
\ No newline at end of file
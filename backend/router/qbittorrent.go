@@ -0,0 +1,636 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/types"
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Main routes of qBittorrent Web API v2, for Sonarr, Radarr and browser extensions
+// https://github.com/qbittorrent/qBittorrent/wiki/WebUI-API-(qBittorrent-4.1)
+
+const (
+	qbittorrentPrefix     = "/api/v2/"
+	qbittorrentVersion    = "v4.3.9"
+	qbittorrentAPIVersion = "2.8.3"
+	qbittorrentCookie     = "SID"
+	qbittorrentSessionTTL = time.Hour
+	// qBittorrent reports unknown eta as 100 days
+	qbittorrentInfiniteETA = 8640000
+)
+
+// Sessions expire after an hour without requests, as in qBittorrent
+type qbittorrentSessions struct {
+	lock     sync.Mutex
+	lastSeen map[string]time.Time
+	logger   *log.Logger
+}
+
+func (sessions *qbittorrentSessions) create() string {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		sessions.logger.WithFields(log.Fields{"Error": err}).Error("Unable to create qBittorrent session id")
+	}
+	sid := hex.EncodeToString(randomBytes)
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	sessions.lastSeen[sid] = time.Now()
+	return sid
+}
+
+func (sessions *qbittorrentSessions) check(sid string) bool {
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	for oldSid, lastSeen := range sessions.lastSeen {
+		if time.Since(lastSeen) > qbittorrentSessionTTL {
+			delete(sessions.lastSeen, oldSid)
+		}
+	}
+	if _, isExist := sessions.lastSeen[sid]; !isExist {
+		return false
+	}
+	sessions.lastSeen[sid] = time.Now()
+	return true
+}
+
+func (sessions *qbittorrentSessions) remove(sid string) {
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	delete(sessions.lastSeen, sid)
+}
+
+func writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(text))
+}
+
+// qbitAuth Without remote support the api is not reachable from other machines, like the rest of api
+func (server *Server) qbitAuth(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if server.config.ConnectSetting.SupportRemote {
+			cookie, err := r.Cookie(qbittorrentCookie)
+			if err != nil || !server.qbitSessions.check(cookie.Value) {
+				writeText(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+		}
+		handle(w, r, ps)
+	}
+}
+
+func (server *Server) qbitLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.FormValue("username") != server.config.ConnectSetting.AuthUsername || r.FormValue("password") != server.config.ConnectSetting.AuthPassword {
+		writeText(w, http.StatusOK, "Fails.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     qbittorrentCookie,
+		Value:    server.qbitSessions.create(),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeText(w, http.StatusOK, "Ok.")
+}
+
+func (server *Server) qbitLogout(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if cookie, err := r.Cookie(qbittorrentCookie); err == nil {
+		server.qbitSessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: qbittorrentCookie, Value: "", Path: "/", MaxAge: -1})
+	writeText(w, http.StatusOK, "")
+}
+
+func qbitVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeText(w, http.StatusOK, qbittorrentVersion)
+}
+
+func qbitAPIVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeText(w, http.StatusOK, qbittorrentAPIVersion)
+}
+
+func (server *Server) qbitDefaultSavePath(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeText(w, http.StatusOK, server.config.Current().TorrentConfig.DataDir)
+}
+
+// Preferences read by *arr apps to check seeding limits and queueing
+func (server *Server) qbitPreferences(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	config := server.config.Current()
+	torrentConfig := config.TorrentConfig
+	server.WriteResponse(w, JsonFormat{
+		"save_path":                torrentConfig.DataDir,
+		"temp_path":                config.EngineSetting.Tmpdir,
+		"listen_port":              torrentConfig.ListenPort,
+		"dht":                      !torrentConfig.NoDHT,
+		"pex":                      !torrentConfig.DisablePEX,
+		"queueing_enabled":         true,
+		"max_active_downloads":     config.EngineSetting.MaxActiveTorrents,
+		"max_active_torrents":      config.EngineSetting.MaxActiveTorrents,
+		"max_ratio_enabled":        false,
+		"max_ratio":                -1,
+		"max_seeding_time_enabled": false,
+		"max_seeding_time":         -1,
+		"web_ui_username":          server.config.ConnectSetting.AuthUsername,
+	})
+}
+
+func (server *Server) qbitState(detail engine.TorrentDetail) string {
+	done := detail.HasInfo && detail.BytesCompleted == detail.TotalLength
+	switch detail.Status {
+	case engine.AnalysingStatus:
+		return "metaDL"
+	case engine.QueuedStatus:
+		return "queuedDL"
+	case engine.RunningStatus:
+		if done {
+			return "uploading"
+		}
+		if detail.DownloadRate == 0 {
+			return "stalledDL"
+		}
+		return "downloading"
+	case engine.CompletedStatus:
+		if detail.InClient && server.config.Current().TorrentConfig.Seed {
+			return "uploading"
+		}
+		return "pausedUP"
+	}
+	if done {
+		return "pausedUP"
+	}
+	return "pausedDL"
+}
+
+func qbitProgress(detail engine.TorrentDetail) float64 {
+	if detail.TotalLength == 0 {
+		return 0
+	}
+	return float64(detail.BytesCompleted) / float64(detail.TotalLength)
+}
+
+func qbitETA(detail engine.TorrentDetail) int64 {
+	left := detail.TotalLength - detail.BytesCompleted
+	if detail.HasInfo && left == 0 {
+		return 0
+	}
+	if detail.DownloadRate <= 0 || !detail.HasInfo {
+		return qbittorrentInfiniteETA
+	}
+	return int64(float64(left) / detail.DownloadRate)
+}
+
+func (server *Server) qbitTorrentInfo(detail engine.TorrentDetail, index int) JsonFormat {
+	return JsonFormat{
+		"hash":           detail.HexString,
+		"name":           detail.Name,
+		"size":           detail.TotalLength,
+		"total_size":     detail.TotalLength,
+		"progress":       qbitProgress(detail),
+		"dlspeed":        int64(detail.DownloadRate),
+		"upspeed":        int64(detail.UploadRate),
+		"priority":       index + 1,
+		"num_seeds":      detail.ConnectedSeeders,
+		"num_leechs":     detail.ActivePeers - detail.ConnectedSeeders,
+		"num_complete":   detail.ConnectedSeeders,
+		"num_incomplete": detail.TotalPeers - detail.ConnectedSeeders,
+		"ratio":          detail.Ratio(),
+		"eta":            qbitETA(detail),
+		"state":          server.qbitState(detail),
+		"category":       detail.Category,
+		"tags":           strings.Join(detail.Tags, ","),
+		"save_path":      detail.StoragePath,
+		"content_path":   filepath.Join(detail.StoragePath, detail.Name),
+		"amount_left":    detail.TotalLength - detail.BytesCompleted,
+		"completed":      detail.BytesCompleted,
+		"downloaded":     detail.BytesDownloaded,
+		"uploaded":       detail.BytesUploaded,
+		"magnet_uri":     detail.MagnetLink,
+		"added_on":       unixTime(detail.AddedTime),
+		"completion_on":  qbitTime(detail.CompletedTime),
+		"last_activity":  unixTime(detail.LastActivity),
+		"seen_complete":  unixTime(detail.LastSeenComplete),
+		"time_active":    detail.ActiveSeconds,
+		"seeding_time":   detail.SeedingSeconds,
+		"dl_limit":       -1,
+		"up_limit":       -1,
+		"max_ratio":      -1,
+		"ratio_limit":    -2,
+		"seq_dl":         false,
+		"force_start":    false,
+		"super_seeding":  false,
+		"auto_tmm":       false,
+		"tracker":        "",
+	}
+}
+
+// qbitHashes "all" or hashes joined by |
+func qbitHashes(value string) (hashes map[string]bool, all bool) {
+	if value == "all" {
+		return nil, true
+	}
+	hashes = make(map[string]bool)
+	for _, hash := range strings.Split(value, "|") {
+		if hash != "" {
+			hashes[strings.ToLower(hash)] = true
+		}
+	}
+	return
+}
+
+func (server *Server) qbitFilter(filter string, detail engine.TorrentDetail) bool {
+	state := server.qbitState(detail)
+	switch filter {
+	case "downloading":
+		return strings.HasSuffix(state, "DL") || state == "downloading"
+	case "seeding":
+		return state == "uploading"
+	case "completed":
+		return detail.HasInfo && detail.BytesCompleted == detail.TotalLength
+	case "paused", "stopped":
+		return strings.HasPrefix(state, "paused")
+	case "resumed", "running":
+		return !strings.HasPrefix(state, "paused")
+	case "active":
+		return detail.DownloadRate > 0 || detail.UploadRate > 0
+	case "inactive":
+		return detail.DownloadRate == 0 && detail.UploadRate == 0
+	case "stalled":
+		return state == "stalledDL"
+	}
+	return true
+}
+
+func lessJSONValue(a interface{}, b interface{}) bool {
+	switch value := a.(type) {
+	case int:
+		other, _ := b.(int)
+		return value < other
+	case int64:
+		other, _ := b.(int64)
+		return value < other
+	case float64:
+		other, _ := b.(float64)
+		return value < other
+	case string:
+		other, _ := b.(string)
+		return value < other
+	}
+	return false
+}
+
+// qBittorrent answers -1 for times not happened
+func qbitTime(t time.Time) int64 {
+	if t.IsZero() {
+		return -1
+	}
+	return t.Unix()
+}
+
+// tags are separated by comma in qBittorrent
+func qbitTags(value string) (tags []string) {
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return
+}
+
+func qbitHasTag(tags []string, tag string) bool {
+	for _, singleTag := range tags {
+		if singleTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *Server) qbitTorrentsInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	filter := r.FormValue("filter")
+	_, hasCategory := r.Form["category"]
+	category := r.FormValue("category")
+	_, hasTag := r.Form["tag"]
+	tag := r.FormValue("tag")
+	hashes, all := qbitHashes(r.FormValue("hashes"))
+	if r.FormValue("hashes") == "" {
+		all = true
+	}
+
+	torrents := []JsonFormat{}
+	for index, detail := range server.engine.GetTorrentDetails() {
+		if !all && !hashes[detail.HexString] {
+			continue
+		}
+		if hasCategory && detail.Category != category {
+			continue
+		}
+		// "" asks for torrents without tags
+		if hasTag && (tag == "" && len(detail.Tags) > 0 || tag != "" && !qbitHasTag(detail.Tags, tag)) {
+			continue
+		}
+		if !server.qbitFilter(filter, detail) {
+			continue
+		}
+		torrents = append(torrents, server.qbitTorrentInfo(detail, index))
+	}
+
+	if sortKey := r.FormValue("sort"); sortKey != "" {
+		reverse := r.FormValue("reverse") == "true"
+		sort.SliceStable(torrents, func(i, j int) bool {
+			if reverse {
+				return lessJSONValue(torrents[j][sortKey], torrents[i][sortKey])
+			}
+			return lessJSONValue(torrents[i][sortKey], torrents[j][sortKey])
+		})
+	}
+	if offset, err := strconv.Atoi(r.FormValue("offset")); err == nil {
+		if offset < 0 {
+			offset += len(torrents)
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		if offset > len(torrents) {
+			offset = len(torrents)
+		}
+		torrents = torrents[offset:]
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 && limit < len(torrents) {
+		torrents = torrents[:limit]
+	}
+	server.WriteResponse(w, torrents)
+}
+
+// torrents/add takes urls separated by new lines and files in field "torrents"
+func (server *Server) qbitTorrentsAdd(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && err != http.ErrNotMultipart {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to parse form")
+		writeText(w, http.StatusBadRequest, "Fails.")
+		return
+	}
+	options := engine.AddOptions{
+		Category:    r.FormValue("category"),
+		StoragePath: r.FormValue("savepath"),
+		Tags:        qbitTags(r.FormValue("tags")),
+		Paused:      r.FormValue("paused") == "true" || r.FormValue("stopped") == "true",
+	}
+
+	added, failed := 0, 0
+	for _, link := range strings.Split(r.FormValue("urls"), "\n") {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if _, err := server.engine.AddOneTorrentFromURL(link, options); err != nil {
+			server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent from url")
+			failed++
+			continue
+		}
+		added++
+	}
+	if r.MultipartForm != nil {
+		for _, fileHeader := range r.MultipartForm.File["torrents"] {
+			if err := server.qbitAddFile(fileHeader, options); err != nil {
+				server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent")
+				failed++
+				continue
+			}
+			added++
+		}
+	}
+
+	if added == 0 {
+		if failed == 0 {
+			writeText(w, http.StatusBadRequest, "Fails.")
+		} else {
+			writeText(w, http.StatusUnsupportedMediaType, "Fails.")
+		}
+		return
+	}
+	writeText(w, http.StatusOK, "Ok.")
+}
+
+func (server *Server) qbitAddFile(fileHeader *multipart.FileHeader, options engine.AddOptions) error {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	filePathAbs, err := server.saveTorrentFile(file, fileHeader.Filename)
+	if err != nil {
+		return err
+	}
+	tmpTorrent, err := server.engine.AddOneTorrentFromFile(filePathAbs, options)
+	if err != nil || tmpTorrent == nil {
+		return err
+	}
+	if !options.Paused {
+		server.engine.GenerateInfoFromTorrent(tmpTorrent)
+		server.engine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
+	}
+	return nil
+}
+
+func (server *Server) qbitForEach(r *http.Request, action func(detail engine.TorrentDetail)) {
+	hashes, all := qbitHashes(r.FormValue("hashes"))
+	for _, detail := range server.engine.GetTorrentDetails() {
+		if all || hashes[detail.HexString] {
+			action(detail)
+		}
+	}
+}
+
+func (server *Server) qbitPause(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.qbitForEach(r, func(detail engine.TorrentDetail) {
+		server.engine.StopOneTorrent(detail.HexString)
+	})
+	writeText(w, http.StatusOK, "")
+}
+
+func (server *Server) qbitResume(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.qbitForEach(r, func(detail engine.TorrentDetail) {
+		server.engine.StartDownloadTorrent(detail.HexString)
+	})
+	writeText(w, http.StatusOK, "")
+}
+
+func (server *Server) qbitDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	deleteFiles := r.FormValue("deleteFiles") == "true"
+	server.qbitForEach(r, func(detail engine.TorrentDetail) {
+		server.engine.DelOneTorrent(detail.HexString, deleteFiles)
+	})
+	writeText(w, http.StatusOK, "")
+}
+
+func (server *Server) qbitProperties(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.engine.GetTorrentDetail(strings.ToLower(r.FormValue("hash")))
+	if !isExist {
+		writeText(w, http.StatusNotFound, "Torrent hash was not found")
+		return
+	}
+	server.WriteResponse(w, JsonFormat{
+		"save_path":                detail.StoragePath,
+		"total_size":               detail.TotalLength,
+		"piece_size":               detail.PieceLength,
+		"pieces_num":               detail.PieceCount,
+		"pieces_have":              detail.PiecesComplete,
+		"total_downloaded":         detail.BytesDownloaded,
+		"total_downloaded_session": detail.BytesDownloaded,
+		"total_uploaded":           detail.BytesUploaded,
+		"total_uploaded_session":   detail.BytesUploaded,
+		"total_wasted":             0,
+		"dl_speed":                 int64(detail.DownloadRate),
+		"up_speed":                 int64(detail.UploadRate),
+		"dl_speed_avg":             int64(detail.DownloadRate),
+		"up_speed_avg":             int64(detail.UploadRate),
+		"dl_limit":                 -1,
+		"up_limit":                 -1,
+		"eta":                      qbitETA(detail),
+		"share_ratio":              detail.Ratio(),
+		"nb_connections":           detail.ActivePeers,
+		"nb_connections_limit":     server.config.Current().EngineSetting.MaxEstablishedConns,
+		"peers":                    detail.ActivePeers - detail.ConnectedSeeders,
+		"peers_total":              detail.TotalPeers,
+		"seeds":                    detail.ConnectedSeeders,
+		"seeds_total":              detail.ConnectedSeeders,
+		"addition_date":            unixTime(detail.AddedTime),
+		"completion_date":          qbitTime(detail.CompletedTime),
+		"creation_date":            0,
+		"time_elapsed":             detail.ActiveSeconds,
+		"seeding_time":             detail.SeedingSeconds,
+		"last_seen":                qbitTime(detail.LastSeenComplete),
+		"reannounce":               0,
+		"comment":                  "",
+		"created_by":               "",
+	})
+}
+
+func qbitFilePriority(priority types.PiecePriority) int {
+	switch {
+	case priority == types.PiecePriorityNone:
+		return 0
+	case priority == types.PiecePriorityNormal:
+		return 1
+	case priority == types.PiecePriorityHigh:
+		return 6
+	}
+	return 7
+}
+
+func (server *Server) qbitFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	detail, isExist := server.engine.GetTorrentDetail(strings.ToLower(r.FormValue("hash")))
+	if !isExist {
+		writeText(w, http.StatusNotFound, "Torrent hash was not found")
+		return
+	}
+	files := []JsonFormat{}
+	for index, file := range detail.Files {
+		progress := 1.0
+		if file.Length > 0 {
+			progress = float64(file.BytesCompleted) / float64(file.Length)
+		}
+		files = append(files, JsonFormat{
+			"index":    index,
+			"name":     file.Path,
+			"size":     file.Length,
+			"progress": progress,
+			"priority": qbitFilePriority(file.Priority),
+			"is_seed":  progress == 1,
+		})
+	}
+	server.WriteResponse(w, files)
+}
+
+// Categories are those of tasks, plus those created by clients
+func (server *Server) qbitCategoriesList(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	categories := JsonFormat{}
+	server.qbitCategoriesLock.Lock()
+	for name, savePath := range server.qbitCategories {
+		categories[name] = JsonFormat{"name": name, "savePath": savePath}
+	}
+	server.qbitCategoriesLock.Unlock()
+	for _, detail := range server.engine.GetTorrentDetails() {
+		if _, isExist := categories[detail.Category]; detail.Category != "" && !isExist {
+			categories[detail.Category] = JsonFormat{"name": detail.Category, "savePath": ""}
+		}
+	}
+	server.WriteResponse(w, categories)
+}
+
+func (server *Server) qbitCreateCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := strings.TrimSpace(r.FormValue("category"))
+	if name == "" {
+		writeText(w, http.StatusBadRequest, "Invalid category name")
+		return
+	}
+	server.qbitCategoriesLock.Lock()
+	server.qbitCategories[name] = r.FormValue("savePath")
+	server.qbitCategoriesLock.Unlock()
+	writeText(w, http.StatusOK, "")
+}
+
+func (server *Server) qbitTransferInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stats := server.engine.GetEngineStats()
+	connectionStatus := "connected"
+	if stats.ActivePeers == 0 {
+		connectionStatus = "firewalled"
+	}
+	server.WriteResponse(w, JsonFormat{
+		"dl_info_speed":     int64(stats.DownloadRate),
+		"dl_info_data":      stats.BytesDownloaded,
+		"up_info_speed":     int64(stats.UploadRate),
+		"up_info_data":      stats.BytesUploaded,
+		"dl_rate_limit":     qbitRateLimit(server.config.Current().TorrentConfig.DownloadRateLimiter),
+		"up_rate_limit":     qbitRateLimit(server.config.Current().TorrentConfig.UploadRateLimiter),
+		"dht_nodes":         stats.DHTNodes,
+		"connection_status": connectionStatus,
+	})
+}
+
+// qbitRateLimit Bytes per second, 0 if there is no limit
+func qbitRateLimit(limiter *rate.Limiter) int64 {
+	if limiter == nil || limiter.Limit() == rate.Inf {
+		return 0
+	}
+	return int64(limiter.Limit())
+}
+
+func (server *Server) handleQBittorrent(router *httprouter.Router) {
+	router.POST(qbittorrentPrefix+"auth/login", server.qbitLogin)
+	router.POST(qbittorrentPrefix+"auth/logout", server.qbitLogout)
+
+	router.GET(qbittorrentPrefix+"app/version", server.qbitAuth(qbitVersion))
+	router.GET(qbittorrentPrefix+"app/webapiVersion", server.qbitAuth(qbitAPIVersion))
+	router.GET(qbittorrentPrefix+"app/defaultSavePath", server.qbitAuth(server.qbitDefaultSavePath))
+	router.GET(qbittorrentPrefix+"app/preferences", server.qbitAuth(server.qbitPreferences))
+	router.GET(qbittorrentPrefix+"transfer/info", server.qbitAuth(server.qbitTransferInfo))
+
+	router.GET(qbittorrentPrefix+"torrents/info", server.qbitAuth(server.qbitTorrentsInfo))
+	router.POST(qbittorrentPrefix+"torrents/info", server.qbitAuth(server.qbitTorrentsInfo))
+	router.GET(qbittorrentPrefix+"torrents/properties", server.qbitAuth(server.qbitProperties))
+	router.GET(qbittorrentPrefix+"torrents/files", server.qbitAuth(server.qbitFiles))
+	router.GET(qbittorrentPrefix+"torrents/categories", server.qbitAuth(server.qbitCategoriesList))
+	router.POST(qbittorrentPrefix+"torrents/createCategory", server.qbitAuth(server.qbitCreateCategory))
+	router.POST(qbittorrentPrefix+"torrents/add", server.qbitAuth(server.qbitTorrentsAdd))
+	router.POST(qbittorrentPrefix+"torrents/delete", server.qbitAuth(server.qbitDelete))
+	// qBittorrent 5 renamed pause and resume to stop and start
+	router.POST(qbittorrentPrefix+"torrents/pause", server.qbitAuth(server.qbitPause))
+	router.POST(qbittorrentPrefix+"torrents/stop", server.qbitAuth(server.qbitPause))
+	router.POST(qbittorrentPrefix+"torrents/resume", server.qbitAuth(server.qbitResume))
+	router.POST(qbittorrentPrefix+"torrents/start", server.qbitAuth(server.qbitResume))
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newQbitClient Keeps the SID cookie like qBittorrent clients do
+func newQbitClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+func qbitRequest(t *testing.T, client *http.Client, request *http.Request) (int, string) {
+	t.Helper()
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func qbitGet(t *testing.T, client *http.Client, apiURL string) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qbitRequest(t, client, request)
+}
+
+func qbitPost(t *testing.T, client *http.Client, apiURL string, form url.Values) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return qbitRequest(t, client, request)
+}
+
+func TestQbitLogin(t *testing.T) {
+	server, httpServer := newTestServer(t, testRemoteConfig)
+	client := newQbitClient(t)
+	apiURL := httpServer.URL + qbittorrentPrefix
+
+	if status, body := qbitGet(t, client, apiURL+"app/version"); status != http.StatusForbidden {
+		t.Fatalf("without login: status %d, body %q", status, body)
+	}
+	if _, body := qbitPost(t, client, apiURL+"auth/login", url.Values{"username": {"admin"}, "password": {"wrong"}}); body != "Fails." {
+		t.Fatalf("login with wrong password %q", body)
+	}
+	if status, _ := qbitGet(t, client, apiURL+"app/version"); status != http.StatusForbidden {
+		t.Fatalf("status %d after failed login", status)
+	}
+	if _, body := qbitPost(t, client, apiURL+"auth/login", url.Values{"username": {"admin"}, "password": {"secret"}}); body != "Ok." {
+		t.Fatalf("login %q", body)
+	}
+	if status, body := qbitGet(t, client, apiURL+"app/version"); status != http.StatusOK || body != qbittorrentVersion {
+		t.Fatalf("after login: status %d, body %q", status, body)
+	}
+
+	// sessions unused for longer than their ttl are gone
+	server.qbitSessions.lock.Lock()
+	for sid := range server.qbitSessions.lastSeen {
+		server.qbitSessions.lastSeen[sid] = time.Now().Add(-qbittorrentSessionTTL - time.Minute)
+	}
+	server.qbitSessions.lock.Unlock()
+	if status, _ := qbitGet(t, client, apiURL+"app/version"); status != http.StatusForbidden {
+		t.Fatalf("status %d with expired session", status)
+	}
+
+	qbitPost(t, client, apiURL+"auth/login", url.Values{"username": {"admin"}, "password": {"secret"}})
+	qbitPost(t, client, apiURL+"auth/logout", nil)
+	if status, _ := qbitGet(t, client, apiURL+"app/version"); status != http.StatusForbidden {
+		t.Fatalf("status %d after logout", status)
+	}
+}
+
+func TestQbitRoundTrip(t *testing.T) {
+	_, httpServer := newTestServer(t, testConfig)
+	client := newQbitClient(t)
+	apiURL := httpServer.URL + qbittorrentPrefix
+	data, hexString := testTorrent(t, "qbittorrent")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("category", "tv")
+	part, err := writer.CreateFormFile("torrents", "qbittorrent.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(data)
+	_ = writer.Close()
+	request, err := http.NewRequest(http.MethodPost, apiURL+"torrents/add", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	if status, text := qbitRequest(t, client, request); status != http.StatusOK || text != "Ok." {
+		t.Fatalf("add: status %d, body %q", status, text)
+	}
+
+	state := func() string {
+		t.Helper()
+		_, text := qbitGet(t, client, apiURL+"torrents/info?hashes="+hexString)
+		var torrents []struct {
+			Hash     string `json:"hash"`
+			State    string `json:"state"`
+			Category string `json:"category"`
+		}
+		if err := json.Unmarshal([]byte(text), &torrents); err != nil {
+			t.Fatalf("torrents/info %q: %v", text, err)
+		}
+		if len(torrents) == 0 {
+			return ""
+		}
+		if torrents[0].Hash != hexString || torrents[0].Category != "tv" {
+			t.Fatalf("torrents/info %+v", torrents[0])
+		}
+		return torrents[0].State
+	}
+	if got := state(); got != "stalledDL" {
+		t.Fatalf("state after add %q", got)
+	}
+	qbitPost(t, client, apiURL+"torrents/pause", url.Values{"hashes": {hexString}})
+	if got := state(); got != "pausedDL" {
+		t.Fatalf("state after pause %q", got)
+	}
+	qbitPost(t, client, apiURL+"torrents/start", url.Values{"hashes": {"all"}})
+	if got := state(); got != "stalledDL" {
+		t.Fatalf("state after start %q", got)
+	}
+	qbitPost(t, client, apiURL+"torrents/delete", url.Values{"hashes": {hexString}, "deleteFiles": {"true"}})
+	if got := state(); got != "" {
+		t.Fatalf("state after delete %q", got)
+	}
+}
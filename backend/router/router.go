@@ -1,45 +1,144 @@
 package router
 
 import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/anatasluo/ant/backend/engine"
 	"github.com/anatasluo/ant/backend/setting"
+	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 )
 
-var (
-	clientConfig  = setting.GetClientSetting()
-	runningEngine *engine.Engine
-	logger        = clientConfig.LoggerSetting.Logger
-)
+// Server Every api of one engine, state of compatible apis like sessions lives here too,
+// so several servers for different engines can be mounted in one process
+type Server struct {
+	engine         *engine.Engine
+	config         *setting.ClientSetting
+	logger         *log.Logger
+	handler        http.Handler
+	requestLatency *requestMetrics
+
+	// Set while new settings are applied, other requests to apply are refused meanwhile
+	restarting int32
+
+	qbitSessions qbittorrentSessions
+	// Categories created by clients which no task uses yet, they are lost on restart
+	qbitCategories     map[string]string
+	qbitCategoriesLock sync.Mutex
+
+	transmissionSessionID string
+	torrentIDs            transmissionIDs
 
-func InitRouter() *negroni.Negroni {
-	runningEngine = engine.GetEngine()
+	aria2SessionID string
+
+	// Done when websockets and player streams are to be closed
+	streams      context.Context
+	closeStreams context.CancelFunc
+}
+
+// New Create the http handler for a running engine, settings are taken from the engine
+func New(runningEngine *engine.Engine) *Server {
+	config := runningEngine.Config()
+	server := &Server{
+		engine:         runningEngine,
+		config:         config,
+		logger:         config.LoggerSetting.Logger,
+		qbitSessions:   qbittorrentSessions{lastSeen: make(map[string]time.Time), logger: config.LoggerSetting.Logger},
+		qbitCategories: make(map[string]string),
+		torrentIDs:     transmissionIDs{byHash: make(map[string]int), nextID: 1},
+	}
+	server.streams, server.closeStreams = context.WithCancel(context.Background())
 	router := httprouter.New()
 
 	// Enable router
-	handleTorrent(router)
-	handleMagnet(router)
-	handleWS(router)
-	handlePlayer(router)
-	handleSetting(router)
+	server.handleTorrent(router)
+	server.handleMagnet(router)
+	server.handleWS(router)
+	server.handlePlayer(router)
+	server.handleSetting(router)
+	server.handleRSS(router)
+	server.handleSearch(router)
+	server.handleTransmission(router)
+	server.handleQBittorrent(router)
+	server.handleAria2(router)
+	server.handleAPIV1(router)
+	if config.MetricsSetting.EnableMetrics {
+		server.handleMetrics(router)
+		server.requestLatency = newRequestMetrics(router)
+	}
+	if config.WebUISetting.EnableWebUI {
+		server.handleWebUI(router)
+	}
 
 	// Use global middleware
 	n := negroni.New()
 
-	//Enable cors
-	c := cors.AllowAll()
+	//Enable cors, paging headers of lists are readable by web client too
+	c := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"X-Total-Count", "X-Next-Cursor"},
+	})
 	n.Use(c)
 
-	//Enable auth
-	//auth := setting.Auth{Username : clientConfig.ConnectSetting.AuthUsername, Password : clientConfig.ConnectSetting.AuthPassword}
-	//auth.Hash()
-	//n.Use(auth)
+	//Enable auth for remote control
+	if config.ConnectSetting.SupportRemote {
+		auth := setting.Auth{Username: config.ConnectSetting.AuthUsername, Password: config.ConnectSetting.AuthPassword, ExemptPrefixes: []string{qbittorrentPrefix, aria2Path}}
+		auth.Hash()
+		n.Use(auth)
+	}
 
 	n.Use(negroni.NewLogger())
 
+	if server.requestLatency != nil {
+		n.Use(server.requestLatency)
+	}
+
 	n.UseHandler(router)
+	server.handler = n
+
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.handler.ServeHTTP(w, r)
+}
+
+// CloseStreams Close websockets and player streams. http.Server.Shutdown does not wait for
+// hijacked websockets and would wait for streams until its timeout, register it with RegisterOnShutdown
+func (server *Server) CloseStreams() {
+	server.closeStreams()
+}
+
+// streamContext Done when client goes away or streams are closed
+func (server *Server) streamContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-server.streams.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
 
-	return n
+// closeWebsocket Close conn when ctx is done, so blocked reads of handler return.
+// Clients are told the reason if streams are closed
+func (server *Server) closeWebsocket(ctx context.Context, conn *websocket.Conn) {
+	go func() {
+		<-ctx.Done()
+		if server.streams.Err() != nil {
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		}
+		_ = conn.Close()
+	}()
 }
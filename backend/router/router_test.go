@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/anatasluo/ant/backend/setting"
+)
+
+// testConfig Engine without network services, lists to download or port mapping
+const testConfig = `[enginesetting]
+  disableipv6 = true
+  defaultipblocklist = ""
+  defaulttrackerlist = ""
+  enabledefaulttrackers = false
+  torrentcaches = []
+[loggersetting]
+  loggingoutput = "stdout"
+  logginglevel = 2
+[portmappingsetting]
+  enableportmapping = false
+[rsssetting]
+  enablerss = false
+[torrentconfig]
+  nodht = true
+  disableutp = true
+  listenport = 0
+`
+
+// testRemoteConfig Remote control on, compatible apis check their own credentials
+const testRemoteConfig = testConfig + `[connectsetting]
+  supportremote = true
+  authusername = "admin"
+  authpassword = "secret"
+`
+
+// newTestServer Serve the api of a new engine configured by config
+func newTestServer(t *testing.T, config string) (*Server, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientSetting, err := setting.New(setting.PathSetting{ConfigFile: configFile, DataDir: dir, StateDir: dir, LogDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runningEngine, err := engine.New(clientSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := New(runningEngine)
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.CloseStreams()
+		httpServer.Close()
+		_ = runningEngine.Cleanup()
+	})
+	return server, httpServer
+}
+
+// testTorrent A torrent file of one piece, whose data is never found
+func testTorrent(t *testing.T, name string) (data []byte, hexString string) {
+	t.Helper()
+	infoBytes, err := bencode.Marshal(metainfo.Info{
+		Name:        name,
+		Length:      1 << 14,
+		PieceLength: 1 << 14,
+		Pieces:      make([]byte, 20),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrentMetaInfo := metainfo.MetaInfo{InfoBytes: infoBytes}
+	data, err = bencode.Marshal(torrentMetaInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, torrentMetaInfo.HashInfoBytes().HexString()
+}
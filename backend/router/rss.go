@@ -0,0 +1,144 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+type rssTestRequest struct {
+	Rule    engine.RSSRule
+	FeedURL string
+	FeedID  int
+}
+
+func (server *Server) getRSSFeeds(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	feeds, err := server.engine.RSS.GetFeeds()
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get rss feeds")
+	}
+	server.WriteResponse(w, feeds)
+}
+
+func (server *Server) saveRSSFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var feed engine.RSSFeed
+	isSaved := false
+	err := json.NewDecoder(r.Body).Decode(&feed)
+	if err == nil {
+		err = server.engine.RSS.SaveFeed(&feed)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to save rss feed")
+	} else {
+		isSaved = true
+	}
+	server.WriteResponse(w, JsonFormat{
+		"IsSaved": isSaved,
+		"Feed":    feed,
+	})
+}
+
+func (server *Server) delRSSFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	feedID, err := strconv.Atoi(r.FormValue("id"))
+	if err == nil {
+		err = server.engine.RSS.DelFeed(feedID)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to delete rss feed")
+	}
+	server.WriteResponse(w, JsonFormat{
+		"IsDeleted": err == nil,
+	})
+}
+
+func (server *Server) refreshRSSFeeds(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	go server.engine.RSS.PollAll()
+	server.WriteResponse(w, JsonFormat{
+		"IsRefreshing": true,
+	})
+}
+
+func (server *Server) getRSSRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rules, err := server.engine.RSS.GetRules()
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get rss rules")
+	}
+	server.WriteResponse(w, rules)
+}
+
+func (server *Server) saveRSSRule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var rule engine.RSSRule
+	isSaved := false
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err == nil {
+		err = server.engine.RSS.SaveRule(&rule)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to save rss rule")
+	} else {
+		isSaved = true
+	}
+	res := JsonFormat{
+		"IsSaved": isSaved,
+		"Rule":    rule,
+	}
+	if err != nil {
+		res["Error"] = err.Error()
+	}
+	server.WriteResponse(w, res)
+}
+
+func (server *Server) delRSSRule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ruleID, err := strconv.Atoi(r.FormValue("id"))
+	if err == nil {
+		err = server.engine.RSS.DelRule(ruleID)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to delete rss rule")
+	}
+	server.WriteResponse(w, JsonFormat{
+		"IsDeleted": err == nil,
+	})
+}
+
+// Match a rule against a feed, nothing will be added
+func (server *Server) testRSSRule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var testRequest rssTestRequest
+	var results []engine.RSSMatchResult
+	err := json.NewDecoder(r.Body).Decode(&testRequest)
+	if err == nil && testRequest.FeedURL == "" {
+		var feeds []engine.RSSFeed
+		feeds, err = server.engine.RSS.GetFeeds()
+		for _, feed := range feeds {
+			if feed.ID == testRequest.FeedID {
+				testRequest.FeedURL = feed.URL
+			}
+		}
+	}
+	if err == nil {
+		results, err = server.engine.RSS.TestRule(testRequest.Rule, testRequest.FeedURL)
+	}
+	res := JsonFormat{
+		"Results": results,
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to test rss rule")
+		res["Error"] = err.Error()
+	}
+	server.WriteResponse(w, res)
+}
+
+func (server *Server) handleRSS(router *httprouter.Router) {
+	router.GET("/rss/feeds", server.getRSSFeeds)
+	router.POST("/rss/feeds/save", server.saveRSSFeed)
+	router.POST("/rss/feeds/delOne", server.delRSSFeed)
+	router.POST("/rss/feeds/refresh", server.refreshRSSFeeds)
+	router.GET("/rss/rules", server.getRSSRules)
+	router.POST("/rss/rules/save", server.saveRSSRule)
+	router.POST("/rss/rules/delOne", server.delRSSRule)
+	router.POST("/rss/rules/test", server.testRSSRule)
+}
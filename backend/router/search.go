@@ -0,0 +1,49 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+func (server *Server) searchTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.FormValue("q")
+	if query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	results, indexerErrors := server.engine.SearchIndexers(r.Context(), query, r.FormValue("cat"))
+	server.WriteResponse(w, JsonFormat{
+		"Results": results,
+		"Errors":  indexerErrors,
+	})
+}
+
+// Add a search result, magnet is preferred to the torrent url of indexer
+func (server *Server) addSearchResult(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	linkAddress := r.FormValue("magnetURI")
+	if linkAddress == "" {
+		linkAddress = r.FormValue("torrentURL")
+	}
+	server.logger.Infof("add search result request, address: %s", linkAddress)
+	_, err := server.engine.AddOneTorrentFromURL(linkAddress, engine.AddOptions{})
+
+	var isAdded bool
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a search result")
+		isAdded = false
+	} else {
+		isAdded = true
+	}
+
+	server.WriteResponse(w, JsonFormat{
+		"IsAdded": isAdded,
+	})
+}
+
+func (server *Server) handleSearch(router *httprouter.Router) {
+	router.GET("/search", server.searchTorrents)
+	router.POST("/search/add", server.addSearchResult)
+}
@@ -1,53 +1,113 @@
 package router
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/anatasluo/ant/backend/engine"
 	"github.com/anatasluo/ant/backend/setting"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
-func getSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	WriteResponse(w, clientConfig.GetWebSetting())
+// Longest time a proxy test takes, all steps included
+const proxyTestTimeout = 30 * time.Second
+
+func (server *Server) getSetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.WriteResponse(w, server.config.GetWebSetting())
+}
+
+func (server *Server) getStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.engine.Client().WriteStatus(w)
 }
 
-func getStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	runningEngine.TorrentEngine.WriteStatus(w)
+func (server *Server) getStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.WriteResponse(w, server.engine.GetEngineStats())
 }
 
-func getRunningQueue(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) getRunningQueue(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var tmp engine.TorrentLogsAndID
-	runningEngine.TorrentDB.GetLogs(&tmp)
-	WriteResponse(w, tmp)
+	server.engine.TorrentDB.GetLogs(&tmp)
+	server.WriteResponse(w, tmp)
 }
 
-func applySetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) getSettingSchema(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.WriteResponse(w, setting.WebSettingSchema())
+}
+
+// applySetting Fields missing in request keep their values. Settings which can be changed on
+// running client take effect at once, the others restart torrent client or wait for next start.
+// Applied lists changed settings in effect, Pending the ones which are saved but not in effect yet
+func (server *Server) applySetting(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	decoder := json.NewDecoder(r.Body)
-	isApplied := false
-	var newSettings setting.WebSetting
+	newSettings := server.config.GetWebSetting()
 	err := decoder.Decode(&newSettings)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("Failed to get new settings")
-	}else{
-		if runningEngine.EngineRunningInfo.HasRestarted == false {
-			runningEngine.EngineRunningInfo.HasRestarted = true
-			clientConfig.UpdateConfig(newSettings)
-			logger.WithFields(log.Fields{"Settings": newSettings}).Info("Setting update")
-			isApplied = true
-			runningEngine.Restart()
-			runningEngine.EngineRunningInfo.HasRestarted = false
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Failed to get new settings")
+		server.WriteResponse(w, JsonFormat{
+			"IsApplied": false,
+		})
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&server.restarting, 0, 1) {
+		server.WriteResponse(w, JsonFormat{
+			"IsApplied": false,
+			"Error":     "settings are being applied",
+		})
+		return
+	}
+	defer atomic.StoreInt32(&server.restarting, 0)
+
+	changes, err := server.config.UpdateConfig(newSettings)
+	if validationErrors, isInvalid := err.(setting.ValidationErrors); isInvalid {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		server.WriteResponse(w, JsonFormat{
+			"IsApplied": false,
+			"Errors":    validationErrors,
+		})
+		return
+	} else if err != nil {
+		server.WriteResponse(w, JsonFormat{
+			"IsApplied": false,
+			"Error":     err.Error(),
+		})
+		return
+	}
+	server.logger.WithFields(log.Fields{"Live": changes.Live, "Restart": changes.Restart, "Start": changes.Start}).Info("Setting update")
+	server.engine.ApplyLiveSettings()
+	applied, pending := append([]string{}, changes.Live...), append([]string{}, changes.Start...)
+	response := JsonFormat{
+		"IsApplied": true,
+	}
+	if len(changes.Restart) > 0 {
+		if err := server.engine.RestartClient(); err != nil {
+			pending = append(pending, changes.Restart...)
+			response["Error"] = err.Error()
+		} else {
+			applied = append(applied, changes.Restart...)
 		}
 	}
-	WriteResponse(w, JsonFormat{
-		"IsApplied":isApplied,
-	})
+	response["Applied"] = applied
+	response["Pending"] = pending
+	server.WriteResponse(w, response)
+}
+
+// testProxy Check proxy of current settings, url is the target to reach through it, the default trackers list if empty
+func (server *Server) testProxy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx, cancel := context.WithTimeout(r.Context(), proxyTestTimeout)
+	defer cancel()
+	server.WriteResponse(w, server.engine.TestProxy(ctx, r.FormValue("url")))
 }
 
-func handleSetting(router *httprouter.Router)  {
-	router.GET("/settings/config", getSetting)
-	router.GET("/settings/status", getStatus)
-	router.GET("/settings/queue", getRunningQueue)
-	router.POST("/settings/apply", applySetting)
+func (server *Server) handleSetting(router *httprouter.Router)  {
+	router.GET("/settings/config", server.getSetting)
+	router.GET("/settings/schema", server.getSettingSchema)
+	router.GET("/settings/status", server.getStatus)
+	router.GET("/settings/stats", server.getStats)
+	router.GET("/settings/queue", server.getRunningQueue)
+	router.POST("/settings/apply", server.applySetting)
+	router.GET("/settings/proxy/test", server.testProxy)
 }
\ No newline at end of file
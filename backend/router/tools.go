@@ -9,19 +9,19 @@ import (
 
 type JsonFormat map[string]interface{}
 
-func hexStringToHash(hexString string) (torrentHash metainfo.Hash)  {
+func (server *Server) hexStringToHash(hexString string) (torrentHash metainfo.Hash)  {
 	torrentHash = metainfo.Hash{}
 	err := torrentHash.FromHexString(hexString)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error":err}).Error("Unable to get hash from hex string")
+		server.logger.WithFields(log.Fields{"Error":err}).Error("Unable to get hash from hex string")
 	}
 	return
 }
 
-func WriteResponse(w http.ResponseWriter, jsonStruct interface{}) {
+func (server *Server) WriteResponse(w http.ResponseWriter, jsonStruct interface{}) {
 	resInfo, err := json.Marshal(jsonStruct)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error":err}).Error("unable to format a json")
+		server.logger.WithFields(log.Fields{"Error":err}).Error("unable to format a json")
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(resInfo)
@@ -1,7 +1,10 @@
 package router
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
 	"github.com/anatasluo/ant/backend/engine"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
@@ -9,163 +12,357 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-func addOneTorrentFromFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) addOneTorrentFromFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	//Get torrent file from form
 	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("Unable to parse form")
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to parse form")
 		return
 	}
 	file, handler, err := r.FormFile("oneTorrentFile")
 
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("Unable to get file from form")
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get file from form")
 		return
 	}
 
 	defer file.Close()
 
-	filePath := filepath.Join(clientConfig.EngineSetting.Tmpdir, handler.Filename)
-	filePathAbs, _ := filepath.Abs(filePath)
-
-	f, err := os.OpenFile(filePathAbs, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer f.Close()
-
-	_, err = io.Copy(f, file)
+	filePathAbs, err := server.saveTorrentFile(file, handler.Filename)
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("Unable to copy file from form")
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to copy file from form")
 		return
 	}
 
 	//Start to add to client
-	tmpTorrent, err := runningEngine.AddOneTorrentFromFile(filePathAbs)
+	tmpTorrent, err := server.engine.AddOneTorrentFromFile(filePathAbs, engine.AddOptions{
+		Category:    r.FormValue("category"),
+		StoragePath: r.FormValue("storagePath"),
+	})
 
 	var isAdded bool
 	if err != nil {
-		logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent")
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent")
 		isAdded = false
 	} else {
 		if tmpTorrent != nil {
-			runningEngine.GenerateInfoFromTorrent(tmpTorrent)
-			runningEngine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
+			server.engine.GenerateInfoFromTorrent(tmpTorrent)
+			server.engine.StartDownloadTorrent(tmpTorrent.InfoHash().HexString())
 			isAdded = true
 		}
 	}
 
-	WriteResponse(w, JsonFormat{
+	server.WriteResponse(w, JsonFormat{
 		"IsAdded": isAdded,
 	})
 
 }
 
-func getOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// saveTorrentFile keeps an uploaded torrent file in Tmpdir, engine adds torrents from files
+func (server *Server) saveTorrentFile(file io.Reader, fileName string) (filePathAbs string, err error) {
+	filePath := filepath.Join(server.config.Current().EngineSetting.Tmpdir, filepath.Base(fileName))
+	filePathAbs, _ = filepath.Abs(filePath)
+
+	f, err := os.OpenFile(filePathAbs, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, file)
+	return
+}
+
+func (server *Server) getOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	hexString := r.FormValue("hexString")
-	singleTorrent, isExist := runningEngine.GetOneTorrent(hexString)
+	singleTorrent, isExist := server.engine.GetOneTorrent(hexString)
 	if isExist {
-		torrentWebInfo := runningEngine.GenerateInfoFromTorrent(singleTorrent)
-		WriteResponse(w, torrentWebInfo)
+		torrentWebInfo := server.engine.GenerateInfoFromTorrent(singleTorrent)
+		if torrentWebInfo == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		server.WriteResponse(w, torrentWebInfo)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-// running first, stopped then
-func appendRunningTorrents(resInfo []engine.TorrentWebInfo) []engine.TorrentWebInfo {
-	var stopped []engine.TorrentWebInfo
-	for _, singleTorrent := range runningEngine.TorrentEngine.Torrents() {
-		singleTorrentLog, isExist := runningEngine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
-		if isExist && singleTorrentLog.Status != engine.CompletedStatus {
-			switch singleTorrentLog.Status {
-			case engine.CompletedStatus:
-			default:
-				resInfo = append(resInfo, *runningEngine.GenerateInfoFromTorrent(singleTorrent))
-			}
+// torrentQuery Filter, sort and page of a list from query parameters, the same for /torrent lists and /api/v1/torrents
+func torrentQuery(r *http.Request) (query engine.TorrentQuery, err error) {
+	values := r.URL.Query()
+	query.Filter.Status = values.Get("status")
+	if category, hasCategory := values["category"]; hasCategory {
+		query.Filter.Category = &category[0]
+	}
+	query.Filter.Tag = values.Get("tag")
+	if search := values.Get("search"); search != "" {
+		query.Filter.Name = "(?i)" + regexp.QuoteMeta(search)
+	}
+	query.Sort = values.Get("sort")
+	query.Reverse = values.Get("reverse") == "true"
+	query.Cursor = values.Get("cursor")
+	if offset := values.Get("offset"); offset != "" {
+		if query.Offset, err = strconv.Atoi(offset); err != nil || query.Offset < 0 {
+			return query, errors.New("offset must be a number not less than 0")
 		}
 	}
-	sortTorrents(resInfo)
-	sortTorrents(stopped)
-	return append(resInfo, stopped...)
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+			return query, errors.New("limit must be a number not less than 0")
+		}
+	}
+	return
 }
 
-func sortTorrents(resInfo []engine.TorrentWebInfo) {
-	sort.Slice(resInfo, func(i, j int) bool {
-		return resInfo[i].HexString < resInfo[j].HexString
-	})
+// Size of whole list and cursor of next page are sent in headers, so bodies stay lists
+func writePageHeaders(w http.ResponseWriter, page engine.TorrentPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
 }
 
-func appendCompletedTorrents(resInfo []engine.TorrentWebInfo) []engine.TorrentWebInfo {
-	for _, singleTorrentLog := range runningEngine.EngineRunningInfo.TorrentLogs {
-		if singleTorrentLog.Status == engine.CompletedStatus {
-			resInfo = append(resInfo, *runningEngine.GenerateInfoFromLog(singleTorrentLog))
+// selectFields keeps only fields listed in parameter "fields" of every item, items must be a list of objects
+func selectFields(r *http.Request, items interface{}) (interface{}, error) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return items, nil
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]json.RawMessage
+	if err = json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, field := range strings.Split(fields, ",") {
+		wanted[strings.TrimSpace(field)] = true
+	}
+	for _, object := range objects {
+		for field := range object {
+			if !wanted[field] {
+				delete(object, field)
+			}
 		}
 	}
-	sortTorrents(resInfo)
-	return resInfo
+	if objects == nil {
+		objects = []map[string]json.RawMessage{}
+	}
+	return objects, nil
 }
 
-func getAllTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var resInfo []engine.TorrentWebInfo
-	resInfo = appendRunningTorrents(resInfo)
-	resInfo = appendCompletedTorrents(resInfo)
-	WriteResponse(w, resInfo)
+// torrentWebInfo Info for website of one task, tasks not loaded in client are left out unless completed
+func (server *Server) torrentWebInfo(detail engine.TorrentDetail) (*engine.TorrentWebInfo, bool) {
+	infoHash := metainfo.NewHashFromHex(detail.HexString)
+	if detail.Status == engine.CompletedStatus {
+		singleTorrentLog, isExist := server.engine.GetTorrentLog(detail.HexString)
+		if !isExist {
+			return nil, false
+		}
+		return server.engine.GenerateInfoFromLog(singleTorrentLog), true
+	}
+	singleTorrent, isExist := server.engine.Client().Torrent(infoHash)
+	if !isExist {
+		return nil, false
+	}
+	torrentWebInfo := server.engine.GenerateInfoFromTorrent(singleTorrent)
+	return torrentWebInfo, torrentWebInfo != nil
 }
 
-func getCompletedTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// Lists are sorted by hash unless asked
+func (server *Server) writeTorrentList(w http.ResponseWriter, r *http.Request, withRunning bool, withCompleted bool) {
+	query, err := torrentQuery(r)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Warn("Invalid query of torrent list")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if query.Sort == "" {
+		query.Sort = engine.SortHash
+	}
+	allQuery := query
+	allQuery.Cursor, allQuery.Offset, allQuery.Limit = "", 0, 0
+	allPage, err := server.engine.QueryTorrents(allQuery)
+
+	var details []engine.TorrentDetail
+	for _, detail := range allPage.Torrents {
+		if detail.Status == engine.CompletedStatus && withCompleted || detail.Status != engine.CompletedStatus && withRunning {
+			details = append(details, detail)
+		}
+	}
+	var page engine.TorrentPage
+	if err == nil {
+		page, err = engine.PageTorrentDetails(details, query)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Warn("Invalid query of torrent list")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	var resInfo []engine.TorrentWebInfo
-	resInfo = appendCompletedTorrents(resInfo)
-	WriteResponse(w, resInfo)
+	for _, detail := range page.Torrents {
+		if torrentWebInfo, isExist := server.torrentWebInfo(detail); isExist {
+			resInfo = append(resInfo, *torrentWebInfo)
+		}
+	}
+	resBody, err := selectFields(r, resInfo)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to select fields")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writePageHeaders(w, page)
+	server.WriteResponse(w, resBody)
 }
 
-func getAllEngineTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var resInfo []engine.TorrentWebInfo
-	resInfo = appendRunningTorrents(resInfo)
-	WriteResponse(w, resInfo)
+func (server *Server) getAllTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeTorrentList(w, r, true, true)
+}
+
+func (server *Server) getCompletedTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeTorrentList(w, r, false, true)
 }
 
-func delOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) getAllEngineTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.writeTorrentList(w, r, true, false)
+}
+
+// Files are deleted too, unless deleteFiles is "false"
+func (server *Server) delOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	hexString := r.FormValue("hexString")
-	deleted := runningEngine.DelOneTorrent(hexString)
-	WriteResponse(w, JsonFormat{
+	deleteFiles := r.FormValue("deleteFiles") != "false"
+	deleted := server.engine.DelOneTorrent(hexString, deleteFiles)
+	server.WriteResponse(w, JsonFormat{
 		"IsDeleted": deleted,
 	})
 }
 
-func stopOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) stopOneTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	hexString := r.FormValue("hexString")
-	stopped := runningEngine.StopOneTorrent(hexString)
-	WriteResponse(w, JsonFormat{
+	stopped := server.engine.StopOneTorrent(hexString)
+	server.WriteResponse(w, JsonFormat{
 		"IsStopped": stopped,
 	})
 }
 
-func startDownloadTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) startDownloadTorrent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	hexString := r.FormValue("hexString")
-	downloaded := runningEngine.StartDownloadTorrent(hexString)
-	WriteResponse(w, JsonFormat{
+	downloaded := server.engine.StartDownloadTorrent(hexString)
+	server.WriteResponse(w, JsonFormat{
 		"IsDownloading": downloaded,
 	})
 }
 
+func (server *Server) addOneTorrentFromURL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	linkAddress := r.FormValue("linkAddress")
+	server.logger.Infof("add url request, address: %s", linkAddress)
+	_, err := server.engine.AddOneTorrentFromURL(linkAddress, engine.AddOptions{
+		Category:    r.FormValue("category"),
+		StoragePath: r.FormValue("storagePath"),
+	})
+
+	var isAdded bool
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("unable to add a torrent from url")
+		isAdded = false
+	} else {
+		isAdded = true
+	}
+
+	server.WriteResponse(w, JsonFormat{
+		"IsAdded": isAdded,
+	})
+}
+
+// priority is one of the piece priorities of anacrolix/torrent, 0 means skip the file
+func (server *Server) setFilePriority(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hexString := r.FormValue("hexString")
+	fileIndex, indexErr := strconv.Atoi(r.FormValue("fileIndex"))
+	priority, priorityErr := strconv.ParseUint(r.FormValue("priority"), 10, 8)
+	changed := false
+	if indexErr == nil && priorityErr == nil && priority <= uint64(types.PiecePriorityHigh) {
+		changed = server.engine.SetFilePriority(hexString, fileIndex, types.PiecePriority(priority))
+	}
+	server.WriteResponse(w, JsonFormat{
+		"IsChanged": changed,
+	})
+}
+
+func (server *Server) getTorrentHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hexString := r.FormValue("hexString")
+	histories, err := server.engine.TorrentDB.GetHistory(hexString)
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to get torrent history")
+	}
+	server.WriteResponse(w, histories)
+}
+
+// Body is a json engine.BulkRequest, results are in the order of hashes
+func (server *Server) runBulk(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var request engine.BulkRequest
+	var results []engine.BulkResult
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == nil {
+		results, err = server.engine.RunBulk(request)
+	}
+	if err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to run bulk operation")
+		server.WriteResponse(w, JsonFormat{
+			"IsDone": false,
+			"Error":  err.Error(),
+		})
+		return
+	}
+	server.WriteResponse(w, JsonFormat{
+		"IsDone":  true,
+		"Results": results,
+	})
+}
+
+func (server *Server) startAllTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	results, _ := server.engine.StartAllTorrents()
+	server.WriteResponse(w, JsonFormat{
+		"IsDone":  true,
+		"Results": results,
+	})
+}
+
+func (server *Server) stopAllTorrents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	results, _ := server.engine.StopAllTorrents()
+	server.WriteResponse(w, JsonFormat{
+		"IsDone":  true,
+		"Results": results,
+	})
+}
+
 func test(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 }
 
-func handleTorrent(router *httprouter.Router) {
-	router.POST("/torrent/addOneFile", addOneTorrentFromFile)
-	router.POST("/torrent/getOne", getOneTorrent)
-	router.GET("/torrent/getAllEngineTorrents", getAllEngineTorrents)
-	router.GET("/torrent/getAllTorrents", getAllTorrents)
-	router.GET("/torrent/getCompletedTorrents", getCompletedTorrents)
-	router.POST("/torrent/delOne", delOneTorrent)
-	router.POST("/torrent/startDownload", startDownloadTorrent)
-	router.POST("/torrent/stopDownload", stopOneTorrent)
+func (server *Server) handleTorrent(router *httprouter.Router) {
+	router.POST("/torrent/addOneFile", server.addOneTorrentFromFile)
+	router.POST("/torrent/addOneURL", server.addOneTorrentFromURL)
+	router.POST("/torrent/setPriority", server.setFilePriority)
+	router.POST("/torrent/getOne", server.getOneTorrent)
+	router.GET("/torrent/getAllEngineTorrents", server.getAllEngineTorrents)
+	router.GET("/torrent/getAllTorrents", server.getAllTorrents)
+	router.GET("/torrent/getCompletedTorrents", server.getCompletedTorrents)
+	router.POST("/torrent/delOne", server.delOneTorrent)
+	router.POST("/torrent/startDownload", server.startDownloadTorrent)
+	router.POST("/torrent/stopDownload", server.stopOneTorrent)
+	router.GET("/torrent/history", server.getTorrentHistory)
+	router.POST("/torrent/bulk", server.runBulk)
+	router.POST("/torrent/startAll", server.startAllTorrents)
+	router.POST("/torrent/stopAll", server.stopAllTorrents)
 	router.GET("/torrent/test", test)
 }
@@ -0,0 +1,594 @@
+package router
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types"
+	"github.com/anatasluo/ant/backend/engine"
+	"github.com/anatasluo/ant/backend/setting"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Transmission RPC, enough of it for Sonarr, Radarr, transmission-remote and mobile remotes
+// https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md
+
+const (
+	transmissionSessionHeader = "X-Transmission-Session-Id"
+	transmissionVersion       = "3.00 (ANT)"
+	transmissionRPCVersion    = 17
+)
+
+// Status codes of Transmission
+const (
+	transmissionStopped      = 0
+	transmissionDownloadWait = 3
+	transmissionDownloading  = 4
+	transmissionSeeding      = 6
+)
+
+type transmissionRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       interface{}     `json:"tag,omitempty"`
+}
+
+type transmissionResponse struct {
+	Result    string      `json:"result"`
+	Arguments interface{} `json:"arguments"`
+	Tag       interface{} `json:"tag,omitempty"`
+}
+
+// Transmission refers torrents by numbers, they are given on first sight and kept until restart
+type transmissionIDs struct {
+	lock   sync.Mutex
+	byHash map[string]int
+	nextID int
+}
+
+func (ids *transmissionIDs) get(hexString string) int {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	id, isExist := ids.byHash[hexString]
+	if !isExist {
+		id = ids.nextID
+		ids.byHash[hexString] = id
+		ids.nextID++
+	}
+	return id
+}
+
+// selectTorrents ids can be absent, a number, a hash, "recently-active" or a list of numbers and hashes
+func (server *Server) selectTorrents(rawIDs json.RawMessage) (selected []engine.TorrentDetail, err error) {
+	details := server.engine.GetTorrentDetails()
+	rawIDs = bytes.TrimSpace(rawIDs)
+	if len(rawIDs) == 0 || string(rawIDs) == "null" || string(rawIDs) == `"recently-active"` {
+		return details, nil
+	}
+	var ids []interface{}
+	if rawIDs[0] == '[' {
+		err = json.Unmarshal(rawIDs, &ids)
+	} else {
+		var id interface{}
+		err = json.Unmarshal(rawIDs, &id)
+		ids = append(ids, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid ids: %v", err)
+	}
+	wanted := make(map[interface{}]bool)
+	for _, id := range ids {
+		switch value := id.(type) {
+		case float64:
+			wanted[int(value)] = true
+		case string:
+			wanted[strings.ToLower(value)] = true
+		default:
+			return nil, fmt.Errorf("invalid id %v", id)
+		}
+	}
+	for _, detail := range details {
+		if wanted[detail.HexString] || wanted[server.torrentIDs.get(detail.HexString)] {
+			selected = append(selected, detail)
+		}
+	}
+	return
+}
+
+func (server *Server) transmissionStatus(detail engine.TorrentDetail) int {
+	switch detail.Status {
+	case engine.QueuedStatus:
+		return transmissionDownloadWait
+	case engine.AnalysingStatus:
+		return transmissionDownloading
+	case engine.RunningStatus:
+		if detail.HasInfo && detail.BytesCompleted == detail.TotalLength {
+			return transmissionSeeding
+		}
+		return transmissionDownloading
+	case engine.CompletedStatus:
+		if detail.InClient && server.config.Current().TorrentConfig.Seed {
+			return transmissionSeeding
+		}
+	}
+	return transmissionStopped
+}
+
+// unixTime Seconds since epoch, 0 for times not happened
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func transmissionETA(detail engine.TorrentDetail) int64 {
+	left := detail.TotalLength - detail.BytesCompleted
+	if left == 0 && detail.HasInfo {
+		return 0
+	}
+	if detail.DownloadRate <= 0 || !detail.HasInfo {
+		return -1
+	}
+	return int64(float64(left) / detail.DownloadRate)
+}
+
+func transmissionPriority(priority types.PiecePriority) int {
+	if priority > types.PiecePriorityNormal {
+		return 1
+	}
+	return 0
+}
+
+// Fields of torrent-get, other fields asked by clients are left out
+var transmissionFields = map[string]func(server *Server, detail engine.TorrentDetail, index int) interface{}{
+	"id": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return server.torrentIDs.get(detail.HexString)
+	},
+	"hashString": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.HexString },
+	"name":       func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.Name },
+	"status": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return server.transmissionStatus(detail)
+	},
+	"downloadDir": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.StoragePath
+	},
+	"totalSize":     func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.TotalLength },
+	"sizeWhenDone":  func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.TotalLength },
+	"haveValid":     func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.BytesCompleted },
+	"haveUnchecked": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"leftUntilDone": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.TotalLength - detail.BytesCompleted
+	},
+	"desiredAvailable": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"percentDone": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		if detail.TotalLength == 0 {
+			return 0
+		}
+		return float64(detail.BytesCompleted) / float64(detail.TotalLength)
+	},
+	"metadataPercentComplete": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		if detail.HasInfo {
+			return 1
+		}
+		return 0
+	},
+	"isFinished": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.Status == engine.CompletedStatus
+	},
+	"isStalled":   func(server *Server, detail engine.TorrentDetail, index int) interface{} { return false },
+	"isPrivate":   func(server *Server, detail engine.TorrentDetail, index int) interface{} { return false },
+	"error":       func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"errorString": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return "" },
+	"eta": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return transmissionETA(detail)
+	},
+	"rateDownload": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return int64(detail.DownloadRate)
+	},
+	"rateUpload": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return int64(detail.UploadRate)
+	},
+	"downloadedEver": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.BytesDownloaded
+	},
+	"uploadedEver": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.BytesUploaded },
+	"uploadRatio": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		// Transmission tells no ratio by -1
+		if detail.BytesDownloaded == 0 {
+			return -1
+		}
+		return detail.Ratio()
+	},
+	"peersConnected": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.ActivePeers },
+	"peersSendingToUs": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.ConnectedSeeders
+	},
+	"peersGettingFromUs": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"pieceCount":         func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.PieceCount },
+	"pieceSize":          func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.PieceLength },
+	"magnetLink":         func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.MagnetLink },
+	"queuePosition":      func(server *Server, detail engine.TorrentDetail, index int) interface{} { return index },
+	"addedDate": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return unixTime(detail.AddedTime)
+	},
+	"doneDate": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return unixTime(detail.CompletedTime)
+	},
+	"activityDate": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return unixTime(detail.LastActivity)
+	},
+	"secondsDownloading": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		return detail.DownloadingSeconds
+	},
+	"secondsSeeding": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return detail.SeedingSeconds },
+	"seedRatioLimit": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"seedRatioMode":  func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"seedIdleLimit":  func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"seedIdleMode":   func(server *Server, detail engine.TorrentDetail, index int) interface{} { return 0 },
+	"fileCount":      func(server *Server, detail engine.TorrentDetail, index int) interface{} { return len(detail.Files) },
+	"labels": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		if detail.Category == "" {
+			return []string{}
+		}
+		return []string{detail.Category}
+	},
+	"files": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		files := make([]JsonFormat, 0, len(detail.Files))
+		for _, file := range detail.Files {
+			files = append(files, JsonFormat{
+				"name":           file.Path,
+				"length":         file.Length,
+				"bytesCompleted": file.BytesCompleted,
+			})
+		}
+		return files
+	},
+	"fileStats": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		fileStats := make([]JsonFormat, 0, len(detail.Files))
+		for _, file := range detail.Files {
+			fileStats = append(fileStats, JsonFormat{
+				"bytesCompleted": file.BytesCompleted,
+				"wanted":         file.Priority != types.PiecePriorityNone,
+				"priority":       transmissionPriority(file.Priority),
+			})
+		}
+		return fileStats
+	},
+	"wanted": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		wanted := make([]bool, 0, len(detail.Files))
+		for _, file := range detail.Files {
+			wanted = append(wanted, file.Priority != types.PiecePriorityNone)
+		}
+		return wanted
+	},
+	"priorities": func(server *Server, detail engine.TorrentDetail, index int) interface{} {
+		priorities := make([]int, 0, len(detail.Files))
+		for _, file := range detail.Files {
+			priorities = append(priorities, transmissionPriority(file.Priority))
+		}
+		return priorities
+	},
+	"peers":    func(server *Server, detail engine.TorrentDetail, index int) interface{} { return []JsonFormat{} },
+	"trackers": func(server *Server, detail engine.TorrentDetail, index int) interface{} { return []JsonFormat{} },
+}
+
+func (server *Server) transmissionTorrentGet(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		Fields []string        `json:"fields"`
+		IDs    json.RawMessage `json:"ids"`
+	}
+	if err := json.Unmarshal(arguments, &request); err != nil {
+		return nil, err
+	}
+	selected, err := server.selectTorrents(request.IDs)
+	if err != nil {
+		return nil, err
+	}
+	allDetails := server.engine.GetTorrentDetails()
+	queuePositions := make(map[string]int, len(allDetails))
+	for index, detail := range allDetails {
+		queuePositions[detail.HexString] = index
+	}
+	torrents := make([]JsonFormat, 0, len(selected))
+	for _, detail := range selected {
+		fields := JsonFormat{}
+		for _, field := range request.Fields {
+			if fieldFunc, isExist := transmissionFields[field]; isExist {
+				fields[field] = fieldFunc(server, detail, queuePositions[detail.HexString])
+			}
+		}
+		torrents = append(torrents, fields)
+	}
+	return JsonFormat{
+		"torrents": torrents,
+		"removed":  []int{},
+	}, nil
+}
+
+// torrent-add accepts base64 torrent file in metainfo, or a magnet, url or path on this machine in filename
+func (server *Server) transmissionTorrentAdd(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		Filename    string   `json:"filename"`
+		Metainfo    string   `json:"metainfo"`
+		DownloadDir string   `json:"download-dir"`
+		Paused      bool     `json:"paused"`
+		Labels      []string `json:"labels"`
+	}
+	if err := json.Unmarshal(arguments, &request); err != nil {
+		return nil, err
+	}
+	options := engine.AddOptions{StoragePath: request.DownloadDir, Paused: request.Paused}
+	if len(request.Labels) > 0 {
+		options.Category = request.Labels[0]
+	}
+
+	var knownHash *metainfo.Hash
+	var torrentMetaInfo *metainfo.MetaInfo
+	switch {
+	case request.Metainfo != "":
+		data, err := base64.StdEncoding.DecodeString(request.Metainfo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metainfo: %v", err)
+		}
+		torrentMetaInfo, err = metainfo.Load(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid or corrupt torrent file")
+		}
+		infoHash := torrentMetaInfo.HashInfoBytes()
+		knownHash = &infoHash
+	case strings.HasPrefix(request.Filename, "magnet:"):
+		magnet, err := metainfo.ParseMagnetUri(request.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("invalid magnet: %v", err)
+		}
+		knownHash = &magnet.InfoHash
+	case request.Filename == "":
+		return nil, fmt.Errorf("no filename or metainfo")
+	}
+	if knownHash != nil {
+		if detail, isExist := server.engine.GetTorrentDetail(knownHash.HexString()); isExist {
+			return JsonFormat{"torrent-duplicate": server.transmissionAddedTorrent(detail.HexString, detail.Name)}, nil
+		}
+	}
+
+	var tmpTorrent *torrent.Torrent
+	var err error
+	isLink := strings.Contains(request.Filename, ":")
+	switch {
+	case torrentMetaInfo != nil:
+		tmpTorrent, err = server.engine.AddOneTorrentFromInfoHashWithOptions(torrentMetaInfo, options)
+	case isLink:
+		tmpTorrent, err = server.engine.AddOneTorrentFromURL(request.Filename, options)
+	default:
+		tmpTorrent, err = server.engine.AddOneTorrentFromFile(request.Filename, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tmpTorrent == nil {
+		return nil, fmt.Errorf("task has been completed")
+	}
+	hexString := tmpTorrent.InfoHash().HexString()
+	if !request.Paused && !isLink {
+		server.engine.GenerateInfoFromTorrent(tmpTorrent)
+		server.engine.StartDownloadTorrent(hexString)
+	}
+	name := hexString
+	if tmpTorrent.Info() != nil {
+		name = tmpTorrent.Name()
+	}
+	return JsonFormat{"torrent-added": server.transmissionAddedTorrent(hexString, name)}, nil
+}
+
+func (server *Server) transmissionAddedTorrent(hexString string, name string) JsonFormat {
+	return JsonFormat{
+		"id":         server.torrentIDs.get(hexString),
+		"hashString": hexString,
+		"name":       name,
+	}
+}
+
+func (server *Server) transmissionTorrentAction(arguments json.RawMessage, action func(detail engine.TorrentDetail) bool) (interface{}, error) {
+	var request struct {
+		IDs json.RawMessage `json:"ids"`
+	}
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &request); err != nil {
+			return nil, err
+		}
+	}
+	selected, err := server.selectTorrents(request.IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, detail := range selected {
+		if !action(detail) {
+			server.logger.WithFields(log.Fields{"HexString": detail.HexString}).Warn("Transmission rpc action not done")
+		}
+	}
+	return JsonFormat{}, nil
+}
+
+func (server *Server) transmissionTorrentRemove(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		DeleteLocalData bool `json:"delete-local-data"`
+	}
+	if len(arguments) > 0 {
+		if err := json.Unmarshal(arguments, &request); err != nil {
+			return nil, err
+		}
+	}
+	return server.transmissionTorrentAction(arguments, func(detail engine.TorrentDetail) bool {
+		return server.engine.DelOneTorrent(detail.HexString, request.DeleteLocalData)
+	})
+}
+
+// transmissionEncryption Transmission has no mode refusing encryption, tolerated is the one preferring plain connections
+func transmissionEncryption(mode string, preferEncryption bool) string {
+	switch {
+	case mode == setting.EncryptionRequired:
+		return "required"
+	case mode == setting.EncryptionPreferred && preferEncryption:
+		return "preferred"
+	}
+	return "tolerated"
+}
+
+// transmissionSpeedLimit Limit in kB/s, as units of session-get say, and whether there is one
+func transmissionSpeedLimit(limiter *rate.Limiter) (enabled bool, kiloBytes int64) {
+	if limiter == nil || limiter.Limit() == rate.Inf {
+		return false, 0
+	}
+	return true, int64(limiter.Limit()) / 1000
+}
+
+func (server *Server) transmissionSessionGet(arguments json.RawMessage) (interface{}, error) {
+	config := server.config.Current()
+	torrentConfig := config.TorrentConfig
+	downloadLimited, downloadLimit := transmissionSpeedLimit(torrentConfig.DownloadRateLimiter)
+	uploadLimited, uploadLimit := transmissionSpeedLimit(torrentConfig.UploadRateLimiter)
+	return JsonFormat{
+		"version":                    transmissionVersion,
+		"rpc-version":                transmissionRPCVersion,
+		"rpc-version-minimum":        14,
+		"session-id":                 server.transmissionSessionID,
+		"download-dir":               torrentConfig.DataDir,
+		"config-dir":                 config.Paths.StateDir,
+		"peer-port":                  torrentConfig.ListenPort,
+		"dht-enabled":                !torrentConfig.NoDHT,
+		"pex-enabled":                !torrentConfig.DisablePEX,
+		"utp-enabled":                !torrentConfig.DisableUTP,
+		"encryption":                 transmissionEncryption(config.EncryptionMode, torrentConfig.HeaderObfuscationPolicy.Preferred),
+		"download-queue-enabled":     true,
+		"download-queue-size":        config.EngineSetting.MaxActiveTorrents,
+		"speed-limit-down-enabled":   downloadLimited,
+		"speed-limit-down":           downloadLimit,
+		"speed-limit-up-enabled":     uploadLimited,
+		"speed-limit-up":             uploadLimit,
+		"alt-speed-enabled":          false,
+		"seedRatioLimited":           false,
+		"seedRatioLimit":             0,
+		"idle-seeding-limit-enabled": false,
+		"rename-partial-files":       false,
+		"start-added-torrents":       true,
+		"units": JsonFormat{
+			"speed-units":  []string{"B/s", "KB/s", "MB/s", "GB/s", "TB/s"},
+			"speed-bytes":  1000,
+			"size-units":   []string{"B", "KB", "MB", "GB", "TB"},
+			"size-bytes":   1000,
+			"memory-units": []string{"B", "KiB", "MiB", "GiB", "TiB"},
+			"memory-bytes": 1024,
+		},
+	}, nil
+}
+
+func (server *Server) transmissionSessionStats(arguments json.RawMessage) (interface{}, error) {
+	stats := server.engine.GetEngineStats()
+	details := server.engine.GetTorrentDetails()
+	active, paused := 0, 0
+	for _, detail := range details {
+		switch detail.Status {
+		case engine.RunningStatus, engine.AnalysingStatus:
+			active++
+		case engine.StoppedStatus:
+			paused++
+		}
+	}
+	sessionStats := JsonFormat{
+		"uploadedBytes":   stats.BytesUploaded,
+		"downloadedBytes": stats.BytesDownloaded,
+		"filesAdded":      len(details),
+		"sessionCount":    1,
+		"secondsActive":   0,
+	}
+	return JsonFormat{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(details),
+		"downloadSpeed":      int64(stats.DownloadRate),
+		"uploadSpeed":        int64(stats.UploadRate),
+		"current-stats":      sessionStats,
+		"cumulative-stats":   sessionStats,
+	}, nil
+}
+
+var transmissionMethods = map[string]func(server *Server, arguments json.RawMessage) (interface{}, error){
+	"torrent-get": (*Server).transmissionTorrentGet,
+	"torrent-add": (*Server).transmissionTorrentAdd,
+	"torrent-start": func(server *Server, arguments json.RawMessage) (interface{}, error) {
+		return server.transmissionTorrentAction(arguments, func(detail engine.TorrentDetail) bool {
+			return server.engine.StartDownloadTorrent(detail.HexString)
+		})
+	},
+	"torrent-start-now": func(server *Server, arguments json.RawMessage) (interface{}, error) {
+		return server.transmissionTorrentAction(arguments, func(detail engine.TorrentDetail) bool {
+			return server.engine.StartDownloadTorrent(detail.HexString)
+		})
+	},
+	"torrent-stop": func(server *Server, arguments json.RawMessage) (interface{}, error) {
+		return server.transmissionTorrentAction(arguments, func(detail engine.TorrentDetail) bool {
+			return server.engine.StopOneTorrent(detail.HexString)
+		})
+	},
+	"torrent-remove": (*Server).transmissionTorrentRemove,
+	"session-get":    (*Server).transmissionSessionGet,
+	"session-stats":  (*Server).transmissionSessionStats,
+}
+
+// Every request needs the session id got from a 409 response, against CSRF from browsers
+func (server *Server) transmissionRPC(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Header.Get(transmissionSessionHeader) != server.transmissionSessionID {
+		w.Header().Set(transmissionSessionHeader, server.transmissionSessionID)
+		http.Error(w, transmissionSessionHeader+" is missing or expired", http.StatusConflict)
+		return
+	}
+	var request transmissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	response := transmissionResponse{
+		Result:    "success",
+		Arguments: JsonFormat{},
+		Tag:       request.Tag,
+	}
+	method, isExist := transmissionMethods[request.Method]
+	if !isExist {
+		response.Result = "method name not recognized"
+	} else {
+		arguments, err := method(server, request.Arguments)
+		if err != nil {
+			server.logger.WithFields(log.Fields{"Error": err, "Method": request.Method}).Error("Transmission rpc failed")
+			response.Result = err.Error()
+		} else {
+			response.Arguments = arguments
+		}
+	}
+	server.WriteResponse(w, response)
+}
+
+func (server *Server) newTransmissionSessionID() string {
+	randomBytes := make([]byte, 24)
+	if _, err := rand.Read(randomBytes); err != nil {
+		server.logger.WithFields(log.Fields{"Error": err}).Error("Unable to create transmission session id")
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
+
+func (server *Server) handleTransmission(router *httprouter.Router) {
+	server.transmissionSessionID = server.newTransmissionSessionID()
+	router.POST("/transmission/rpc", server.transmissionRPC)
+	router.GET("/transmission/rpc", server.transmissionRPC)
+}
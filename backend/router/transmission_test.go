@@ -0,0 +1,125 @@
+package router
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// transmissionClient Posts rpc requests, keeping the session id as Transmission clients do
+type transmissionClient struct {
+	t         *testing.T
+	url       string
+	sessionID string
+}
+
+func (client *transmissionClient) post(body string) *http.Response {
+	client.t.Helper()
+	request, err := http.NewRequest(http.MethodPost, client.url+"/transmission/rpc", bytes.NewBufferString(body))
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	if client.sessionID != "" {
+		request.Header.Set(transmissionSessionHeader, client.sessionID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	return response
+}
+
+func (client *transmissionClient) call(method string, arguments interface{}) map[string]interface{} {
+	client.t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"method": method, "arguments": arguments, "tag": 7})
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	response := client.post(string(body))
+	defer response.Body.Close()
+	var result struct {
+		Result    string                 `json:"result"`
+		Arguments map[string]interface{} `json:"arguments"`
+		Tag       int                    `json:"tag"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		client.t.Fatalf("%s: status %d, error %v", method, response.StatusCode, err)
+	}
+	if result.Result != "success" || result.Tag != 7 {
+		client.t.Fatalf("%s: result %q, tag %d", method, result.Result, result.Tag)
+	}
+	return result.Arguments
+}
+
+func TestTransmissionSessionID(t *testing.T) {
+	_, httpServer := newTestServer(t, testConfig)
+	client := &transmissionClient{t: t, url: httpServer.URL}
+	const sessionGet = `{"method": "session-get"}`
+
+	for _, sessionID := range []string{"", "wrong"} {
+		client.sessionID = sessionID
+		response := client.post(sessionGet)
+		response.Body.Close()
+		if response.StatusCode != http.StatusConflict {
+			t.Fatalf("session id %q: status %d, want 409", sessionID, response.StatusCode)
+		}
+		client.sessionID = response.Header.Get(transmissionSessionHeader)
+		if client.sessionID == "" {
+			t.Fatal("no session id in 409 response")
+		}
+	}
+
+	// the id of 409 response is taken
+	response := client.post(sessionGet)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d with session id", response.StatusCode)
+	}
+	if version := client.call("session-get", nil)["rpc-version"]; version != float64(transmissionRPCVersion) {
+		t.Errorf("rpc-version %v", version)
+	}
+}
+
+func TestTransmissionRoundTrip(t *testing.T) {
+	_, httpServer := newTestServer(t, testConfig)
+	client := &transmissionClient{t: t, url: httpServer.URL}
+	response := client.post(`{"method": "session-get"}`)
+	response.Body.Close()
+	client.sessionID = response.Header.Get(transmissionSessionHeader)
+
+	data, hexString := testTorrent(t, "transmission")
+	added, _ := client.call("torrent-add", map[string]interface{}{"metainfo": base64.StdEncoding.EncodeToString(data)})["torrent-added"].(map[string]interface{})
+	if added["hashString"] != hexString || added["name"] != "transmission" {
+		t.Fatalf("torrent-added %v", added)
+	}
+	if duplicate := client.call("torrent-add", map[string]interface{}{"metainfo": base64.StdEncoding.EncodeToString(data)}); duplicate["torrent-duplicate"] == nil {
+		t.Errorf("second add %v, want torrent-duplicate", duplicate)
+	}
+	id := added["id"]
+
+	status := func() interface{} {
+		t.Helper()
+		torrents, _ := client.call("torrent-get", map[string]interface{}{"ids": []interface{}{id}, "fields": []string{"id", "status"}})["torrents"].([]interface{})
+		if len(torrents) == 0 {
+			return nil
+		}
+		return torrents[0].(map[string]interface{})["status"]
+	}
+	if got := status(); got != float64(transmissionDownloading) {
+		t.Fatalf("status after add %v", got)
+	}
+	client.call("torrent-stop", map[string]interface{}{"ids": []interface{}{hexString}})
+	if got := status(); got != float64(transmissionStopped) {
+		t.Fatalf("status after stop %v", got)
+	}
+	client.call("torrent-start", map[string]interface{}{"ids": id})
+	if got := status(); got != float64(transmissionDownloading) {
+		t.Fatalf("status after start %v", got)
+	}
+	client.call("torrent-remove", map[string]interface{}{"ids": []interface{}{id}, "delete-local-data": true})
+	if got := status(); got != nil {
+		t.Fatalf("status after remove %v", got)
+	}
+}
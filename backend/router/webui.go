@@ -0,0 +1,67 @@
+package router
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/anatasluo/ant/backend/webui"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// Requests matching no api route get files of web client, unknown paths get index.html
+// so that routes of the single page app still work after reloading
+type webUIHandler struct {
+	files      fs.FS
+	fileServer http.Handler
+}
+
+func newWebUIHandler(files fs.FS) *webUIHandler {
+	return &webUIHandler{
+		files:      files,
+		fileServer: http.FileServer(http.FS(files)),
+	}
+}
+
+func (handler *webUIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name != "" {
+		if info, err := fs.Stat(handler.files, name); err == nil && !info.IsDir() {
+			handler.fileServer.ServeHTTP(w, r)
+			return
+		}
+		// Missing assets are real errors, not routes of app
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	index, err := fs.ReadFile(handler.files, "index.html")
+	if err != nil {
+		http.Error(w, "web client not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(index)
+}
+
+func (server *Server) handleWebUI(router *httprouter.Router) {
+	files := webui.Files()
+	if server.config.WebUISetting.WebUIDir != "" {
+		files = os.DirFS(server.config.WebUISetting.WebUIDir)
+		if _, err := fs.Stat(files, "index.html"); err != nil {
+			server.logger.WithFields(log.Fields{"Error": err, "Dir": server.config.WebUISetting.WebUIDir}).Error("Web client not found, use the embedded one")
+			files = webui.Files()
+		}
+	}
+	router.NotFound = newWebUIHandler(files)
+	server.logger.Info("Web client is served at http://" + server.config.ConnectSetting.Addr)
+}
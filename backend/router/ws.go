@@ -15,34 +15,41 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-//TODO : close handle
-func torrentProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (server *Server) torrentProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
-	logger.Info("websocket created!")
+	server.logger.Info("websocket created!")
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		logger.Error("Unable to init websocket", err)
+		server.logger.Error("Unable to init websocket", err)
 		return
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
+	ctx, cancel := server.streamContext(r)
+	defer cancel()
+	server.closeWebsocket(ctx, conn)
 	var tmp engine.MessageFromWeb
 	var resInfo engine.TorrentProgressInfo
 
 	go func() {
 		for {
-			cmdID := <-runningEngine.EngineRunningInfo.EngineCMD
-			logger.Debug("Send CMD Now: ", cmdID)
+			var cmdID engine.MessageTypeID
+			select {
+			case cmdID = <-server.engine.EngineRunningInfo.EngineCMD:
+			case <-ctx.Done():
+				return
+			}
+			server.logger.Debug("Send CMD Now: ", cmdID)
 			if cmdID == engine.RefreshInfo {
 				resInfo.MessageType = engine.RefreshInfo
 				err = conn.WriteJSON(resInfo)
 				if err != nil {
 					select {
-					case runningEngine.EngineRunningInfo.EngineCMD <- cmdID:
+					case server.engine.EngineRunningInfo.EngineCMD <- cmdID:
 					default:
 					}
-					logger.Error("Unable to write message", err)
+					server.logger.Error("Unable to write message", err)
 					return
 				}
 			}
@@ -52,16 +59,19 @@ func torrentProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	for {
 		err = conn.ReadJSON(&tmp)
 		if err != nil {
-			logger.Errorf("Unable to read ressage: %v", err)
+			server.logger.Errorf("Unable to read ressage: %v", err)
 			break
 		}
 
 		if tmp.MessageType == engine.GetInfo {
-			singleTorrent, isExist := runningEngine.GetOneTorrent(tmp.HexString)
+			singleTorrent, isExist := server.engine.GetOneTorrent(tmp.HexString)
 			if isExist {
-				singleTorrentLog := runningEngine.EngineRunningInfo.HashToTorrentLog[singleTorrent.InfoHash()]
-				if singleTorrentLog != nil && (singleTorrentLog.Status == engine.RunningStatus || singleTorrentLog.Status == engine.CompletedStatus) {
-					singleWebLog := runningEngine.GenerateInfoFromTorrent(singleTorrent)
+				singleTorrentLog, logExist := server.engine.GetTorrentLog(tmp.HexString)
+				if logExist && (singleTorrentLog.Status == engine.RunningStatus || singleTorrentLog.Status == engine.CompletedStatus) {
+					singleWebLog := server.engine.GenerateInfoFromTorrent(singleTorrent)
+					if singleWebLog == nil {
+						continue
+					}
 					resInfo.MessageType = engine.GetInfo
 					resInfo.HexString = singleWebLog.HexString
 					resInfo.Percentage = singleWebLog.Percentage
@@ -74,6 +84,6 @@ func torrentProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	}
 }
 
-func handleWS(router *httprouter.Router) {
-	router.GET("/ws", torrentProgress)
+func (server *Server) handleWS(router *httprouter.Router) {
+	router.GET("/ws", server.torrentProgress)
 }
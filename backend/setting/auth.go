@@ -11,27 +11,47 @@ import (
 type Auth struct {
 	Username string
 	Password string
+	// Apis with their own login, such as qBittorrent Web API
+	ExemptPrefixes []string
 }
 
 // Negroni compatible interface
+// Clients send "Authorization: MD5 base64(md5(username):md5(password))" or plain Basic
 func (c Auth) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	for _, prefix := range c.ExemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			next(w, r)
+			return
+		}
+	}
 	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 
-	if len(auth) != 2 || auth[0] != "MD5" {
-		http.Error(w, "authorization failed", http.StatusUnauthorized)
+	if len(auth) != 2 || (auth[0] != "MD5" && auth[0] != "Basic") {
+		authFailed(w)
 		return
 	}
 
 	payload, _ := base64.StdEncoding.DecodeString(auth[1])
 	pair := strings.SplitN(string(payload), ":", 2)
 
+	// Basic is for clients of other protocols, such as Transmission RPC, and browsers
+	if len(pair) == 2 && auth[0] == "Basic" {
+		pair[0], pair[1] = getMD5Hash(pair[0]), getMD5Hash(pair[1])
+	}
+
 	if len(pair) != 2 || !c.validate(pair[0], pair[1]) {
-		http.Error(w, "authorization failed", http.StatusUnauthorized)
+		authFailed(w)
 		return
 	}
 	next(w,r)
 }
 
+// Clients like transmission-remote only send credentials after a challenge
+func authFailed(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="ANT"`)
+	http.Error(w, "authorization failed", http.StatusUnauthorized)
+}
+
 func (c Auth) validate(username, password string) bool {
 	if username == c.Username && password == c.Password {
 		return true
@@ -0,0 +1,152 @@
+package setting
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml"
+)
+
+// Same values as config.toml shipped with the app, used for keys missing in config file
+var defaultSettings = map[string]interface{}{
+	"ConnectSetting.AuthPassword":  "passwd",
+	"ConnectSetting.AuthUsername":  "ANT",
+	"ConnectSetting.IP":            "127.0.0.1",
+	"ConnectSetting.Port":          8482,
+	"ConnectSetting.SupportRemote": false,
+
+	"EncryptionPolicy.DisableEncryption":  false,
+	"EncryptionPolicy.ForceEncryption":    false,
+	"EncryptionPolicy.PreferNoEncryption": true,
+
+	"EngineSetting.DataDir":               "download",
+	"EngineSetting.DefaultIPBlockList":    "http://john.bitsurge.net/public/biglist.p2p.gz",
+	"EngineSetting.DefaultTrackerList":    "https://raw.githubusercontent.com/ngosang/trackerslist/master/trackers_all_ip.txt",
+	"EngineSetting.DisableIPv4":           false,
+	"EngineSetting.DisableIPv6":           false,
+	"EngineSetting.EnableDefaultTrackers": true,
+	"EngineSetting.MaxActiveTorrents":     5,
+	"EngineSetting.MaxEstablishedConns":   100,
+	"EngineSetting.ProxyDNSServer":        "1.1.1.1:53",
+	"EngineSetting.ProxyOnly":             false,
+	"EngineSetting.SocksProxyURL":         "",
+	"EngineSetting.Tmpdir":                "tmp",
+	"EngineSetting.TorrentCaches":         []string{"https://itorrents.org/torrent/{HASH}.torrent"},
+	"EngineSetting.TorrentDBPath":         "storm.db",
+	"EngineSetting.UseSocksproxy":         false,
+
+	"MetricsSetting.EnableMetrics":    true,
+	"MetricsSetting.MaxTorrentLabels": 20,
+
+	"PortMappingSetting.EnablePortMapping": true,
+	"PortMappingSetting.Protocols":         []string{"pcp", "natpmp", "upnp"},
+	"PortMappingSetting.Gateway":           "",
+	"PortMappingSetting.UPnPRootURL":       "",
+	"PortMappingSetting.Lease":             "1h",
+
+	"RSSSetting.EnableRSS":    true,
+	"RSSSetting.PollInterval": "15m",
+
+	"SearchSetting.SearchTimeout": "15s",
+
+	"WebUISetting.EnableWebUI": false,
+	"WebUISetting.WebUIDir":    "",
+
+	"LoggerSetting.DisableUTPLogger": false,
+	"LoggerSetting.LoggingLevel":     5,
+	"LoggerSetting.LoggingOutput":    "file",
+
+	"TorrentConfig.ListenAddr":        "",
+	"TorrentConfig.ListenInterface":   "",
+	"TorrentConfig.ListenPort":        42096,
+	"TorrentConfig.DisablePEX":        false,
+	"TorrentConfig.DisableTCP":        false,
+	"TorrentConfig.DisableUTP":        false,
+	"TorrentConfig.DownloadRateLimit": "",
+	"TorrentConfig.NoDHT":             false,
+	"TorrentConfig.NoUpload":          false,
+	"TorrentConfig.Seed":              false,
+	"TorrentConfig.UploadRateLimit":   "",
+	"TorrentConfig.Debug":             false,
+	"TorrentConfig.PeerID":            "",
+	"TorrentConfig.PeerIDPrefix":      "",
+}
+
+func (cc *ClientSetting) setDefaults() {
+	for key, value := range defaultSettings {
+		cc.viper.SetDefault(key, value)
+	}
+}
+
+func configString(settings map[string]interface{}) (string, error) {
+	tr, err := toml.TreeFromMap(settings)
+	if err != nil {
+		return "", err
+	}
+	return tr.String(), nil
+}
+
+// writeConfig Save settings to config file, the watcher knows it from changes by others
+func (cc *ClientSetting) writeConfig(settings map[string]interface{}) error {
+	trS, err := configString(settings)
+	if err != nil {
+		return err
+	}
+	cc.state.written = []byte(trS)
+	return ioutil.WriteFile(cc.Paths.ConfigFile, cc.state.written, 0644)
+}
+
+// PrintConfig writes the effective config, including defaults and resolved paths
+func (cc *ClientSetting) PrintConfig(w io.Writer) error {
+	cc.state.lock.Lock()
+	trS, err := configString(cc.viper.AllSettings())
+	cc.state.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "# config file: %s\n", cc.Paths.ConfigFile)
+	_, _ = fmt.Fprintf(w, "# data dir:    %s\n", cc.TorrentConfig.DataDir)
+	_, _ = fmt.Fprintf(w, "# state dir:   %s\n", cc.Paths.StateDir)
+	_, _ = fmt.Fprintf(w, "# log dir:     %s\n", cc.Paths.LogDir)
+	_, _ = fmt.Fprintf(w, "# listen:      %s\n", cc.ConnectSetting.Addr)
+	_, err = io.WriteString(w, trS)
+	return err
+}
+
+// CheckConfig returns every problem found in config file and directories
+func (cc *ClientSetting) CheckConfig() (problems []error) {
+	cc.state.lock.Lock()
+	err := cc.viper.ReadInConfig()
+	webSetting := cc.getWebSetting()
+	cc.state.lock.Unlock()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("config file %s: %v", cc.Paths.ConfigFile, err))
+	}
+	if _, _, err := net.SplitHostPort(cc.ConnectSetting.Addr); err != nil {
+		problems = append(problems, fmt.Errorf("listen address %q: %v", cc.ConnectSetting.Addr, err))
+	}
+	if err := webSetting.Validate(); err != nil {
+		for _, fieldErr := range err.(ValidationErrors) {
+			problems = append(problems, fmt.Errorf("%s: %s", fieldErr.Field, fieldErr.Message))
+		}
+	}
+	for _, dir := range []string{cc.TorrentConfig.DataDir, cc.Tmpdir, cc.Paths.StateDir, cc.Paths.LogDir, filepath.Dir(cc.TorrentDBPath)} {
+		if err := checkWritable(dir); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return
+}
+
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".ant-check-")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,59 @@
+package setting
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/mse"
+)
+
+// Encryption modes of connections to peers, chosen by EncryptionPolicy of config file
+const (
+	// Plain BitTorrent protocol only, encrypted peers are refused
+	EncryptionDisabled = "disabled"
+	// Both are accepted, PreferNoEncryption decides which is tried first
+	EncryptionPreferred = "preferred"
+	// Only connections encrypted with RC4 as a whole, not just their headers
+	EncryptionRequired = "required"
+)
+
+func encryptionMode(disableEncryption, forceEncryption bool) string {
+	switch {
+	case forceEncryption:
+		return EncryptionRequired
+	case disableEncryption:
+		return EncryptionDisabled
+	}
+	return EncryptionPreferred
+}
+
+func preferRC4(provided mse.CryptoMethod) mse.CryptoMethod {
+	if provided&mse.CryptoMethodRC4 != 0 {
+		return mse.CryptoMethodRC4
+	}
+	return mse.CryptoMethodPlaintext
+}
+
+// onlyRC4 Peers which offer no RC4 get no method, their handshake fails
+func onlyRC4(provided mse.CryptoMethod) mse.CryptoMethod {
+	return provided & mse.CryptoMethodRC4
+}
+
+// setEncryptionPolicy Map mode onto header obfuscation, and crypto methods offered to and accepted from peers
+func setEncryptionPolicy(config *torrent.ClientConfig, mode string, preferNoEncryption bool) {
+	switch mode {
+	case EncryptionDisabled:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{RequirePreferred: true, Preferred: false}
+		config.CryptoProvides = mse.CryptoMethodPlaintext
+		config.CryptoSelector = mse.DefaultCryptoSelector
+	case EncryptionRequired:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{RequirePreferred: true, Preferred: true}
+		config.CryptoProvides = mse.CryptoMethodRC4
+		config.CryptoSelector = onlyRC4
+	default:
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{RequirePreferred: false, Preferred: !preferNoEncryption}
+		config.CryptoProvides = mse.AllSupportedCrypto
+		config.CryptoSelector = preferRC4
+		if preferNoEncryption {
+			config.CryptoSelector = mse.DefaultCryptoSelector
+		}
+	}
+}
@@ -11,25 +11,22 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/iplist"
-	"github.com/pelletier/go-toml"
+	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"golang.org/x/time/rate"
 )
 
-var (
-	clientConfig      ClientSetting
-	haveCreatedConfig = false
-	globalViper       = viper.New()
-)
-
 type ConnectSetting struct {
 	SupportRemote bool
 	IP            string
@@ -40,15 +37,27 @@ type ConnectSetting struct {
 }
 
 type EngineSetting struct {
-	UseSocksproxy         bool
-	SocksProxyURL         string
+	UseSocksproxy bool
+	// socks5://, http:// or https:// url, with user and password if proxy needs them
+	SocksProxyURL string
+	// Turn off whatever can not go through proxy, so nothing goes around it
+	ProxyOnly bool
+	// DNS server reached through proxy in proxy-only mode, host:port
+	ProxyDNSServer        string
 	MaxActiveTorrents     int
 	TorrentDBPath         string
 	TorrentConfig         torrent.ClientConfig `json:"-"`
 	Tmpdir                string
 	MaxEstablishedConns   int
 	EnableDefaultTrackers bool
+	DefaultTrackerList    string
 	DefaultTrackers       [][]string
+	// URL templates of torrent caches, {HASH} and {hash} are replaced by info hash
+	TorrentCaches []string
+	// disabled, preferred or required, taken from EncryptionPolicy of config file
+	EncryptionMode string
+	// Network interface such as tun0 peers are reached through, any if empty
+	ListenInterface string
 }
 
 type LoggerSetting struct {
@@ -57,206 +66,480 @@ type LoggerSetting struct {
 	Logger        *log.Logger
 }
 
+// RSSSetting Feeds and download rules themselves are kept in the torrent database
+type RSSSetting struct {
+	EnableRSS       bool
+	RSSPollInterval time.Duration
+}
+
+// TorznabIndexer A Torznab compatible indexer, such as Jackett or Prowlarr
+type TorznabIndexer struct {
+	Name       string
+	URL        string
+	APIKey     string
+	Categories string
+	Timeout    time.Duration
+	Disabled   bool
+}
+
+type SearchSetting struct {
+	Indexers      []TorznabIndexer
+	SearchTimeout time.Duration
+}
+
+// CommandHook Run an external command on torrent events, placeholders in Command are
+// {name}, {hash}, {path}, {category} and {files}
+type CommandHook struct {
+	Events  []string
+	Command string
+	Timeout time.Duration
+}
+
+// Webhook Post a json payload on torrent events, signed with HMAC-SHA256 if Secret is set
+type Webhook struct {
+	Events  []string
+	URL     string
+	Secret  string
+	Retries int
+	Timeout time.Duration
+}
+
+type HookSetting struct {
+	CommandHooks []CommandHook
+	Webhooks     []Webhook
+}
+
+type MetricsSetting struct {
+	EnableMetrics bool
+	// Torrents with per-torrent series, the most active ones are chosen. 0 turns them off
+	MaxTorrentLabels int
+}
+
+// PortMappingSetting Map listen port on gateway by PCP, NAT-PMP or UPnP IGD
+type PortMappingSetting struct {
+	EnablePortMapping bool
+	// Tried in this order, pcp, natpmp and upnp
+	Protocols []string
+	// host or host:port of PCP and NAT-PMP gateway, default gateway of system if empty
+	Gateway string
+	// Description of UPnP gateway, it is found by SSDP if empty
+	UPnPRootURL string
+	// Lease asked for, mappings are renewed at half of the lease granted
+	Lease time.Duration
+}
+
+// WebUISetting Serve the Angular client on the same port as the api, for browsers on other machines
+type WebUISetting struct {
+	EnableWebUI bool
+	// Built client (the dist directory), the copy embedded in binary is used if empty
+	WebUIDir string
+}
+
+// ClientSetting Settings of one instance. A ClientSetting is never changed once it is loaded,
+// reloading config publishes a new one, see Current
 type ClientSetting struct {
 	ConnectSetting
 	EngineSetting
 	LoggerSetting
+	RSSSetting
+	SearchSetting
+	HookSetting
+	MetricsSetting
+	WebUISetting
+	PortMappingSetting
+	Paths PathSetting
+	// Values of config file, defaults included. Every instance has its own
+	viper *viper.Viper
+	state *settingState
 }
 
-// WebSetting These settings can be determined by users
-type WebSetting struct {
-	UseSocksProxy         bool
-	SocksProxyURL         string
-	MaxEstablishedConns   int
-	Tmpdir                string
-	DataDir               string
-	EnableDefaultTrackers bool
-	DefaultTrackerList    string
-	DisableIPv4           bool
-	DisableIPv6           bool
-}
-
-func (cc *ClientSetting) GetWebSetting() (webSetting WebSetting) {
-	webSetting.EnableDefaultTrackers = cc.EngineSetting.EnableDefaultTrackers
-	webSetting.DefaultTrackerList = globalViper.GetString("EngineSetting.DefaultTrackerList")
-	webSetting.UseSocksProxy = cc.EngineSetting.UseSocksproxy
-	webSetting.SocksProxyURL = cc.EngineSetting.SocksProxyURL
-	webSetting.MaxEstablishedConns = cc.MaxEstablishedConns
-	webSetting.Tmpdir = cc.Tmpdir
-	webSetting.DataDir = cc.TorrentConfig.DataDir
-	webSetting.DisableIPv4 = cc.TorrentConfig.DisableIPv4
-	webSetting.DisableIPv6 = cc.TorrentConfig.DisableIPv6
-	return
+// settingState Shared by every ClientSetting loaded for one instance
+type settingState struct {
+	// Serializes loading and saving config, viper is not safe for concurrent use
+	lock sync.Mutex
+	// *ClientSetting loaded last
+	current atomic.Value
+	// Config file as ant wrote it last, the watcher skips changes made by ant itself
+	written []byte
+	logFile *os.File
+	// Urls of lists fetched since start, each one is fetched once
+	downloaded map[string]bool
+	// Lists are fetched by client of engine, files of urls waiting for it are kept here
+	httpClient *http.Client
+	pending    map[string]string
+}
+
+// Current Settings loaded last. Settings read when ant starts can be taken from any ClientSetting,
+// the others are taken from Current, so values read together come from one config
+func (cc *ClientSetting) Current() *ClientSetting {
+	return cc.state.current.Load().(*ClientSetting)
+}
+
+// parseRate Bytes per second of a rate limit, rate.Inf if it is unlimited
+func parseRate(value string) (rate.Limit, error) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	if value == "" {
+		return rate.Inf, nil
+	}
+	bytesPerSecond, err := humanize.ParseBytes(value)
+	if err != nil {
+		return rate.Inf, fmt.Errorf("rate limit %q: %v", value, err)
+	}
+	if bytesPerSecond == 0 {
+		return rate.Inf, nil
+	}
+	return rate.Limit(bytesPerSecond), nil
 }
 
-// TODO
-func calculateRateLimiters(uploadRate, downloadRate string) (*rate.Limiter, *rate.Limiter) {
-	downloadRateLimiter := rate.NewLimiter(rate.Inf, 0)
-	uploadRateLimiter := rate.NewLimiter(rate.Inf, 0)
+// newRateLimiter Burst is never below the chunks anacrolix takes at once
+func newRateLimiter(value string, minBurst int) (*rate.Limiter, error) {
+	limit, err := parseRate(value)
+	if err != nil || limit == rate.Inf {
+		return rate.NewLimiter(rate.Inf, 0), err
+	}
+	burst := minBurst
+	if int(limit) > burst {
+		burst = int(limit)
+	}
+	return rate.NewLimiter(limit, burst), nil
+}
+
+func (cc *ClientSetting) calculateRateLimiters(uploadRate, downloadRate string) (*rate.Limiter, *rate.Limiter) {
+	uploadRateLimiter, err := newRateLimiter(uploadRate, 256<<10)
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Invalid upload rate limit, upload is not limited")
+	}
+	downloadRateLimiter, err := newRateLimiter(downloadRate, 1<<20)
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Invalid download rate limit, download is not limited")
+	}
 	return uploadRateLimiter, downloadRateLimiter
 }
 
-func (cc *ClientSetting) loadValueFromConfig() {
+// loadValueFromConfig Fill a new ClientSetting from viper. Lists and log file of previous
+// settings are kept, previous is nil when config is loaded first. State lock is held by caller
+func (cc *ClientSetting) loadValueFromConfig(previous *ClientSetting) {
 
-	cc.LoggerSetting.LoggingLevel = log.AllLevels[globalViper.GetInt("LoggerSetting.LoggingLevel")]
-	cc.LoggerSetting.LoggingOutput = globalViper.GetString("LoggerSetting.LoggingOutput")
+	loggingLevel := cc.viper.GetInt("LoggerSetting.LoggingLevel")
+	if loggingLevel < 0 || loggingLevel >= len(log.AllLevels) {
+		loggingLevel = int(log.InfoLevel)
+	}
+	cc.LoggerSetting.LoggingLevel = log.AllLevels[loggingLevel]
+	cc.LoggerSetting.LoggingOutput = cc.viper.GetString("LoggerSetting.LoggingOutput")
 	cc.LoggerSetting.Logger.SetLevel(cc.LoggerSetting.LoggingLevel)
 
-	cc.EngineSetting.UseSocksproxy = globalViper.GetBool("EngineSetting.UseSocksproxy")
-	cc.EngineSetting.SocksProxyURL = globalViper.GetString("EngineSetting.SocksProxyURL")
-	cc.EngineSetting.MaxActiveTorrents = globalViper.GetInt("EngineSetting.MaxActiveTorrents")
-	cc.EngineSetting.TorrentDBPath = globalViper.GetString("EngineSetting.TorrentDBPath")
-	cc.EngineSetting.MaxEstablishedConns = globalViper.GetInt("EngineSetting.MaxEstablishedConns")
-	tmpDir, tmpErr := filepath.Abs(filepath.ToSlash(globalViper.GetString("EngineSetting.Tmpdir")))
-	_ = os.Mkdir(tmpDir, 0755)
+	cc.EngineSetting.UseSocksproxy = cc.viper.GetBool("EngineSetting.UseSocksproxy")
+	cc.EngineSetting.SocksProxyURL = cc.viper.GetString("EngineSetting.SocksProxyURL")
+	cc.EngineSetting.ProxyOnly = cc.viper.GetBool("EngineSetting.ProxyOnly")
+	cc.EngineSetting.ProxyDNSServer = cc.viper.GetString("EngineSetting.ProxyDNSServer")
+	cc.EngineSetting.MaxActiveTorrents = cc.viper.GetInt("EngineSetting.MaxActiveTorrents")
+	cc.EngineSetting.TorrentDBPath = cc.Paths.join(cc.Paths.StateDir, cc.viper.GetString("EngineSetting.TorrentDBPath"))
+	cc.EngineSetting.MaxEstablishedConns = cc.viper.GetInt("EngineSetting.MaxEstablishedConns")
+	cc.EngineSetting.TorrentCaches = cc.viper.GetStringSlice("EngineSetting.TorrentCaches")
+	tmpDir, tmpErr := filepath.Abs(cc.Paths.join(cc.Paths.StateDir, cc.viper.GetString("EngineSetting.Tmpdir")))
+	_ = os.MkdirAll(tmpDir, 0755)
 	cc.EngineSetting.Tmpdir = tmpDir
 	if tmpErr != nil {
 		cc.Logger.WithFields(log.Fields{"Error": tmpErr}).Error("Fail to create default cache directory")
 	}
 
-	cc.ConnectSetting.IP = globalViper.GetString("ConnectSetting.IP")
-	cc.ConnectSetting.Port = globalViper.GetInt("ConnectSetting.Port")
-	cc.ConnectSetting.SupportRemote = globalViper.GetBool("ConnectSetting.SupportRemote")
+	cc.ConnectSetting.IP = cc.viper.GetString("ConnectSetting.IP")
+	cc.ConnectSetting.Port = cc.viper.GetInt("ConnectSetting.Port")
+	cc.ConnectSetting.SupportRemote = cc.viper.GetBool("ConnectSetting.SupportRemote")
 	if cc.ConnectSetting.SupportRemote {
 		cc.ConnectSetting.Addr = ":" + strconv.Itoa(cc.ConnectSetting.Port)
 	} else {
 		cc.ConnectSetting.Addr = cc.ConnectSetting.IP + ":" + strconv.Itoa(cc.ConnectSetting.Port)
 	}
-	cc.ConnectSetting.AuthUsername = globalViper.GetString("ConnectSetting.AuthUsername")
-	cc.ConnectSetting.AuthPassword = globalViper.GetString("ConnectSetting.AuthPassword")
+	if cc.Paths.ListenAddr != "" {
+		cc.ConnectSetting.Addr = cc.Paths.ListenAddr
+	}
+	cc.ConnectSetting.AuthUsername = cc.viper.GetString("ConnectSetting.AuthUsername")
+	cc.ConnectSetting.AuthPassword = cc.viper.GetString("ConnectSetting.AuthPassword")
 
 	cc.EngineSetting.TorrentConfig = *torrent.NewDefaultClientConfig()
-	cc.EngineSetting.TorrentConfig.UploadRateLimiter, cc.EngineSetting.TorrentConfig.DownloadRateLimiter = calculateRateLimiters(viper.GetString("TorrentConfig.UploadRateLimit"), viper.GetString("TorrentConfig.DownloadRateLimit"))
-	tmpDataDir, err := filepath.Abs(filepath.ToSlash(globalViper.GetString("EngineSetting.DataDir")))
-	_ = os.Mkdir(tmpDataDir, 0755)
+	cc.EngineSetting.TorrentConfig.UploadRateLimiter, cc.EngineSetting.TorrentConfig.DownloadRateLimiter = cc.calculateRateLimiters(cc.viper.GetString("TorrentConfig.UploadRateLimit"), cc.viper.GetString("TorrentConfig.DownloadRateLimit"))
+	tmpDataDir, err := filepath.Abs(cc.Paths.join(cc.Paths.DataDir, cc.viper.GetString("EngineSetting.DataDir")))
+	if cc.Paths.FixedDataDir {
+		// an explicit data directory is where downloads go
+		tmpDataDir = cc.Paths.DataDir
+	}
+	_ = os.MkdirAll(tmpDataDir, 0755)
 	cc.EngineSetting.TorrentConfig.DataDir = tmpDataDir
 	if err != nil {
 		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Fail to create default datadir")
 	}
-	tmpListenAddr := globalViper.GetString("TorrentConfig.ListenAddr")
+	tmpListenAddr := cc.viper.GetString("TorrentConfig.ListenAddr")
 	if tmpListenAddr != "" {
 		cc.EngineSetting.TorrentConfig.SetListenAddr(tmpListenAddr)
 	}
-	cc.EngineSetting.TorrentConfig.ListenPort = globalViper.GetInt("TorrentConfig.ListenPort")
-	cc.EngineSetting.TorrentConfig.DisablePEX = globalViper.GetBool("TorrentConfig.DisablePEX")
-	cc.EngineSetting.TorrentConfig.NoDHT = globalViper.GetBool("TorrentConfig.NoDHT")
-	cc.EngineSetting.TorrentConfig.NoUpload = globalViper.GetBool("TorrentConfig.NoUpload")
-	cc.EngineSetting.TorrentConfig.Seed = globalViper.GetBool("TorrentConfig.Seed")
-	cc.EngineSetting.TorrentConfig.DisableUTP = globalViper.GetBool("TorrentConfig.DisableUTP")
-	cc.EngineSetting.TorrentConfig.DisableTCP = globalViper.GetBool("TorrentConfig.DisableTCP")
-	cc.EngineSetting.TorrentConfig.DisableIPv6 = globalViper.GetBool("EngineSetting.DisableIPv6")
-	cc.EngineSetting.TorrentConfig.DisableIPv4 = globalViper.GetBool("EngineSetting.DisableIPv4")
-	cc.EngineSetting.TorrentConfig.Debug = globalViper.GetBool("TorrentConfig.Debug")
-	cc.EngineSetting.TorrentConfig.PeerID = globalViper.GetString("TorrentConfig.PeerID")
-
-	cc.EngineSetting.TorrentConfig.HeaderObfuscationPolicy.Preferred = !globalViper.GetBool("EncryptionPolicy.PreferNoEncryption")
-
-	blockListPath, err := filepath.Abs("biglist.p2p.gz")
-	if err != nil {
+	cc.EngineSetting.TorrentConfig.ListenPort = cc.viper.GetInt("TorrentConfig.ListenPort")
+	cc.EngineSetting.ListenInterface = cc.viper.GetString("TorrentConfig.ListenInterface")
+	cc.EngineSetting.TorrentConfig.DisablePEX = cc.viper.GetBool("TorrentConfig.DisablePEX")
+	cc.EngineSetting.TorrentConfig.NoDHT = cc.viper.GetBool("TorrentConfig.NoDHT")
+	cc.EngineSetting.TorrentConfig.NoUpload = cc.viper.GetBool("TorrentConfig.NoUpload")
+	cc.EngineSetting.TorrentConfig.Seed = cc.viper.GetBool("TorrentConfig.Seed")
+	cc.EngineSetting.TorrentConfig.DisableUTP = cc.viper.GetBool("TorrentConfig.DisableUTP")
+	cc.EngineSetting.TorrentConfig.DisableTCP = cc.viper.GetBool("TorrentConfig.DisableTCP")
+	cc.EngineSetting.TorrentConfig.DisableIPv6 = cc.viper.GetBool("EngineSetting.DisableIPv6")
+	cc.EngineSetting.TorrentConfig.DisableIPv4 = cc.viper.GetBool("EngineSetting.DisableIPv4")
+	cc.EngineSetting.TorrentConfig.Debug = cc.viper.GetBool("TorrentConfig.Debug")
+	if peerID := cc.viper.GetString("TorrentConfig.PeerID"); len(peerID) == 20 {
+		cc.EngineSetting.TorrentConfig.PeerID = peerID
+	} else if peerID != "" {
+		cc.Logger.WithFields(log.Fields{"PeerID": peerID}).Error("Peer ID must be 20 bytes long, a random one is used")
+	}
+	if prefix := cc.viper.GetString("TorrentConfig.PeerIDPrefix"); prefix != "" && len(prefix) <= 20 {
+		cc.EngineSetting.TorrentConfig.Bep20 = prefix
+	}
+
+	cc.EngineSetting.EncryptionMode = encryptionMode(cc.viper.GetBool("EncryptionPolicy.DisableEncryption"), cc.viper.GetBool("EncryptionPolicy.ForceEncryption"))
+	setEncryptionPolicy(&cc.EngineSetting.TorrentConfig, cc.EngineSetting.EncryptionMode, cc.viper.GetBool("EncryptionPolicy.PreferNoEncryption"))
+
+	if previous != nil {
+		// blocklist is only read when ant starts
+		cc.EngineSetting.TorrentConfig.IPBlocklist = previous.TorrentConfig.IPBlocklist
+	} else if blockListPath, err := filepath.Abs(filepath.Join(cc.Paths.StateDir, "biglist.p2p.gz")); err != nil {
 		fmt.Printf("Failed to update block list is: %v\n", err)
 	} else {
-		cc.EngineSetting.TorrentConfig.IPBlocklist = cc.getBlocklist(blockListPath, globalViper.GetString("EngineSetting.DefaultIPBlockList"))
+		cc.EngineSetting.TorrentConfig.IPBlocklist = cc.getBlocklist(blockListPath, cc.viper.GetString("EngineSetting.DefaultIPBlockList"))
 	}
 
-	cc.EngineSetting.EnableDefaultTrackers = globalViper.GetBool("EngineSetting.EnableDefaultTrackers")
-	if cc.EngineSetting.EnableDefaultTrackers {
-		trackerPath, err := filepath.Abs("tracker.txt")
+	cc.EngineSetting.EnableDefaultTrackers = cc.viper.GetBool("EngineSetting.EnableDefaultTrackers")
+	cc.EngineSetting.DefaultTrackerList = cc.viper.GetString("EngineSetting.DefaultTrackerList")
+	if previous != nil && previous.EnableDefaultTrackers && cc.EnableDefaultTrackers && previous.DefaultTrackerList == cc.DefaultTrackerList {
+		cc.EngineSetting.DefaultTrackers = previous.DefaultTrackers
+	} else if cc.EngineSetting.EnableDefaultTrackers {
+		trackerPath, err := filepath.Abs(filepath.Join(cc.Paths.StateDir, "tracker.txt"))
 		if err != nil {
 			cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to update trackers list")
 		}
-		cc.EngineSetting.DefaultTrackers = cc.getDefaultTrackers(trackerPath, globalViper.GetString("EngineSetting.DefaultTrackerList"))
+		cc.EngineSetting.DefaultTrackers = cc.getDefaultTrackers(trackerPath, cc.EngineSetting.DefaultTrackerList)
 	} else {
 		cc.EngineSetting.DefaultTrackers = [][]string{}
 	}
 
-	if cc.UseSocksproxy {
-		cc.TorrentConfig.HTTPProxy = func(request *http.Request) (*url.URL, error) {
-			return url.Parse(cc.SocksProxyURL)
+	cc.RSSSetting.EnableRSS = cc.viper.GetBool("RSSSetting.EnableRSS")
+	cc.RSSSetting.RSSPollInterval = cc.viper.GetDuration("RSSSetting.PollInterval")
+	if cc.RSSSetting.RSSPollInterval < time.Minute {
+		cc.RSSSetting.RSSPollInterval = 15 * time.Minute
+	}
+
+	cc.SearchSetting.SearchTimeout = cc.viper.GetDuration("SearchSetting.SearchTimeout")
+	if cc.SearchSetting.SearchTimeout <= 0 {
+		cc.SearchSetting.SearchTimeout = 15 * time.Second
+	}
+	cc.SearchSetting.Indexers = nil
+	err = cc.viper.UnmarshalKey("SearchSetting.Indexers", &cc.SearchSetting.Indexers)
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to load torznab indexers")
+	}
+	for index := range cc.SearchSetting.Indexers {
+		if cc.SearchSetting.Indexers[index].Timeout <= 0 || cc.SearchSetting.Indexers[index].Timeout > cc.SearchSetting.SearchTimeout {
+			cc.SearchSetting.Indexers[index].Timeout = cc.SearchSetting.SearchTimeout
 		}
 	}
 
+	cc.MetricsSetting.EnableMetrics = cc.viper.GetBool("MetricsSetting.EnableMetrics")
+	cc.MetricsSetting.MaxTorrentLabels = cc.viper.GetInt("MetricsSetting.MaxTorrentLabels")
+
+	cc.PortMappingSetting.EnablePortMapping = cc.viper.GetBool("PortMappingSetting.EnablePortMapping")
+	cc.PortMappingSetting.Protocols = cc.viper.GetStringSlice("PortMappingSetting.Protocols")
+	cc.PortMappingSetting.Gateway = cc.viper.GetString("PortMappingSetting.Gateway")
+	cc.PortMappingSetting.UPnPRootURL = cc.viper.GetString("PortMappingSetting.UPnPRootURL")
+	cc.PortMappingSetting.Lease = cc.viper.GetDuration("PortMappingSetting.Lease")
+	if cc.PortMappingSetting.Lease < 2*time.Minute {
+		cc.PortMappingSetting.Lease = time.Hour
+	}
+
+	cc.WebUISetting.EnableWebUI = cc.viper.GetBool("WebUISetting.EnableWebUI")
+	cc.WebUISetting.WebUIDir = cc.viper.GetString("WebUISetting.WebUIDir")
+	if cc.WebUISetting.WebUIDir != "" {
+		cc.WebUISetting.WebUIDir = cc.Paths.join(cc.Paths.StateDir, cc.WebUISetting.WebUIDir)
+	}
+
+	cc.HookSetting.CommandHooks = nil
+	cc.HookSetting.Webhooks = nil
+	err = cc.viper.UnmarshalKey("HookSetting.Commands", &cc.HookSetting.CommandHooks)
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to load command hooks")
+	}
+	err = cc.viper.UnmarshalKey("HookSetting.Webhooks", &cc.HookSetting.Webhooks)
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to load webhooks")
+	}
+
+	// logger is shared by every ClientSetting, its output is changed under its own lock
 	if cc.LoggerSetting.LoggingOutput == "file" {
-		file, err := os.OpenFile("./ant_engine.log", os.O_CREATE|os.O_WRONLY, 0755)
-		if err != nil {
-			cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to open log file")
-		} else {
-			cc.Logger.Out = file
+		if cc.state.logFile == nil {
+			file, err := os.OpenFile(filepath.Join(cc.Paths.LogDir, "ant_engine.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to open log file")
+			} else {
+				cc.state.logFile = file
+				cc.Logger.SetOutput(file)
+			}
 		}
 	} else {
-		cc.Logger.Out = os.Stdout
+		cc.Logger.SetOutput(os.Stdout)
+		if cc.state.logFile != nil {
+			_ = cc.state.logFile.Close()
+			cc.state.logFile = nil
+		}
 	}
 
-	if globalViper.GetBool("LoggerSetting.DisableUTPLogger") {
+	if cc.viper.GetBool("LoggerSetting.DisableUTPLogger") {
 		cc.Logger.Info("go-libutp logging turned off")
 		utp.Logger.SetOutput(io.Discard)
 	}
 }
 
-func (cc *ClientSetting) loadFromConfigFile() {
-	globalViper.SetConfigName("config")
-	globalViper.AddConfigPath("./")
-	err := globalViper.ReadInConfig()
+func (cc *ClientSetting) loadFromConfigFile() error {
+	cc.setDefaults()
+	cc.viper.SetConfigFile(cc.Paths.ConfigFile)
+	cc.viper.SetConfigType("toml")
+	if _, statErr := os.Stat(cc.Paths.ConfigFile); os.IsNotExist(statErr) {
+		cc.LoggerSetting.Logger.WithFields(log.Fields{"Path": cc.Paths.ConfigFile}).Info("Config file not found, create it with default settings")
+		if writeErr := cc.writeConfig(cc.viper.AllSettings()); writeErr != nil {
+			cc.LoggerSetting.Logger.WithFields(log.Fields{"Error": writeErr}).Error("Unable to create config file")
+		}
+	}
+	err := cc.viper.ReadInConfig()
 	if err != nil {
-		cc.LoggerSetting.Logger.WithFields(log.Fields{"Detail": err}).Error("Can not find config.toml")
-	} else {
-		cc.loadValueFromConfig()
+		cc.LoggerSetting.Logger.WithFields(log.Fields{"Detail": err, "Path": cc.Paths.ConfigFile}).Error("Can not read config file")
+	}
+	// defaults are used if config can not be read
+	cc.loadValueFromConfig(nil)
+	cc.state.current.Store(cc)
+	// refused settings leave nothing open or watched
+	if checkErr := cc.checkListenAddr(); checkErr != nil {
+		if cc.state.logFile != nil {
+			cc.Logger.SetOutput(os.Stderr)
+			_ = cc.state.logFile.Close()
+			cc.state.logFile = nil
+		}
+		return checkErr
 	}
 	if err == nil {
-		// don't watch config if viper config is not loaded, hangs
-		globalViper.WatchConfig()
+		cc.watchConfig()
 	}
-	globalViper.OnConfigChange(func(e fsnotify.Event) {
-		log.Infof("Config file has changed: %s", e.Name)
-		// this is limited, for TorrentConfig change to take effect, must restart client
-		cc.loadValueFromConfig()
-	})
+	return nil
 }
 
-// Load setting from config.toml
-func (cc *ClientSetting) createClientSetting() {
+// reload Load config into a new ClientSetting and publish it, state lock is held by caller.
+// This is limited, for TorrentConfig change to take effect, client must be restarted
+func (cc *ClientSetting) reload() {
+	previous := cc.Current()
+	next := &ClientSetting{Paths: cc.Paths, viper: cc.viper, state: cc.state}
+	next.LoggerSetting.Logger = previous.Logger
+	next.loadValueFromConfig(previous)
+	cc.state.current.Store(next)
+}
 
-	//Default settings
-	cc.LoggerSetting.Logger = log.New()
+// watchConfig Reload config when it is changed by others. The directory is watched,
+// as editors save files by renaming new ones over them
+func (cc *ClientSetting) watchConfig() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Unable to watch config file")
+		return
+	}
+	configFile := filepath.Clean(cc.Paths.ConfigFile)
+	if err = watcher.Add(filepath.Dir(configFile)); err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Unable to watch config file")
+		_ = watcher.Close()
+		return
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					cc.onConfigChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				cc.Logger.WithFields(log.Fields{"Error": err}).Error("Config watcher failed")
+			}
+		}
+	}()
+}
 
-	cc.loadFromConfigFile()
+func (cc *ClientSetting) onConfigChange() {
+	cc.state.lock.Lock()
+	defer cc.state.lock.Unlock()
+	content, err := ioutil.ReadFile(cc.Paths.ConfigFile)
+	if err != nil || bytes.Equal(content, cc.state.written) {
+		// gone while being replaced, or saved by UpdateConfig which has loaded it
+		return
+	}
+	cc.Logger.WithFields(log.Fields{"Path": cc.Paths.ConfigFile}).Info("Config file has changed")
+	if err = cc.viper.ReadInConfig(); err != nil {
+		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Can not read changed config file, settings are kept")
+		return
+	}
+	cc.reload()
 }
 
-func GetClientSetting() *ClientSetting {
-	if !haveCreatedConfig {
-		haveCreatedConfig = true
-		clientConfig.createClientSetting()
+// New Load settings of one instance from config file in paths, it is created with defaults if missing
+// Relative paths are taken from working directory, directories are created if needed
+func New(paths PathSetting) (*ClientSetting, error) {
+	if err := paths.prepare(); err != nil {
+		return nil, err
+	}
+	cc := &ClientSetting{Paths: paths, viper: viper.New(), state: &settingState{downloaded: make(map[string]bool), pending: make(map[string]string)}}
+	cc.LoggerSetting.Logger = log.New()
+	if err := cc.loadFromConfigFile(); err != nil {
+		return nil, err
 	}
-	return &clientConfig
+	return cc, nil
 }
 
-func (cc *ClientSetting) UpdateConfig(newSetting WebSetting) {
-	globalViper.Set("EngineSetting.EnableDefaultTrackers", newSetting.EnableDefaultTrackers)
-	globalViper.Set("EngineSetting.DefaultTrackerList", newSetting.DefaultTrackerList)
-	globalViper.Set("EngineSetting.UseSocksproxy", newSetting.UseSocksProxy)
-	globalViper.Set("EngineSetting.SocksProxyURL", newSetting.SocksProxyURL)
-	globalViper.Set("EngineSetting.MaxEstablishedConns", newSetting.MaxEstablishedConns)
-	globalViper.Set("EngineSetting.Tmpdir", newSetting.Tmpdir)
-	globalViper.Set("EngineSetting.DataDir", newSetting.DataDir)
-	globalViper.Set("EngineSetting.DisableIPv4", newSetting.DisableIPv4)
-	globalViper.Set("EngineSetting.DisableIPv6", newSetting.DisableIPv6)
+// checkListenAddr Auth is only installed with SupportRemote, so an address of --listen or ANT_LISTEN
+// other than loopback is refused unless remote support is on with credentials
+func (cc *ClientSetting) checkListenAddr() error {
+	if cc.Paths.ListenAddr == "" || isLoopbackAddr(cc.Paths.ListenAddr) {
+		return nil
+	}
+	if cc.ConnectSetting.SupportRemote && cc.ConnectSetting.AuthUsername != "" && cc.ConnectSetting.AuthPassword != "" {
+		return nil
+	}
+	return fmt.Errorf("listen address %s is reachable from other hosts, set supportremote, authusername and authpassword of connectsetting to use it", cc.Paths.ListenAddr)
+}
 
-	tr, err := toml.TreeFromMap(globalViper.AllSettings())
+// isLoopbackAddr Whether host of addr is loopback, an empty host means every interface
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		cc.Logger.Fatal("Unable to load toml tree")
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
-	trS := tr.String()
-	err = ioutil.WriteFile("config.toml", []byte(trS), 0644)
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NewFromFlags Settings of ant program, paths are taken from command line flags and environment
+func NewFromFlags() (*ClientSetting, error) {
+	paths, err := ResolvePaths()
 	if err != nil {
-		cc.Logger.WithFields(log.Fields{"Error": err, "Settings": trS}).Fatal("Unable to update settings")
+		return nil, err
 	}
-	haveCreatedConfig = false
-	GetClientSetting()
+	return New(paths)
 }
 
 func (cc *ClientSetting) getDefaultTrackers(filepath string, url string) [][]string {
 	datas, err := readLines(filepath)
 	if err != nil {
-		panic(err)
+		// The list is downloaded below, it will be used from next start
+		cc.Logger.WithFields(log.Fields{"Error": err}).Warn("Unable to read trackers list")
 	}
 	var res [][]string
 
@@ -269,16 +552,14 @@ func (cc *ClientSetting) getDefaultTrackers(filepath string, url string) [][]str
 	}
 
 	//Update list if possible for next time
-	go cc.downloadFile(url, filepath)
+	cc.downloadList(url, filepath)
 	return res
 }
 
 // Download and add the blocklist.
 func (cc *ClientSetting) getBlocklist(filepath string, blocklistURL string) iplist.Ranger {
 	// Update list if possible for next time
-	defer func() {
-		go cc.downloadFile(blocklistURL, filepath)
-	}()
+	defer cc.downloadList(blocklistURL, filepath)
 
 	// Load blocklist.
 	// #nosec
@@ -344,9 +625,35 @@ func readLines(path string) (lines []string, err error) {
 	return
 }
 
-func (cc *ClientSetting) downloadFile(downloadURL string, filepath string) {
+// downloadList Update a list in background for next start, each url is fetched once a run.
+// State lock is held by caller
+func (cc *ClientSetting) downloadList(downloadURL string, filepath string) {
+	if downloadURL == "" || cc.state.downloaded[downloadURL] {
+		return
+	}
+	cc.state.downloaded[downloadURL] = true
+	if cc.state.httpClient == nil {
+		cc.state.pending[downloadURL] = filepath
+		return
+	}
+	go cc.downloadFile(cc.state.httpClient, downloadURL, filepath)
+}
+
+// SetHTTPClient Lists are fetched by client of engine, so they go through its proxy and
+// ListenInterface. Nothing is fetched before it is set, lists waiting for it are fetched now
+func (cc *ClientSetting) SetHTTPClient(client *http.Client) {
+	cc.state.lock.Lock()
+	defer cc.state.lock.Unlock()
+	cc.state.httpClient = client
+	for downloadURL, filepath := range cc.state.pending {
+		go cc.downloadFile(client, downloadURL, filepath)
+		delete(cc.state.pending, downloadURL)
+	}
+}
+
+func (cc *ClientSetting) downloadFile(client *http.Client, downloadURL string, filepath string) {
 	// Get the data
-	resp, err := http.Get(downloadURL)
+	resp, err := client.Get(downloadURL)
 	if err != nil {
 		cc.Logger.WithFields(log.Fields{"Error": err}).Error("Failed to update list")
 		return
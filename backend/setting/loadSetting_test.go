@@ -0,0 +1,149 @@
+package setting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig Settings without lists to download
+const testConfig = `[enginesetting]
+  defaultipblocklist = ""
+  defaulttrackerlist = ""
+  enabledefaulttrackers = false
+  maxestablishedconns = 50
+[loggersetting]
+  loggingoutput = "file"
+  logginglevel = 2
+`
+
+func newTestSetting(t *testing.T) *ClientSetting {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configFile, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cc, err := New(PathSetting{ConfigFile: configFile, DataDir: dir, StateDir: dir, LogDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.state.lock.Lock()
+		defer cc.state.lock.Unlock()
+		if cc.state.logFile != nil {
+			_ = cc.state.logFile.Close()
+		}
+	})
+	return cc
+}
+
+func TestUpdateConfigPublishesNewSetting(t *testing.T) {
+	cc := newTestSetting(t)
+	first := cc.Current()
+	if first != cc || first.MaxEstablishedConns != 50 {
+		t.Fatalf("first setting %p has %d connections, want %p with 50", first, first.MaxEstablishedConns, cc)
+	}
+	logFile := cc.state.logFile
+
+	webSetting := cc.GetWebSetting()
+	webSetting.MaxEstablishedConns = 80
+	changes, err := cc.UpdateConfig(webSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Live) != 1 || changes.Live[0] != "MaxEstablishedConns" {
+		t.Errorf("changes %+v", changes)
+	}
+	updated := cc.Current()
+	if updated == first || updated.MaxEstablishedConns != 80 {
+		t.Fatalf("current setting has %d connections after update", updated.MaxEstablishedConns)
+	}
+	if first.MaxEstablishedConns != 50 {
+		t.Error("loaded setting is changed by update")
+	}
+	if cc.state.logFile != logFile {
+		t.Error("log file is opened again by update")
+	}
+
+	// watcher skips the file written by UpdateConfig
+	time.Sleep(300 * time.Millisecond)
+	if cc.Current() != updated {
+		t.Error("config written by UpdateConfig is loaded again")
+	}
+}
+
+func TestChangedConfigFileIsLoaded(t *testing.T) {
+	cc := newTestSetting(t)
+	// a value saved by UpdateConfig can still be changed in file
+	webSetting := cc.GetWebSetting()
+	webSetting.MaxEstablishedConns = 60
+	if _, err := cc.UpdateConfig(webSetting); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(cc.Paths.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := strings.Replace(string(content), "maxestablishedconns = 60", "maxestablishedconns = 70", 1)
+	if changed == string(content) {
+		t.Fatalf("setting not found in config:\n%s", content)
+	}
+	if err = ioutil.WriteFile(cc.Paths.ConfigFile, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.Current().MaxEstablishedConns != 70 {
+		if time.Now().After(deadline) {
+			t.Fatal("changed config file is not loaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if cc.MaxEstablishedConns != 50 {
+		t.Error("loaded setting is changed by reload")
+	}
+}
+
+func TestListenAddrNeedsAuth(t *testing.T) {
+	const remote = `[connectsetting]
+  supportremote = true
+  authusername = "admin"
+  authpassword = "secret"
+`
+	tests := []struct {
+		listenAddr string
+		config     string
+		valid      bool
+	}{
+		{"", testConfig, true},
+		{"127.0.0.1:8482", testConfig, true},
+		{"localhost:8482", testConfig, true},
+		{"[::1]:8482", testConfig, true},
+		{"0.0.0.0:8482", testConfig, false},
+		{":8482", testConfig, false},
+		{"192.168.1.2:8482", testConfig, false},
+		{"0.0.0.0:8482", testConfig + remote, true},
+		{"0.0.0.0:8482", testConfig + strings.Replace(remote, `"secret"`, `""`, 1), false},
+		{"0.0.0.0:8482", testConfig + strings.Replace(remote, "true", "false", 1), false},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		configFile := filepath.Join(dir, "config.toml")
+		if err := os.WriteFile(configFile, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cc, err := New(PathSetting{ConfigFile: configFile, DataDir: dir, StateDir: dir, LogDir: dir, ListenAddr: test.listenAddr})
+		if (err == nil) != test.valid {
+			t.Errorf("listen on %q with config\n%s\nerror %v", test.listenAddr, test.config, err)
+		}
+		if err == nil && test.listenAddr != "" && cc.ConnectSetting.Addr != test.listenAddr {
+			t.Errorf("listen on %q, address is %q", test.listenAddr, cc.ConnectSetting.Addr)
+		}
+		if cc != nil && cc.state.logFile != nil {
+			_ = cc.state.logFile.Close()
+		}
+	}
+}
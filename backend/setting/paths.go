@@ -0,0 +1,139 @@
+package setting
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PathSetting Where ANT keeps its files. Flags win over environment variables.
+//
+// If none of them is set and ./config.toml exists (as the Electron app runs the engine),
+// everything stays relative to the working directory. Otherwise the XDG base directories
+// are used: config in $XDG_CONFIG_HOME/ant, torrent db, lists and logs in $XDG_STATE_HOME/ant
+// and downloads in $XDG_DATA_HOME/ant.
+type PathSetting struct {
+	ConfigFile string
+	// Base of relative DataDir in config
+	DataDir string
+	// Torrent db, tracker list, ip blocklist and cached torrent files
+	StateDir string
+	LogDir   string
+	// DataDir is where downloads go, instead of base of DataDir in config
+	FixedDataDir bool
+	// Overrides the address of ConnectSetting if not empty, addresses other than
+	// loopback need SupportRemote with credentials
+	ListenAddr string
+	// Working directory mode, for the Electron app
+	WorkingDirMode bool
+}
+
+// CommandFlags Options given on command line
+type CommandFlags struct {
+	ConfigFile  string
+	DataDir     string
+	StateDir    string
+	LogDir      string
+	ListenAddr  string
+	PrintConfig bool
+	CheckConfig bool
+}
+
+var Flags CommandFlags
+
+const appDirName = "ant"
+
+func init() {
+	flag.StringVar(&Flags.ConfigFile, "config", "", "path of config file (env ANT_CONFIG)")
+	flag.StringVar(&Flags.DataDir, "data-dir", "", "directory of downloads (env ANT_DATA_DIR)")
+	flag.StringVar(&Flags.StateDir, "state-dir", "", "directory of torrent database, lists and cache (env ANT_STATE_DIR)")
+	flag.StringVar(&Flags.LogDir, "log-dir", "", "directory of ant_engine.log (env ANT_LOG_DIR)")
+	flag.StringVar(&Flags.ListenAddr, "listen", "", "address of the http api, such as 0.0.0.0:8482, addresses other than loopback need supportremote with credentials (env ANT_LISTEN)")
+	flag.BoolVar(&Flags.PrintConfig, "print-config", false, "print the effective config and exit")
+	flag.BoolVar(&Flags.CheckConfig, "check-config", false, "check the config and exit")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func xdgDir(envName string, fallback ...string) string {
+	if dir := os.Getenv(envName); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, appDirName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(append([]string{home}, append(fallback, appDirName)...)...)
+}
+
+// ResolvePaths combines flags, environment variables and defaults
+func ResolvePaths() (paths PathSetting, err error) {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	paths.ConfigFile = firstNonEmpty(Flags.ConfigFile, os.Getenv("ANT_CONFIG"))
+	paths.DataDir = firstNonEmpty(Flags.DataDir, os.Getenv("ANT_DATA_DIR"))
+	paths.StateDir = firstNonEmpty(Flags.StateDir, os.Getenv("ANT_STATE_DIR"))
+	paths.LogDir = firstNonEmpty(Flags.LogDir, os.Getenv("ANT_LOG_DIR"))
+	paths.ListenAddr = firstNonEmpty(Flags.ListenAddr, os.Getenv("ANT_LISTEN"))
+	paths.FixedDataDir = paths.DataDir != ""
+
+	if paths.ConfigFile == "" && paths.DataDir == "" && paths.StateDir == "" {
+		if _, statErr := os.Stat("config.toml"); statErr == nil {
+			paths.WorkingDirMode = true
+		}
+	}
+
+	if paths.WorkingDirMode {
+		workingDir, _ := os.Getwd()
+		paths.ConfigFile = filepath.Join(workingDir, "config.toml")
+		paths.DataDir = workingDir
+		paths.StateDir = workingDir
+	} else {
+		if paths.ConfigFile == "" {
+			paths.ConfigFile = filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "config.toml")
+		}
+		if paths.DataDir == "" {
+			paths.DataDir = xdgDir("XDG_DATA_HOME", ".local", "share")
+		}
+		if paths.StateDir == "" {
+			paths.StateDir = xdgDir("XDG_STATE_HOME", ".local", "state")
+		}
+	}
+	if paths.LogDir == "" {
+		paths.LogDir = paths.StateDir
+	}
+	return
+}
+
+// Make paths absolute and create their directories
+func (paths *PathSetting) prepare() (err error) {
+	for _, dir := range []*string{&paths.ConfigFile, &paths.DataDir, &paths.StateDir, &paths.LogDir} {
+		if *dir, err = filepath.Abs(*dir); err != nil {
+			return
+		}
+	}
+	for _, dir := range []string{filepath.Dir(paths.ConfigFile), paths.DataDir, paths.StateDir, paths.LogDir} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create directory %q: %v", dir, err)
+		}
+	}
+	return
+}
+
+// Relative paths in config are taken from base directory
+func (paths PathSetting) join(base string, path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
@@ -0,0 +1,96 @@
+package setting
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := newTestSetting(t).GetWebSetting()
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("default settings are invalid: %v", err)
+	}
+	tests := []struct {
+		name   string
+		change func(webSetting *WebSetting)
+		field  string
+	}{
+		{"min", func(webSetting *WebSetting) { webSetting.Port = 0 }, "Port"},
+		{"max", func(webSetting *WebSetting) { webSetting.Port = 65536 }, "Port"},
+		{"max of level", func(webSetting *WebSetting) { webSetting.LoggingLevel = 7 }, "LoggingLevel"},
+		{"min of duration", func(webSetting *WebSetting) { webSetting.RSSPollInterval = "30s" }, "RSSPollInterval"},
+		{"not a duration", func(webSetting *WebSetting) { webSetting.Lease = "an hour" }, "Lease"},
+		{"options", func(webSetting *WebSetting) { webSetting.LoggingOutput = "syslog" }, "LoggingOutput"},
+		{"required", func(webSetting *WebSetting) { webSetting.DataDir = "  " }, "DataDir"},
+		{"required ip", func(webSetting *WebSetting) { webSetting.IP = "" }, "IP"},
+		{"ip", func(webSetting *WebSetting) { webSetting.IP = "localhost" }, "IP"},
+		{"url", func(webSetting *WebSetting) { webSetting.DefaultTrackerList = "trackers.txt" }, "DefaultTrackerList"},
+		{"rate", func(webSetting *WebSetting) { webSetting.UploadRateLimit = "fast" }, "UploadRateLimit"},
+		{"address", func(webSetting *WebSetting) { webSetting.ListenAddr = "0.0.0.0:99999" }, "ListenAddr"},
+		{"proxy scheme", func(webSetting *WebSetting) { webSetting.SocksProxyURL = "ftp://proxy:21" }, "SocksProxyURL"},
+		{"proxy needs url", func(webSetting *WebSetting) { webSetting.UseSocksProxy, webSetting.SocksProxyURL = true, "" }, "SocksProxyURL"},
+		{"remote needs password", func(webSetting *WebSetting) { webSetting.SupportRemote, webSetting.AuthUsername = true, "" }, "AuthPassword"},
+		{"both families off", func(webSetting *WebSetting) { webSetting.DisableIPv4, webSetting.DisableIPv6 = true, true }, "DisableIPv6"},
+		{"protocol", func(webSetting *WebSetting) { webSetting.Protocols = []string{"upnp", "nat"} }, "Protocols"},
+		{"peer id", func(webSetting *WebSetting) { webSetting.PeerID = "-AN0100-" }, "PeerID"},
+	}
+	for _, test := range tests {
+		webSetting := valid
+		webSetting.Protocols = append([]string(nil), valid.Protocols...)
+		test.change(&webSetting)
+		err := webSetting.Validate()
+		errs, isValidation := err.(ValidationErrors)
+		if !isValidation || len(errs) != 1 || errs[0].Field != test.field {
+			t.Errorf("%s: got %v, want one error of %s", test.name, err, test.field)
+		}
+	}
+
+	// empty values which are not required, and values at the limits
+	webSetting := valid
+	webSetting.ListenAddr, webSetting.UploadRateLimit, webSetting.Port = "", "", 65535
+	webSetting.MaxEstablishedConns, webSetting.RSSPollInterval = 1, "1m"
+	if err := webSetting.Validate(); err != nil {
+		t.Errorf("valid settings rejected: %v", err)
+	}
+}
+
+func TestSecretSettings(t *testing.T) {
+	cc := newTestSetting(t)
+	webSetting := cc.GetWebSetting()
+	if webSetting.AuthPassword != SecretMask {
+		t.Fatalf("password is read as %q", webSetting.AuthPassword)
+	}
+	for _, schema := range WebSettingSchema() {
+		if schema.Name == "AuthPassword" && (!schema.Secret || schema.Default != nil) {
+			t.Errorf("schema of password: %+v", schema)
+		}
+	}
+
+	// masked and empty passwords keep the saved one
+	webSetting.SupportRemote = true
+	for _, password := range []string{SecretMask, ""} {
+		webSetting.AuthPassword = password
+		changes, err := cc.UpdateConfig(webSetting)
+		if err != nil {
+			t.Fatalf("password %q: %v", password, err)
+		}
+		if saved := cc.Current().AuthPassword; saved != defaultSettings["ConnectSetting.AuthPassword"] {
+			t.Errorf("password %q saved as %q", password, saved)
+		}
+		for _, name := range changes.Start {
+			if name == "AuthPassword" {
+				t.Errorf("password %q is reported as changed", password)
+			}
+		}
+	}
+
+	webSetting.AuthPassword = "new secret"
+	if _, err := cc.UpdateConfig(webSetting); err != nil {
+		t.Fatal(err)
+	}
+	if saved := cc.Current().AuthPassword; saved != "new secret" {
+		t.Errorf("new password saved as %q", saved)
+	}
+	if read := cc.GetWebSetting().AuthPassword; read != SecretMask {
+		t.Errorf("new password is read as %q", read)
+	}
+}
@@ -0,0 +1,16 @@
+// Package webui holds the Angular client built by "npm run build:webui"
+package webui
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed dist
+var dist embed.FS
+
+// Files Built client with index.html at its root
+func Files() fs.FS {
+	files, _ := fs.Sub(dist, "dist")
+	return files
+}
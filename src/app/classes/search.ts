@@ -0,0 +1,25 @@
+export class SearchResult {
+  Title:                string;
+  Size:                 number;
+  Seeders:              number;
+  Leechers:             number;
+  MagnetURI:            string;
+  TorrentURL:           string;
+  InfoHash:             string;
+  Indexer:              string;
+  PublishDate:          string;
+}
+
+export class IndexerError {
+  Indexer:              string;
+  Error:                string;
+}
+
+export class SearchResponse {
+  Results:              SearchResult[];
+  Errors:               IndexerError[];
+}
+
+export class AddResponse {
+  IsAdded:              boolean;
+}
@@ -1,5 +1,10 @@
 import { Component, OnInit } from '@angular/core';
 import { shell } from 'electron';
+import * as _ from 'lodash';
+
+import { IndexerError, SearchResult } from '../../classes/search';
+import { SearchService } from '../../providers/search.service';
+import { MessagesService } from '../../providers/messages.service';
 
 @Component({
   selector: 'app-search',
@@ -13,7 +18,18 @@ export class SearchComponent implements OnInit {
   torrentdownload = require('../../../assets/tools/torrentdownload.png');
   toorgle = require('../../../assets/tools/toorgle.png');
   torrentseeker = require('../../../assets/tools/torrentseeker.png');
-  constructor() { }
+
+  query = '';
+  searching = false;
+  searched = false;
+  results: SearchResult[] = [];
+  indexerErrors: IndexerError[] = [];
+  // 'adding' or 'added' for results clicked, see resultKey
+  addStates: { [key: string]: string } = {};
+
+  constructor(private searchService: SearchService,
+              private messagesService: MessagesService,
+  ) { }
 
   ngOnInit() {
   }
@@ -21,4 +37,57 @@ export class SearchComponent implements OnInit {
   openUrl (url: string) {
     shell.openExternal(url);
   }
+
+  search() {
+    const query = _.trim(this.query);
+    if (query === '' || this.searching) {
+      return;
+    }
+    this.searching = true;
+    this.searchService.search(query)
+        .subscribe(response => {
+          this.searching = false;
+          this.searched = true;
+          // engine sends null for empty lists
+          this.results = response.Results || [];
+          this.indexerErrors = response.Errors || [];
+          this.addStates = {};
+        });
+  }
+
+  addResult(result: SearchResult) {
+    const key = this.resultKey(result);
+    if (this.addStates[key]) {
+      return;
+    }
+    this.addStates[key] = 'adding';
+    this.searchService.addResult(result)
+        .subscribe(response => {
+          if (response && response.IsAdded) {
+            this.addStates[key] = 'added';
+            this.messagesService.add('add search result successfully');
+          } else {
+            delete this.addStates[key];
+            this.messagesService.add('Failed to add search result ' + result.Title);
+          }
+        });
+  }
+
+  // Results are merged by info hash in engine, those without one are told apart by link
+  resultKey(result: SearchResult): string {
+    return result.InfoHash || result.MagnetURI || result.TorrentURL;
+  }
+
+  formatSize(size: number): string {
+    if (!size) {
+      return '-';
+    }
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let unit = 0;
+    while (size >= 1024 && unit < units.length - 1) {
+      size /= 1024;
+      unit++;
+    }
+    return size.toFixed(unit === 0 ? 0 : 1) + ' ' + units[unit];
+  }
 }
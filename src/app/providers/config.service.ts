@@ -13,5 +13,19 @@ export class ConfigService {
   wsBaseUrl = 'ws://' + this.addr + '/ws';
   playerUrl = this.baseUrl + '/player';
 
-  constructor() { }
+  constructor() {
+    // Served by the engine itself, the api is on the same origin
+    if (ConfigService.servedByEngine()) {
+      this.addr = window.location.host;
+      this.baseUrl = window.location.origin;
+      this.wsBaseUrl = (window.location.protocol === 'https:' ? 'wss://' : 'ws://') + this.addr + '/ws';
+      this.playerUrl = this.baseUrl + '/player';
+    }
+  }
+
+  // Electron loads the client from file:// or the dev server of ng serve
+  private static servedByEngine(): boolean {
+    const protocol = window.location.protocol;
+    return (protocol === 'http:' || protocol === 'https:') && window.location.port !== '4200';
+  }
 }
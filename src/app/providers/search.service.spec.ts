@@ -0,0 +1,52 @@
+import { TestBed } from '@angular/core/testing';
+import { HttpClientTestingModule, HttpTestingController } from '@angular/common/http/testing';
+
+import { SearchService } from './search.service';
+import { TorrentService } from './torrent.service';
+import { SearchResult } from '../classes/search';
+
+describe('SearchService', () => {
+  let service: SearchService;
+  let httpMock: HttpTestingController;
+
+  beforeEach(() => {
+    TestBed.configureTestingModule({
+      imports: [ HttpClientTestingModule ]
+    });
+    service = TestBed.get(SearchService);
+    httpMock = TestBed.get(HttpTestingController);
+  });
+
+  afterEach(() => {
+    httpMock.verify();
+  });
+
+  it('should query /search', () => {
+    service.search('some show').subscribe(response => {
+      expect(response.Results.length).toBe(1);
+    });
+    const request = httpMock.expectOne(req => req.url === service.searchUrl);
+    expect(request.request.params.get('q')).toBe('some show');
+    request.flush({Results: [{Title: 'some show'}], Errors: null});
+  });
+
+  it('should add magnets through the magnet path', () => {
+    const result = <SearchResult>{MagnetURI: 'magnet:?xt=urn:btih:0123', TorrentURL: 'http://indexer/1.torrent'};
+    service.addResult(result).subscribe(response => {
+      expect(response.IsAdded).toBe(true);
+    });
+    const request = httpMock.expectOne(TestBed.get(TorrentService).sendMagnetUrl);
+    expect(request.request.body.get('linkAddress')).toBe(result.MagnetURI);
+    request.flush({IsAdded: true});
+  });
+
+  it('should add torrent urls through /search/add', () => {
+    const result = <SearchResult>{MagnetURI: '', TorrentURL: 'http://indexer/1.torrent'};
+    service.addResult(result).subscribe(response => {
+      expect(response.IsAdded).toBe(false);
+    });
+    const request = httpMock.expectOne(service.addSearchResultUrl);
+    expect(request.request.body.get('torrentURL')).toBe(result.TorrentURL);
+    request.flush({IsAdded: false});
+  });
+});
@@ -0,0 +1,77 @@
+import { Injectable } from '@angular/core';
+import { HttpClient, HttpHeaders, HttpParams } from '@angular/common/http';
+import { AddResponse, SearchResponse, SearchResult } from '../classes/search';
+
+import { Observable, of} from 'rxjs';
+import { catchError, tap} from 'rxjs/operators';
+
+import { ConfigService } from './config.service';
+import { TorrentService } from './torrent.service';
+
+@Injectable({
+  providedIn: 'root'
+})
+
+export class SearchService {
+
+  searchUrl = this.configService.baseUrl + '/search';
+  addSearchResultUrl = this.configService.baseUrl + '/search' + '/add';
+
+  private formHttpOptions = {
+    headers: new HttpHeaders({
+      'enctype': 'multipart/form-data',
+      'Access-Control-Allow-Origin': this.configService.addr,
+    })
+  };
+
+  constructor(
+      private httpClient: HttpClient,
+      private configService: ConfigService,
+      private torrentService: TorrentService
+  ) { }
+
+  // Query every enabled Torznab indexer of the engine, failed indexers are listed in Errors
+  search(query: string): Observable<SearchResponse> {
+    const params = new HttpParams().set('q', query);
+    return this.httpClient.get<SearchResponse>(this.searchUrl, {params: params})
+        .pipe(
+            tap(_ => console.log('search indexers')),
+            catchError(this.handleError<SearchResponse>('search indexers', {Results: [], Errors: []}))
+        );
+  }
+
+  // Magnets are added as magnets sent by hand, torrent urls are fetched by the engine
+  addResult(result: SearchResult): Observable<AddResponse> {
+    const formData: FormData = new FormData();
+    let addUrl = this.addSearchResultUrl;
+    if (result.MagnetURI) {
+      addUrl = this.torrentService.sendMagnetUrl;
+      formData.append('linkAddress', result.MagnetURI);
+    } else {
+      formData.append('torrentURL', result.TorrentURL);
+    }
+    return this.httpClient.post<AddResponse>(addUrl, formData, this.formHttpOptions)
+        .pipe(
+            tap(_ => console.log('add search result')),
+            catchError(this.handleError<AddResponse>('add search result', {IsAdded: false}))
+        );
+  }
+
+  /**
+   * Handle Http operation that failed.
+   * Let the app continue.
+   * @param operation - name of the operation that failed
+   * @param result - optional value to return as the observable result
+   */
+  private handleError<T>(operation = 'operation', result?: T) {
+    return (error: any): Observable<T> => {
+
+      // TODO: send the error to remote logging infrastructure
+      console.error(error); // log to console instead
+
+      // Let the app keep running by returning an empty result.
+      return of(result as T);
+    };
+  }
+
+}